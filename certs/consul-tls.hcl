@@ -0,0 +1,11 @@
+# Gli health check gRPC dei servizi usano l'mTLS: l'agent presenta il proprio certificato,
+# firmato dalla stessa CA dei servizi. Le porte HTTP e DNS di Consul restano invariate.
+enable_agent_tls_for_checks = true
+
+tls {
+  defaults {
+    ca_file   = "/certs/ca.pem"
+    cert_file = "/certs/consul.pem"
+    key_file  = "/certs/consul-key.pem"
+  }
+}
@@ -0,0 +1,48 @@
+#!/bin/sh
+# Genera una CA di sviluppo e un certificato per ogni servizio, usato sia come server sia
+# come client per l'mTLS. I certificati dei servizi contengono il nome logico registrato su
+# Consul (es. analysis-service), che i client verificano al posto dell'hostname del container.
+#
+# Uso: sh certs/gen-certs.sh [cartella]   (FORCE=1 rigenera anche se la CA esiste già)
+# Per la rotazione basta rigenerare i file: i servizi li ricaricano senza riavvio.
+# Genera anche sensor-jwt.key, il segreto con cui il collector verifica i JWT dei sensori.
+set -eu
+
+DIR="${1:-$(dirname "$0")}"
+DAYS="${DAYS:-365}"
+cd "$DIR"
+
+if [ ! -f sensor-jwt.key ]; then
+	openssl rand -hex 32 > sensor-jwt.key
+	echo "Segreto JWT dei sensori: $DIR/sensor-jwt.key"
+fi
+
+if [ -f ca.pem ] && [ "${FORCE:-0}" != "1" ]; then
+	echo "Certificati già presenti in $DIR (FORCE=1 per rigenerarli)."
+	exit 0
+fi
+
+openssl req -x509 -newkey rsa:2048 -nodes -days "$DAYS" \
+	-keyout ca-key.pem -out ca.pem -subj "/CN=ids-project-ca" 2>/dev/null
+
+# issue <nome> <SAN>
+issue() {
+	name="$1"
+	san="$2"
+	openssl req -newkey rsa:2048 -nodes -keyout "$name-key.pem" -out "$name.csr" -subj "/CN=$name" 2>/dev/null
+	printf 'subjectAltName=%s\nextendedKeyUsage=serverAuth,clientAuth\nkeyUsage=digitalSignature,keyEncipherment\n' "$san" > "$name.ext"
+	openssl x509 -req -in "$name.csr" -CA ca.pem -CAkey ca-key.pem -CAcreateserial \
+		-days "$DAYS" -extfile "$name.ext" -out "$name.pem" 2>/dev/null
+	rm -f "$name.csr" "$name.ext"
+	echo "  $name.pem ($san)"
+}
+
+echo "CA: $DIR/ca.pem"
+issue collector-service "DNS:collector-service,DNS:collector,DNS:localhost,IP:127.0.0.1"
+issue analysis-service "DNS:analysis-service"
+issue storage-service "DNS:storage-service,DNS:storage,DNS:localhost,IP:127.0.0.1"
+issue inference-service "DNS:inference-service"
+# Client: l'agent Consul per gli health check e il generatore di traffico
+issue consul "DNS:consul"
+issue test-client "DNS:test-client"
+rm -f ca.srl
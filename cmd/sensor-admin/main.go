@@ -0,0 +1,148 @@
+// sensor-admin emette e revoca le credenziali con cui i sensori si autenticano al collector.
+//
+//	sensor-admin issue  -client sensor-1 [-type api-key|jwt] [-ttl 720h]
+//	sensor-admin revoke -id <credential-id>
+//	sensor-admin list
+//
+// Le credenziali sono scritte nel file condiviso con il collector (SENSOR_KEYS_PATH), che
+// le ricarica da solo: emissioni e revoche hanno effetto senza riavvii. La chiave o il
+// token emessi vengono stampati una sola volta su stdout.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
+)
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Uso: sensor-admin <issue|revoke|list> [flag]")
+	fmt.Fprintln(os.Stderr, "  issue   -client ID [-type api-key|jwt] [-ttl durata]  emette una credenziale")
+	fmt.Fprintln(os.Stderr, "  revoke  -id ID                                        revoca una credenziale")
+	fmt.Fprintln(os.Stderr, "  list                                                  elenca le credenziali")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	keysPath := fs.String("keys", getEnv("SENSOR_KEYS_PATH", "sensor-keys.json"), "File delle credenziali condiviso con il collector")
+	var err error
+	switch cmd {
+	case "issue":
+		clientID := fs.String("client", "", "SourceClientId del sensore")
+		kind := fs.String("type", sensorauth.KindAPIKey, "Tipo di credenziale: api-key o jwt")
+		ttl := fs.Duration("ttl", 0, "Validità della credenziale (0 = senza scadenza)")
+		secretFile := fs.String("jwt-secret", getEnv("SENSOR_JWT_SECRET_FILE", ""), "File con il segreto di firma dei JWT")
+		fs.Parse(args)
+		err = issue(openStore(*keysPath), *clientID, *kind, *ttl, *secretFile)
+	case "revoke":
+		id := fs.String("id", "", "Identificativo della credenziale da revocare")
+		fs.Parse(args)
+		err = revoke(openStore(*keysPath), *id)
+	case "list":
+		fs.Parse(args)
+		list(openStore(*keysPath))
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sensor-admin %s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+func openStore(path string) *sensorauth.Store {
+	store, err := sensorauth.OpenStore(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sensor-admin: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+func issue(store *sensorauth.Store, clientID, kind string, ttl time.Duration, secretFile string) error {
+	var secret string
+	var cred sensorauth.Credential
+	var err error
+	switch kind {
+	case sensorauth.KindAPIKey:
+		secret, cred, err = store.IssueAPIKey(clientID, ttl)
+	case sensorauth.KindJWT:
+		if secretFile == "" {
+			return fmt.Errorf("-jwt-secret (o SENSOR_JWT_SECRET_FILE) è obbligatorio per i JWT")
+		}
+		key, readErr := os.ReadFile(secretFile)
+		if readErr != nil {
+			return readErr
+		}
+		secret, cred, err = store.IssueJWT(bytes.TrimSpace(key), clientID, ttl)
+	default:
+		return fmt.Errorf("tipo di credenziale sconosciuto %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Emessa credenziale %s (%s) per %s%s. Conservarla ora: non verrà mostrata di nuovo.\n", cred.ID, cred.Kind, cred.ClientID, expiry(cred))
+	fmt.Println(secret)
+	return nil
+}
+
+func revoke(store *sensorauth.Store, id string) error {
+	if id == "" {
+		return fmt.Errorf("-id è obbligatorio")
+	}
+	cred, err := store.Revoke(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Credenziale %s (%s) di %s revocata.\n", cred.ID, cred.Kind, cred.ClientID)
+	return nil
+}
+
+func list(store *sensorauth.Store) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tTYPE\tCREATED\tEXPIRES\tSTATUS")
+	now := time.Now().Unix()
+	for _, c := range store.Credentials() {
+		expires, state := "-", "active"
+		if c.ExpiresAt != 0 {
+			expires = formatTime(c.ExpiresAt)
+			if now >= c.ExpiresAt {
+				state = "expired"
+			}
+		}
+		if c.RevokedAt != 0 {
+			state = "revoked " + formatTime(c.RevokedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.ClientID, c.Kind, formatTime(c.CreatedAt), expires, state)
+	}
+	w.Flush()
+}
+
+func expiry(c sensorauth.Credential) string {
+	if c.ExpiresAt == 0 {
+		return ", senza scadenza"
+	}
+	return ", scade il " + formatTime(c.ExpiresAt)
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
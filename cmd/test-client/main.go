@@ -2,87 +2,96 @@ package main
 
 import (
 	"context"
+	crand "crypto/rand"
 	"encoding/csv"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/mtls"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-// I percorsi dei file rimangono costanti perché sono legati al codice
-const (
-	trainFilePath = "KDDTrain+.txt"
-	testFilePath  = "KDDTest+.txt"
-)
+// Il percorso del file rimane costante perché è legato al codice
+const testFilePath = "KDDTest+.txt"
 
-var (
-	protocolMap = make(map[string]float32)
-	serviceMap  = make(map[string]float32)
-	flagMap     = make(map[string]float32)
-)
+// sendAttempts è il numero di tentativi di invio di ogni metrica.
+const sendAttempts = 3
 
-// Le funzioni buildCategoricalMaps e recordToFeatures rimangono invariate...
-func buildCategoricalMaps(filePath string) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Fatalf("Impossibile aprire il file: %v", filePath)
+// newMetricID genera l'identificativo univoco di una metrica.
+func newMetricID() string {
+	id := make([]byte, 16)
+	crand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// retryDelay restituisce l'attesa indicata dal collector quando rifiuta una metrica per
+// superamento dei limiti di frequenza (RESOURCE_EXHAUSTED con RetryInfo), altrimenti 0.
+func retryDelay(err error) time.Duration {
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		return 0
 	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	reader.Comment = '@'
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
 		}
-		if err != nil || len(record) < 4 {
+	}
+	return 0
+}
+
+// loadCredentials legge il file delle credenziali dei client di test, emesse con sensor-admin
+// (make sensor-credentials): una riga "<client-id> <api-key o JWT>" per client.
+func loadCredentials(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make(map[string]string)
+	for n, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if _, exists := protocolMap[record[1]]; !exists {
-			protocolMap[record[1]] = float32(len(protocolMap))
-		}
-		if _, exists := serviceMap[record[2]]; !exists {
-			serviceMap[record[2]] = float32(len(serviceMap))
-		}
-		if _, exists := flagMap[record[3]]; !exists {
-			flagMap[record[3]] = float32(len(flagMap))
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: attesa una riga \"<client-id> <credenziale>\"", path, n+1)
 		}
+		credentials[fields[0]] = fields[1]
 	}
+	return credentials, nil
 }
 
-func recordToFeatures(record []string) ([]float32, error) {
-	if len(record) < 41 {
-		return nil, fmt.Errorf("record con meno di 41 feature: %d", len(record))
+// sensorAuthorization restituisce l'header authorization con cui il client si presenta al
+// collector: la API key indicata oppure la credenziale emessa per sourceID. Vuoto senza credenziali.
+func sensorAuthorization(sourceID, apiKey string, credentials map[string]string) string {
+	if apiKey != "" {
+		return "ApiKey " + apiKey
 	}
-	features := make([]float32, 41)
-	for i := 0; i < 41; i++ {
-		var val float64
-		var err error
-		switch i {
-		case 1:
-			features[i] = protocolMap[record[i]]
-		case 2:
-			features[i] = serviceMap[record[i]]
-		case 3:
-			features[i] = flagMap[record[i]]
-		default:
-			val, err = strconv.ParseFloat(strings.TrimSpace(record[i]), 32)
-			if err == nil {
-				features[i] = float32(val)
-			}
-		}
+	if credential, ok := credentials[sourceID]; ok {
+		return sensorauth.Authorization(credential)
 	}
-	return features, nil
+	return ""
+}
+
+// recordToFeatures codifica un record NSL-KDD con lo schema e il vocabolario condivisi con
+// l'addestramento, così il modello riceve gli stessi codici categorici su cui è stato addestrato.
+func recordToFeatures(record []string) ([]float32, error) {
+	return features.Default().EncodeRecord(record)
 }
 
 func main() {
@@ -92,12 +101,30 @@ func main() {
 	numClients := flag.Int("clients", 5, "Numero di client concorrenti da avviare")
 	recordsPerClient := flag.Int("records", 200, "Numero di record che ogni client invierà (0 per infinito)")
 	delayMs := flag.Int("delay", 500, "Pausa media in millisecondi tra un invio e l'altro")
+	tlsCert := flag.String("tls-cert", "", "Certificato client per l'mTLS verso il collector (vuoto = connessione in chiaro)")
+	tlsKey := flag.String("tls-key", "", "Chiave del certificato client")
+	tlsCA := flag.String("tls-ca", "", "Bundle delle CA che firmano il certificato del collector")
+	tlsServerName := flag.String("tls-server-name", "collector-service", "Nome atteso nel certificato del collector")
+	credentialsFile := flag.String("credentials", "", "File con la credenziale emessa con sensor-admin per ogni client ID (vuoto = nessuna credenziale)")
+	apiKey := flag.String("api-key", "", "API key emessa con sensor-admin (vale per un solo client ID, usare con -clients 1)")
 	flag.Parse()
 
+	tlsFiles, err := mtls.Load(mtls.Files{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA})
+	if err != nil {
+		log.Fatalf("Configurazione TLS non valida: %v", err)
+	}
+	creds := tlsFiles.ClientCredentials(*tlsServerName)
+
+	var credentials map[string]string
+	if *credentialsFile != "" {
+		if credentials, err = loadCredentials(*credentialsFile); err != nil {
+			log.Fatalf("Impossibile leggere le credenziali dei client: %v", err)
+		}
+	}
+
 	log.Printf("--- Avvio Data Generator ---")
 	log.Printf("Target: %s | Modalità: %s | Client: %d | Record per client: %d", *collectorAddr, *mode, *numClients, *recordsPerClient)
 
-	buildCategoricalMaps(trainFilePath)
 	var wg sync.WaitGroup
 
 	for i := 1; i <= *numClients; i++ {
@@ -110,7 +137,7 @@ func main() {
 
 			time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond)
 
-			conn, err := grpc.Dial(*collectorAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			conn, err := grpc.Dial(*collectorAddr, grpc.WithTransportCredentials(creds))
 			if err != nil {
 				log.Printf("[Client %d] Errore di connessione: %v", clientID, err)
 				return
@@ -118,6 +145,12 @@ func main() {
 			defer conn.Close()
 			c := pb.NewMetricsCollectorClient(conn)
 
+			sourceID := fmt.Sprintf("concurrent-client-%d", clientID)
+			authorization := sensorAuthorization(sourceID, *apiKey, credentials)
+			if authorization == "" && credentials != nil {
+				log.Printf("[Client %d] Nessuna credenziale per %s: le metriche verranno inviate senza.", clientID, sourceID)
+			}
+
 			file, err := os.Open(testFilePath)
 			if err != nil {
 				log.Printf("[Client %d] Impossibile aprire file: %v", clientID, err)
@@ -148,26 +181,43 @@ func main() {
 					continue
 				}
 
-				features, err := recordToFeatures(record)
+				vector, err := recordToFeatures(record)
 				if err != nil {
+					log.Printf("[Client %d] Record scartato: %v", clientID, err)
 					continue
 				}
 
 				metric := &pb.Metric{
-					SourceClientId: fmt.Sprintf("concurrent-client-%d", clientID),
+					SourceClientId: sourceID,
 					Type:           "network_traffic",
 					Timestamp:      time.Now().Unix(),
-					Features:       features,
+					Features:       vector,
+					MetricId:       newMetricID(),
 				}
 
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				resp, err := c.SendMetric(ctx, metric)
-				if err != nil {
-					log.Printf("[Client %d] Impossibile inviare metrica: %v", clientID, err)
-				} else {
-					log.Printf("[Client %d] Invio record (Etichetta: %s) -> Risposta: %s", clientID, label, resp.Message)
+				// I tentativi successivi riusano lo stesso metric_id: se il primo è arrivato
+				// nonostante il timeout, la pipeline riconosce la ripetizione e non la conta due volte
+				for attempt := 1; attempt <= sendAttempts; attempt++ {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					if authorization != "" {
+						ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+					}
+					resp, err := c.SendMetric(ctx, metric)
+					cancel()
+					if err == nil {
+						log.Printf("[Client %d] Invio record (Etichetta: %s) -> Risposta: %s", clientID, label, resp.Message)
+						break
+					}
+					log.Printf("[Client %d] Impossibile inviare metrica (tentativo %d/%d): %v", clientID, attempt, sendAttempts, err)
+					// Una metrica rifiutata dalla validazione (ed è in quarantena) non va ritentata
+					if status.Code(err) == codes.InvalidArgument {
+						break
+					}
+					// Se il collector ci sta limitando, rispettiamo l'attesa che ha indicato
+					if delay := retryDelay(err); delay > 0 && attempt < sendAttempts {
+						time.Sleep(delay)
+					}
 				}
-				cancel()
 
 				recordsSent++
 				if *recordsPerClient > 0 && recordsSent >= *recordsPerClient {
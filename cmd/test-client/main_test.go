@@ -1,18 +1,23 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // TestRecordToFeatures è la nostra funzione di test.
 func TestRecordToFeatures(t *testing.T) {
 	// Fase 1: Setup
-	// Inizializziamo le mappe categoriche con valori noti per avere un test prevedibile.
-	// Non chiamiamo buildCategoricalMaps() per non dipendere da un file esterno.
-	protocolMap = map[string]float32{"tcp": 1.0, "udp": 2.0}
-	serviceMap = map[string]float32{"http": 10.0, "ftp": 20.0}
-	flagMap = map[string]float32{"SF": 0.0, "REJ": 1.0}
+	// I codici categorici vengono dal vocabolario condiviso con l'addestramento (pkg/features):
+	// tcp = 1, http = 24, SF = 9, icmp = 0, other = 44, S0 = 5.
 
 	// Fase 2: Definire i casi di test
 	// Creiamo una struct per rendere i casi di test più leggibili.
@@ -26,7 +31,7 @@ func TestRecordToFeatures(t *testing.T) {
 			name: "Record valido e completo",
 			// Un record di 41+ elementi con valori numerici e categorici noti
 			inputRecord:   []string{"0", "tcp", "http", "SF", "491", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "2", "2", "0.00", "0.00", "0.00", "0.00", "1.00", "0.00", "0.00", "150", "25", "0.17", "0.03", "0.17", "0.00", "0.00", "0.00", "0.05", "0.00", "normal"},
-			expectedFeats: []float32{0, 1.0, 10.0, 0.0, 491, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 1, 0, 0, 150, 25, 0.17, 0.03, 0.17, 0, 0, 0, 0.05, 0},
+			expectedFeats: []float32{0, 1, 24, 9, 491, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 1, 0, 0, 150, 25, 0.17, 0.03, 0.17, 0, 0, 0, 0.05, 0},
 			expectError:   false,
 		},
 		{
@@ -36,13 +41,24 @@ func TestRecordToFeatures(t *testing.T) {
 			expectError:   true,
 		},
 		{
-			name: "Valori categorici non mappati",
-			// 'icmp' e 'other' non sono nelle nostre mappe di test
-			inputRecord: []string{"0", "icmp", "other", "S0", "10", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "2", "2", "0.00", "0.00", "0.00", "0.00", "1.00", "0.00", "0.00", "150", "25", "0.17", "0.03", "0.17", "0.00", "0.00", "0.00", "0.05", "0.00", "normal"},
-			// La funzione dovrebbe usare 0 per i valori non trovati
-			expectedFeats: []float32{0, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 1, 0, 0, 150, 25, 0.17, 0.03, 0.17, 0, 0, 0, 0.05, 0},
+			name:          "Altri valori categorici",
+			inputRecord:   []string{"0", "icmp", "other", "S0", "10", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "2", "2", "0.00", "0.00", "0.00", "0.00", "1.00", "0.00", "0.00", "150", "25", "0.17", "0.03", "0.17", "0.00", "0.00", "0.00", "0.05", "0.00", "normal"},
+			expectedFeats: []float32{0, 0, 44, 5, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 1, 0, 0, 150, 25, 0.17, 0.03, 0.17, 0, 0, 0, 0.05, 0},
 			expectError:   false,
 		},
+		{
+			name: "Valore categorico fuori vocabolario",
+			// Un servizio sconosciuto non può essere codificato: il record va scartato, non azzerato
+			inputRecord:   []string{"0", "tcp", "unknown_svc", "SF", "10", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "2", "2", "0.00", "0.00", "0.00", "0.00", "1.00", "0.00", "0.00", "150", "25", "0.17", "0.03", "0.17", "0.00", "0.00", "0.00", "0.05", "0.00", "normal"},
+			expectedFeats: nil,
+			expectError:   true,
+		},
+		{
+			name:          "Valore numerico non valido",
+			inputRecord:   []string{"0", "tcp", "http", "SF", "abc", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "2", "2", "0.00", "0.00", "0.00", "0.00", "1.00", "0.00", "0.00", "150", "25", "0.17", "0.03", "0.17", "0.00", "0.00", "0.00", "0.05", "0.00", "normal"},
+			expectedFeats: nil,
+			expectError:   true,
+		},
 	}
 
 	// Fase 3: Eseguire i test
@@ -72,3 +88,48 @@ func TestRecordToFeatures(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryDelay(t *testing.T) {
+	throttled, err := status.New(codes.ResourceExhausted, "rate limit exceeded").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Impossibile costruire lo status: %v", err)
+	}
+	if got := retryDelay(throttled.Err()); got != 1500*time.Millisecond {
+		t.Errorf("Attesa di 1.5s indicata dal collector, ottenuta %s", got)
+	}
+	if got := retryDelay(status.Error(codes.Unavailable, "down")); got != 0 {
+		t.Errorf("Senza RESOURCE_EXHAUSTED non c'è un'attesa indicata, ottenuta %s", got)
+	}
+}
+
+func TestSensorAuthorization(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.txt")
+	content := "# client di test\nconcurrent-client-1 ids_k1_s1\n\nconcurrent-client-2 eyJ.e30.sig\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := loadCredentials(path)
+	if err != nil {
+		t.Fatalf("loadCredentials fallita: %v", err)
+	}
+
+	tests := []struct {
+		sourceID, apiKey, want string
+	}{
+		{"concurrent-client-1", "", "ApiKey ids_k1_s1"},
+		{"concurrent-client-2", "", "Bearer eyJ.e30.sig"},
+		{"concurrent-client-3", "", ""},
+		{"concurrent-client-2", "ids_k_s", "ApiKey ids_k_s"}, // La API key indicata ha la precedenza
+	}
+	for _, tt := range tests {
+		if got := sensorAuthorization(tt.sourceID, tt.apiKey, credentials); got != tt.want {
+			t.Errorf("%s (api key %q): atteso %q, ottenuto %q", tt.sourceID, tt.apiKey, tt.want, got)
+		}
+	}
+
+	os.WriteFile(path, []byte("concurrent-client-1\n"), 0o600)
+	if _, err := loadCredentials(path); err == nil {
+		t.Errorf("Una riga senza credenziale doveva essere rifiutata")
+	}
+}
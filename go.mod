@@ -4,13 +4,23 @@ go 1.23.11
 
 require (
 	github.com/ANGEL0CADUTO/IDS_project/pkg/consul v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/dedup v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/features v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/hashring v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/iforest v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/mtls v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/ratelimit v0.0.0-00010101000000-000000000000
+	github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth v0.0.0-00010101000000-000000000000
 	github.com/ANGEL0CADUTO/IDS_project/pkg/tracing v0.0.0-00010101000000-000000000000
 	github.com/hashicorp/consul/api v1.32.1
 	github.com/influxdata/influxdb-client-go/v2 v2.14.0
 	github.com/sony/gobreaker v1.0.0
+	go.etcd.io/bbolt v1.4.3
 	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.51.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250715232539-7130f93afb79
 	google.golang.org/grpc v1.73.0
 	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -61,10 +71,23 @@ require (
 	golang.org/x/sys v0.34.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20250603155806-513f23925822 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20250715232539-7130f93afb79 // indirect
 )
 
 // --- AGGIUNGI QUESTO BLOCCO ALLA FINE ---
 replace github.com/ANGEL0CADUTO/IDS_project/pkg/consul => ./pkg/consul
 
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/dedup => ./pkg/dedup
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/features => ./pkg/features
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/hashring => ./pkg/hashring
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/iforest => ./pkg/iforest
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/mtls => ./pkg/mtls
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/ratelimit => ./pkg/ratelimit
+
+replace github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth => ./pkg/sensorauth
+
 replace github.com/ANGEL0CADUTO/IDS_project/pkg/tracing => ./pkg/tracing
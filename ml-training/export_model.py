@@ -0,0 +1,97 @@
+"""Esporta l'Isolation Forest addestrato nel formato JSON letto dal pacchetto Go pkg/iforest.
+
+Produce:
+  - ../services/inference/isolation_forest_model.json: gli alberi del modello .joblib, valutabili
+    dal servizio di analisi senza il servizio di inferenza Python;
+  - ../pkg/iforest/testdata/kddtest_parity.csv: per ogni record di KDDTest+.txt le 41 feature
+    codificate, il decision_function e la predizione di scikit-learn, usati dal test di parità Go.
+
+Uso (dalla cartella ml-training):
+  python export_model.py
+"""
+import csv
+import hashlib
+import json
+import os
+
+import joblib
+import pandas as pd
+
+from train_model import categorical_cols, col_names
+from vocabulary import encode_categorical, load_vocabulary
+
+FORMAT = "ids-iforest/v1"
+
+MODEL_PATH = '../services/inference/isolation_forest_model.joblib'
+JSON_PATH = '../services/inference/isolation_forest_model.json'
+PARITY_PATH = '../pkg/iforest/testdata/kddtest_parity.csv'
+
+
+def model_version(path):
+    """Stessa versione riportata dal servizio di inferenza quando MODEL_VERSION non è impostata."""
+    digest = hashlib.sha256()
+    with open(path, 'rb') as f:
+        for chunk in iter(lambda: f.read(1 << 20), b''):
+            digest.update(chunk)
+    return 'sha256:' + digest.hexdigest()[:12]
+
+
+def export_forest(model, path, name, version, vocabulary_version):
+    """Scrive gli alberi del modello nel formato JSON di pkg/iforest."""
+    # Con max_features < 1 ogni albero vede solo un sottoinsieme delle colonne
+    subsample_features = model._max_features != model.n_features_in_
+    trees = []
+    for estimator, features in zip(model.estimators_, model.estimators_features_):
+        tree = estimator.tree_
+        exported = {
+            "children_left": [int(v) for v in tree.children_left],
+            "children_right": [int(v) for v in tree.children_right],
+            "feature": [int(v) for v in tree.feature],
+            "threshold": [float(v) for v in tree.threshold],
+            "n_node_samples": [int(v) for v in tree.n_node_samples],
+        }
+        if subsample_features:
+            exported["features"] = [int(v) for v in features]
+        trees.append(exported)
+
+    document = {
+        "format": FORMAT,
+        "model_name": name,
+        "model_version": version,
+        "vocabulary_version": vocabulary_version,
+        "n_features": int(model.n_features_in_),
+        "max_samples": int(model._max_samples),
+        "offset": float(model.offset_),
+        "trees": trees,
+    }
+    with open(path, 'w') as f:
+        # repr dei float: le soglie devono essere identiche a quelle di scikit-learn
+        json.dump(document, f, separators=(',', ':'))
+    print(f"Modello esportato in '{path}' ({len(trees)} alberi).")
+
+
+def export_parity(model, vocabulary, test_path, path):
+    """Scrive le predizioni di scikit-learn su KDDTest+ per il test di parità Go."""
+    test = pd.read_csv(test_path, header=None, names=col_names, comment='@', low_memory=False)
+    test = test.drop(columns=['label', 'difficulty'])
+
+    # I record con valori categorici assenti dal vocabolario non sono codificabili
+    test, skipped = encode_categorical(test, vocabulary, categorical_cols)
+    test = test.apply(pd.to_numeric)
+
+    scores = model.decision_function(test)
+    predictions = model.predict(test)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, 'w', newline='') as f:
+        writer = csv.writer(f)
+        writer.writerow(list(test.columns) + ['score', 'prediction'])
+        for row, score, prediction in zip(test.itertuples(index=False), scores, predictions):
+            writer.writerow([repr(float(v)) for v in row] + [repr(float(score)), int(prediction)])
+    print(f"File di parità scritto in '{path}': {len(test)} record ({skipped} con categorie sconosciute esclusi).")
+
+
+if __name__ == '__main__':
+    model = joblib.load(MODEL_PATH)
+    vocabulary = load_vocabulary()
+    export_forest(model, JSON_PATH, os.getenv('MODEL_NAME', 'isolation_forest'), model_version(MODEL_PATH), vocabulary['version'])
+    export_parity(model, vocabulary, "../KDDTest+.txt", PARITY_PATH)
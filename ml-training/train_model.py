@@ -1,9 +1,8 @@
 import pandas as pd
 from sklearn.ensemble import IsolationForest
-from sklearn.preprocessing import LabelEncoder
 import joblib
 
-print("Inizio dello script di addestramento...")
+from vocabulary import encode_categorical, load_vocabulary
 
 # 1. Caricamento e Preparazione dei Dati
 # I nomi delle colonne sono standard per il dataset NSL-KDD
@@ -16,34 +15,51 @@ col_names = ["duration", "protocol_type", "service", "flag", "src_bytes", "dst_b
              "dst_host_same_src_port_rate", "dst_host_srv_diff_host_rate", "dst_host_serror_rate",
              "dst_host_srv_serror_rate", "dst_host_rerror_rate", "dst_host_srv_rerror_rate", "label", "difficulty"]
 
-# Carichiamo il dataset, usando il parametro 'comment' per ignorare l'header ARFF
-# Le righe che iniziano con '@' verranno trattate come commenti e saltate.
-df = pd.read_csv("KDDTrain+.txt", header=None, names=col_names, comment='@', low_memory=False)
-print(f"Dataset caricato. Numero di righe: {len(df)}")
+# Colonne categoriche: il modello accetta solo numeri, vanno codificate con il vocabolario condiviso
+categorical_cols = ['protocol_type', 'service', 'flag']
 
-# Rimuoviamo le ultime due colonne ('label' e 'difficulty') che non servono per l'addestramento unsupervised
-df = df.drop(columns=['label', 'difficulty'])
 
-# Gestione delle colonne categoriche: il modello accetta solo numeri.
-# Usiamo LabelEncoder per trasformare le stringhe (es. 'tcp', 'http') in numeri.
-categorical_cols = ['protocol_type', 'service', 'flag']
-for col in categorical_cols:
-    le = LabelEncoder()
-    df[col] = le.fit_transform(df[col])
+def main():
+    print("Inizio dello script di addestramento...")
+
+    # Carichiamo il dataset, usando il parametro 'comment' per ignorare l'header ARFF
+    # Le righe che iniziano con '@' verranno trattate come commenti e saltate.
+    df = pd.read_csv("KDDTrain+.txt", header=None, names=col_names, comment='@', low_memory=False)
+    print(f"Dataset caricato. Numero di righe: {len(df)}")
+
+    # Rimuoviamo le ultime due colonne ('label' e 'difficulty') che non servono per l'addestramento unsupervised
+    df = df.drop(columns=['label', 'difficulty'])
+
+    # Gestione delle colonne categoriche: il modello accetta solo numeri.
+    # Le stringhe (es. 'tcp', 'http') diventano i codici di pkg/features/vocabulary.json,
+    # gli stessi con cui i componenti Go codificano le metriche.
+    vocabulary = load_vocabulary()
+    df, skipped = encode_categorical(df, vocabulary, categorical_cols)
+    if skipped:
+        print(f"Scartati {skipped} record con categorie assenti dal vocabolario {vocabulary['version']}")
+
+    # Assicuriamoci che tutti i dati siano numerici
+    df = df.apply(pd.to_numeric)
+    print("Dati pre-processati. Colonne categoriche convertite in numeri.")
+
+
+    # 2. Addestramento del Modello Isolation Forest
+    model = IsolationForest(n_estimators=100, contamination='auto', random_state=42, n_jobs=-1)
 
-# Assicuriamoci che tutti i dati siano numerici
-df = df.apply(pd.to_numeric)
-print("Dati pre-processati. Colonne categoriche convertite in numeri.")
+    print("Inizio addestramento del modello Isolation Forest... (potrebbe richiedere un minuto)")
+    model.fit(df)
+    print("Addestramento completato.")
 
+    # 3. Salvataggio del Modello Addestrato
+    model_filename = '../services/inference/isolation_forest_model.joblib'
+    joblib.dump(model, model_filename)
+    print(f"Modello salvato con successo come '{model_filename}'")
 
-# 2. Addestramento del Modello Isolation Forest
-model = IsolationForest(n_estimators=100, contamination='auto', random_state=42, n_jobs=-1)
+    # 4. Esportazione per lo scoring in Go (pkg/iforest) e per il test di parità
+    import export_model  # Importato qui: export_model importa a sua volta i nomi delle colonne da questo file
+    export_model.export_forest(model, export_model.JSON_PATH, 'isolation_forest', export_model.model_version(model_filename), vocabulary['version'])
+    export_model.export_parity(model, vocabulary, "../KDDTest+.txt", export_model.PARITY_PATH)
 
-print("Inizio addestramento del modello Isolation Forest... (potrebbe richiedere un minuto)")
-model.fit(df)
-print("Addestramento completato.")
 
-# 3. Salvataggio del Modello Addestrato
-model_filename = '../services/inference/isolation_forest_model.joblib'
-joblib.dump(model, model_filename)
-print(f"Modello salvato con successo come '{model_filename}'")
\ No newline at end of file
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,32 @@
+"""Codifica delle colonne categoriche con il vocabolario condiviso pkg/features/vocabulary.json.
+
+Il vocabolario è lo stesso usato dai componenti Go: il codice di un valore è la sua posizione
+nella lista della colonna. Cambiare le liste richiede un nuovo 'version' e un nuovo addestramento.
+"""
+import json
+
+import pandas as pd
+
+VOCABULARY_PATH = '../pkg/features/vocabulary.json'
+
+
+def load_vocabulary(path=VOCABULARY_PATH):
+    with open(path) as f:
+        return json.load(f)
+
+
+def encode_categorical(df, vocabulary, columns):
+    """Sostituisce i valori testuali con i codici del vocabolario.
+
+    Restituisce il DataFrame codificato con le sole righe i cui valori sono tutti nel vocabolario,
+    e il numero di righe scartate.
+    """
+    known = pd.Series(True, index=df.index)
+    for col in columns:
+        known &= df[col].isin(vocabulary[col])
+    skipped = int((~known).sum())
+    df = df[known].copy()
+    for col in columns:
+        codes = {value: code for code, value in enumerate(vocabulary[col])}
+        df[col] = df[col].map(codes)
+    return df, skipped
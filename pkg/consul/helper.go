@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	consulapi "github.com/hashicorp/consul/api"
 )
 
-// RegisterService si registra al Consul locale
-func RegisterService(consulAddr, serviceName, serviceID string, servicePort int) *consulapi.Client {
+// RegisterService si registra al Consul locale. Con tlsServerName non vuoto il servizio
+// accetta solo connessioni mTLS: l'health check gRPC di Consul usa il TLS e verifica il
+// certificato per quel nome (il certificato client è quello dell'agent, vedi
+// enable_agent_tls_for_checks nella configurazione di Consul).
+func RegisterService(consulAddr, serviceName, serviceID string, servicePort int, tlsServerName string) *consulapi.Client {
 	config := consulapi.DefaultConfig()
 	config.Address = consulAddr
 	client, err := consulapi.NewClient(config)
@@ -31,6 +33,8 @@ func RegisterService(consulAddr, serviceName, serviceID string, servicePort int)
 			Interval:                       "10s",
 			Timeout:                        "1s",
 			DeregisterCriticalServiceAfter: "1m",
+			GRPCUseTLS:                     tlsServerName != "",
+			TLSServerName:                  tlsServerName,
 		},
 	}
 
@@ -43,75 +47,6 @@ func RegisterService(consulAddr, serviceName, serviceID string, servicePort int)
 	return client
 }
 
-// DiscoverService interroga Consul per trovare l'indirizzo di un altro servizio.
-// Utile per i servizi che necessitano di connettersi a un singleton.
-func DiscoverService(client *consulapi.Client, serviceName string) (string, error) {
-	const maxRetries = 15
-	var lastErr error
-
-	for i := 0; i < maxRetries; i++ {
-		// La funzione Health().Service() restituisce una lista di istanze sane
-		services, _, err := client.Health().Service(serviceName, "", true, nil)
-		if err != nil {
-			lastErr = fmt.Errorf("failed to query consul for service '%s': %w", serviceName, err)
-			log.Printf("Retrying discovery for '%s' in 2 seconds... (Attempt %d/%d)", serviceName, i+1, maxRetries)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-
-		// Se troviamo almeno un'istanza sana, prendiamo la prima e usciamo.
-		if len(services) > 0 {
-			// 'service' qui è di tipo *api.ServiceEntry
-			service := services[0].Service
-			addr := fmt.Sprintf("%s:%d", service.Address, service.Port)
-			log.Printf("Successfully discovered service '%s' at %s", serviceName, addr)
-			return addr, nil
-		}
-
-		lastErr = fmt.Errorf("no healthy instances found for service '%s'", serviceName)
-		log.Printf("Retrying discovery for '%s' in 2 seconds... (Attempt %d/%d)", serviceName, i+1, maxRetries)
-		time.Sleep(2 * time.Second)
-	}
-
-	return "", lastErr
-}
-
-// DiscoverAllServices interroga Consul per trovare TUTTI gli indirizzi sani di un servizio.
-// Questa è la funzione chiave per il load balancing.
-func DiscoverAllServices(client *consulapi.Client, serviceName string) ([]string, error) {
-	const maxRetries = 15
-	var lastErr error
-
-	for i := 0; i < maxRetries; i++ {
-		services, _, err := client.Health().Service(serviceName, "", true, nil)
-		if err != nil {
-			lastErr = fmt.Errorf("failed to query consul for service '%s': %w", serviceName, err)
-			log.Printf("Retrying discovery for '%s' in 2 seconds... (Attempt %d/%d)", serviceName, i+1, maxRetries)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-
-		if len(services) > 0 {
-			var addrs []string
-			// Qui cicliamo su TUTTE le istanze trovate
-			for _, serviceEntry := range services {
-				// 'serviceEntry' è di tipo *api.ServiceEntry, quindi accediamo a .Service
-				service := serviceEntry.Service
-				addr := fmt.Sprintf("%s:%d", service.Address, service.Port)
-				addrs = append(addrs, addr)
-			}
-			log.Printf("Successfully discovered %d healthy instances for service '%s': %v", len(addrs), serviceName, addrs)
-			return addrs, nil
-		}
-
-		lastErr = fmt.Errorf("no healthy instances found for service '%s'", serviceName)
-		log.Printf("Retrying discovery for '%s' in 2 seconds... (Attempt %d/%d)", serviceName, i+1, maxRetries)
-		time.Sleep(2 * time.Second)
-	}
-
-	return nil, lastErr
-}
-
 // DeregisterService si deregistra da Consul
 func DeregisterService(client *consulapi.Client, serviceID string) {
 	err := client.Agent().ServiceDeregister(serviceID)
@@ -0,0 +1,253 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// watchWaitTime è la durata massima di una blocking query verso Consul.
+	watchWaitTime = 5 * time.Minute
+	// watchMinBackoff e watchMaxBackoff limitano l'attesa tra due tentativi falliti.
+	watchMinBackoff = 1 * time.Second
+	watchMaxBackoff = 30 * time.Second
+)
+
+// ServiceEvent descrive un cambiamento nell'insieme delle istanze sane di un servizio.
+type ServiceEvent struct {
+	Service   string
+	Instances []string // Tutte le istanze sane dopo il cambiamento
+	Added     []string // Istanze comparse
+	Removed   []string // Istanze deregistrate o non più sane
+}
+
+// ServiceWatcher mantiene in memoria l'elenco aggiornato delle istanze sane di un servizio,
+// usando le blocking query di Consul invece di interrogarlo a ogni richiesta.
+// La lettura dell'elenco non blocca mai: in caso di errori verso Consul resta
+// valido l'ultimo elenco noto.
+type ServiceWatcher struct {
+	client      *consulapi.Client
+	serviceName string
+
+	mu          sync.RWMutex
+	instances   []string
+	subscribers []chan ServiceEvent
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// NewServiceWatcher crea un watcher per il servizio indicato. Il watcher non parte finché non si chiama Start.
+func NewServiceWatcher(client *consulapi.Client, serviceName string) *ServiceWatcher {
+	return &ServiceWatcher{
+		client:      client,
+		serviceName: serviceName,
+		ready:       make(chan struct{}),
+	}
+}
+
+// Start avvia in background il ciclo di blocking query, che termina quando il context viene cancellato.
+func (w *ServiceWatcher) Start(ctx context.Context) {
+	go w.run(ctx)
+}
+
+// Instances restituisce una copia dell'ultimo elenco noto di istanze sane, ordinato.
+func (w *ServiceWatcher) Instances() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]string(nil), w.instances...)
+}
+
+// Subscribe restituisce un canale su cui vengono pubblicati i cambiamenti di membership.
+// Il canale ha buffer unitario: un sottoscrittore lento riceve sempre l'ultimo evento,
+// perché gli eventi non ancora letti vengono sostituiti da quelli più recenti.
+func (w *ServiceWatcher) Subscribe() <-chan ServiceEvent {
+	ch := make(chan ServiceEvent, 1)
+	w.mu.Lock()
+	w.subscribers = append(w.subscribers, ch)
+	w.mu.Unlock()
+	return ch
+}
+
+// WaitReady attende il completamento della prima interrogazione a Consul.
+func (w *ServiceWatcher) WaitReady(ctx context.Context) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for first discovery of '%s': %w", w.serviceName, ctx.Err())
+	}
+}
+
+// WaitForInstance attende che sia disponibile almeno un'istanza sana e ne restituisce l'indirizzo.
+// A differenza di un ciclo di retry con sleep, ritorna appena Consul segnala un'istanza.
+func (w *ServiceWatcher) WaitForInstance(ctx context.Context) (string, error) {
+	if instances := w.Instances(); len(instances) > 0 {
+		return instances[0], nil
+	}
+	events := w.Subscribe()
+	defer w.unsubscribe(events)
+	for {
+		// Ricontrolliamo dopo la sottoscrizione per non perdere un cambiamento avvenuto nel frattempo
+		if instances := w.Instances(); len(instances) > 0 {
+			return instances[0], nil
+		}
+		select {
+		case <-events:
+		case <-ctx.Done():
+			return "", fmt.Errorf("no healthy instances found for service '%s': %w", w.serviceName, ctx.Err())
+		}
+	}
+}
+
+func (w *ServiceWatcher) unsubscribe(ch <-chan ServiceEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, sub := range w.subscribers {
+		if sub == ch {
+			w.subscribers = append(w.subscribers[:i], w.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *ServiceWatcher) run(ctx context.Context) {
+	var lastIndex uint64
+	backoff := watchMinBackoff
+
+	for {
+		opts := (&consulapi.QueryOptions{WaitIndex: lastIndex, WaitTime: watchWaitTime}).WithContext(ctx)
+		services, meta, err := w.client.Health().Service(w.serviceName, "", true, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Watch for service '%s' failed, retrying in %s: %v", w.serviceName, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > watchMaxBackoff {
+				backoff = watchMaxBackoff
+			}
+			continue
+		}
+		backoff = watchMinBackoff
+
+		// Se l'indice torna indietro (es. dopo un restore di Consul) ripartiamo da zero,
+		// come indicato dalla documentazione delle blocking query.
+		if meta.LastIndex < lastIndex {
+			lastIndex = 0
+		} else {
+			lastIndex = meta.LastIndex
+		}
+
+		addrs := make([]string, 0, len(services))
+		for _, serviceEntry := range services {
+			service := serviceEntry.Service
+			addrs = append(addrs, fmt.Sprintf("%s:%d", service.Address, service.Port))
+		}
+		w.update(addrs)
+		w.readyOnce.Do(func() { close(w.ready) })
+	}
+}
+
+// update sostituisce l'elenco delle istanze e, se è cambiato, notifica i sottoscrittori.
+func (w *ServiceWatcher) update(addrs []string) {
+	sort.Strings(addrs)
+
+	w.mu.Lock()
+	added, removed := diffInstances(w.instances, addrs)
+	if len(added) == 0 && len(removed) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	w.instances = addrs
+	subscribers := append([]chan ServiceEvent(nil), w.subscribers...)
+	w.mu.Unlock()
+
+	log.Printf("Healthy instances of '%s' changed: %v (added %v, removed %v)", w.serviceName, addrs, added, removed)
+	event := ServiceEvent{Service: w.serviceName, Instances: append([]string(nil), addrs...), Added: added, Removed: removed}
+	for _, ch := range subscribers {
+		publishLatest(ch, event)
+	}
+}
+
+// publishLatest invia l'evento senza bloccare, sostituendo un eventuale evento non ancora letto.
+// Instances contiene sempre lo stato completo, mentre Added e Removed vengono accumulati
+// con quelli dell'evento scartato, così il sottoscrittore non perde nessuna deregistrazione.
+func publishLatest(ch chan ServiceEvent, event ServiceEvent) {
+	for {
+		select {
+		case ch <- event:
+			return
+		default:
+		}
+		select {
+		case stale := <-ch:
+			event.Added, event.Removed = mergeChanges(stale, event)
+		default:
+		}
+	}
+}
+
+func mergeChanges(stale, latest ServiceEvent) (added, removed []string) {
+	current := make(map[string]struct{}, len(latest.Instances))
+	for _, addr := range latest.Instances {
+		current[addr] = struct{}{}
+	}
+	addedSet := make(map[string]struct{})
+	removedSet := make(map[string]struct{})
+	for _, addr := range append(stale.Added, latest.Added...) {
+		if _, ok := current[addr]; ok {
+			addedSet[addr] = struct{}{}
+		}
+	}
+	for _, addr := range append(stale.Removed, latest.Removed...) {
+		if _, ok := current[addr]; !ok {
+			removedSet[addr] = struct{}{}
+		}
+	}
+	return sortedKeys(addedSet), sortedKeys(removedSet)
+}
+
+// diffInstances calcola le istanze comparse e scomparse tra due elenchi.
+func diffInstances(before, after []string) (added, removed []string) {
+	beforeSet := make(map[string]struct{}, len(before))
+	for _, addr := range before {
+		beforeSet[addr] = struct{}{}
+	}
+	afterSet := make(map[string]struct{}, len(after))
+	for _, addr := range after {
+		afterSet[addr] = struct{}{}
+		if _, ok := beforeSet[addr]; !ok {
+			added = append(added, addr)
+		}
+	}
+	for _, addr := range before {
+		if _, ok := afterSet[addr]; !ok {
+			removed = append(removed, addr)
+		}
+	}
+	return added, removed
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
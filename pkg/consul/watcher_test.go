@@ -0,0 +1,185 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// fakeConsul simula l'endpoint /v1/health/service di Consul con il supporto alle blocking query:
+// una richiesta con index uguale a quello corrente resta in attesa finché le istanze non cambiano.
+type fakeConsul struct {
+	mu        sync.Mutex
+	index     uint64
+	instances []string
+	changed   chan struct{}
+}
+
+func newFakeConsul(instances ...string) *fakeConsul {
+	return &fakeConsul{index: 1, instances: instances, changed: make(chan struct{})}
+}
+
+func (f *fakeConsul) setInstances(instances ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.instances = instances
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	f.mu.Lock()
+	if waitIndex == f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(2 * time.Second):
+		}
+		f.mu.Lock()
+	}
+	index := f.index
+	entries := make([]*consulapi.ServiceEntry, 0, len(f.instances))
+	for _, addr := range f.instances {
+		host, portStr, _ := net.SplitHostPort(addr)
+		port, _ := strconv.Atoi(portStr)
+		entries = append(entries, &consulapi.ServiceEntry{Service: &consulapi.AgentService{Address: host, Port: port}})
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	json.NewEncoder(w).Encode(entries)
+}
+
+func newTestWatcher(t *testing.T, fake *fakeConsul) *ServiceWatcher {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	config := consulapi.DefaultConfig()
+	config.Address = srv.Listener.Addr().String()
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		t.Fatalf("Impossibile creare il client Consul: %v", err)
+	}
+
+	return NewServiceWatcher(client, "analysis-service")
+}
+
+func waitEvent(t *testing.T, events <-chan ServiceEvent) ServiceEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Nessun evento ricevuto dal watcher")
+		return ServiceEvent{}
+	}
+}
+
+func TestServiceWatcher_InitialDiscovery(t *testing.T) {
+	fake := newFakeConsul("analysis-2:50053", "analysis-1:50053")
+	w := newTestWatcher(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Start(ctx)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer waitCancel()
+	if err := w.WaitReady(waitCtx); err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+
+	expected := []string{"analysis-1:50053", "analysis-2:50053"}
+	if got := w.Instances(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Istanze attese %v, ricevute %v", expected, got)
+	}
+}
+
+func TestServiceWatcher_PublishesChanges(t *testing.T) {
+	fake := newFakeConsul("analysis-1:50053", "analysis-2:50053")
+	w := newTestWatcher(t, fake)
+	events := w.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Start(ctx)
+	waitEvent(t, events) // Prima scoperta
+
+	fake.setInstances("analysis-2:50053", "analysis-3:50053")
+	ev := waitEvent(t, events)
+
+	if !reflect.DeepEqual(ev.Added, []string{"analysis-3:50053"}) {
+		t.Errorf("Istanze aggiunte attese [analysis-3:50053], ricevute %v", ev.Added)
+	}
+	if !reflect.DeepEqual(ev.Removed, []string{"analysis-1:50053"}) {
+		t.Errorf("Istanze rimosse attese [analysis-1:50053], ricevute %v", ev.Removed)
+	}
+	if got := w.Instances(); !reflect.DeepEqual(got, []string{"analysis-2:50053", "analysis-3:50053"}) {
+		t.Errorf("Elenco delle istanze non aggiornato: %v", got)
+	}
+}
+
+func TestServiceWatcher_KeepsLastKnownInstancesWhenStopped(t *testing.T) {
+	fake := newFakeConsul("analysis-1:50053")
+	w := newTestWatcher(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := w.Subscribe()
+	w.Start(ctx)
+	waitEvent(t, events)
+	cancel()
+
+	if got := w.Instances(); !reflect.DeepEqual(got, []string{"analysis-1:50053"}) {
+		t.Errorf("Il watcher doveva mantenere l'ultimo elenco noto, ricevuto %v", got)
+	}
+}
+
+func TestServiceWatcher_WaitForInstance(t *testing.T) {
+	fake := newFakeConsul()
+	w := newTestWatcher(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Start(ctx)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		fake.setInstances("storage-1:50052")
+	}()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer waitCancel()
+	addr, err := w.WaitForInstance(waitCtx)
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if addr != "storage-1:50052" {
+		t.Errorf("Indirizzo atteso storage-1:50052, ricevuto %s", addr)
+	}
+}
+
+func TestPublishLatest_MergesUnreadEvents(t *testing.T) {
+	ch := make(chan ServiceEvent, 1)
+	publishLatest(ch, ServiceEvent{Instances: []string{"b"}, Added: []string{"b"}, Removed: []string{"a"}})
+	publishLatest(ch, ServiceEvent{Instances: []string{"c"}, Added: []string{"c"}, Removed: []string{"b"}})
+
+	ev := <-ch
+	if !reflect.DeepEqual(ev.Added, []string{"c"}) {
+		t.Errorf("Aggiunte attese [c], ricevute %v", ev.Added)
+	}
+	if !reflect.DeepEqual(ev.Removed, []string{"a", "b"}) {
+		t.Errorf("Rimozioni attese [a b], ricevute %v", ev.Removed)
+	}
+}
@@ -0,0 +1,135 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// State è lo stato di un ID in una Window.
+type State int
+
+const (
+	// New: l'ID non è stato visto nella finestra (o la sua elaborazione è fallita).
+	New State = iota
+	// Pending: un'altra richiesta con lo stesso ID è ancora in elaborazione.
+	Pending
+	// Done: una richiesta con lo stesso ID è già stata elaborata con successo.
+	Done
+)
+
+// Window ricorda gli ID (es. metric_id o chiavi di idempotenza) elaborati di recente, per
+// riconoscere le richieste ripetute da chi ritenta dopo un timeout. Un ID resta nella finestra
+// per ttl dal completamento; oltre maxIDs gli ID più vecchi vengono dimenticati anche prima.
+// La memoria resta quindi limitata, al prezzo di non riconoscere ripetizioni molto tardive.
+//
+// Una *Window nil non ricorda nulla e gli ID vuoti non vengono mai deduplicati.
+type Window struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	maxIDs int
+	ids    map[string]entry
+	order  []position // ID in ordine di inserimento, per la scadenza
+	seq    uint64
+	now    func() time.Time
+}
+
+type entry struct {
+	state  State
+	expiry time.Time
+	seq    uint64 // Posizione in order, che può contenere anche inserimenti precedenti dello stesso ID
+}
+
+type position struct {
+	id  string
+	seq uint64
+}
+
+// NewWindow crea una finestra che ricorda gli ID per ttl, fino a maxIDs.
+func NewWindow(ttl time.Duration, maxIDs int) *Window {
+	return &Window{ttl: ttl, maxIDs: maxIDs, ids: make(map[string]entry), now: time.Now}
+}
+
+// Begin registra l'inizio dell'elaborazione di id e ne restituisce lo stato precedente.
+// Solo con New il chiamante deve elaborare la richiesta e poi chiamare Finish o Abort.
+func (w *Window) Begin(id string) State {
+	if w == nil || id == "" {
+		return New
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	if e, ok := w.ids[id]; ok && now.Before(e.expiry) {
+		return e.state
+	}
+	// Un'elaborazione in corso non scade prima di ttl: se il chiamante non la chiude, l'ID torna libero
+	w.put(id, entry{state: Pending, expiry: now.Add(w.ttl)}, now)
+	return New
+}
+
+// Finish segna id come elaborato con successo: le richieste ripetute per ttl sono duplicati.
+func (w *Window) Finish(id string) {
+	if w == nil || id == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.put(id, entry{state: Done, expiry: now.Add(w.ttl)}, now)
+}
+
+// Abort dimentica id dopo un'elaborazione fallita, così una richiesta ripetuta viene elaborata.
+func (w *Window) Abort(id string) {
+	if w == nil || id == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.ids, id) // Resta in order: viene ignorato quando esce dalla finestra
+}
+
+// Seen indica se id è già stato elaborato con successo nella finestra.
+func (w *Window) Seen(id string) bool {
+	if w == nil || id == "" {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.ids[id]
+	return ok && e.state == Done && w.now().Before(e.expiry)
+}
+
+// Len restituisce il numero di ID ricordati.
+func (w *Window) Len() int {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.ids)
+}
+
+// put registra l'ID e fa uscire dalla finestra quelli scaduti o oltre il limite. Va chiamata con mu.
+func (w *Window) put(id string, e entry, now time.Time) {
+	if current, ok := w.ids[id]; ok {
+		e.seq = current.seq
+	} else {
+		w.seq++
+		e.seq = w.seq
+		w.order = append(w.order, position{id: id, seq: e.seq})
+	}
+	w.ids[id] = e
+
+	drop := 0
+	for ; drop < len(w.order); drop++ {
+		oldest := w.order[drop]
+		current, ok := w.ids[oldest.id]
+		if !ok || current.seq != oldest.seq {
+			continue // ID dimenticato con Abort o inserito di nuovo più avanti
+		}
+		if len(w.ids) <= w.maxIDs && now.Before(current.expiry) {
+			break
+		}
+		delete(w.ids, oldest.id)
+	}
+	w.order = w.order[drop:]
+}
@@ -0,0 +1,82 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock permette di far scorrere il tempo della finestra nei test.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestWindow(ttl time.Duration, maxIDs int) (*Window, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	w := NewWindow(ttl, maxIDs)
+	w.now = clock.now
+	return w, clock
+}
+
+func TestWindow_BeginFinishAbort(t *testing.T) {
+	w, _ := newTestWindow(time.Minute, 10)
+
+	if got := w.Begin("m1"); got != New {
+		t.Fatalf("Un ID nuovo deve essere elaborato, stato %v", got)
+	}
+	if got := w.Begin("m1"); got != Pending {
+		t.Errorf("Una ripetizione durante l'elaborazione deve risultare in corso, stato %v", got)
+	}
+	w.Finish("m1")
+	if got := w.Begin("m1"); got != Done || !w.Seen("m1") {
+		t.Errorf("Una ripetizione dopo il completamento è un duplicato, stato %v", got)
+	}
+
+	w.Begin("m2")
+	w.Abort("m2")
+	if got := w.Begin("m2"); got != New {
+		t.Errorf("Dopo un'elaborazione fallita la ripetizione va elaborata, stato %v", got)
+	}
+}
+
+func TestWindow_ForgetsExpiredAndOldestIDs(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 2)
+	w.Finish("a")
+	clock.t = clock.t.Add(30 * time.Second)
+	w.Finish("b")
+	clock.t = clock.t.Add(31 * time.Second)
+	if w.Seen("a") || !w.Seen("b") {
+		t.Errorf("Dopo il ttl l'ID deve essere dimenticato, gli altri no")
+	}
+
+	w.Finish("c")
+	w.Finish("d")
+	if w.Len() > 2 || w.Seen("b") || !w.Seen("d") {
+		t.Errorf("Oltre il limite devono essere dimenticati gli ID più vecchi (ricordati %d)", w.Len())
+	}
+}
+
+func TestWindow_ReinsertedIDIsNotEvictedByItsOldPosition(t *testing.T) {
+	w, _ := newTestWindow(time.Minute, 2)
+	w.Begin("a")
+	w.Abort("a")
+	w.Finish("b")
+	w.Finish("a") // "a" torna in fondo alla finestra
+	w.Finish("c") // Oltre il limite esce "b", non il nuovo "a"
+	if !w.Seen("a") || w.Seen("b") || !w.Seen("c") {
+		t.Errorf("La vecchia posizione di un ID reinserito non deve farlo dimenticare")
+	}
+}
+
+func TestWindow_NilAndEmptyIDs(t *testing.T) {
+	var w *Window
+	if w.Begin("a") != New || w.Seen("a") {
+		t.Errorf("Una finestra nil non deduplica")
+	}
+	w.Finish("a")
+
+	real := NewWindow(time.Minute, 10)
+	real.Finish("")
+	if real.Begin("") != New || real.Len() != 0 {
+		t.Errorf("Gli ID vuoti non vengono mai deduplicati")
+	}
+}
@@ -0,0 +1,152 @@
+package features
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSchema_NamesMatchPositions(t *testing.T) {
+	cases := map[string]int{"duration": Duration, "src_bytes": SrcBytes, "count": Count, "serror_rate": SerrorRate, "dst_host_srv_rerror_rate": DstHostSrvRerrorRate}
+	for name, want := range cases {
+		if got, ok := Index(name); !ok || got != want {
+			t.Errorf("Index(%q) = %d, atteso %d", name, got, want)
+		}
+	}
+	if DstHostSrvRerrorRate != NumFeatures-1 {
+		t.Errorf("Le costanti di posizione non coprono le %d feature", NumFeatures)
+	}
+	if _, ok := Index("label"); ok {
+		t.Errorf("L'etichetta non fa parte dello schema")
+	}
+}
+
+func TestVocabulary_CodesFollowTrainingOrder(t *testing.T) {
+	voc := Default()
+	// Stessi codici del LabelEncoder: ordine lessicografico byte per byte, maiuscole prima
+	cases := []struct {
+		index int
+		value string
+		code  float32
+	}{
+		{ProtocolType, "icmp", 0}, {ProtocolType, "tcp", 1}, {ProtocolType, "udp", 2},
+		{Service, "IRC", 0}, {Service, "aol", 3},
+		{Flag, "OTH", 0}, {Flag, "SF", 9},
+	}
+	for _, tc := range cases {
+		code, err := voc.Encode(tc.index, tc.value)
+		if err != nil || code != tc.code {
+			t.Errorf("Encode(%s, %q) = %g, %v; atteso %g", Names[tc.index], tc.value, code, err, tc.code)
+		}
+		if value, _ := voc.Decode(tc.index, tc.code); value != tc.value {
+			t.Errorf("Decode(%s, %g) = %q, atteso %q", Names[tc.index], tc.code, value, tc.value)
+		}
+	}
+	if _, err := voc.Encode(Service, "gopherz"); err == nil {
+		t.Errorf("Un servizio sconosciuto doveva essere rifiutato")
+	}
+	if _, err := ReadVocabulary(strings.NewReader(`{"version":"x","protocol_type":["udp","tcp"],"service":["a"],"flag":["b"]}`)); err == nil {
+		t.Errorf("Un vocabolario non ordinato doveva essere rifiutato")
+	}
+}
+
+func TestValidate_RejectsOutOfSchemaValues(t *testing.T) {
+	valid := make([]float32, NumFeatures)
+	if err := Validate(valid); err != nil {
+		t.Fatalf("Vettore valido rifiutato: %v", err)
+	}
+
+	cases := map[string]struct {
+		mutate func(v []float32)
+		code   string
+	}{
+		"negativo":          {func(v []float32) { v[SrcBytes] = -1 }, CodeNegative},
+		"non finito":        {func(v []float32) { v[Duration] = float32(math.Inf(1)) }, CodeNotFinite},
+		"NaN":               {func(v []float32) { v[Count] = float32(math.NaN()) }, CodeNotFinite},
+		"frazione":          {func(v []float32) { v[SerrorRate] = 1.5 }, CodeOutOfRange},
+		"binaria":           {func(v []float32) { v[LoggedIn] = 2 }, CodeOutOfRange},
+		"codice assente":    {func(v []float32) { v[Flag] = 11 }, CodeUnknownCategory},
+		"codice non intero": {func(v []float32) { v[ProtocolType] = 0.5 }, CodeUnknownCategory},
+	}
+	for name, tc := range cases {
+		v := make([]float32, NumFeatures)
+		tc.mutate(v)
+		var verr *ValidationError
+		if err := Validate(v); !errors.As(err, &verr) || verr.Index < 0 || verr.Code != tc.code {
+			t.Errorf("Valore %s: atteso un errore %s sulla feature, ricevuto %v", name, tc.code, err)
+		}
+	}
+	var verr *ValidationError
+	if err := Validate(make([]float32, 10)); !errors.As(err, &verr) || verr.Code != CodeFeatureCount {
+		t.Errorf("Un vettore incompleto doveva essere rifiutato, ricevuto %v", err)
+	}
+}
+
+// TestEncodeRecord_KDDTestPlus verifica che tutti i record del dataset di test rispettino lo schema.
+func TestEncodeRecord_KDDTestPlus(t *testing.T) {
+	f, err := os.Open("../../KDDTest+.txt")
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("KDDTest+.txt non disponibile")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '@'
+	rows := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Riga %d: %v", rows+1, err)
+		}
+		rows++
+		v, err := Default().EncodeRecord(record)
+		if err != nil {
+			t.Fatalf("Riga %d non conforme allo schema: %v", rows, err)
+		}
+		if rows == 1 && (v[ProtocolType] != 1 || v[Count] != 229) {
+			t.Errorf("Prima riga codificata in modo inatteso: %v", v)
+		}
+	}
+	if rows == 0 {
+		t.Errorf("Nessun record letto")
+	}
+}
+
+func TestRaw_RoundTripIsBitExact(t *testing.T) {
+	v := make([]float32, NumFeatures)
+	for i := range v {
+		v[i] = float32(i) / 3
+	}
+	v[SrcBytes] = 1.2345678e9
+	v[Duration] = float32(math.Copysign(0, -1))
+	v[Hot] = float32(math.NaN())
+
+	got, err := DecodeRaw(EncodeRaw(v))
+	if err != nil {
+		t.Fatalf("Decodifica fallita: %v", err)
+	}
+	if len(got) != len(v) {
+		t.Fatalf("Lunghezza %d, attesa %d", len(got), len(v))
+	}
+	for i := range v {
+		if math.Float32bits(got[i]) != math.Float32bits(v[i]) {
+			t.Errorf("Feature %s: bit diversi dopo la decodifica (%g invece di %g)", Names[i], got[i], v[i])
+		}
+	}
+
+	for _, invalid := range []string{"non base64!", "AAA="} {
+		if _, err := DecodeRaw(invalid); err == nil {
+			t.Errorf("%q doveva essere rifiutato", invalid)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/ANGEL0CADUTO/IDS_project/pkg/features
+
+go 1.23.11
@@ -0,0 +1,34 @@
+package features
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// EncodeRaw codifica un vettore di feature senza perdita di precisione: i float32 in little-endian,
+// in base64. Il risultato è adatto a un campo testuale e DecodeRaw restituisce esattamente gli stessi bit.
+func EncodeRaw(v []float32) string {
+	buf := make([]byte, 4*len(v))
+	for i, value := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// DecodeRaw ricostruisce un vettore codificato con EncodeRaw.
+func DecodeRaw(s string) ([]float32, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode raw features: %w", err)
+	}
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("decode raw features: %d bytes is not a whole number of float32", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
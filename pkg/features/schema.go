@@ -0,0 +1,152 @@
+package features
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// NumFeatures è il numero di feature di un record NSL-KDD (escluse etichetta e difficoltà).
+const NumFeatures = 41
+
+// Posizioni delle feature nel vettore, nell'ordine del dataset NSL-KDD e del modello addestrato.
+const (
+	Duration = iota
+	ProtocolType
+	Service
+	Flag
+	SrcBytes
+	DstBytes
+	Land
+	WrongFragment
+	Urgent
+	Hot
+	NumFailedLogins
+	LoggedIn
+	NumCompromised
+	RootShell
+	SuAttempted
+	NumRoot
+	NumFileCreations
+	NumShells
+	NumAccessFiles
+	NumOutboundCmds
+	IsHostLogin
+	IsGuestLogin
+	Count
+	SrvCount
+	SerrorRate
+	SrvSerrorRate
+	RerrorRate
+	SrvRerrorRate
+	SameSrvRate
+	DiffSrvRate
+	SrvDiffHostRate
+	DstHostCount
+	DstHostSrvCount
+	DstHostSameSrvRate
+	DstHostDiffSrvRate
+	DstHostSameSrcPortRate
+	DstHostSrvDiffHostRate
+	DstHostSerrorRate
+	DstHostSrvSerrorRate
+	DstHostRerrorRate
+	DstHostSrvRerrorRate
+)
+
+// Names sono i nomi canonici delle feature, nello stesso ordine delle costanti di posizione.
+var Names = [NumFeatures]string{
+	"duration", "protocol_type", "service", "flag", "src_bytes", "dst_bytes", "land", "wrong_fragment",
+	"urgent", "hot", "num_failed_logins", "logged_in", "num_compromised", "root_shell", "su_attempted",
+	"num_root", "num_file_creations", "num_shells", "num_access_files", "num_outbound_cmds",
+	"is_host_login", "is_guest_login", "count", "srv_count", "serror_rate", "srv_serror_rate",
+	"rerror_rate", "srv_rerror_rate", "same_srv_rate", "diff_srv_rate", "srv_diff_host_rate",
+	"dst_host_count", "dst_host_srv_count", "dst_host_same_srv_rate", "dst_host_diff_srv_rate",
+	"dst_host_same_src_port_rate", "dst_host_srv_diff_host_rate", "dst_host_serror_rate",
+	"dst_host_srv_serror_rate", "dst_host_rerror_rate", "dst_host_srv_rerror_rate",
+}
+
+var indexes = func() map[string]int {
+	m := make(map[string]int, NumFeatures)
+	for i, name := range Names {
+		m[name] = i
+	}
+	return m
+}()
+
+// Index restituisce la posizione della feature con il nome indicato.
+func Index(name string) (int, bool) {
+	i, ok := indexes[name]
+	return i, ok
+}
+
+// Categorical sono le feature testuali, codificate con il vocabolario condiviso con l'addestramento.
+var Categorical = []int{ProtocolType, Service, Flag}
+
+// binaryFlags sono le feature che valgono solo 0 o 1.
+var binaryFlags = map[int]bool{Land: true, LoggedIn: true, RootShell: true, IsHostLogin: true, IsGuestLogin: true}
+
+// IsCategorical indica se la feature in posizione i è codificata con il vocabolario.
+func IsCategorical(i int) bool {
+	return i == ProtocolType || i == Service || i == Flag
+}
+
+// IsRate indica se la feature in posizione i è una frazione in [0, 1].
+func IsRate(i int) bool {
+	return strings.HasSuffix(Names[i], "_rate")
+}
+
+// Codici dei motivi di rifiuto di un vettore, stabili per chi li conta o li filtra
+// (es. la quarantena del collector).
+const (
+	CodeFeatureCount    = "feature_count"
+	CodeNotFinite       = "not_finite"
+	CodeNegative        = "negative_value"
+	CodeOutOfRange      = "out_of_range"
+	CodeUnknownCategory = "unknown_category"
+)
+
+// ValidationError descrive la prima feature non valida di un vettore.
+type ValidationError struct {
+	Index  int    // Posizione della feature, -1 se il problema è la lunghezza del vettore
+	Code   string // Uno dei codici Code*
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Index < 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("feature %s (%d): %s", Names[e.Index], e.Index, e.Reason)
+}
+
+// Validate verifica che il vettore rispetti lo schema: 41 valori finiti e non negativi,
+// frazioni entro [0, 1], flag binari a 0 o 1 e codici categorici presenti nel vocabolario.
+func (voc *Vocabulary) Validate(v []float32) error {
+	if len(v) != NumFeatures {
+		return &ValidationError{Index: -1, Code: CodeFeatureCount, Reason: fmt.Sprintf("expected %d features, got %d", NumFeatures, len(v))}
+	}
+	for i, value := range v {
+		x := float64(value)
+		switch {
+		case math.IsNaN(x) || math.IsInf(x, 0):
+			return &ValidationError{Index: i, Code: CodeNotFinite, Reason: "value is not finite"}
+		case x < 0:
+			return &ValidationError{Index: i, Code: CodeNegative, Reason: fmt.Sprintf("negative value %g", x)}
+		case IsCategorical(i):
+			if x != math.Trunc(x) || int(x) >= len(voc.values(i)) {
+				return &ValidationError{Index: i, Code: CodeUnknownCategory, Reason: fmt.Sprintf("code %g is not in vocabulary %s", x, voc.Version)}
+			}
+		case IsRate(i) && x > 1:
+			return &ValidationError{Index: i, Code: CodeOutOfRange, Reason: fmt.Sprintf("rate %g is greater than 1", x)}
+		case binaryFlags[i] && x != 0 && x != 1:
+			return &ValidationError{Index: i, Code: CodeOutOfRange, Reason: fmt.Sprintf("flag %g is not 0 or 1", x)}
+		}
+	}
+	return nil
+}
+
+// Validate verifica il vettore con il vocabolario predefinito.
+func Validate(v []float32) error {
+	return Default().Validate(v)
+}
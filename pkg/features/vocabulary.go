@@ -0,0 +1,142 @@
+package features
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// vocabularyJSON è il vocabolario usato anche da ml-training: le posizioni dei valori nelle liste
+// sono i codici delle feature categoriche. Le liste sono in ordine lessicografico (byte per byte),
+// così i codici coincidono con quelli del LabelEncoder di scikit-learn usato per i modelli precedenti.
+//
+//go:embed vocabulary.json
+var vocabularyJSON []byte
+
+// Vocabulary associa i valori testuali delle feature categoriche ai codici numerici del modello.
+// Cambiare una lista richiede un nuovo Version e un nuovo addestramento.
+type Vocabulary struct {
+	Version      string   `json:"version"`
+	ProtocolType []string `json:"protocol_type"`
+	Service      []string `json:"service"`
+	Flag         []string `json:"flag"`
+	codes        map[int]map[string]float32
+}
+
+var (
+	defaultOnce       sync.Once
+	defaultVocabulary *Vocabulary
+)
+
+// Default restituisce il vocabolario distribuito con il pacchetto.
+func Default() *Vocabulary {
+	defaultOnce.Do(func() {
+		voc, err := ReadVocabulary(bytes.NewReader(vocabularyJSON))
+		if err != nil {
+			panic(fmt.Sprintf("features: embedded vocabulary is invalid: %v", err)) // Il file è parte del pacchetto: è un bug
+		}
+		defaultVocabulary = voc
+	})
+	return defaultVocabulary
+}
+
+// ReadVocabulary legge e valida un vocabolario in formato JSON.
+func ReadVocabulary(r io.Reader) (*Vocabulary, error) {
+	var voc Vocabulary
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&voc); err != nil {
+		return nil, fmt.Errorf("decode vocabulary: %w", err)
+	}
+	if voc.Version == "" {
+		return nil, fmt.Errorf("vocabulary has no version")
+	}
+	voc.codes = make(map[int]map[string]float32, len(Categorical))
+	for _, i := range Categorical {
+		values := voc.values(i)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("vocabulary has no values for %s", Names[i])
+		}
+		codes := make(map[string]float32, len(values))
+		for code, value := range values {
+			if code > 0 && values[code-1] >= value {
+				return nil, fmt.Errorf("values for %s must be sorted and unique (%q after %q)", Names[i], value, values[code-1])
+			}
+			codes[value] = float32(code)
+		}
+		voc.codes[i] = codes
+	}
+	return &voc, nil
+}
+
+func (voc *Vocabulary) values(i int) []string {
+	switch i {
+	case ProtocolType:
+		return voc.ProtocolType
+	case Service:
+		return voc.Service
+	case Flag:
+		return voc.Flag
+	}
+	return nil
+}
+
+// Encode restituisce il codice del valore della feature categorica in posizione i.
+func (voc *Vocabulary) Encode(i int, value string) (float32, error) {
+	codes, ok := voc.codes[i]
+	if !ok {
+		return 0, fmt.Errorf("feature %s is not categorical", Names[i])
+	}
+	code, ok := codes[value]
+	if !ok {
+		return 0, fmt.Errorf("unknown %s %q in vocabulary %s", Names[i], value, voc.Version)
+	}
+	return code, nil
+}
+
+// Decode restituisce il valore testuale del codice della feature categorica in posizione i.
+func (voc *Vocabulary) Decode(i int, code float32) (string, error) {
+	values := voc.values(i)
+	if values == nil {
+		return "", fmt.Errorf("feature %s is not categorical", Names[i])
+	}
+	if code < 0 || float64(code) != math.Trunc(float64(code)) || int(code) >= len(values) {
+		return "", fmt.Errorf("code %g for %s is not in vocabulary %s", code, Names[i], voc.Version)
+	}
+	return values[int(code)], nil
+}
+
+// EncodeRecord converte un record testuale NSL-KDD nel vettore del modello e lo valida.
+// Le colonne oltre le 41 feature (etichetta e difficoltà) vengono ignorate.
+func (voc *Vocabulary) EncodeRecord(record []string) ([]float32, error) {
+	if len(record) < NumFeatures {
+		return nil, fmt.Errorf("record has %d fields, expected at least %d", len(record), NumFeatures)
+	}
+	v := make([]float32, NumFeatures)
+	for i := 0; i < NumFeatures; i++ {
+		field := strings.TrimSpace(record[i])
+		if IsCategorical(i) {
+			code, err := voc.Encode(i, field)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = code
+			continue
+		}
+		x, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, fmt.Errorf("feature %s: invalid number %q", Names[i], field)
+		}
+		v[i] = float32(x)
+	}
+	if err := voc.Validate(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,180 @@
+package hashring
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes è il numero di nodi virtuali usato se non specificato.
+// Con 100 nodi virtuali per istanza il carico resta bilanciato entro pochi punti percentuali.
+const DefaultVirtualNodes = 100
+
+// Ring è un anello di hashing consistente con nodi virtuali.
+// Ogni nodo fisico (es. l'indirizzo di un'istanza di analisi) viene proiettato
+// sull'anello in più punti: quando un nodo entra o esce, solo le chiavi che
+// cadono nei suoi segmenti cambiano proprietario (circa 1/N del totale).
+type Ring struct {
+	mu           sync.RWMutex
+	virtualNodes int
+	points       []uint64          // Posizioni dei nodi virtuali, ordinate
+	owners       map[uint64]string // Posizione -> nodo fisico
+	nodes        map[string]struct{}
+}
+
+// New crea un anello vuoto con il numero di nodi virtuali indicato per ogni nodo fisico.
+func New(virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	return &Ring{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint64]string),
+		nodes:        make(map[string]struct{}),
+	}
+}
+
+// Add inserisce un nodo nell'anello. Aggiungere un nodo già presente non ha effetto.
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+	r.nodes[node] = struct{}{}
+	r.rebuild()
+}
+
+// Remove toglie un nodo dall'anello insieme a tutti i suoi nodi virtuali.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+	r.rebuild()
+}
+
+// Set sostituisce la membership dell'anello con l'insieme di nodi indicato.
+// Restituisce true se la membership è cambiata.
+func (r *Ring) Set(nodes []string) bool {
+	wanted := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		wanted[n] = struct{}{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := len(wanted) != len(r.nodes)
+	for n := range wanted {
+		if _, ok := r.nodes[n]; !ok {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false
+	}
+	r.nodes = wanted
+	r.rebuild()
+	return true
+}
+
+// Get restituisce il nodo proprietario della chiave.
+func (r *Ring) Get(key string) (string, bool) {
+	owners := r.GetN(key, 1)
+	if len(owners) == 0 {
+		return "", false
+	}
+	return owners[0], true
+}
+
+// GetN restituisce fino a n nodi fisici distinti per la chiave, in ordine di preferenza:
+// il primo è il proprietario, i successivi sono le repliche incontrate proseguendo sull'anello.
+func (r *Ring) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+
+	result := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for i := 0; i < len(r.points) && len(result) < n; i++ {
+		owner := r.owners[r.points[(start+i)%len(r.points)]]
+		if _, ok := seen[owner]; ok {
+			continue
+		}
+		seen[owner] = struct{}{}
+		result = append(result, owner)
+	}
+	return result
+}
+
+// Nodes restituisce i nodi fisici presenti nell'anello, in ordine alfabetico.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for n := range r.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Len restituisce il numero di nodi fisici nell'anello.
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes)
+}
+
+// rebuild ricalcola le posizioni di tutti i nodi virtuali a partire dai nodi fisici.
+// In caso (rarissimo) di collisione vince il nodo con il nome minore,
+// così il risultato non dipende dall'ordine con cui i nodi sono stati aggiunti.
+func (r *Ring) rebuild() {
+	r.owners = make(map[uint64]string, len(r.nodes)*r.virtualNodes)
+	for node := range r.nodes {
+		for i := 0; i < r.virtualNodes; i++ {
+			p := hashKey(node + "#" + strconv.Itoa(i))
+			if existing, ok := r.owners[p]; ok && existing < node {
+				continue
+			}
+			r.owners[p] = node
+		}
+	}
+
+	r.points = make([]uint64, 0, len(r.owners))
+	for p := range r.owners {
+		r.points = append(r.points, p)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// hashKey calcola la posizione di una chiave sull'anello.
+// FNV-1a da solo distribuisce male chiavi molto simili (es. "addr#1", "addr#2"),
+// quindi il risultato passa per il finalizzatore di splitmix64.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := binary.BigEndian.Uint64(h.Sum(nil))
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
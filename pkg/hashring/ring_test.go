@@ -0,0 +1,202 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testKeys = 10000
+
+func clientIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("concurrent-client-%d", i)
+	}
+	return ids
+}
+
+func instanceAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("analysis-%d:50053", i)
+	}
+	return addrs
+}
+
+// owners calcola il proprietario di ogni chiave.
+func owners(r *Ring, keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, k := range keys {
+		owner, _ := r.Get(k)
+		m[k] = owner
+	}
+	return m
+}
+
+// remapped conta quante chiavi hanno cambiato proprietario.
+func remapped(before, after map[string]string) int {
+	moved := 0
+	for k, owner := range before {
+		if after[k] != owner {
+			moved++
+		}
+	}
+	return moved
+}
+
+func TestRing_EmptyRing(t *testing.T) {
+	r := New(DefaultVirtualNodes)
+	if _, ok := r.Get("client"); ok {
+		t.Errorf("Un anello vuoto non doveva restituire alcun proprietario")
+	}
+	if got := r.GetN("client", 3); len(got) != 0 {
+		t.Errorf("Un anello vuoto non doveva restituire repliche, ricevute %v", got)
+	}
+}
+
+func TestRing_IsDeterministic(t *testing.T) {
+	a := New(DefaultVirtualNodes)
+	b := New(DefaultVirtualNodes)
+	addrs := instanceAddrs(4)
+	a.Set(addrs)
+	// Ordine di inserimento diverso: il risultato non deve cambiare
+	for i := len(addrs) - 1; i >= 0; i-- {
+		b.Add(addrs[i])
+	}
+
+	keys := clientIDs(1000)
+	if moved := remapped(owners(a, keys), owners(b, keys)); moved != 0 {
+		t.Errorf("Due anelli con la stessa membership dovevano essere identici, %d chiavi differiscono", moved)
+	}
+}
+
+func TestRing_AddingNodeRemapsAboutOneNth(t *testing.T) {
+	keys := clientIDs(testKeys)
+	for _, n := range []int{2, 3, 5, 10} {
+		t.Run(fmt.Sprintf("%d->%d", n, n+1), func(t *testing.T) {
+			r := New(DefaultVirtualNodes)
+			r.Set(instanceAddrs(n))
+			before := owners(r, keys)
+
+			newNode := fmt.Sprintf("analysis-%d:50053", n)
+			r.Add(newNode)
+			after := owners(r, keys)
+
+			moved := remapped(before, after)
+			expected := float64(testKeys) / float64(n+1)
+			t.Logf("%d/%d chiavi rimappate (atteso ~%.0f)", moved, testKeys, expected)
+
+			// Ogni chiave spostata deve essere finita sul nuovo nodo
+			for k, owner := range before {
+				if after[k] != owner && after[k] != newNode {
+					t.Fatalf("La chiave %s si è spostata da %s a %s invece che sul nuovo nodo", k, owner, after[k])
+				}
+			}
+			if float64(moved) > expected*1.5 || float64(moved) < expected*0.5 {
+				t.Errorf("Rimappate %d chiavi, attese circa %.0f", moved, expected)
+			}
+		})
+	}
+}
+
+func TestRing_RemovingNodeOnlyRemapsItsKeys(t *testing.T) {
+	keys := clientIDs(testKeys)
+	r := New(DefaultVirtualNodes)
+	addrs := instanceAddrs(5)
+	r.Set(addrs)
+	before := owners(r, keys)
+
+	removed := addrs[2]
+	r.Remove(removed)
+	after := owners(r, keys)
+
+	moved := remapped(before, after)
+	owned := 0
+	for k, owner := range before {
+		if owner == removed {
+			owned++
+			continue
+		}
+		if after[k] != owner {
+			t.Fatalf("La chiave %s non apparteneva al nodo rimosso ma si è spostata", k)
+		}
+	}
+	t.Logf("%d/%d chiavi rimappate", moved, testKeys)
+	if moved != owned {
+		t.Errorf("Dovevano spostarsi solo le %d chiavi del nodo rimosso, se ne sono spostate %d", owned, moved)
+	}
+}
+
+func TestRing_ModuloHashingRemapsMostKeys(t *testing.T) {
+	// Confronto con la vecchia strategia hash % N usata dal collector:
+	// passando da 4 a 5 istanze la maggior parte dei client cambia istanza.
+	keys := clientIDs(testKeys)
+	modulo := func(n int) map[string]string {
+		m := make(map[string]string, len(keys))
+		for _, k := range keys {
+			m[k] = fmt.Sprint(hashKey(k) % uint64(n))
+		}
+		return m
+	}
+	moduloMoved := remapped(modulo(4), modulo(5))
+
+	r := New(DefaultVirtualNodes)
+	r.Set(instanceAddrs(4))
+	before := owners(r, keys)
+	r.Set(instanceAddrs(5))
+	ringMoved := remapped(before, owners(r, keys))
+
+	t.Logf("hash %% N: %d chiavi rimappate, anello: %d", moduloMoved, ringMoved)
+	if ringMoved*2 >= moduloMoved {
+		t.Errorf("L'anello doveva rimappare molte meno chiavi dell'hash modulo (%d vs %d)", ringMoved, moduloMoved)
+	}
+}
+
+func TestRing_BalancedLoad(t *testing.T) {
+	keys := clientIDs(testKeys)
+	r := New(DefaultVirtualNodes)
+	r.Set(instanceAddrs(5))
+
+	load := make(map[string]int)
+	for _, owner := range owners(r, keys) {
+		load[owner]++
+	}
+	expected := testKeys / 5
+	for node, count := range load {
+		if count < expected/2 || count > expected*3/2 {
+			t.Errorf("Carico sbilanciato sul nodo %s: %d chiavi (atteso ~%d)", node, count, expected)
+		}
+	}
+}
+
+func TestRing_GetNReturnsDistinctReplicas(t *testing.T) {
+	r := New(DefaultVirtualNodes)
+	r.Set(instanceAddrs(3))
+
+	replicas := r.GetN("concurrent-client-1", 2)
+	if len(replicas) != 2 {
+		t.Fatalf("Attese 2 repliche, ricevute %v", replicas)
+	}
+	if replicas[0] == replicas[1] {
+		t.Errorf("Le repliche devono essere nodi distinti, ricevute %v", replicas)
+	}
+	if owner, _ := r.Get("concurrent-client-1"); owner != replicas[0] {
+		t.Errorf("La prima replica deve essere il proprietario (%s), ricevuta %s", owner, replicas[0])
+	}
+	if got := r.GetN("concurrent-client-1", 10); len(got) != 3 {
+		t.Errorf("Con 3 nodi GetN deve restituire al massimo 3 repliche, ricevute %v", got)
+	}
+}
+
+func TestRing_SetReportsMembershipChanges(t *testing.T) {
+	r := New(DefaultVirtualNodes)
+	if !r.Set(instanceAddrs(2)) {
+		t.Errorf("Il primo Set doveva modificare la membership")
+	}
+	if r.Set(instanceAddrs(2)) {
+		t.Errorf("Un Set con gli stessi nodi non doveva modificare la membership")
+	}
+	if !r.Set(instanceAddrs(3)) {
+		t.Errorf("Un Set con un nodo in più doveva modificare la membership")
+	}
+}
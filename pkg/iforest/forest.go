@@ -0,0 +1,177 @@
+package iforest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Format è l'identificatore del formato JSON prodotto da ml-training/export_model.py.
+const Format = "ids-iforest/v1"
+
+// leaf è il valore dei figli di un nodo foglia nel formato di scikit-learn (TREE_LEAF).
+const leaf = -1
+
+// Model è un Isolation Forest esportato da scikit-learn, valutabile senza il servizio Python.
+// Il punteggio coincide con IsolationForest.decision_function: negativo per le anomalie.
+type Model struct {
+	Format  string `json:"format"`
+	Name    string `json:"model_name"`
+	Version string `json:"model_version"`
+	// VocabularyVersion è la versione del vocabolario categorico (pkg/features) usata in addestramento.
+	VocabularyVersion string  `json:"vocabulary_version,omitempty"`
+	NumFeatures       int     `json:"n_features"`
+	MaxSamples        int     `json:"max_samples"` // Campioni usati per costruire ogni albero
+	Offset            float64 `json:"offset"`      // offset_ del modello: decision_function = score_samples - offset
+	Trees             []Tree  `json:"trees"`
+	avgPathTotal      float64 // Normalizzazione delle profondità: numero di alberi * c(MaxSamples)
+}
+
+// Tree è un albero di isolamento nella rappresentazione a vettori paralleli di scikit-learn:
+// il nodo i ha figli Left[i] e Right[i] (-1 per le foglie) e divide su Feature[i] <= Threshold[i].
+type Tree struct {
+	// Features, se presente, associa le colonne viste dall'albero a quelle del vettore completo
+	// (solo se il modello è stato addestrato con max_features < 1).
+	Features  []int     `json:"features,omitempty"`
+	Left      []int     `json:"children_left"`
+	Right     []int     `json:"children_right"`
+	Feature   []int     `json:"feature"`
+	Threshold []float64 `json:"threshold"`
+	Samples   []int     `json:"n_node_samples"`
+	// pathLength[i] è la profondità di isolamento stimata per le foglie: archi dalla radice più c(Samples[i]).
+	pathLength []float64
+}
+
+// Load legge un modello dal file JSON indicato.
+func Load(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read legge e valida un modello in formato JSON.
+func Read(r io.Reader) (*Model, error) {
+	var m Model
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode model: %w", err)
+	}
+	if m.Format != Format {
+		return nil, fmt.Errorf("unsupported model format %q (expected %q)", m.Format, Format)
+	}
+	if m.NumFeatures <= 0 || m.MaxSamples <= 0 || len(m.Trees) == 0 {
+		return nil, fmt.Errorf("model needs n_features, max_samples and at least one tree")
+	}
+	for i := range m.Trees {
+		if err := m.Trees[i].prepare(m.NumFeatures); err != nil {
+			return nil, fmt.Errorf("tree %d: %w", i, err)
+		}
+	}
+	m.avgPathTotal = float64(len(m.Trees)) * averagePathLength(m.MaxSamples)
+	return &m, nil
+}
+
+// prepare valida la struttura dell'albero e precalcola la profondità di ogni foglia.
+func (t *Tree) prepare(numFeatures int) error {
+	n := len(t.Left)
+	if n == 0 || len(t.Right) != n || len(t.Feature) != n || len(t.Threshold) != n || len(t.Samples) != n {
+		return fmt.Errorf("node arrays must be non-empty and of the same length")
+	}
+	columns := numFeatures
+	if len(t.Features) > 0 {
+		columns = len(t.Features)
+		for _, f := range t.Features {
+			if f < 0 || f >= numFeatures {
+				return fmt.Errorf("feature index %d out of range", f)
+			}
+		}
+	}
+
+	t.pathLength = make([]float64, n)
+	depth := make([]int, n)
+	visited := make([]bool, n)
+	stack := []int{0}
+	visited[0] = true
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		left, right := t.Left[node], t.Right[node]
+		if left == leaf || right == leaf {
+			if left != right {
+				return fmt.Errorf("node %d has only one child", node)
+			}
+			t.pathLength[node] = float64(depth[node]) + averagePathLength(t.Samples[node])
+			continue
+		}
+		if t.Feature[node] < 0 || t.Feature[node] >= columns {
+			return fmt.Errorf("node %d splits on feature %d, out of range", node, t.Feature[node])
+		}
+		for _, child := range []int{left, right} {
+			// Ogni nodo deve avere un solo genitore: esclude cicli e nodi condivisi
+			if child <= 0 || child >= n || visited[child] {
+				return fmt.Errorf("node %d has invalid child %d", node, child)
+			}
+			visited[child] = true
+			depth[child] = depth[node] + 1
+			stack = append(stack, child)
+		}
+	}
+	return nil
+}
+
+// isolationDepth restituisce la profondità di isolamento di x nell'albero.
+func (t *Tree) isolationDepth(x []float32) float64 {
+	node := 0
+	for t.Left[node] != leaf {
+		column := t.Feature[node]
+		if len(t.Features) > 0 {
+			column = t.Features[column]
+		}
+		// Come scikit-learn: le feature sono float32, confrontate con soglie float64
+		if float64(x[column]) <= t.Threshold[node] {
+			node = t.Left[node]
+		} else {
+			node = t.Right[node]
+		}
+	}
+	return t.pathLength[node]
+}
+
+// Score restituisce il punteggio di x, equivalente a decision_function di scikit-learn.
+func (m *Model) Score(x []float32) (float64, error) {
+	if len(x) != m.NumFeatures {
+		return 0, fmt.Errorf("expected %d features, got %d", m.NumFeatures, len(x))
+	}
+	var depth float64
+	for i := range m.Trees {
+		depth += m.Trees[i].isolationDepth(x)
+	}
+	// score_samples è l'opposto del punteggio di anomalia 2^(-E[h(x)]/c(max_samples))
+	return -math.Pow(2, -depth/m.avgPathTotal) - m.Offset, nil
+}
+
+// Predict restituisce -1 per le anomalie e 1 per le metriche normali, come IsolationForest.predict.
+func Predict(score float64) int32 {
+	if score < 0 {
+		return -1
+	}
+	return 1
+}
+
+// averagePathLength è la lunghezza media di un cammino di ricerca senza successo in un albero
+// binario di ricerca con n elementi: stima la profondità ancora necessaria per isolare un punto
+// in una foglia che contiene n campioni.
+func averagePathLength(n int) float64 {
+	switch {
+	case n <= 1:
+		return 0
+	case n == 2:
+		return 1
+	}
+	const eulerGamma = 0.5772156649015329
+	return 2*(math.Log(float64(n-1))+eulerGamma) - 2*float64(n-1)/float64(n)
+}
@@ -0,0 +1,78 @@
+package iforest
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// tinyForest è un albero su due feature: x0 <= 0.5 isola subito (1 campione), altrimenti
+// x1 <= 2 separa una foglia da 2 campioni e una da 5.
+const tinyForest = `{
+  "format": "ids-iforest/v1",
+  "model_name": "tiny",
+  "model_version": "1",
+  "n_features": 2,
+  "max_samples": 8,
+  "offset": -0.5,
+  "trees": [{
+    "children_left":  [1, -1, 3, -1, -1],
+    "children_right": [2, -1, 4, -1, -1],
+    "feature":        [0, -2, 1, -2, -2],
+    "threshold":      [0.5, -2, 2, -2, -2],
+    "n_node_samples": [8, 1, 7, 2, 5]
+  }]
+}`
+
+func TestModel_ScoreMatchesIsolationForestDefinition(t *testing.T) {
+	m, err := Read(strings.NewReader(tinyForest))
+	if err != nil {
+		t.Fatalf("Modello valido rifiutato: %v", err)
+	}
+
+	c := func(n float64) float64 { return 2*(math.Log(n-1)+0.5772156649015329) - 2*(n-1)/n }
+	cases := []struct {
+		x     []float32
+		depth float64
+	}{
+		{[]float32{0, 0}, 1},        // Foglia con un campione: nessuna correzione
+		{[]float32{1, 1}, 2 + 1},    // Foglia con due campioni: c(2) = 1
+		{[]float32{1, 3}, 2 + c(5)}, // Foglia con cinque campioni
+		{[]float32{0.5, 9}, 1},      // La soglia è inclusiva: x0 = 0.5 va a sinistra
+	}
+	for _, tc := range cases {
+		want := -math.Pow(2, -tc.depth/c(8)) + 0.5
+		got, err := m.Score(tc.x)
+		if err != nil {
+			t.Fatalf("Score(%v): %v", tc.x, err)
+		}
+		if math.Abs(got-want) > 1e-12 {
+			t.Errorf("Score(%v) = %.15f, atteso %.15f", tc.x, got, want)
+		}
+	}
+
+	// Il punto isolato al primo taglio è il più anomalo
+	isolated, _ := m.Score([]float32{0, 0})
+	dense, _ := m.Score([]float32{1, 3})
+	if Predict(isolated) != -1 || Predict(dense) != 1 {
+		t.Errorf("Predizioni inattese: isolato %.3f, denso %.3f", isolated, dense)
+	}
+	if _, err := m.Score([]float32{1}); err == nil {
+		t.Errorf("Un vettore con un numero di feature sbagliato doveva essere rifiutato")
+	}
+}
+
+func TestRead_RejectsMalformedModels(t *testing.T) {
+	cases := map[string]string{
+		"formato":        strings.Replace(tinyForest, "ids-iforest/v1", "other/v9", 1),
+		"figlio":         strings.Replace(tinyForest, "[1, -1, 3, -1, -1]", "[1, -1, 1, -1, -1]", 1),
+		"feature":        strings.Replace(tinyForest, "[0, -2, 1, -2, -2]", "[0, -2, 7, -2, -2]", 1),
+		"lunghezze":      strings.Replace(tinyForest, "[8, 1, 7, 2, 5]", "[8, 1, 7]", 1),
+		"un solo figlio": strings.Replace(tinyForest, "[2, -1, 4, -1, -1]", "[-1, -1, 4, -1, -1]", 1),
+	}
+	for name, source := range cases {
+		if _, err := Read(strings.NewReader(source)); err == nil {
+			t.Errorf("Modello con %s non valido accettato", name)
+		}
+	}
+}
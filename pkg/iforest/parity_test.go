@@ -0,0 +1,94 @@
+package iforest
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// File prodotti da ml-training/export_model.py a partire dal modello .joblib del servizio di inferenza
+// (make export-model, o la fase exporter del Dockerfile di analysis). Sono generati e non versionati:
+// senza di essi il test viene saltato, tranne con IFOREST_PARITY_REQUIRED impostata (make test-unit e
+// la build di analysis), dove la parità con scikit-learn è la garanzia su cui si basa il fallback locale.
+const (
+	exportedModelPath = "../../services/inference/isolation_forest_model.json"
+	parityPath        = "testdata/kddtest_parity.csv"
+)
+
+// missingArtifact salta il test se un file generato manca, o lo fa fallire se la parità è obbligatoria.
+func missingArtifact(t *testing.T, path string) {
+	t.Helper()
+	if os.Getenv("IFOREST_PARITY_REQUIRED") != "" {
+		t.Fatalf("File generato assente (%s): eseguire make export-model", path)
+	}
+	t.Skipf("File generato assente (%s): eseguire make export-model", path)
+}
+
+// TestParity_KDDTestPlus confronta il punteggio Go con quello di scikit-learn per ogni record
+// di KDDTest+.txt. Ogni riga del file di parità contiene le 41 feature già codificate,
+// il decision_function e la predizione del modello .joblib.
+func TestParity_KDDTestPlus(t *testing.T) {
+	m, err := Load(exportedModelPath)
+	if errors.Is(err, os.ErrNotExist) {
+		missingArtifact(t, exportedModelPath)
+	}
+	if err != nil {
+		t.Fatalf("Modello esportato non valido: %v", err)
+	}
+	f, err := os.Open(parityPath)
+	if errors.Is(err, os.ErrNotExist) {
+		missingArtifact(t, parityPath)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	if _, err := r.Read(); err != nil { // Intestazione
+		t.Fatalf("File di parità vuoto: %v", err)
+	}
+	rows, mismatches := 0, 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Riga %d: %v", rows+2, err)
+		}
+		if len(record) != m.NumFeatures+2 {
+			t.Fatalf("Riga %d: attese %d colonne, trovate %d", rows+2, m.NumFeatures+2, len(record))
+		}
+		x := make([]float32, m.NumFeatures)
+		for i := range x {
+			v, err := strconv.ParseFloat(record[i], 32)
+			if err != nil {
+				t.Fatalf("Riga %d, feature %d: %v", rows+2, i, err)
+			}
+			x[i] = float32(v)
+		}
+		wantScore, _ := strconv.ParseFloat(record[m.NumFeatures], 64)
+		wantPrediction, _ := strconv.Atoi(record[m.NumFeatures+1])
+
+		got, err := m.Score(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows++
+		if math.Abs(got-wantScore) > 1e-9 || int(Predict(got)) != wantPrediction {
+			mismatches++
+			if mismatches <= 5 {
+				t.Errorf("Riga %d: punteggio Go %.12f (predizione %d), scikit-learn %.12f (predizione %d)", rows+1, got, Predict(got), wantScore, wantPrediction)
+			}
+		}
+	}
+	if mismatches > 0 {
+		t.Errorf("%d record su %d non coincidono con scikit-learn", mismatches, rows)
+	}
+	t.Logf("Parità verificata su %d record di KDDTest+", rows)
+}
@@ -0,0 +1,7 @@
+module github.com/ANGEL0CADUTO/IDS_project/pkg/mtls
+
+go 1.23.11
+
+require google.golang.org/grpc v1.73.0
+
+require google.golang.org/protobuf v1.36.6 // indirect
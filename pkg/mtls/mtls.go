@@ -0,0 +1,193 @@
+package mtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Files è il materiale TLS di un servizio: il certificato con la sua chiave, usato sia come
+// server sia come client, e il bundle delle CA che firmano i certificati degli altri servizi.
+type Files struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Reloader tiene in memoria certificato e bundle CA e li ricarica quando i file cambiano
+// (rotazione), senza riavviare il servizio: le nuove connessioni usano subito il materiale
+// aggiornato, quelle già aperte restano valide.
+//
+// Un *Reloader nil significa TLS disattivato: le credenziali restituite sono in chiaro.
+type Reloader struct {
+	files Files
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+	raw  [][]byte // Contenuto dei file all'ultimo caricamento, per riconoscere le modifiche
+}
+
+// Load carica il materiale TLS. Se nessun file è configurato restituisce nil (TLS disattivato);
+// se ne è configurata solo una parte restituisce un errore, per non avviare per sbaglio un
+// servizio in chiaro.
+func Load(files Files) (*Reloader, error) {
+	if files.CertFile == "" && files.KeyFile == "" && files.CAFile == "" {
+		return nil, nil
+	}
+	if files.CertFile == "" || files.KeyFile == "" || files.CAFile == "" {
+		return nil, errors.New("TLS requires certificate, key and CA bundle files")
+	}
+	r := &Reloader{files: files}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload rilegge i file e, se sono cambiati e validi, sostituisce il materiale in uso.
+// Con file non validi (es. rotazione a metà) il materiale precedente resta in uso.
+func (r *Reloader) reload() (changed bool, err error) {
+	var raw [][]byte
+	for _, path := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("read %s: %w", path, err)
+		}
+		raw = append(raw, data)
+	}
+
+	r.mu.RLock()
+	unchanged := r.raw != nil && bytes.Equal(raw[0], r.raw[0]) && bytes.Equal(raw[1], r.raw[1]) && bytes.Equal(raw[2], r.raw[2])
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(raw[0], raw[1])
+	if err != nil {
+		return false, fmt.Errorf("load certificate %s: %w", r.files.CertFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw[2]) {
+		return false, fmt.Errorf("no certificates found in CA bundle %s", r.files.CAFile)
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.raw = &cert, pool, raw
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch controlla i file ogni interval e ricarica il materiale quando cambia, finché ctx non termina.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.reload()
+		if err != nil {
+			log.Printf("ERROR: TLS material not reloaded, keeping the previous one: %v", err)
+		} else if changed {
+			log.Printf("TLS certificate and CA bundle reloaded from %s.", r.files.CertFile)
+		}
+	}
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig restituisce la configurazione di un server che richiede e verifica il
+// certificato dei client.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}
+}
+
+// ClientConfig restituisce la configurazione di un client che presenta il proprio certificato e
+// verifica che quello del server sia valido per serverName. Le istanze sono registrate in Consul
+// con l'hostname del container, quindi i client verificano il nome logico del servizio
+// (es. analysis-service); con serverName vuoto si usa l'host dell'indirizzo.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// La verifica standard userebbe un bundle CA fisso: la rifacciamo in VerifyConnection
+		// con quello corrente, così anche la rotazione della CA non richiede un riavvio.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			_, pool := r.current()
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// ServerCredentials restituisce le credenziali gRPC del server (in chiaro se r è nil).
+func (r *Reloader) ServerCredentials() credentials.TransportCredentials {
+	if r == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(r.ServerConfig())
+}
+
+// ClientCredentials restituisce le credenziali gRPC per chiamare il servizio serverName
+// (in chiaro se r è nil).
+func (r *Reloader) ClientCredentials(serverName string) credentials.TransportCredentials {
+	if r == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(r.ClientConfig(serverName))
+}
+
+// Enabled indica se il TLS è attivo.
+func (r *Reloader) Enabled() bool {
+	return r != nil
+}
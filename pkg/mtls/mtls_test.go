@@ -0,0 +1,192 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA è una CA di test che firma i certificati dei servizi.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Impossibile creare la CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue scrive in dir certificato e chiave per il servizio name, firmati dalla CA, e il bundle CA.
+func (ca *testCA) issue(t *testing.T, dir, name string) Files {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Impossibile creare il certificato: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	files := Files{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+		CAFile:   filepath.Join(dir, name+"-ca.pem"),
+	}
+	writeFile(t, files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	writeFile(t, files.CAFile, ca.pem)
+	return files
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustLoad(t *testing.T, files Files) *Reloader {
+	t.Helper()
+	r, err := Load(files)
+	if err != nil {
+		t.Fatalf("Load fallita: %v", err)
+	}
+	return r
+}
+
+// handshake esegue un handshake TLS su loopback tra client e server e restituisce il primo errore.
+func handshake(client, server *tls.Config) error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- tls.Server(conn, server).Handshake()
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	clientErr := tls.Client(conn, client).Handshake()
+	if clientErr != nil {
+		conn.Close() // Sblocca il server in attesa
+	}
+	if err := <-serverErr; err != nil {
+		return err
+	}
+	return clientErr
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ids-ca")
+	server := mustLoad(t, ca.issue(t, dir, "analysis-service"))
+	client := mustLoad(t, ca.issue(t, dir, "collector-service"))
+
+	if err := handshake(client.ClientConfig("analysis-service"), server.ServerConfig()); err != nil {
+		t.Fatalf("Handshake tra servizi della stessa CA fallito: %v", err)
+	}
+	if err := handshake(client.ClientConfig("storage-service"), server.ServerConfig()); err == nil {
+		t.Errorf("Un certificato per un altro servizio doveva essere rifiutato")
+	}
+
+	// Un client senza certificato non deve passare
+	noCert := &tls.Config{InsecureSkipVerify: true}
+	if err := handshake(noCert, server.ServerConfig()); err == nil {
+		t.Errorf("Il server doveva richiedere il certificato del client")
+	}
+
+	// Né un client con un certificato di un'altra CA
+	other := mustLoad(t, newTestCA(t, "other-ca").issue(t, t.TempDir(), "collector-service"))
+	if err := handshake(other.ClientConfig("analysis-service"), server.ServerConfig()); err == nil {
+		t.Errorf("Un client con un certificato di un'altra CA doveva essere rifiutato")
+	}
+}
+
+func TestReloader_RotationWithoutRestart(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newTestCA(t, "old-ca")
+	serverFiles := oldCA.issue(t, dir, "analysis-service")
+	server := mustLoad(t, serverFiles)
+
+	// Rotazione della CA: il client ha già il nuovo materiale, il server non ancora
+	newCA := newTestCA(t, "new-ca")
+	client := mustLoad(t, newCA.issue(t, t.TempDir(), "collector-service"))
+	if err := handshake(client.ClientConfig("analysis-service"), server.ServerConfig()); err == nil {
+		t.Fatalf("Prima della rotazione il server non deve accettare la nuova CA")
+	}
+
+	newCA.issue(t, dir, "analysis-service") // Sovrascrive i file del server
+	if changed, err := server.reload(); err != nil || !changed {
+		t.Fatalf("Il nuovo materiale doveva essere ricaricato: changed=%v err=%v", changed, err)
+	}
+	if err := handshake(client.ClientConfig("analysis-service"), server.ServerConfig()); err != nil {
+		t.Errorf("Dopo la rotazione l'handshake doveva riuscire senza riavvio: %v", err)
+	}
+	if changed, _ := server.reload(); changed {
+		t.Errorf("Senza modifiche ai file non deve esserci un nuovo caricamento")
+	}
+
+	// Un file non valido (es. rotazione a metà) non sostituisce il materiale in uso
+	writeFile(t, serverFiles.KeyFile, []byte("not a key"))
+	if _, err := server.reload(); err == nil {
+		t.Errorf("Una chiave non valida doveva essere segnalata")
+	}
+	if err := handshake(client.ClientConfig("analysis-service"), server.ServerConfig()); err != nil {
+		t.Errorf("Con file non validi doveva restare in uso il materiale precedente: %v", err)
+	}
+}
+
+func TestLoad_DisabledAndPartialConfig(t *testing.T) {
+	r, err := Load(Files{})
+	if err != nil || r != nil || r.Enabled() {
+		t.Errorf("Senza file il TLS deve risultare disattivato: %v", err)
+	}
+	if _, err := Load(Files{CertFile: "cert.pem"}); err == nil {
+		t.Errorf("Una configurazione parziale doveva essere rifiutata")
+	}
+}
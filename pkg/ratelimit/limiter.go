@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Priority è la priorità di un gruppo di client quando il limite globale è quasi esaurito.
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+)
+
+// shedReserve è la frazione della capacità globale che le richieste di ciascuna priorità devono
+// lasciare libera: sotto carico le richieste a bassa priorità vengono scartate per prime, così
+// la capacità residua resta ai client più importanti. La riserva non supera mai Burst-1 token
+// (vedi reserve): con un bucket pieno ogni richiesta è ammessa, anche con un burst piccolo.
+var shedReserve = map[Priority]float64{
+	Low:    0.5,
+	Normal: 0.2,
+	High:   0,
+}
+
+// Limit è un token bucket: Rate richieste al secondo, con raffiche fino a Burst.
+// Un Rate non positivo significa nessun limite.
+type Limit struct {
+	Rate  float64
+	Burst float64
+}
+
+func (l Limit) unlimited() bool {
+	return l.Rate <= 0
+}
+
+// normalized porta Burst ad almeno un token: con meno nessuna richiesta passerebbe mai.
+func (l Limit) normalized() Limit {
+	l.Burst = math.Max(l.Burst, 1)
+	return l
+}
+
+// Group è un gruppo di client con lo stesso limite per client e la stessa priorità.
+type Group struct {
+	Name     string
+	Limit    Limit
+	Priority Priority
+}
+
+// Reason è il motivo di una decisione del Limiter.
+type Reason int
+
+const (
+	// Allowed: la richiesta rientra nei limiti.
+	Allowed Reason = iota
+	// ClientLimit: il client ha superato il limite del suo gruppo.
+	ClientLimit
+	// GlobalLimit: il limite globale è esaurito.
+	GlobalLimit
+	// Shed: la richiesta è stata scartata per lasciare la capacità residua ai client con priorità più alta.
+	Shed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case Allowed:
+		return "allowed"
+	case ClientLimit:
+		return "client_limit"
+	case GlobalLimit:
+		return "global_limit"
+	case Shed:
+		return "shed"
+	}
+	return "unknown"
+}
+
+// Decision è l'esito di Allow. Se la richiesta non è ammessa, RetryAfter è l'attesa
+// minima prima che un nuovo tentativo possa essere ammesso.
+type Decision struct {
+	Allowed    bool
+	Reason     Reason
+	RetryAfter time.Duration
+}
+
+// Limiter applica un token bucket per client e uno globale. I bucket dei client inattivi
+// vengono dimenticati quando sono di nuovo pieni, quindi la memoria resta proporzionale ai
+// client attivi.
+//
+// Un *Limiter nil ammette tutte le richieste.
+type Limiter struct {
+	mu        sync.Mutex
+	global    *bucket
+	clients   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval è l'intervallo minimo tra due pulizie dei bucket inattivi.
+const sweepInterval = time.Minute
+
+// New crea un Limiter con il limite globale indicato (Limit{} = nessun limite globale).
+func New(global Limit) *Limiter {
+	l := &Limiter{clients: make(map[string]*bucket), now: time.Now}
+	if !global.unlimited() {
+		l.global = newBucket(global, l.now())
+	}
+	l.lastSweep = l.now()
+	return l
+}
+
+// Allow decide se ammettere una richiesta di clientID, che appartiene a group.
+// I token vengono consumati solo se la richiesta è ammessa da entrambi i limiti.
+func (l *Limiter) Allow(clientID string, group Group) Decision {
+	if l == nil {
+		return Decision{Allowed: true}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	var client *bucket
+	if !group.Limit.unlimited() {
+		client = l.clients[clientID]
+		if client == nil || client.limit != group.Limit.normalized() {
+			// Client nuovo o spostato in un altro gruppo: riparte con il bucket pieno
+			client = newBucket(group.Limit, now)
+			l.clients[clientID] = client
+		}
+		client.refill(now)
+		if wait := client.wait(0); wait > 0 {
+			return Decision{Reason: ClientLimit, RetryAfter: wait}
+		}
+	}
+	if l.global != nil {
+		l.global.refill(now)
+		if wait := l.global.wait(0); wait > 0 {
+			return Decision{Reason: GlobalLimit, RetryAfter: wait}
+		}
+		if wait := l.global.wait(l.global.reserve(group.Priority)); wait > 0 {
+			return Decision{Reason: Shed, RetryAfter: wait}
+		}
+		l.global.tokens--
+	}
+	if client != nil {
+		client.tokens--
+	}
+	return Decision{Allowed: true}
+}
+
+// Clients restituisce il numero di bucket per client in memoria.
+func (l *Limiter) Clients() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
+
+// sweep dimentica i bucket tornati pieni: un bucket pieno equivale a uno nuovo. Va chiamata con mu.
+func (l *Limiter) sweep(now time.Time) {
+	for id, b := range l.clients {
+		b.refill(now)
+		if b.tokens >= b.limit.Burst {
+			delete(l.clients, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	limit = limit.normalized()
+	return &bucket{limit: limit, tokens: limit.Burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.limit.Burst, b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// reserve restituisce i token che una richiesta della priorità indicata deve lasciare nel bucket.
+func (b *bucket) reserve(p Priority) float64 {
+	return math.Min(shedReserve[p]*b.limit.Burst, b.limit.Burst-1)
+}
+
+// wait restituisce il tempo necessario perché il bucket abbia un token oltre reserve (0 = subito).
+func (b *bucket) wait(reserve float64) time.Duration {
+	missing := reserve + 1 - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.limit.Rate * float64(time.Second)))
+}
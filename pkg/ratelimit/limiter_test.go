@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock permette di far scorrere il tempo del limiter nei test.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestLimiter(global Limit) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := New(global)
+	l.now = clock.now
+	l.lastSweep = clock.t
+	if l.global != nil {
+		l.global.last = clock.t
+	}
+	return l, clock
+}
+
+func TestLimiter_ClientBucket(t *testing.T) {
+	l, clock := newTestLimiter(Limit{})
+	group := Group{Name: "default", Limit: Limit{Rate: 2, Burst: 3}, Priority: Normal}
+
+	for i := 0; i < 3; i++ {
+		if d := l.Allow("c1", group); !d.Allowed {
+			t.Fatalf("La richiesta %d rientra nel burst e doveva essere ammessa: %+v", i+1, d)
+		}
+	}
+	d := l.Allow("c1", group)
+	if d.Allowed || d.Reason != ClientLimit {
+		t.Fatalf("Oltre il burst la richiesta doveva essere limitata per client: %+v", d)
+	}
+	if d.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Con 2 richieste/s il prossimo token arriva tra 500ms, RetryAfter %s", d.RetryAfter)
+	}
+	if d := l.Allow("c2", group); !d.Allowed {
+		t.Errorf("Il limite di un client non deve influire sugli altri: %+v", d)
+	}
+
+	clock.t = clock.t.Add(d.RetryAfter)
+	if d := l.Allow("c1", group); !d.Allowed {
+		t.Errorf("Dopo RetryAfter la richiesta doveva essere ammessa: %+v", d)
+	}
+}
+
+func TestLimiter_GlobalLimitAndShedding(t *testing.T) {
+	l, _ := newTestLimiter(Limit{Rate: 1, Burst: 10})
+	low := Group{Name: "bulk", Priority: Low}
+	high := Group{Name: "critical", Priority: High}
+
+	// I client a bassa priorità devono lasciare libera metà della capacità globale
+	admitted := 0
+	var last Decision
+	for i := 0; i < 10; i++ {
+		if last = l.Allow("bulk-1", low); last.Allowed {
+			admitted++
+		}
+	}
+	if admitted != 5 || last.Reason != Shed || last.RetryAfter <= 0 {
+		t.Fatalf("Attese 5 richieste a bassa priorità ammesse e poi scartate, ammesse %d (ultima %+v)", admitted, last)
+	}
+
+	// La capacità residua resta ai client ad alta priorità, fino al limite globale
+	admitted = 0
+	for i := 0; i < 10; i++ {
+		if last = l.Allow("critical-1", high); last.Allowed {
+			admitted++
+		}
+	}
+	if admitted != 5 || last.Reason != GlobalLimit {
+		t.Errorf("Attese 5 richieste ad alta priorità ammesse e poi il limite globale, ammesse %d (ultima %+v)", admitted, last)
+	}
+}
+
+func TestLimiter_FullBucketAlwaysAdmits(t *testing.T) {
+	// Con un burst piccolo la riserva di shedding supererebbe la capacità del bucket
+	for _, global := range []Limit{{Rate: 10, Burst: 1}, {Rate: 10, Burst: 2}} {
+		for _, priority := range []Priority{Low, Normal, High} {
+			l, clock := newTestLimiter(global)
+			group := Group{Name: "g", Priority: priority}
+			if d := l.Allow("c1", group); !d.Allowed {
+				t.Errorf("Burst %v, priorità %d: con il bucket globale pieno la richiesta doveva essere ammessa: %+v", global.Burst, priority, d)
+			}
+			// Dopo aver svuotato il bucket si torna ad ammettere appena è di nuovo pieno
+			clock.t = clock.t.Add(time.Second)
+			if d := l.Allow("c1", group); !d.Allowed {
+				t.Errorf("Burst %v, priorità %d: con il bucket di nuovo pieno la richiesta doveva essere ammessa: %+v", global.Burst, priority, d)
+			}
+		}
+	}
+}
+
+func TestLimiter_RejectedRequestsDoNotConsumeTokens(t *testing.T) {
+	l, _ := newTestLimiter(Limit{Rate: 1, Burst: 2})
+	group := Group{Name: "default", Limit: Limit{Rate: 1, Burst: 5}, Priority: High}
+
+	l.Allow("a", group)
+	l.Allow("a", group)
+	if d := l.Allow("a", group); d.Reason != GlobalLimit {
+		t.Fatalf("Atteso il limite globale, ottenuto %+v", d)
+	}
+	// La richiesta rifiutata dal limite globale non ha consumato il token del client
+	if tokens := l.clients["a"].tokens; tokens != 3 {
+		t.Errorf("Attesi 3 token rimasti al client, trovati %v", tokens)
+	}
+}
+
+func TestLimiter_ForgetsIdleClients(t *testing.T) {
+	l, clock := newTestLimiter(Limit{})
+	group := Group{Name: "default", Limit: Limit{Rate: 10, Burst: 10}}
+	l.Allow("a", group)
+	l.Allow("b", group)
+
+	clock.t = clock.t.Add(sweepInterval)
+	l.Allow("c", group)
+	if n := l.Clients(); n != 1 {
+		t.Errorf("I bucket tornati pieni dovevano essere dimenticati, in memoria %d", n)
+	}
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	if d := l.Allow("a", Group{Limit: Limit{Rate: 1}}); !d.Allowed {
+		t.Errorf("Un limiter nil ammette tutte le richieste")
+	}
+}
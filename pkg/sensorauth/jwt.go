@@ -0,0 +1,86 @@
+package sensorauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims sono i claim dei JWT dei sensori. Subject è l'identità del sensore, cioè il
+// SourceClientId per cui può inviare metriche; ID (jti) permette di revocare il token.
+type Claims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// jwtHeader è l'unico header accettato: HS256, con il segreto condiviso tra chi emette i token e il collector.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// ErrTokenExpired è restituito da VerifyJWT per un token scaduto.
+var ErrTokenExpired = errors.New("token expired")
+
+// SignJWT firma i claim con HS256.
+func SignJWT(secret []byte, claims Claims) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("empty JWT secret")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("JWT subject is required")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + sign(secret, signingInput), nil
+}
+
+// VerifyJWT verifica firma e scadenza di un token HS256 e ne restituisce i claim.
+func VerifyJWT(secret []byte, token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("malformed token")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	// L'algoritmo è fissato dal collector: un token "alg":"none" o asimmetrico non viene mai accettato
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return Claims{}, errors.New("unsupported token algorithm")
+	}
+	if len(secret) == 0 || !hmac.Equal([]byte(parts[2]), []byte(sign(secret, parts[0]+"."+parts[1]))) {
+		return Claims{}, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("malformed token payload: %w", err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if claims.Subject == "" {
+		return Claims{}, errors.New("token without subject")
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func sign(secret []byte, signingInput string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
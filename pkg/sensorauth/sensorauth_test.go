@@ -0,0 +1,147 @@
+package sensorauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+func TestJWT_SignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	token, err := SignJWT(testSecret, Claims{Subject: "sensor-1", ID: "k1", ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("SignJWT fallita: %v", err)
+	}
+	claims, err := VerifyJWT(testSecret, token, now)
+	if err != nil || claims.Subject != "sensor-1" || claims.ID != "k1" {
+		t.Fatalf("Token valido rifiutato: %+v %v", claims, err)
+	}
+
+	if _, err := VerifyJWT([]byte("other-secret"), token, now); err == nil {
+		t.Errorf("Un token firmato con un altro segreto doveva essere rifiutato")
+	}
+	if _, err := VerifyJWT(testSecret, token, now.Add(2*time.Hour)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Un token scaduto doveva essere rifiutato, errore %v", err)
+	}
+
+	// Un token con il payload modificato (es. un altro sub) non deve superare la verifica
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"sensor-2"}`))
+	if _, err := VerifyJWT(testSecret, strings.Join(parts, "."), now); err == nil {
+		t.Errorf("Un token con il payload modificato doveva essere rifiutato")
+	}
+	// Né un token che dichiara "alg":"none"
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	parts[2] = ""
+	if _, err := VerifyJWT(testSecret, strings.Join(parts, "."), now); err == nil {
+		t.Errorf("Un token senza firma doveva essere rifiutato")
+	}
+}
+
+func TestStore_IssueAndRevokeAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	admin, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore fallita: %v", err)
+	}
+	key, cred, err := admin.IssueAPIKey("sensor-1", 0)
+	if err != nil {
+		t.Fatalf("IssueAPIKey fallita: %v", err)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), key) {
+		t.Errorf("La API key in chiaro non deve essere salvata nello store")
+	}
+
+	// Il collector legge lo stesso file con un'altra istanza
+	collector, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore fallita: %v", err)
+	}
+	if got, err := collector.AuthenticateAPIKey(key); err != nil || got.ClientID != "sensor-1" {
+		t.Fatalf("API key valida rifiutata: %+v %v", got, err)
+	}
+	if _, err := collector.AuthenticateAPIKey(key + "x"); !errors.Is(err, ErrUnknownCredential) {
+		t.Errorf("Una API key sconosciuta doveva essere rifiutata, errore %v", err)
+	}
+
+	if _, err := admin.Revoke(cred.ID); err != nil {
+		t.Fatalf("Revoke fallita: %v", err)
+	}
+	if changed, err := collector.reload(); err != nil || !changed {
+		t.Fatalf("La revoca doveva essere ricaricata: changed=%v err=%v", changed, err)
+	}
+	if _, err := collector.AuthenticateAPIKey(key); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Una API key revocata doveva essere rifiutata, errore %v", err)
+	}
+	if _, err := admin.Revoke("missing"); !errors.Is(err, ErrUnknownCredential) {
+		t.Errorf("La revoca di una credenziale inesistente doveva fallire, errore %v", err)
+	}
+}
+
+func TestStore_IssuedJWTCanBeRevoked(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("OpenStore fallita: %v", err)
+	}
+	token, cred, err := store.IssueJWT(testSecret, "sensor-1", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueJWT fallita: %v", err)
+	}
+	claims, err := VerifyJWT(testSecret, token, time.Now())
+	if err != nil || claims.ID != cred.ID {
+		t.Fatalf("Il token emesso doveva essere valido: %+v %v", claims, err)
+	}
+	if got, err := store.AuthenticateToken(claims); err != nil || got.ClientID != "sensor-1" {
+		t.Fatalf("Il token emesso doveva essere accettato dallo store: %+v %v", got, err)
+	}
+	store.Revoke(cred.ID)
+	if _, err := store.AuthenticateToken(claims); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Il token revocato doveva essere rifiutato, errore %v", err)
+	}
+}
+
+func TestStore_TokenNotIssuedByTheStoreIsRejected(t *testing.T) {
+	store, _ := OpenStore(filepath.Join(t.TempDir(), "keys.json"))
+	_, cred, err := store.IssueJWT(testSecret, "sensor-1", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueJWT fallita: %v", err)
+	}
+	_, apiKey, _ := store.IssueAPIKey("sensor-1", 0)
+
+	tests := []struct {
+		name   string
+		claims Claims
+	}{
+		{"senza jti", Claims{Subject: "sensor-1"}},
+		{"jti mai emesso", Claims{Subject: "sensor-1", ID: "forged"}},
+		{"jti di un altro client", Claims{Subject: "sensor-2", ID: cred.ID}},
+		{"jti di una API key", Claims{Subject: "sensor-1", ID: apiKey.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.AuthenticateToken(tt.claims); !errors.Is(err, ErrUnknownCredential) {
+				t.Errorf("Atteso ErrUnknownCredential, errore %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_ExpiredAPIKey(t *testing.T) {
+	store, _ := OpenStore(filepath.Join(t.TempDir(), "keys.json"))
+	clock := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return clock }
+	key, _, err := store.IssueAPIKey("sensor-1", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAPIKey fallita: %v", err)
+	}
+	clock = clock.Add(time.Hour)
+	if _, err := store.AuthenticateAPIKey(key); !errors.Is(err, ErrExpired) {
+		t.Errorf("Una API key scaduta doveva essere rifiutata, errore %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package sensorauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipi di credenziale emessi dallo Store.
+const (
+	KindAPIKey = "api-key"
+	KindJWT    = "jwt"
+)
+
+// apiKeyPrefix rende le API key riconoscibili (es. nei log o in uno scanner di segreti).
+const apiKeyPrefix = "ids_"
+
+var (
+	ErrUnknownCredential = errors.New("unknown credential")
+	ErrRevoked           = errors.New("credential revoked")
+	ErrExpired           = errors.New("credential expired")
+)
+
+// Credential è una credenziale emessa per un sensore. Delle API key si conserva solo
+// l'impronta SHA-256: la chiave in chiaro viene mostrata una sola volta, all'emissione.
+type Credential struct {
+	ID        string `json:"id"`
+	ClientID  string `json:"client_id"`
+	Kind      string `json:"kind"`
+	Hash      string `json:"hash,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	RevokedAt int64  `json:"revoked_at,omitempty"`
+}
+
+// check restituisce l'errore per una credenziale revocata o scaduta.
+func (c Credential) check(now time.Time) error {
+	if c.RevokedAt != 0 {
+		return ErrRevoked
+	}
+	if c.ExpiresAt != 0 && now.Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	return nil
+}
+
+type storeFile struct {
+	Credentials []Credential `json:"credentials"`
+}
+
+// Store è il file JSON delle credenziali dei sensori. Il collector lo legge e lo ricarica
+// quando cambia; il comando di amministrazione (cmd/sensor-admin) vi emette e revoca le
+// credenziali, riscrivendolo in modo atomico.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.RWMutex
+	byID   map[string]Credential
+	byHash map[string]string // Impronta della API key -> ID
+	raw    []byte
+}
+
+// OpenStore apre lo store in path. Un file mancante equivale a uno store vuoto.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload rilegge il file se è cambiato. Con un file non valido resta in uso il contenuto precedente.
+func (s *Store) reload() (changed bool, err error) {
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		raw, err = nil, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read credential store %s: %w", s.path, err)
+	}
+
+	s.mu.RLock()
+	unchanged := s.byID != nil && bytes.Equal(raw, s.raw)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var file storeFile
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &file); err != nil {
+			return false, fmt.Errorf("parse credential store %s: %w", s.path, err)
+		}
+	}
+	byID := make(map[string]Credential, len(file.Credentials))
+	byHash := make(map[string]string)
+	for _, c := range file.Credentials {
+		byID[c.ID] = c
+		if c.Hash != "" {
+			byHash[c.Hash] = c.ID
+		}
+	}
+
+	s.mu.Lock()
+	s.byID, s.byHash, s.raw = byID, byHash, raw
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Watch ricarica il file ogni interval finché ctx non termina, così le emissioni e le
+// revoche hanno effetto senza riavviare il collector.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.reload()
+		if err != nil {
+			log.Printf("ERROR: sensor credentials not reloaded, keeping the previous ones: %v", err)
+		} else if changed {
+			log.Printf("Sensor credentials reloaded from %s (%d credentials).", s.path, s.Len())
+		}
+	}
+}
+
+// Len restituisce il numero di credenziali nello store, comprese quelle revocate.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byID)
+}
+
+// Credentials restituisce le credenziali in ordine di emissione.
+func (s *Store) Credentials() []Credential {
+	s.mu.RLock()
+	creds := make([]Credential, 0, len(s.byID))
+	for _, c := range s.byID {
+		creds = append(creds, c)
+	}
+	s.mu.RUnlock()
+	sort.Slice(creds, func(i, j int) bool {
+		if creds[i].CreatedAt != creds[j].CreatedAt {
+			return creds[i].CreatedAt < creds[j].CreatedAt
+		}
+		return creds[i].ID < creds[j].ID
+	})
+	return creds
+}
+
+// AuthenticateAPIKey restituisce la credenziale di una API key valida.
+func (s *Store) AuthenticateAPIKey(key string) (Credential, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return Credential{}, ErrUnknownCredential
+	}
+	s.mu.RLock()
+	c, ok := s.byID[s.byHash[hashKey(key)]]
+	s.mu.RUnlock()
+	if !ok {
+		return Credential{}, ErrUnknownCredential
+	}
+	return c, c.check(s.now())
+}
+
+// Authorization restituisce il valore dell'header authorization per una credenziale emessa
+// dallo store: "ApiKey <key>" per una API key, "Bearer <token>" per un JWT.
+func Authorization(credential string) string {
+	if strings.HasPrefix(credential, apiKeyPrefix) {
+		return "ApiKey " + credential
+	}
+	return "Bearer " + credential
+}
+
+// AuthenticateToken restituisce la credenziale di un JWT già verificato. Il token è valido solo
+// se il suo jti è un JWT emesso tramite lo store per lo stesso sub, non revocato e non scaduto:
+// un token firmato con il segreto ma mai emesso (o senza jti) viene rifiutato.
+func (s *Store) AuthenticateToken(claims Claims) (Credential, error) {
+	if claims.ID == "" {
+		return Credential{}, ErrUnknownCredential
+	}
+	s.mu.RLock()
+	c, ok := s.byID[claims.ID]
+	s.mu.RUnlock()
+	if !ok || c.Kind != KindJWT || c.ClientID != claims.Subject {
+		return Credential{}, ErrUnknownCredential
+	}
+	return c, c.check(s.now())
+}
+
+// IssueAPIKey emette una API key per clientID (ttl 0 = senza scadenza) e restituisce la chiave in chiaro.
+func (s *Store) IssueAPIKey(clientID string, ttl time.Duration) (string, Credential, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", Credential{}, err
+	}
+	var key string
+	cred, err := s.issue(clientID, KindAPIKey, ttl, func(c *Credential) error {
+		key = apiKeyPrefix + c.ID + "_" + secret
+		c.Hash = hashKey(key)
+		return nil
+	})
+	return key, cred, err
+}
+
+// IssueJWT emette un JWT per clientID firmato con secret (ttl 0 = senza scadenza).
+// Il token è registrato nello store con il suo jti, così può essere revocato.
+func (s *Store) IssueJWT(secret []byte, clientID string, ttl time.Duration) (string, Credential, error) {
+	var token string
+	cred, err := s.issue(clientID, KindJWT, ttl, func(c *Credential) error {
+		var err error
+		token, err = SignJWT(secret, Claims{Subject: clientID, ID: c.ID, IssuedAt: c.CreatedAt, ExpiresAt: c.ExpiresAt})
+		return err
+	})
+	return token, cred, err
+}
+
+func (s *Store) issue(clientID, kind string, ttl time.Duration, finish func(*Credential) error) (Credential, error) {
+	if clientID == "" {
+		return Credential{}, errors.New("client ID is required")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Credential{}, err
+	}
+	now := s.now()
+	cred := Credential{ID: id, ClientID: clientID, Kind: kind, CreatedAt: now.Unix()}
+	if ttl > 0 {
+		cred.ExpiresAt = now.Add(ttl).Unix()
+	}
+	if err := finish(&cred); err != nil {
+		return Credential{}, err
+	}
+	return cred, s.update(func(byID map[string]Credential) error {
+		byID[cred.ID] = cred
+		return nil
+	})
+}
+
+// Revoke revoca la credenziale id: API key e JWT vengono rifiutati dal prossimo ricaricamento del collector.
+func (s *Store) Revoke(id string) (Credential, error) {
+	var revoked Credential
+	err := s.update(func(byID map[string]Credential) error {
+		c, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownCredential, id)
+		}
+		if c.RevokedAt == 0 {
+			c.RevokedAt = s.now().Unix()
+			byID[id] = c
+		}
+		revoked = c
+		return nil
+	})
+	return revoked, err
+}
+
+// update applica change allo stato più recente del file e lo riscrive in modo atomico.
+func (s *Store) update(change func(map[string]Credential) error) error {
+	if _, err := s.reload(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	byID := make(map[string]Credential, len(s.byID)+1)
+	for id, c := range s.byID {
+		byID[id] = c
+	}
+	s.mu.RUnlock()
+	if err := change(byID); err != nil {
+		return err
+	}
+
+	file := storeFile{Credentials: make([]Credential, 0, len(byID))}
+	for _, c := range byID {
+		file.Credentials = append(file.Credentials, c)
+	}
+	sort.Slice(file.Credentials, func(i, j int) bool { return file.Credentials[i].ID < file.Credentials[j].ID })
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write credential store %s: %w", s.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write credential store %s: %w", s.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write credential store %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("write credential store %s: %w", s.path, err)
+	}
+	_, err = s.reload()
+	return err
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
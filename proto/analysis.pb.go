@@ -74,6 +74,310 @@ func (x *AnalysisResponse) GetMessage() string {
 	return ""
 }
 
+// Un batch di metriche inoltrato dal collector.
+type MetricBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetricBatch) Reset() {
+	*x = MetricBatch{}
+	mi := &file_analysis_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetricBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetricBatch) ProtoMessage() {}
+
+func (x *MetricBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetricBatch.ProtoReflect.Descriptor instead.
+func (*MetricBatch) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MetricBatch) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// Esiti dell'analisi di un batch, uno per ogni metrica ricevuta.
+type BatchAnalysisResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*AnalysisResponse    `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchAnalysisResponse) Reset() {
+	*x = BatchAnalysisResponse{}
+	mi := &file_analysis_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchAnalysisResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchAnalysisResponse) ProtoMessage() {}
+
+func (x *BatchAnalysisResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchAnalysisResponse.ProtoReflect.Descriptor instead.
+func (*BatchAnalysisResponse) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BatchAnalysisResponse) GetResults() []*AnalysisResponse {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// Finestra di correlazione di un client per una regola: gli istanti (event time) delle anomalie
+// ancora nella finestra.
+type ClientWindow struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ClientId          string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`                                    // Chiave di correlazione: <rule_id>/<client_id>
+	AnomalyTimestamps []int64                `protobuf:"varint,2,rep,packed,name=anomaly_timestamps,json=anomalyTimestamps,proto3" json:"anomaly_timestamps,omitempty"` // Istanti Unix in nanosecondi
+	MaxEventTime      int64                  `protobuf:"varint,3,opt,name=max_event_time,json=maxEventTime,proto3" json:"max_event_time,omitempty"`                     // Event time più recente visto per il client (base del watermark), in nanosecondi
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ClientWindow) Reset() {
+	*x = ClientWindow{}
+	mi := &file_analysis_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientWindow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientWindow) ProtoMessage() {}
+
+func (x *ClientWindow) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientWindow.ProtoReflect.Descriptor instead.
+func (*ClientWindow) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ClientWindow) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ClientWindow) GetAnomalyTimestamps() []int64 {
+	if x != nil {
+		return x.AnomalyTimestamps
+	}
+	return nil
+}
+
+func (x *ClientWindow) GetMaxEventTime() int64 {
+	if x != nil {
+		return x.MaxEventTime
+	}
+	return 0
+}
+
+// Stato di correlazione trasferito tra istanze quando cambia la proprietà dei client.
+type CorrelationState struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Windows        []*ClientWindow        `protobuf:"bytes,1,rep,name=windows,proto3" json:"windows,omitempty"`
+	SourceInstance string                 `protobuf:"bytes,2,opt,name=source_instance,json=sourceInstance,proto3" json:"source_instance,omitempty"` // Istanza che cede lo stato
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CorrelationState) Reset() {
+	*x = CorrelationState{}
+	mi := &file_analysis_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CorrelationState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CorrelationState) ProtoMessage() {}
+
+func (x *CorrelationState) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CorrelationState.ProtoReflect.Descriptor instead.
+func (*CorrelationState) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CorrelationState) GetWindows() []*ClientWindow {
+	if x != nil {
+		return x.Windows
+	}
+	return nil
+}
+
+func (x *CorrelationState) GetSourceInstance() string {
+	if x != nil {
+		return x.SourceInstance
+	}
+	return ""
+}
+
+// Richiesta di esportazione dello stato dei client che, con la membership indicata,
+// appartengono all'istanza richiedente.
+type ExportStateRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Members        []string               `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`                                     // Istanze dell'anello secondo il richiedente
+	TargetInstance string                 `protobuf:"bytes,2,opt,name=target_instance,json=targetInstance,proto3" json:"target_instance,omitempty"` // Istanza che riceverà lo stato
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ExportStateRequest) Reset() {
+	*x = ExportStateRequest{}
+	mi := &file_analysis_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportStateRequest) ProtoMessage() {}
+
+func (x *ExportStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportStateRequest.ProtoReflect.Descriptor instead.
+func (*ExportStateRequest) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ExportStateRequest) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+func (x *ExportStateRequest) GetTargetInstance() string {
+	if x != nil {
+		return x.TargetInstance
+	}
+	return ""
+}
+
+// Esito dell'importazione di uno stato di correlazione.
+type ImportStateResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ImportedClients int32                  `protobuf:"varint,1,opt,name=imported_clients,json=importedClients,proto3" json:"imported_clients,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ImportStateResponse) Reset() {
+	*x = ImportStateResponse{}
+	mi := &file_analysis_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportStateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportStateResponse) ProtoMessage() {}
+
+func (x *ImportStateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_analysis_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportStateResponse.ProtoReflect.Descriptor instead.
+func (*ImportStateResponse) Descriptor() ([]byte, []int) {
+	return file_analysis_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ImportStateResponse) GetImportedClients() int32 {
+	if x != nil {
+		return x.ImportedClients
+	}
+	return 0
+}
+
 var File_analysis_proto protoreflect.FileDescriptor
 
 const file_analysis_proto_rawDesc = "" +
@@ -81,9 +385,28 @@ const file_analysis_proto_rawDesc = "" +
 	"\x0eanalysis.proto\x12\x05proto\x1a\rmetrics.proto\"J\n" +
 	"\x10AnalysisResponse\x12\x1c\n" +
 	"\tprocessed\x18\x01 \x01(\bR\tprocessed\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2J\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"6\n" +
+	"\vMetricBatch\x12'\n" +
+	"\ametrics\x18\x01 \x03(\v2\r.proto.MetricR\ametrics\"J\n" +
+	"\x15BatchAnalysisResponse\x121\n" +
+	"\aresults\x18\x01 \x03(\v2\x17.proto.AnalysisResponseR\aresults\"\x80\x01\n" +
+	"\fClientWindow\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12-\n" +
+	"\x12anomaly_timestamps\x18\x02 \x03(\x03R\x11anomalyTimestamps\x12$\n" +
+	"\x0emax_event_time\x18\x03 \x01(\x03R\fmaxEventTime\"j\n" +
+	"\x10CorrelationState\x12-\n" +
+	"\awindows\x18\x01 \x03(\v2\x13.proto.ClientWindowR\awindows\x12'\n" +
+	"\x0fsource_instance\x18\x02 \x01(\tR\x0esourceInstance\"W\n" +
+	"\x12ExportStateRequest\x12\x18\n" +
+	"\amembers\x18\x01 \x03(\tR\amembers\x12'\n" +
+	"\x0ftarget_instance\x18\x02 \x01(\tR\x0etargetInstance\"@\n" +
+	"\x13ImportStateResponse\x12)\n" +
+	"\x10imported_clients\x18\x01 \x01(\x05R\x0fimportedClients2\x95\x02\n" +
 	"\x0fAnalysisService\x127\n" +
-	"\rAnalyzeMetric\x12\r.proto.Metric\x1a\x17.proto.AnalysisResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
+	"\rAnalyzeMetric\x12\r.proto.Metric\x1a\x17.proto.AnalysisResponse\x12B\n" +
+	"\x0eAnalyzeMetrics\x12\x12.proto.MetricBatch\x1a\x1c.proto.BatchAnalysisResponse\x12A\n" +
+	"\vExportState\x12\x19.proto.ExportStateRequest\x1a\x17.proto.CorrelationState\x12B\n" +
+	"\vImportState\x12\x17.proto.CorrelationState\x1a\x1a.proto.ImportStateResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
 
 var (
 	file_analysis_proto_rawDescOnce sync.Once
@@ -97,19 +420,34 @@ func file_analysis_proto_rawDescGZIP() []byte {
 	return file_analysis_proto_rawDescData
 }
 
-var file_analysis_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_analysis_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_analysis_proto_goTypes = []any{
-	(*AnalysisResponse)(nil), // 0: proto.AnalysisResponse
-	(*Metric)(nil),           // 1: proto.Metric
+	(*AnalysisResponse)(nil),      // 0: proto.AnalysisResponse
+	(*MetricBatch)(nil),           // 1: proto.MetricBatch
+	(*BatchAnalysisResponse)(nil), // 2: proto.BatchAnalysisResponse
+	(*ClientWindow)(nil),          // 3: proto.ClientWindow
+	(*CorrelationState)(nil),      // 4: proto.CorrelationState
+	(*ExportStateRequest)(nil),    // 5: proto.ExportStateRequest
+	(*ImportStateResponse)(nil),   // 6: proto.ImportStateResponse
+	(*Metric)(nil),                // 7: proto.Metric
 }
 var file_analysis_proto_depIdxs = []int32{
-	1, // 0: proto.AnalysisService.AnalyzeMetric:input_type -> proto.Metric
-	0, // 1: proto.AnalysisService.AnalyzeMetric:output_type -> proto.AnalysisResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	7, // 0: proto.MetricBatch.metrics:type_name -> proto.Metric
+	0, // 1: proto.BatchAnalysisResponse.results:type_name -> proto.AnalysisResponse
+	3, // 2: proto.CorrelationState.windows:type_name -> proto.ClientWindow
+	7, // 3: proto.AnalysisService.AnalyzeMetric:input_type -> proto.Metric
+	1, // 4: proto.AnalysisService.AnalyzeMetrics:input_type -> proto.MetricBatch
+	5, // 5: proto.AnalysisService.ExportState:input_type -> proto.ExportStateRequest
+	4, // 6: proto.AnalysisService.ImportState:input_type -> proto.CorrelationState
+	0, // 7: proto.AnalysisService.AnalyzeMetric:output_type -> proto.AnalysisResponse
+	2, // 8: proto.AnalysisService.AnalyzeMetrics:output_type -> proto.BatchAnalysisResponse
+	4, // 9: proto.AnalysisService.ExportState:output_type -> proto.CorrelationState
+	6, // 10: proto.AnalysisService.ImportState:output_type -> proto.ImportStateResponse
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_analysis_proto_init() }
@@ -124,7 +462,7 @@ func file_analysis_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_analysis_proto_rawDesc), len(file_analysis_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
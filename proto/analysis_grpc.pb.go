@@ -19,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	AnalysisService_AnalyzeMetric_FullMethodName = "/proto.AnalysisService/AnalyzeMetric"
+	AnalysisService_AnalyzeMetric_FullMethodName  = "/proto.AnalysisService/AnalyzeMetric"
+	AnalysisService_AnalyzeMetrics_FullMethodName = "/proto.AnalysisService/AnalyzeMetrics"
+	AnalysisService_ExportState_FullMethodName    = "/proto.AnalysisService/ExportState"
+	AnalysisService_ImportState_FullMethodName    = "/proto.AnalysisService/ImportState"
 )
 
 // AnalysisServiceClient is the client API for AnalysisService service.
@@ -30,6 +33,12 @@ const (
 type AnalysisServiceClient interface {
 	// Riceve una metrica, la analizza e decide cosa fare.
 	AnalyzeMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*AnalysisResponse, error)
+	// Analizza un batch di metriche restituendo un esito per ciascuna, nello stesso ordine.
+	AnalyzeMetrics(ctx context.Context, in *MetricBatch, opts ...grpc.CallOption) (*BatchAnalysisResponse, error)
+	// Esporta (e rimuove localmente) le finestre dei client che appartengono all'istanza richiedente.
+	ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (*CorrelationState, error)
+	// Importa le finestre cedute da un'altra istanza, unendole a quelle locali.
+	ImportState(ctx context.Context, in *CorrelationState, opts ...grpc.CallOption) (*ImportStateResponse, error)
 }
 
 type analysisServiceClient struct {
@@ -50,6 +59,36 @@ func (c *analysisServiceClient) AnalyzeMetric(ctx context.Context, in *Metric, o
 	return out, nil
 }
 
+func (c *analysisServiceClient) AnalyzeMetrics(ctx context.Context, in *MetricBatch, opts ...grpc.CallOption) (*BatchAnalysisResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchAnalysisResponse)
+	err := c.cc.Invoke(ctx, AnalysisService_AnalyzeMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analysisServiceClient) ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (*CorrelationState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CorrelationState)
+	err := c.cc.Invoke(ctx, AnalysisService_ExportState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analysisServiceClient) ImportState(ctx context.Context, in *CorrelationState, opts ...grpc.CallOption) (*ImportStateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImportStateResponse)
+	err := c.cc.Invoke(ctx, AnalysisService_ImportState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AnalysisServiceServer is the server API for AnalysisService service.
 // All implementations must embed UnimplementedAnalysisServiceServer
 // for forward compatibility.
@@ -58,6 +97,12 @@ func (c *analysisServiceClient) AnalyzeMetric(ctx context.Context, in *Metric, o
 type AnalysisServiceServer interface {
 	// Riceve una metrica, la analizza e decide cosa fare.
 	AnalyzeMetric(context.Context, *Metric) (*AnalysisResponse, error)
+	// Analizza un batch di metriche restituendo un esito per ciascuna, nello stesso ordine.
+	AnalyzeMetrics(context.Context, *MetricBatch) (*BatchAnalysisResponse, error)
+	// Esporta (e rimuove localmente) le finestre dei client che appartengono all'istanza richiedente.
+	ExportState(context.Context, *ExportStateRequest) (*CorrelationState, error)
+	// Importa le finestre cedute da un'altra istanza, unendole a quelle locali.
+	ImportState(context.Context, *CorrelationState) (*ImportStateResponse, error)
 	mustEmbedUnimplementedAnalysisServiceServer()
 }
 
@@ -71,6 +116,15 @@ type UnimplementedAnalysisServiceServer struct{}
 func (UnimplementedAnalysisServiceServer) AnalyzeMetric(context.Context, *Metric) (*AnalysisResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AnalyzeMetric not implemented")
 }
+func (UnimplementedAnalysisServiceServer) AnalyzeMetrics(context.Context, *MetricBatch) (*BatchAnalysisResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AnalyzeMetrics not implemented")
+}
+func (UnimplementedAnalysisServiceServer) ExportState(context.Context, *ExportStateRequest) (*CorrelationState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportState not implemented")
+}
+func (UnimplementedAnalysisServiceServer) ImportState(context.Context, *CorrelationState) (*ImportStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportState not implemented")
+}
 func (UnimplementedAnalysisServiceServer) mustEmbedUnimplementedAnalysisServiceServer() {}
 func (UnimplementedAnalysisServiceServer) testEmbeddedByValue()                         {}
 
@@ -110,6 +164,60 @@ func _AnalysisService_AnalyzeMetric_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AnalysisService_AnalyzeMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalysisServiceServer).AnalyzeMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalysisService_AnalyzeMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalysisServiceServer).AnalyzeMetrics(ctx, req.(*MetricBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalysisService_ExportState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalysisServiceServer).ExportState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalysisService_ExportState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalysisServiceServer).ExportState(ctx, req.(*ExportStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalysisService_ImportState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CorrelationState)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalysisServiceServer).ImportState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalysisService_ImportState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalysisServiceServer).ImportState(ctx, req.(*CorrelationState))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // AnalysisService_ServiceDesc is the grpc.ServiceDesc for AnalysisService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -121,6 +229,18 @@ var AnalysisService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AnalyzeMetric",
 			Handler:    _AnalysisService_AnalyzeMetric_Handler,
 		},
+		{
+			MethodName: "AnalyzeMetrics",
+			Handler:    _AnalysisService_AnalyzeMetrics_Handler,
+		},
+		{
+			MethodName: "ExportState",
+			Handler:    _AnalysisService_ExportState_Handler,
+		},
+		{
+			MethodName: "ImportState",
+			Handler:    _AnalysisService_ImportState_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "analysis.proto",
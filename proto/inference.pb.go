@@ -70,7 +70,13 @@ func (x *InferenceRequest) GetFeatures() []float32 {
 type InferenceResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// La predizione del modello (-1 per anomalia, 1 per normale)
-	Prediction    int32 `protobuf:"varint,1,opt,name=prediction,proto3" json:"prediction,omitempty"`
+	Prediction int32 `protobuf:"varint,1,opt,name=prediction,proto3" json:"prediction,omitempty"`
+	// Punteggio continuo del modello (decision_function dell'Isolation Forest):
+	// negativo per le anomalie, tanto più basso quanto più la metrica è anomala
+	Score float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	// Nome e versione del modello che ha prodotto la predizione
+	ModelName     string `protobuf:"bytes,3,opt,name=model_name,json=modelName,proto3" json:"model_name,omitempty"`
+	ModelVersion  string `protobuf:"bytes,4,opt,name=model_version,json=modelVersion,proto3" json:"model_version,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -112,19 +118,139 @@ func (x *InferenceResponse) GetPrediction() int32 {
 	return 0
 }
 
+func (x *InferenceResponse) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *InferenceResponse) GetModelName() string {
+	if x != nil {
+		return x.ModelName
+	}
+	return ""
+}
+
+func (x *InferenceResponse) GetModelVersion() string {
+	if x != nil {
+		return x.ModelVersion
+	}
+	return ""
+}
+
+// Richiesta di inferenza per più metriche
+type InferenceBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*InferenceRequest    `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InferenceBatchRequest) Reset() {
+	*x = InferenceBatchRequest{}
+	mi := &file_proto_inference_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InferenceBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InferenceBatchRequest) ProtoMessage() {}
+
+func (x *InferenceBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_inference_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InferenceBatchRequest.ProtoReflect.Descriptor instead.
+func (*InferenceBatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_inference_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *InferenceBatchRequest) GetRequests() []*InferenceRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+// Risposte di inferenza, nello stesso ordine delle richieste
+type InferenceBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Responses     []*InferenceResponse   `protobuf:"bytes,1,rep,name=responses,proto3" json:"responses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InferenceBatchResponse) Reset() {
+	*x = InferenceBatchResponse{}
+	mi := &file_proto_inference_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InferenceBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InferenceBatchResponse) ProtoMessage() {}
+
+func (x *InferenceBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_inference_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InferenceBatchResponse.ProtoReflect.Descriptor instead.
+func (*InferenceBatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_inference_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *InferenceBatchResponse) GetResponses() []*InferenceResponse {
+	if x != nil {
+		return x.Responses
+	}
+	return nil
+}
+
 var File_proto_inference_proto protoreflect.FileDescriptor
 
 const file_proto_inference_proto_rawDesc = "" +
 	"\n" +
 	"\x15proto/inference.proto\x12\x05proto\".\n" +
 	"\x10InferenceRequest\x12\x1a\n" +
-	"\bfeatures\x18\x01 \x03(\x02R\bfeatures\"3\n" +
+	"\bfeatures\x18\x01 \x03(\x02R\bfeatures\"\x8d\x01\n" +
 	"\x11InferenceResponse\x12\x1e\n" +
 	"\n" +
 	"prediction\x18\x01 \x01(\x05R\n" +
-	"prediction2I\n" +
+	"prediction\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\x12\x1d\n" +
+	"\n" +
+	"model_name\x18\x03 \x01(\tR\tmodelName\x12#\n" +
+	"\rmodel_version\x18\x04 \x01(\tR\fmodelVersion\"L\n" +
+	"\x15InferenceBatchRequest\x123\n" +
+	"\brequests\x18\x01 \x03(\v2\x17.proto.InferenceRequestR\brequests\"P\n" +
+	"\x16InferenceBatchResponse\x126\n" +
+	"\tresponses\x18\x01 \x03(\v2\x18.proto.InferenceResponseR\tresponses2\x96\x01\n" +
 	"\tInference\x12<\n" +
-	"\aPredict\x12\x17.proto.InferenceRequest\x1a\x18.proto.InferenceResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
+	"\aPredict\x12\x17.proto.InferenceRequest\x1a\x18.proto.InferenceResponse\x12K\n" +
+	"\fPredictBatch\x12\x1c.proto.InferenceBatchRequest\x1a\x1d.proto.InferenceBatchResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
 
 var (
 	file_proto_inference_proto_rawDescOnce sync.Once
@@ -138,19 +264,25 @@ func file_proto_inference_proto_rawDescGZIP() []byte {
 	return file_proto_inference_proto_rawDescData
 }
 
-var file_proto_inference_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_inference_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_proto_inference_proto_goTypes = []any{
-	(*InferenceRequest)(nil),  // 0: proto.InferenceRequest
-	(*InferenceResponse)(nil), // 1: proto.InferenceResponse
+	(*InferenceRequest)(nil),       // 0: proto.InferenceRequest
+	(*InferenceResponse)(nil),      // 1: proto.InferenceResponse
+	(*InferenceBatchRequest)(nil),  // 2: proto.InferenceBatchRequest
+	(*InferenceBatchResponse)(nil), // 3: proto.InferenceBatchResponse
 }
 var file_proto_inference_proto_depIdxs = []int32{
-	0, // 0: proto.Inference.Predict:input_type -> proto.InferenceRequest
-	1, // 1: proto.Inference.Predict:output_type -> proto.InferenceResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: proto.InferenceBatchRequest.requests:type_name -> proto.InferenceRequest
+	1, // 1: proto.InferenceBatchResponse.responses:type_name -> proto.InferenceResponse
+	0, // 2: proto.Inference.Predict:input_type -> proto.InferenceRequest
+	2, // 3: proto.Inference.PredictBatch:input_type -> proto.InferenceBatchRequest
+	1, // 4: proto.Inference.Predict:output_type -> proto.InferenceResponse
+	3, // 5: proto.Inference.PredictBatch:output_type -> proto.InferenceBatchResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_inference_proto_init() }
@@ -164,7 +296,7 @@ func file_proto_inference_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_inference_proto_rawDesc), len(file_proto_inference_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Inference_Predict_FullMethodName = "/proto.Inference/Predict"
+	Inference_Predict_FullMethodName      = "/proto.Inference/Predict"
+	Inference_PredictBatch_FullMethodName = "/proto.Inference/PredictBatch"
 )
 
 // InferenceClient is the client API for Inference service.
@@ -30,6 +31,8 @@ const (
 type InferenceClient interface {
 	// RPC per ottenere una predizione da un set di feature
 	Predict(ctx context.Context, in *InferenceRequest, opts ...grpc.CallOption) (*InferenceResponse, error)
+	// RPC per ottenere le predizioni di più set di feature con una sola chiamata
+	PredictBatch(ctx context.Context, in *InferenceBatchRequest, opts ...grpc.CallOption) (*InferenceBatchResponse, error)
 }
 
 type inferenceClient struct {
@@ -50,6 +53,16 @@ func (c *inferenceClient) Predict(ctx context.Context, in *InferenceRequest, opt
 	return out, nil
 }
 
+func (c *inferenceClient) PredictBatch(ctx context.Context, in *InferenceBatchRequest, opts ...grpc.CallOption) (*InferenceBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InferenceBatchResponse)
+	err := c.cc.Invoke(ctx, Inference_PredictBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // InferenceServer is the server API for Inference service.
 // All implementations must embed UnimplementedInferenceServer
 // for forward compatibility.
@@ -58,6 +71,8 @@ func (c *inferenceClient) Predict(ctx context.Context, in *InferenceRequest, opt
 type InferenceServer interface {
 	// RPC per ottenere una predizione da un set di feature
 	Predict(context.Context, *InferenceRequest) (*InferenceResponse, error)
+	// RPC per ottenere le predizioni di più set di feature con una sola chiamata
+	PredictBatch(context.Context, *InferenceBatchRequest) (*InferenceBatchResponse, error)
 	mustEmbedUnimplementedInferenceServer()
 }
 
@@ -71,6 +86,9 @@ type UnimplementedInferenceServer struct{}
 func (UnimplementedInferenceServer) Predict(context.Context, *InferenceRequest) (*InferenceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Predict not implemented")
 }
+func (UnimplementedInferenceServer) PredictBatch(context.Context, *InferenceBatchRequest) (*InferenceBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PredictBatch not implemented")
+}
 func (UnimplementedInferenceServer) mustEmbedUnimplementedInferenceServer() {}
 func (UnimplementedInferenceServer) testEmbeddedByValue()                   {}
 
@@ -110,6 +128,24 @@ func _Inference_Predict_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Inference_PredictBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InferenceBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).PredictBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_PredictBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).PredictBatch(ctx, req.(*InferenceBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Inference_ServiceDesc is the grpc.ServiceDesc for Inference service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -121,6 +157,10 @@ var Inference_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Predict",
 			Handler:    _Inference_Predict_Handler,
 		},
+		{
+			MethodName: "PredictBatch",
+			Handler:    _Inference_PredictBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/inference.proto",
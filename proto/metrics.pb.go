@@ -31,8 +31,12 @@ type Metric struct {
 	Value          float64                `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"` // Possiamo tenerlo per metriche semplici
 	Timestamp      int64                  `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	Features       []float32              `protobuf:"fixed32,5,rep,packed,name=features,proto3" json:"features,omitempty"` // <-- NUOVO CAMPO: un array di float
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	// Identificativo univoco della metrica, assegnato dal sensore e riusato nei tentativi successivi
+	// allo stesso invio: collector, analisi e storage scartano le ripetizioni con lo stesso ID.
+	// Se assente lo assegna il collector (le ripetizioni del sensore non sono allora riconoscibili).
+	MetricId      string `protobuf:"bytes,6,opt,name=metric_id,json=metricId,proto3" json:"metric_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Metric) Reset() {
@@ -100,6 +104,13 @@ func (x *Metric) GetFeatures() []float32 {
 	return nil
 }
 
+func (x *Metric) GetMetricId() string {
+	if x != nil {
+		return x.MetricId
+	}
+	return ""
+}
+
 // Risposta dal collector.
 type CollectorResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -153,23 +164,155 @@ func (x *CollectorResponse) GetMessage() string {
 	return ""
 }
 
+// Esito dell'ingestione di un singolo record all'interno di uno stream.
+type RecordResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`       // Posizione del record nello stream (a partire da 0)
+	Accepted      bool                   `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"` // True se il record è stato accettato
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`    // Messaggio di stato per il record
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordResult) Reset() {
+	*x = RecordResult{}
+	mi := &file_metrics_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordResult) ProtoMessage() {}
+
+func (x *RecordResult) ProtoReflect() protoreflect.Message {
+	mi := &file_metrics_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordResult.ProtoReflect.Descriptor instead.
+func (*RecordResult) Descriptor() ([]byte, []int) {
+	return file_metrics_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RecordResult) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *RecordResult) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *RecordResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// Risposta del collector al termine di uno stream di metriche.
+type BatchCollectorResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Received      int32                  `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"` // Numero di record ricevuti
+	Accepted      int32                  `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"` // Numero di record accettati
+	Results       []*RecordResult        `protobuf:"bytes,3,rep,name=results,proto3" json:"results,omitempty"`    // Esito per ogni record, nell'ordine di arrivo
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCollectorResponse) Reset() {
+	*x = BatchCollectorResponse{}
+	mi := &file_metrics_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCollectorResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCollectorResponse) ProtoMessage() {}
+
+func (x *BatchCollectorResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metrics_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCollectorResponse.ProtoReflect.Descriptor instead.
+func (*BatchCollectorResponse) Descriptor() ([]byte, []int) {
+	return file_metrics_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchCollectorResponse) GetReceived() int32 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+func (x *BatchCollectorResponse) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *BatchCollectorResponse) GetResults() []*RecordResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
 var File_metrics_proto protoreflect.FileDescriptor
 
 const file_metrics_proto_rawDesc = "" +
 	"\n" +
-	"\rmetrics.proto\x12\x05proto\"\x96\x01\n" +
+	"\rmetrics.proto\x12\x05proto\"\xb3\x01\n" +
 	"\x06Metric\x12(\n" +
 	"\x10source_client_id\x18\x01 \x01(\tR\x0esourceClientId\x12\x12\n" +
 	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
 	"\x05value\x18\x03 \x01(\x01R\x05value\x12\x1c\n" +
 	"\ttimestamp\x18\x04 \x01(\x03R\ttimestamp\x12\x1a\n" +
-	"\bfeatures\x18\x05 \x03(\x02R\bfeatures\"I\n" +
+	"\bfeatures\x18\x05 \x03(\x02R\bfeatures\x12\x1b\n" +
+	"\tmetric_id\x18\x06 \x01(\tR\bmetricId\"I\n" +
 	"\x11CollectorResponse\x12\x1a\n" +
 	"\baccepted\x18\x01 \x01(\bR\baccepted\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2I\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"Z\n" +
+	"\fRecordResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\bR\baccepted\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"\x7f\n" +
+	"\x16BatchCollectorResponse\x12\x1a\n" +
+	"\breceived\x18\x01 \x01(\x05R\breceived\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\x05R\baccepted\x12-\n" +
+	"\aresults\x18\x03 \x03(\v2\x13.proto.RecordResultR\aresults2\x88\x01\n" +
 	"\x10MetricsCollector\x125\n" +
 	"\n" +
-	"SendMetric\x12\r.proto.Metric\x1a\x18.proto.CollectorResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
+	"SendMetric\x12\r.proto.Metric\x1a\x18.proto.CollectorResponse\x12=\n" +
+	"\vSendMetrics\x12\r.proto.Metric\x1a\x1d.proto.BatchCollectorResponse(\x01B+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
 
 var (
 	file_metrics_proto_rawDescOnce sync.Once
@@ -183,19 +326,24 @@ func file_metrics_proto_rawDescGZIP() []byte {
 	return file_metrics_proto_rawDescData
 }
 
-var file_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_metrics_proto_goTypes = []any{
-	(*Metric)(nil),            // 0: proto.Metric
-	(*CollectorResponse)(nil), // 1: proto.CollectorResponse
+	(*Metric)(nil),                 // 0: proto.Metric
+	(*CollectorResponse)(nil),      // 1: proto.CollectorResponse
+	(*RecordResult)(nil),           // 2: proto.RecordResult
+	(*BatchCollectorResponse)(nil), // 3: proto.BatchCollectorResponse
 }
 var file_metrics_proto_depIdxs = []int32{
-	0, // 0: proto.MetricsCollector.SendMetric:input_type -> proto.Metric
-	1, // 1: proto.MetricsCollector.SendMetric:output_type -> proto.CollectorResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2, // 0: proto.BatchCollectorResponse.results:type_name -> proto.RecordResult
+	0, // 1: proto.MetricsCollector.SendMetric:input_type -> proto.Metric
+	0, // 2: proto.MetricsCollector.SendMetrics:input_type -> proto.Metric
+	1, // 3: proto.MetricsCollector.SendMetric:output_type -> proto.CollectorResponse
+	3, // 4: proto.MetricsCollector.SendMetrics:output_type -> proto.BatchCollectorResponse
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_metrics_proto_init() }
@@ -209,7 +357,7 @@ func file_metrics_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_metrics_proto_rawDesc), len(file_metrics_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
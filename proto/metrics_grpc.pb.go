@@ -21,7 +21,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MetricsCollector_SendMetric_FullMethodName = "/proto.MetricsCollector/SendMetric"
+	MetricsCollector_SendMetric_FullMethodName  = "/proto.MetricsCollector/SendMetric"
+	MetricsCollector_SendMetrics_FullMethodName = "/proto.MetricsCollector/SendMetrics"
 )
 
 // MetricsCollectorClient is the client API for MetricsCollector service.
@@ -32,6 +33,9 @@ const (
 type MetricsCollectorClient interface {
 	// Un semplice RPC per inviare un singolo dato metrico.
 	SendMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*CollectorResponse, error)
+	// RPC client-streaming per l'ingestione in batch: il sensore invia un flusso
+	// di metriche e riceve alla chiusura l'esito di ogni singolo record.
+	SendMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, BatchCollectorResponse], error)
 }
 
 type metricsCollectorClient struct {
@@ -52,6 +56,19 @@ func (c *metricsCollectorClient) SendMetric(ctx context.Context, in *Metric, opt
 	return out, nil
 }
 
+func (c *metricsCollectorClient) SendMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, BatchCollectorResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MetricsCollector_ServiceDesc.Streams[0], MetricsCollector_SendMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Metric, BatchCollectorResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricsCollector_SendMetricsClient = grpc.ClientStreamingClient[Metric, BatchCollectorResponse]
+
 // MetricsCollectorServer is the server API for MetricsCollector service.
 // All implementations must embed UnimplementedMetricsCollectorServer
 // for forward compatibility.
@@ -60,6 +77,9 @@ func (c *metricsCollectorClient) SendMetric(ctx context.Context, in *Metric, opt
 type MetricsCollectorServer interface {
 	// Un semplice RPC per inviare un singolo dato metrico.
 	SendMetric(context.Context, *Metric) (*CollectorResponse, error)
+	// RPC client-streaming per l'ingestione in batch: il sensore invia un flusso
+	// di metriche e riceve alla chiusura l'esito di ogni singolo record.
+	SendMetrics(grpc.ClientStreamingServer[Metric, BatchCollectorResponse]) error
 	mustEmbedUnimplementedMetricsCollectorServer()
 }
 
@@ -73,6 +93,9 @@ type UnimplementedMetricsCollectorServer struct{}
 func (UnimplementedMetricsCollectorServer) SendMetric(context.Context, *Metric) (*CollectorResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendMetric not implemented")
 }
+func (UnimplementedMetricsCollectorServer) SendMetrics(grpc.ClientStreamingServer[Metric, BatchCollectorResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method SendMetrics not implemented")
+}
 func (UnimplementedMetricsCollectorServer) mustEmbedUnimplementedMetricsCollectorServer() {}
 func (UnimplementedMetricsCollectorServer) testEmbeddedByValue()                          {}
 
@@ -112,6 +135,13 @@ func _MetricsCollector_SendMetric_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsCollector_SendMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsCollectorServer).SendMetrics(&grpc.GenericServerStream[Metric, BatchCollectorResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricsCollector_SendMetricsServer = grpc.ClientStreamingServer[Metric, BatchCollectorResponse]
+
 // MetricsCollector_ServiceDesc is the grpc.ServiceDesc for MetricsCollector service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -124,6 +154,12 @@ var MetricsCollector_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _MetricsCollector_SendMetric_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendMetrics",
+			Handler:       _MetricsCollector_SendMetrics_Handler,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "metrics.proto",
 }
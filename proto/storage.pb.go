@@ -29,6 +29,11 @@ type Alarm struct {
 	Description   string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`                          // Descrizione dell'allarme
 	Timestamp     int64                  `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                             // Timestamp Unix dell'allarme
 	TriggerMetric *Metric                `protobuf:"bytes,5,opt,name=trigger_metric,json=triggerMetric,proto3" json:"trigger_metric,omitempty"` // La metrica specifica che ha causato l'allarme
+	Severity      string                 `protobuf:"bytes,6,opt,name=severity,proto3" json:"severity,omitempty"`                                // Gravità dichiarata dalla regola: low, medium, high o critical
+	Score         *float64               `protobuf:"fixed64,7,opt,name=score,proto3,oneof" json:"score,omitempty"`                              // Punteggio del modello per la metrica (assente se l'inferenza non era disponibile)
+	ModelName     string                 `protobuf:"bytes,8,opt,name=model_name,json=modelName,proto3" json:"model_name,omitempty"`             // Modello che ha prodotto il punteggio
+	ModelVersion  string                 `protobuf:"bytes,9,opt,name=model_version,json=modelVersion,proto3" json:"model_version,omitempty"`    // Versione del modello che ha prodotto il punteggio
+	Id            string                 `protobuf:"bytes,10,opt,name=id,proto3" json:"id,omitempty"`                                           // Identificativo assegnato dallo storage, valorizzato solo in lettura
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -98,6 +103,41 @@ func (x *Alarm) GetTriggerMetric() *Metric {
 	return nil
 }
 
+func (x *Alarm) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *Alarm) GetScore() float64 {
+	if x != nil && x.Score != nil {
+		return *x.Score
+	}
+	return 0
+}
+
+func (x *Alarm) GetModelName() string {
+	if x != nil {
+		return x.ModelName
+	}
+	return ""
+}
+
+func (x *Alarm) GetModelVersion() string {
+	if x != nil {
+		return x.ModelVersion
+	}
+	return ""
+}
+
+func (x *Alarm) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
 // Risposta generica dal servizio di storage
 type StorageResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -151,24 +191,766 @@ func (x *StorageResponse) GetMessage() string {
 	return ""
 }
 
+// Intervallo temporale delle query, in secondi Unix: start incluso, end escluso.
+// Uno start a 0 non pone limiti inferiori, un end a 0 non pone limiti superiori (gli allarmi
+// hanno il timestamp dell'evento, che può essere successivo all'orologio dello storage).
+type TimeRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         int64                  `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           int64                  `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TimeRange) Reset() {
+	*x = TimeRange{}
+	mi := &file_storage_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimeRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeRange) ProtoMessage() {}
+
+func (x *TimeRange) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeRange.ProtoReflect.Descriptor instead.
+func (*TimeRange) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TimeRange) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *TimeRange) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+type ListAlarmsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"` // Filtri opzionali: un campo vuoto non filtra
+	RuleId        string                 `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	Severity      string                 `protobuf:"bytes,3,opt,name=severity,proto3" json:"severity,omitempty"`
+	TimeRange     *TimeRange             `protobuf:"bytes,4,opt,name=time_range,json=timeRange,proto3" json:"time_range,omitempty"`
+	PageSize      int32                  `protobuf:"varint,5,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`   // 0 per la dimensione predefinita, limitata dal servizio
+	PageToken     string                 `protobuf:"bytes,6,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"` // next_page_token della pagina precedente, vuoto per la prima
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAlarmsRequest) Reset() {
+	*x = ListAlarmsRequest{}
+	mi := &file_storage_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAlarmsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAlarmsRequest) ProtoMessage() {}
+
+func (x *ListAlarmsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAlarmsRequest.ProtoReflect.Descriptor instead.
+func (*ListAlarmsRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListAlarmsRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ListAlarmsRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *ListAlarmsRequest) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *ListAlarmsRequest) GetTimeRange() *TimeRange {
+	if x != nil {
+		return x.TimeRange
+	}
+	return nil
+}
+
+func (x *ListAlarmsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListAlarmsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListAlarmsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alarms        []*Alarm               `protobuf:"bytes,1,rep,name=alarms,proto3" json:"alarms,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"` // Vuoto se non ci sono altre pagine
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAlarmsResponse) Reset() {
+	*x = ListAlarmsResponse{}
+	mi := &file_storage_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAlarmsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAlarmsResponse) ProtoMessage() {}
+
+func (x *ListAlarmsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAlarmsResponse.ProtoReflect.Descriptor instead.
+func (*ListAlarmsResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListAlarmsResponse) GetAlarms() []*Alarm {
+	if x != nil {
+		return x.Alarms
+	}
+	return nil
+}
+
+func (x *ListAlarmsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetAlarmRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetAlarmRequest) Reset() {
+	*x = GetAlarmRequest{}
+	mi := &file_storage_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetAlarmRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetAlarmRequest) ProtoMessage() {}
+
+func (x *GetAlarmRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetAlarmRequest.ProtoReflect.Descriptor instead.
+func (*GetAlarmRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetAlarmRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type QueryMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"` // Tipo della metrica (es. network_traffic)
+	TimeRange     *TimeRange             `protobuf:"bytes,3,opt,name=time_range,json=timeRange,proto3" json:"time_range,omitempty"`
+	PageSize      int32                  `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,5,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryMetricsRequest) Reset() {
+	*x = QueryMetricsRequest{}
+	mi := &file_storage_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryMetricsRequest) ProtoMessage() {}
+
+func (x *QueryMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryMetricsRequest.ProtoReflect.Descriptor instead.
+func (*QueryMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *QueryMetricsRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *QueryMetricsRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *QueryMetricsRequest) GetTimeRange() *TimeRange {
+	if x != nil {
+		return x.TimeRange
+	}
+	return nil
+}
+
+func (x *QueryMetricsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *QueryMetricsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type QueryMetricsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryMetricsResponse) Reset() {
+	*x = QueryMetricsResponse{}
+	mi := &file_storage_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryMetricsResponse) ProtoMessage() {}
+
+func (x *QueryMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryMetricsResponse.ProtoReflect.Descriptor instead.
+func (*QueryMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QueryMetricsResponse) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *QueryMetricsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetClientTimelineRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"` // Obbligatorio
+	TimeRange     *TimeRange             `protobuf:"bytes,2,opt,name=time_range,json=timeRange,proto3" json:"time_range,omitempty"`
+	PageSize      int32                  `protobuf:"varint,3,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,4,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetClientTimelineRequest) Reset() {
+	*x = GetClientTimelineRequest{}
+	mi := &file_storage_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetClientTimelineRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetClientTimelineRequest) ProtoMessage() {}
+
+func (x *GetClientTimelineRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetClientTimelineRequest.ProtoReflect.Descriptor instead.
+func (*GetClientTimelineRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetClientTimelineRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *GetClientTimelineRequest) GetTimeRange() *TimeRange {
+	if x != nil {
+		return x.TimeRange
+	}
+	return nil
+}
+
+func (x *GetClientTimelineRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *GetClientTimelineRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+// Evento della timeline di un client: una metrica ricevuta o un allarme generato
+type TimelineEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*TimelineEvent_Metric
+	//	*TimelineEvent_Alarm
+	Event         isTimelineEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TimelineEvent) Reset() {
+	*x = TimelineEvent{}
+	mi := &file_storage_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimelineEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimelineEvent) ProtoMessage() {}
+
+func (x *TimelineEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimelineEvent.ProtoReflect.Descriptor instead.
+func (*TimelineEvent) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TimelineEvent) GetEvent() isTimelineEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *TimelineEvent) GetMetric() *Metric {
+	if x != nil {
+		if x, ok := x.Event.(*TimelineEvent_Metric); ok {
+			return x.Metric
+		}
+	}
+	return nil
+}
+
+func (x *TimelineEvent) GetAlarm() *Alarm {
+	if x != nil {
+		if x, ok := x.Event.(*TimelineEvent_Alarm); ok {
+			return x.Alarm
+		}
+	}
+	return nil
+}
+
+type isTimelineEvent_Event interface {
+	isTimelineEvent_Event()
+}
+
+type TimelineEvent_Metric struct {
+	Metric *Metric `protobuf:"bytes,1,opt,name=metric,proto3,oneof"`
+}
+
+type TimelineEvent_Alarm struct {
+	Alarm *Alarm `protobuf:"bytes,2,opt,name=alarm,proto3,oneof"`
+}
+
+func (*TimelineEvent_Metric) isTimelineEvent_Event() {}
+
+func (*TimelineEvent_Alarm) isTimelineEvent_Event() {}
+
+type GetClientTimelineResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*TimelineEvent       `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetClientTimelineResponse) Reset() {
+	*x = GetClientTimelineResponse{}
+	mi := &file_storage_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetClientTimelineResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetClientTimelineResponse) ProtoMessage() {}
+
+func (x *GetClientTimelineResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetClientTimelineResponse.ProtoReflect.Descriptor instead.
+func (*GetClientTimelineResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetClientTimelineResponse) GetEvents() []*TimelineEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *GetClientTimelineResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type WatchAlarmsRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ClientIdPrefix string                 `protobuf:"bytes,1,opt,name=client_id_prefix,json=clientIdPrefix,proto3" json:"client_id_prefix,omitempty"` // Solo gli allarmi dei client con questo prefisso (vuoto: tutti)
+	MinSeverity    string                 `protobuf:"bytes,2,opt,name=min_severity,json=minSeverity,proto3" json:"min_severity,omitempty"`            // low, medium, high o critical (vuoto: tutte le gravità)
+	ResumeToken    string                 `protobuf:"bytes,3,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`            // resume_token dell'ultimo evento ricevuto, per riprendere dopo una disconnessione
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WatchAlarmsRequest) Reset() {
+	*x = WatchAlarmsRequest{}
+	mi := &file_storage_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchAlarmsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchAlarmsRequest) ProtoMessage() {}
+
+func (x *WatchAlarmsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchAlarmsRequest.ProtoReflect.Descriptor instead.
+func (*WatchAlarmsRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchAlarmsRequest) GetClientIdPrefix() string {
+	if x != nil {
+		return x.ClientIdPrefix
+	}
+	return ""
+}
+
+func (x *WatchAlarmsRequest) GetMinSeverity() string {
+	if x != nil {
+		return x.MinSeverity
+	}
+	return ""
+}
+
+func (x *WatchAlarmsRequest) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+type AlarmEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alarm         *Alarm                 `protobuf:"bytes,1,opt,name=alarm,proto3" json:"alarm,omitempty"`
+	ResumeToken   string                 `protobuf:"bytes,2,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"` // Da passare a WatchAlarms per ricevere gli allarmi successivi a questo
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AlarmEvent) Reset() {
+	*x = AlarmEvent{}
+	mi := &file_storage_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AlarmEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AlarmEvent) ProtoMessage() {}
+
+func (x *AlarmEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AlarmEvent.ProtoReflect.Descriptor instead.
+func (*AlarmEvent) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AlarmEvent) GetAlarm() *Alarm {
+	if x != nil {
+		return x.Alarm
+	}
+	return nil
+}
+
+func (x *AlarmEvent) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
 var File_storage_proto protoreflect.FileDescriptor
 
 const file_storage_proto_rawDesc = "" +
 	"\n" +
-	"\rstorage.proto\x12\x05proto\x1a\rmetrics.proto\"\xb3\x01\n" +
+	"\rstorage.proto\x12\x05proto\x1a\rmetrics.proto\"\xc8\x02\n" +
 	"\x05Alarm\x12\x17\n" +
 	"\arule_id\x18\x01 \x01(\tR\x06ruleId\x12\x1b\n" +
 	"\tclient_id\x18\x02 \x01(\tR\bclientId\x12 \n" +
 	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\x1c\n" +
 	"\ttimestamp\x18\x04 \x01(\x03R\ttimestamp\x124\n" +
-	"\x0etrigger_metric\x18\x05 \x01(\v2\r.proto.MetricR\rtriggerMetric\"E\n" +
+	"\x0etrigger_metric\x18\x05 \x01(\v2\r.proto.MetricR\rtriggerMetric\x12\x1a\n" +
+	"\bseverity\x18\x06 \x01(\tR\bseverity\x12\x19\n" +
+	"\x05score\x18\a \x01(\x01H\x00R\x05score\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"model_name\x18\b \x01(\tR\tmodelName\x12#\n" +
+	"\rmodel_version\x18\t \x01(\tR\fmodelVersion\x12\x0e\n" +
+	"\x02id\x18\n" +
+	" \x01(\tR\x02idB\b\n" +
+	"\x06_score\"E\n" +
 	"\x0fStorageResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2s\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"3\n" +
+	"\tTimeRange\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x03R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x03R\x03end\"\xd2\x01\n" +
+	"\x11ListAlarmsRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x1a\n" +
+	"\bseverity\x18\x03 \x01(\tR\bseverity\x12/\n" +
+	"\n" +
+	"time_range\x18\x04 \x01(\v2\x10.proto.TimeRangeR\ttimeRange\x12\x1b\n" +
+	"\tpage_size\x18\x05 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x06 \x01(\tR\tpageToken\"b\n" +
+	"\x12ListAlarmsResponse\x12$\n" +
+	"\x06alarms\x18\x01 \x03(\v2\f.proto.AlarmR\x06alarms\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"!\n" +
+	"\x0fGetAlarmRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xb3\x01\n" +
+	"\x13QueryMetricsRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12/\n" +
+	"\n" +
+	"time_range\x18\x03 \x01(\v2\x10.proto.TimeRangeR\ttimeRange\x12\x1b\n" +
+	"\tpage_size\x18\x04 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x05 \x01(\tR\tpageToken\"g\n" +
+	"\x14QueryMetricsResponse\x12'\n" +
+	"\ametrics\x18\x01 \x03(\v2\r.proto.MetricR\ametrics\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\xa4\x01\n" +
+	"\x18GetClientTimelineRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12/\n" +
+	"\n" +
+	"time_range\x18\x02 \x01(\v2\x10.proto.TimeRangeR\ttimeRange\x12\x1b\n" +
+	"\tpage_size\x18\x03 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x04 \x01(\tR\tpageToken\"g\n" +
+	"\rTimelineEvent\x12'\n" +
+	"\x06metric\x18\x01 \x01(\v2\r.proto.MetricH\x00R\x06metric\x12$\n" +
+	"\x05alarm\x18\x02 \x01(\v2\f.proto.AlarmH\x00R\x05alarmB\a\n" +
+	"\x05event\"q\n" +
+	"\x19GetClientTimelineResponse\x12,\n" +
+	"\x06events\x18\x01 \x03(\v2\x14.proto.TimelineEventR\x06events\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\x84\x01\n" +
+	"\x12WatchAlarmsRequest\x12(\n" +
+	"\x10client_id_prefix\x18\x01 \x01(\tR\x0eclientIdPrefix\x12!\n" +
+	"\fmin_severity\x18\x02 \x01(\tR\vminSeverity\x12!\n" +
+	"\fresume_token\x18\x03 \x01(\tR\vresumeToken\"S\n" +
+	"\n" +
+	"AlarmEvent\x12\"\n" +
+	"\x05alarm\x18\x01 \x01(\v2\f.proto.AlarmR\x05alarm\x12!\n" +
+	"\fresume_token\x18\x02 \x01(\tR\vresumeToken2\xc8\x03\n" +
 	"\aStorage\x124\n" +
 	"\vStoreMetric\x12\r.proto.Metric\x1a\x16.proto.StorageResponse\x122\n" +
 	"\n" +
-	"StoreAlarm\x12\f.proto.Alarm\x1a\x16.proto.StorageResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
+	"StoreAlarm\x12\f.proto.Alarm\x1a\x16.proto.StorageResponse\x12A\n" +
+	"\n" +
+	"ListAlarms\x12\x18.proto.ListAlarmsRequest\x1a\x19.proto.ListAlarmsResponse\x120\n" +
+	"\bGetAlarm\x12\x16.proto.GetAlarmRequest\x1a\f.proto.Alarm\x12G\n" +
+	"\fQueryMetrics\x12\x1a.proto.QueryMetricsRequest\x1a\x1b.proto.QueryMetricsResponse\x12V\n" +
+	"\x11GetClientTimeline\x12\x1f.proto.GetClientTimelineRequest\x1a .proto.GetClientTimelineResponse\x12=\n" +
+	"\vWatchAlarms\x12\x19.proto.WatchAlarmsRequest\x1a\x11.proto.AlarmEvent0\x01B+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3"
 
 var (
 	file_storage_proto_rawDescOnce sync.Once
@@ -182,23 +964,53 @@ func file_storage_proto_rawDescGZIP() []byte {
 	return file_storage_proto_rawDescData
 }
 
-var file_storage_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_storage_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_storage_proto_goTypes = []any{
-	(*Alarm)(nil),           // 0: proto.Alarm
-	(*StorageResponse)(nil), // 1: proto.StorageResponse
-	(*Metric)(nil),          // 2: proto.Metric
+	(*Alarm)(nil),                     // 0: proto.Alarm
+	(*StorageResponse)(nil),           // 1: proto.StorageResponse
+	(*TimeRange)(nil),                 // 2: proto.TimeRange
+	(*ListAlarmsRequest)(nil),         // 3: proto.ListAlarmsRequest
+	(*ListAlarmsResponse)(nil),        // 4: proto.ListAlarmsResponse
+	(*GetAlarmRequest)(nil),           // 5: proto.GetAlarmRequest
+	(*QueryMetricsRequest)(nil),       // 6: proto.QueryMetricsRequest
+	(*QueryMetricsResponse)(nil),      // 7: proto.QueryMetricsResponse
+	(*GetClientTimelineRequest)(nil),  // 8: proto.GetClientTimelineRequest
+	(*TimelineEvent)(nil),             // 9: proto.TimelineEvent
+	(*GetClientTimelineResponse)(nil), // 10: proto.GetClientTimelineResponse
+	(*WatchAlarmsRequest)(nil),        // 11: proto.WatchAlarmsRequest
+	(*AlarmEvent)(nil),                // 12: proto.AlarmEvent
+	(*Metric)(nil),                    // 13: proto.Metric
 }
 var file_storage_proto_depIdxs = []int32{
-	2, // 0: proto.Alarm.trigger_metric:type_name -> proto.Metric
-	2, // 1: proto.Storage.StoreMetric:input_type -> proto.Metric
-	0, // 2: proto.Storage.StoreAlarm:input_type -> proto.Alarm
-	1, // 3: proto.Storage.StoreMetric:output_type -> proto.StorageResponse
-	1, // 4: proto.Storage.StoreAlarm:output_type -> proto.StorageResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	13, // 0: proto.Alarm.trigger_metric:type_name -> proto.Metric
+	2,  // 1: proto.ListAlarmsRequest.time_range:type_name -> proto.TimeRange
+	0,  // 2: proto.ListAlarmsResponse.alarms:type_name -> proto.Alarm
+	2,  // 3: proto.QueryMetricsRequest.time_range:type_name -> proto.TimeRange
+	13, // 4: proto.QueryMetricsResponse.metrics:type_name -> proto.Metric
+	2,  // 5: proto.GetClientTimelineRequest.time_range:type_name -> proto.TimeRange
+	13, // 6: proto.TimelineEvent.metric:type_name -> proto.Metric
+	0,  // 7: proto.TimelineEvent.alarm:type_name -> proto.Alarm
+	9,  // 8: proto.GetClientTimelineResponse.events:type_name -> proto.TimelineEvent
+	0,  // 9: proto.AlarmEvent.alarm:type_name -> proto.Alarm
+	13, // 10: proto.Storage.StoreMetric:input_type -> proto.Metric
+	0,  // 11: proto.Storage.StoreAlarm:input_type -> proto.Alarm
+	3,  // 12: proto.Storage.ListAlarms:input_type -> proto.ListAlarmsRequest
+	5,  // 13: proto.Storage.GetAlarm:input_type -> proto.GetAlarmRequest
+	6,  // 14: proto.Storage.QueryMetrics:input_type -> proto.QueryMetricsRequest
+	8,  // 15: proto.Storage.GetClientTimeline:input_type -> proto.GetClientTimelineRequest
+	11, // 16: proto.Storage.WatchAlarms:input_type -> proto.WatchAlarmsRequest
+	1,  // 17: proto.Storage.StoreMetric:output_type -> proto.StorageResponse
+	1,  // 18: proto.Storage.StoreAlarm:output_type -> proto.StorageResponse
+	4,  // 19: proto.Storage.ListAlarms:output_type -> proto.ListAlarmsResponse
+	0,  // 20: proto.Storage.GetAlarm:output_type -> proto.Alarm
+	7,  // 21: proto.Storage.QueryMetrics:output_type -> proto.QueryMetricsResponse
+	10, // 22: proto.Storage.GetClientTimeline:output_type -> proto.GetClientTimelineResponse
+	12, // 23: proto.Storage.WatchAlarms:output_type -> proto.AlarmEvent
+	17, // [17:24] is the sub-list for method output_type
+	10, // [10:17] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_storage_proto_init() }
@@ -207,13 +1019,18 @@ func file_storage_proto_init() {
 		return
 	}
 	file_metrics_proto_init()
+	file_storage_proto_msgTypes[0].OneofWrappers = []any{}
+	file_storage_proto_msgTypes[9].OneofWrappers = []any{
+		(*TimelineEvent_Metric)(nil),
+		(*TimelineEvent_Alarm)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_storage_proto_rawDesc), len(file_storage_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Storage_StoreMetric_FullMethodName = "/proto.Storage/StoreMetric"
-	Storage_StoreAlarm_FullMethodName  = "/proto.Storage/StoreAlarm"
+	Storage_StoreMetric_FullMethodName       = "/proto.Storage/StoreMetric"
+	Storage_StoreAlarm_FullMethodName        = "/proto.Storage/StoreAlarm"
+	Storage_ListAlarms_FullMethodName        = "/proto.Storage/ListAlarms"
+	Storage_GetAlarm_FullMethodName          = "/proto.Storage/GetAlarm"
+	Storage_QueryMetrics_FullMethodName      = "/proto.Storage/QueryMetrics"
+	Storage_GetClientTimeline_FullMethodName = "/proto.Storage/GetClientTimeline"
+	Storage_WatchAlarms_FullMethodName       = "/proto.Storage/WatchAlarms"
 )
 
 // StorageClient is the client API for Storage service.
@@ -33,6 +38,16 @@ type StorageClient interface {
 	StoreMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*StorageResponse, error)
 	// RPC per salvare un record di allarme (lo useremo più avanti)
 	StoreAlarm(ctx context.Context, in *Alarm, opts ...grpc.CallOption) (*StorageResponse, error)
+	// Allarmi filtrati per client, regola, gravità e intervallo, dal più recente
+	ListAlarms(ctx context.Context, in *ListAlarmsRequest, opts ...grpc.CallOption) (*ListAlarmsResponse, error)
+	// Singolo allarme a partire dall'id restituito da ListAlarms
+	GetAlarm(ctx context.Context, in *GetAlarmRequest, opts ...grpc.CallOption) (*Alarm, error)
+	// Metriche filtrate per client, tipo e intervallo, in ordine cronologico
+	QueryMetrics(ctx context.Context, in *QueryMetricsRequest, opts ...grpc.CallOption) (*QueryMetricsResponse, error)
+	// Metriche e allarmi di un client in un'unica sequenza cronologica
+	GetClientTimeline(ctx context.Context, in *GetClientTimelineRequest, opts ...grpc.CallOption) (*GetClientTimelineResponse, error)
+	// Stream degli allarmi salvati da ora in poi (o dal resume_token), filtrati per client e gravità
+	WatchAlarms(ctx context.Context, in *WatchAlarmsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AlarmEvent], error)
 }
 
 type storageClient struct {
@@ -63,6 +78,65 @@ func (c *storageClient) StoreAlarm(ctx context.Context, in *Alarm, opts ...grpc.
 	return out, nil
 }
 
+func (c *storageClient) ListAlarms(ctx context.Context, in *ListAlarmsRequest, opts ...grpc.CallOption) (*ListAlarmsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAlarmsResponse)
+	err := c.cc.Invoke(ctx, Storage_ListAlarms_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) GetAlarm(ctx context.Context, in *GetAlarmRequest, opts ...grpc.CallOption) (*Alarm, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Alarm)
+	err := c.cc.Invoke(ctx, Storage_GetAlarm_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) QueryMetrics(ctx context.Context, in *QueryMetricsRequest, opts ...grpc.CallOption) (*QueryMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryMetricsResponse)
+	err := c.cc.Invoke(ctx, Storage_QueryMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) GetClientTimeline(ctx context.Context, in *GetClientTimelineRequest, opts ...grpc.CallOption) (*GetClientTimelineResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetClientTimelineResponse)
+	err := c.cc.Invoke(ctx, Storage_GetClientTimeline_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) WatchAlarms(ctx context.Context, in *WatchAlarmsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AlarmEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[0], Storage_WatchAlarms_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchAlarmsRequest, AlarmEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Storage_WatchAlarmsClient = grpc.ServerStreamingClient[AlarmEvent]
+
 // StorageServer is the server API for Storage service.
 // All implementations must embed UnimplementedStorageServer
 // for forward compatibility.
@@ -73,6 +147,16 @@ type StorageServer interface {
 	StoreMetric(context.Context, *Metric) (*StorageResponse, error)
 	// RPC per salvare un record di allarme (lo useremo più avanti)
 	StoreAlarm(context.Context, *Alarm) (*StorageResponse, error)
+	// Allarmi filtrati per client, regola, gravità e intervallo, dal più recente
+	ListAlarms(context.Context, *ListAlarmsRequest) (*ListAlarmsResponse, error)
+	// Singolo allarme a partire dall'id restituito da ListAlarms
+	GetAlarm(context.Context, *GetAlarmRequest) (*Alarm, error)
+	// Metriche filtrate per client, tipo e intervallo, in ordine cronologico
+	QueryMetrics(context.Context, *QueryMetricsRequest) (*QueryMetricsResponse, error)
+	// Metriche e allarmi di un client in un'unica sequenza cronologica
+	GetClientTimeline(context.Context, *GetClientTimelineRequest) (*GetClientTimelineResponse, error)
+	// Stream degli allarmi salvati da ora in poi (o dal resume_token), filtrati per client e gravità
+	WatchAlarms(*WatchAlarmsRequest, grpc.ServerStreamingServer[AlarmEvent]) error
 	mustEmbedUnimplementedStorageServer()
 }
 
@@ -89,6 +173,21 @@ func (UnimplementedStorageServer) StoreMetric(context.Context, *Metric) (*Storag
 func (UnimplementedStorageServer) StoreAlarm(context.Context, *Alarm) (*StorageResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StoreAlarm not implemented")
 }
+func (UnimplementedStorageServer) ListAlarms(context.Context, *ListAlarmsRequest) (*ListAlarmsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAlarms not implemented")
+}
+func (UnimplementedStorageServer) GetAlarm(context.Context, *GetAlarmRequest) (*Alarm, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAlarm not implemented")
+}
+func (UnimplementedStorageServer) QueryMetrics(context.Context, *QueryMetricsRequest) (*QueryMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryMetrics not implemented")
+}
+func (UnimplementedStorageServer) GetClientTimeline(context.Context, *GetClientTimelineRequest) (*GetClientTimelineResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClientTimeline not implemented")
+}
+func (UnimplementedStorageServer) WatchAlarms(*WatchAlarmsRequest, grpc.ServerStreamingServer[AlarmEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAlarms not implemented")
+}
 func (UnimplementedStorageServer) mustEmbedUnimplementedStorageServer() {}
 func (UnimplementedStorageServer) testEmbeddedByValue()                 {}
 
@@ -146,6 +245,89 @@ func _Storage_StoreAlarm_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Storage_ListAlarms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAlarmsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).ListAlarms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Storage_ListAlarms_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).ListAlarms(ctx, req.(*ListAlarmsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_GetAlarm_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAlarmRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).GetAlarm(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Storage_GetAlarm_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).GetAlarm(ctx, req.(*GetAlarmRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_QueryMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).QueryMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Storage_QueryMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).QueryMetrics(ctx, req.(*QueryMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_GetClientTimeline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetClientTimelineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).GetClientTimeline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Storage_GetClientTimeline_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).GetClientTimeline(ctx, req.(*GetClientTimelineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_WatchAlarms_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchAlarmsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).WatchAlarms(m, &grpc.GenericServerStream[WatchAlarmsRequest, AlarmEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Storage_WatchAlarmsServer = grpc.ServerStreamingServer[AlarmEvent]
+
 // Storage_ServiceDesc is the grpc.ServiceDesc for Storage service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,7 +343,29 @@ var Storage_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "StoreAlarm",
 			Handler:    _Storage_StoreAlarm_Handler,
 		},
+		{
+			MethodName: "ListAlarms",
+			Handler:    _Storage_ListAlarms_Handler,
+		},
+		{
+			MethodName: "GetAlarm",
+			Handler:    _Storage_GetAlarm_Handler,
+		},
+		{
+			MethodName: "QueryMetrics",
+			Handler:    _Storage_QueryMetrics_Handler,
+		},
+		{
+			MethodName: "GetClientTimeline",
+			Handler:    _Storage_GetClientTimeline_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchAlarms",
+			Handler:       _Storage_WatchAlarms_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "storage.proto",
 }
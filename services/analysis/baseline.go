@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Rilevatore statistico usato in modalità degradata (inferenza non disponibile).
+// Finché il modello ML è sano, ogni metrica che il modello giudica normale aggiorna, per il suo
+// client e per l'insieme di tutti i client, una media e una varianza a decadimento esponenziale
+// (EWMA) di alcune feature. Senza inferenza una metrica è anomala se almeno una feature supera
+// la propria media di oltre zThreshold deviazioni standard.
+
+const (
+	// baselineRuleID identifica le anomalie segnalate dal rilevatore statistico.
+	baselineRuleID = "baseline_zscore"
+	// globalBaselineKey è la baseline di tutti i client, usata per i client senza storia sufficiente.
+	globalBaselineKey = ""
+	// baselineShards è il numero di shard della mappa delle baseline, come per lo store di correlazione.
+	baselineShards = 64
+)
+
+// defaultBaselineFeatures sono le feature seguite di default: volumi, conteggi di connessioni
+// e tassi di errore, che crescono nei flood e negli scan.
+var defaultBaselineFeatures = []string{
+	"src_bytes", "dst_bytes", "count", "srv_count", "serror_rate", "srv_serror_rate",
+	"rerror_rate", "diff_srv_rate", "dst_host_count", "dst_host_serror_rate",
+}
+
+// baselineRule rappresenta le anomalie del rilevatore statistico, correlate con la configurazione globale.
+var baselineRule = &rule{ID: baselineRuleID, Description: "Deviation from the statistical baseline while inference is unavailable", Severity: "medium"}
+
+// baselineConfig raccoglie i parametri del rilevatore.
+type baselineConfig struct {
+	Features   []string // Feature seguite, per nome
+	Alpha      float64  // Peso della nuova osservazione nell'EWMA (0 < Alpha <= 1)
+	ZThreshold float64  // Deviazioni standard oltre la media per considerare anomala una feature
+	MinSamples int      // Osservazioni necessarie perché una baseline venga usata
+}
+
+// parseBaselineFeatures valida un elenco di feature separate da virgole.
+func parseBaselineFeatures(list string) ([]string, error) {
+	var features []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := ruleVariables[name]; !ok || name == "value" {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		features = append(features, name)
+	}
+	if len(features) == 0 {
+		return nil, fmt.Errorf("no features selected")
+	}
+	return features, nil
+}
+
+// featureStats è la media e la varianza a decadimento esponenziale di una feature.
+type featureStats struct {
+	mean     float64
+	variance float64
+}
+
+// clientBaseline è la baseline di un client (o globale).
+type clientBaseline struct {
+	samples int
+	stats   []featureStats // Nello stesso ordine di baselineConfig.Features
+}
+
+// baselineDeviation descrive la feature che si discosta di più dalla baseline.
+type baselineDeviation struct {
+	Feature string
+	Value   float64
+	Mean    float64
+	Z       float64
+}
+
+type baselineShard struct {
+	mu        sync.Mutex
+	baselines map[string]*clientBaseline
+}
+
+// baselineDetector mantiene le baseline dei client. I metodi di un detector nil non fanno nulla,
+// così un server senza rilevatore (es. nei test) resta valido.
+type baselineDetector struct {
+	cfg     baselineConfig
+	indexes []int // Posizioni delle feature seguite nel vettore di ruleInputs
+	shards  []*baselineShard
+}
+
+func newBaselineDetector(cfg baselineConfig) *baselineDetector {
+	d := &baselineDetector{cfg: cfg, shards: make([]*baselineShard, baselineShards)}
+	for _, name := range cfg.Features {
+		d.indexes = append(d.indexes, ruleVariables[name])
+	}
+	for i := range d.shards {
+		d.shards[i] = &baselineShard{baselines: make(map[string]*clientBaseline)}
+	}
+	return d
+}
+
+func (d *baselineDetector) shardFor(clientID string) *baselineShard {
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return d.shards[h.Sum32()%uint32(len(d.shards))]
+}
+
+// observe aggiorna con una metrica normale la baseline del client e quella globale.
+func (d *baselineDetector) observe(clientID string, inputs []float64) {
+	if d == nil {
+		return
+	}
+	d.update(clientID, inputs)
+	if clientID != globalBaselineKey {
+		d.update(globalBaselineKey, inputs)
+	}
+}
+
+func (d *baselineDetector) update(key string, inputs []float64) {
+	shard := d.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	b, ok := shard.baselines[key]
+	if !ok {
+		b = &clientBaseline{stats: make([]featureStats, len(d.indexes))}
+		shard.baselines[key] = b
+	}
+	for i, index := range d.indexes {
+		x := inputs[index]
+		s := &b.stats[i]
+		if b.samples == 0 {
+			s.mean = x
+			continue
+		}
+		// Aggiornamento incrementale di media e varianza esponenziali
+		diff := x - s.mean
+		increment := d.cfg.Alpha * diff
+		s.mean += increment
+		s.variance = (1 - d.cfg.Alpha) * (s.variance + diff*increment)
+	}
+	b.samples++
+}
+
+// snapshot copia la baseline indicata se ha abbastanza osservazioni.
+func (d *baselineDetector) snapshot(key string) (clientBaseline, bool) {
+	shard := d.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	b, ok := shard.baselines[key]
+	if !ok || b.samples < d.cfg.MinSamples {
+		return clientBaseline{}, false
+	}
+	return clientBaseline{samples: b.samples, stats: append([]featureStats(nil), b.stats...)}, true
+}
+
+// stdFloor è la deviazione standard minima di una feature: senza un minimo, una feature
+// sempre nulla durante l'apprendimento renderebbe anomalo qualunque valore diverso da zero.
+func stdFloor(feature string, mean float64) float64 {
+	floor := 1.0 // Conteggi e byte
+	if strings.HasSuffix(feature, "_rate") {
+		floor = 0.05 // Tassi in [0, 1]
+	}
+	return math.Max(floor, 0.1*math.Abs(mean))
+}
+
+// detect confronta la metrica con la baseline del client o, se non è ancora pronta, con quella
+// globale. ready è false se nessuna delle due ha abbastanza osservazioni. Si considerano solo
+// gli scostamenti verso l'alto: volumi, conteggi e tassi di errore crescono durante un attacco.
+func (d *baselineDetector) detect(clientID string, inputs []float64) (deviation baselineDeviation, anomalous, ready bool) {
+	if d == nil {
+		return baselineDeviation{}, false, false
+	}
+	b, ok := d.snapshot(clientID)
+	if !ok {
+		if b, ok = d.snapshot(globalBaselineKey); !ok {
+			return baselineDeviation{}, false, false
+		}
+	}
+
+	for i, index := range d.indexes {
+		feature := d.cfg.Features[i]
+		s := b.stats[i]
+		std := math.Max(math.Sqrt(s.variance), stdFloor(feature, s.mean))
+		z := (inputs[index] - s.mean) / std
+		if z > deviation.Z {
+			deviation = baselineDeviation{Feature: feature, Value: inputs[index], Mean: s.mean, Z: z}
+		}
+	}
+	return deviation, deviation.Z > d.cfg.ZThreshold, true
+}
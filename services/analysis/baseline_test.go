@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+)
+
+func newTestBaseline() *baselineDetector {
+	return newBaselineDetector(baselineConfig{Features: defaultBaselineFeatures, Alpha: 0.05, ZThreshold: 4, MinSamples: 30})
+}
+
+// bulkTransfer è il traffico normale di un client che trasferisce grandi volumi: src_bytes
+// è molto sopra la soglia storica di 95, con una variabilità naturale.
+func bulkTransfer(clientID string, i int) *pb.Metric {
+	return metricWith(clientID, map[string]float32{
+		"src_bytes": float32(50_000 + (i%5-2)*2_000),
+		"dst_bytes": 400,
+		"count":     float32(3 + i%3),
+		"srv_count": float32(3 + i%3),
+	})
+}
+
+// synFlood è una raffica di SYN senza risposta: pochi byte, molte connessioni, tutte in errore.
+func synFlood(clientID string) *pb.Metric {
+	return metricWith(clientID, map[string]float32{"count": 500, "srv_count": 500, "serror_rate": 1, "srv_serror_rate": 1})
+}
+
+func TestBaseline_FlagsDeviationsFromLearnedTraffic(t *testing.T) {
+	d := newTestBaseline()
+	for i := 0; i < 60; i++ {
+		d.observe("bulk", ruleInputs(bulkTransfer("bulk", i)))
+	}
+
+	if deviation, anomalous, ready := d.detect("bulk", ruleInputs(bulkTransfer("bulk", 61))); !ready || anomalous {
+		t.Errorf("Un trasferimento in linea con la baseline non doveva essere anomalo (ready=%v, deviazione %+v)", ready, deviation)
+	}
+	deviation, anomalous, _ := d.detect("bulk", ruleInputs(synFlood("bulk")))
+	if !anomalous {
+		t.Fatalf("Il SYN flood doveva superare la baseline, deviazione %+v", deviation)
+	}
+	if deviation.Z <= 4 {
+		t.Errorf("La deviazione riportata doveva superare la soglia, z=%.1f", deviation.Z)
+	}
+}
+
+func TestBaseline_ColdClientUsesGlobalBaseline(t *testing.T) {
+	d := newTestBaseline()
+	for i := 0; i < 60; i++ {
+		d.observe("bulk", ruleInputs(bulkTransfer("bulk", i)))
+	}
+
+	if _, anomalous, ready := d.detect("new-client", ruleInputs(synFlood("new-client"))); !ready || !anomalous {
+		t.Errorf("Un client senza storia doveva essere confrontato con la baseline globale (ready=%v, anomalous=%v)", ready, anomalous)
+	}
+
+	empty := newTestBaseline()
+	if _, _, ready := empty.detect("new-client", ruleInputs(synFlood("new-client"))); ready {
+		t.Errorf("Senza osservazioni la baseline non doveva essere pronta")
+	}
+}
+
+func TestAnalyzeMetric_Fallback_UsesBaselineInsteadOfThreshold(t *testing.T) {
+	anomalyThreshold = 2
+	timeWindow = time.Minute
+	fallbackThreshold = 95.0
+
+	mockStore := &mockStorageClient{}
+	mockInference := &mockInferenceClient{prediction: 1} // 1 = Normale
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{})
+	analysisServer := &server{
+		storageClient:   mockStore,
+		inferenceClient: mockInference,
+		circuitBreaker:  cb,
+		correlation:     newMemoryCorrelationStore(),
+		baseline:        newTestBaseline(),
+	}
+
+	// Con l'inferenza sana il servizio impara il traffico normale del client
+	for i := 0; i < 60; i++ {
+		if _, err := analysisServer.AnalyzeMetric(context.Background(), bulkTransfer("bulk", i)); err != nil {
+			t.Fatalf("Errore inatteso durante l'apprendimento: %v", err)
+		}
+	}
+
+	// L'inferenza diventa irraggiungibile e il circuito si apre
+	mockInference.prediction = 0
+	for i := 0; i < 10; i++ {
+		_, _ = cb.Execute(func() (interface{}, error) { return nil, errors.New("fail") })
+	}
+
+	// I trasferimenti abituali superano la soglia storica ma non la baseline
+	for i := 0; i < 3; i++ {
+		if _, err := analysisServer.AnalyzeMetric(context.Background(), bulkTransfer("bulk", i)); err != nil {
+			t.Fatalf("Errore inatteso in modalità degradata: %v", err)
+		}
+	}
+	if mockStore.storeAlarmCalledCount != 0 {
+		t.Fatalf("Il traffico abituale del client non doveva generare allarmi, allarmi: %d", mockStore.storeAlarmCalledCount)
+	}
+
+	// Il SYN flood ha pochi byte ma si discosta dalla baseline sui tassi di errore
+	for i := 0; i < 2; i++ {
+		if _, err := analysisServer.AnalyzeMetric(context.Background(), synFlood("bulk")); err != nil {
+			t.Fatalf("Errore inatteso in modalità degradata: %v", err)
+		}
+	}
+	if mockStore.storeAlarmCalledCount != 1 {
+		t.Fatalf("Il SYN flood doveva generare un allarme, allarmi: %d", mockStore.storeAlarmCalledCount)
+	}
+	if mockStore.lastAlarm.RuleId != baselineRuleID {
+		t.Errorf("L'allarme doveva avere RuleId '%s', ma ha '%s'", baselineRuleID, mockStore.lastAlarm.RuleId)
+	}
+}
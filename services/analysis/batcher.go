@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+)
+
+// Micro-batching delle richieste di inferenza: le predizioni richieste in contemporanea da più
+// AnalyzeMetric vengono raccolte e inviate con una sola PredictBatch, fino a maxSize richieste
+// o maxWait di attesa dalla prima. Ogni batch passa dal circuit breaker come una singola chiamata;
+// l'esito (risposta o errore, e quindi il fallback) resta comunque distinto per ogni metrica.
+
+// predictionResult è l'esito della predizione di una singola metrica.
+type predictionResult struct {
+	response *pb.InferenceResponse
+	err      error
+}
+
+// pendingPrediction è una richiesta in attesa di essere inviata in un batch.
+type pendingPrediction struct {
+	ctx      context.Context
+	features []float32
+	result   chan predictionResult // Bufferizzato: il batch non si blocca se il chiamante ha rinunciato
+}
+
+type inferenceBatcher struct {
+	client         pb.InferenceClient
+	circuitBreaker *gobreaker.CircuitBreaker
+	maxSize        int
+	maxWait        time.Duration
+	requests       chan *pendingPrediction
+}
+
+func newInferenceBatcher(client pb.InferenceClient, cb *gobreaker.CircuitBreaker, maxSize int, maxWait time.Duration) *inferenceBatcher {
+	return &inferenceBatcher{
+		client:         client,
+		circuitBreaker: cb,
+		maxSize:        maxSize,
+		maxWait:        maxWait,
+		requests:       make(chan *pendingPrediction, maxSize),
+	}
+}
+
+// submit accoda la predizione di una metrica e restituisce il canale da cui leggerne l'esito.
+func (b *inferenceBatcher) submit(ctx context.Context, features []float32) <-chan predictionResult {
+	p := &pendingPrediction{ctx: ctx, features: features, result: make(chan predictionResult, 1)}
+	select {
+	case b.requests <- p:
+	case <-ctx.Done():
+		p.result <- predictionResult{err: ctx.Err()}
+	}
+	return p.result
+}
+
+// predict richiede la predizione di una metrica e ne attende l'esito.
+func (b *inferenceBatcher) predict(ctx context.Context, features []float32) (*pb.InferenceResponse, error) {
+	return awaitPrediction(ctx, b.submit(ctx, features))
+}
+
+func awaitPrediction(ctx context.Context, pending <-chan predictionResult) (*pb.InferenceResponse, error) {
+	select {
+	case r := <-pending:
+		return r.response, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run raccoglie le richieste in batch finché ctx non viene cancellato.
+func (b *inferenceBatcher) run(ctx context.Context) {
+	for {
+		var first *pendingPrediction
+		select {
+		case <-ctx.Done():
+			return
+		case first = <-b.requests:
+		}
+
+		batch := []*pendingPrediction{first}
+		timer := time.NewTimer(b.maxWait)
+	collect:
+		for len(batch) < b.maxSize {
+			select {
+			case p := <-b.requests:
+				batch = append(batch, p)
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+		timer.Stop()
+		// L'invio avviene in parallelo: mentre un batch è in volo si raccoglie il successivo
+		go b.flush(batch)
+	}
+}
+
+// flush invia un batch e distribuisce le risposte alle singole richieste.
+func (b *inferenceBatcher) flush(batch []*pendingPrediction) {
+	// Le richieste già abbandonate dal chiamante non vengono inviate
+	live := batch[:0]
+	for _, p := range batch {
+		if err := p.ctx.Err(); err != nil {
+			p.result <- predictionResult{err: err}
+			continue
+		}
+		live = append(live, p)
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	ctx, cancel := batchContext(live)
+	defer cancel()
+	req := &pb.InferenceBatchRequest{Requests: make([]*pb.InferenceRequest, len(live))}
+	for i, p := range live {
+		req.Requests[i] = &pb.InferenceRequest{Features: p.features}
+	}
+	response, err := b.circuitBreaker.Execute(func() (interface{}, error) {
+		log.Printf("[DEBUG] Chiamata a PredictBatch con %d metriche (dentro Circuit Breaker)...", len(live))
+		resp, err := b.client.PredictBatch(ctx, req)
+		if err == nil && len(resp.Responses) != len(req.Requests) {
+			err = fmt.Errorf("inference returned %d predictions for %d requests", len(resp.Responses), len(req.Requests))
+		}
+		return resp, err
+	})
+	if err != nil {
+		for _, p := range live {
+			p.result <- predictionResult{err: err}
+		}
+		return
+	}
+	for i, p := range live {
+		p.result <- predictionResult{response: response.(*pb.InferenceBatchResponse).Responses[i]}
+	}
+}
+
+// batchContext restituisce il contesto della chiamata di un batch: la sua scadenza è la più lontana
+// tra quelle delle richieste, così nessuna richiesta viene interrotta prima del proprio limite.
+// Se anche una sola richiesta non ha scadenza, neanche il batch ne ha.
+func batchContext(batch []*pendingPrediction) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, p := range batch {
+		deadline, ok := p.ctx.Deadline()
+		if !ok {
+			return context.WithCancel(context.Background())
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return context.WithDeadline(context.Background(), latest)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+)
+
+// mockBatchInferenceClient classifica come anomale le metriche con la prima feature negativa
+// e registra la dimensione di ogni batch ricevuto.
+type mockBatchInferenceClient struct {
+	pb.InferenceClient
+	mu    sync.Mutex
+	sizes []int
+	fail  bool
+}
+
+func (m *mockBatchInferenceClient) PredictBatch(ctx context.Context, in *pb.InferenceBatchRequest, opts ...grpc.CallOption) (*pb.InferenceBatchResponse, error) {
+	m.mu.Lock()
+	m.sizes = append(m.sizes, len(in.Requests))
+	m.mu.Unlock()
+	if m.fail {
+		return nil, errors.New("simulated inference failure")
+	}
+	resp := &pb.InferenceBatchResponse{}
+	for _, req := range in.Requests {
+		prediction := int32(1)
+		if req.Features[0] < 0 {
+			prediction = -1
+		}
+		resp.Responses = append(resp.Responses, &pb.InferenceResponse{Prediction: prediction, Score: float64(req.Features[0])})
+	}
+	return resp, nil
+}
+
+func (m *mockBatchInferenceClient) batchSizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.sizes...)
+}
+
+func startBatcher(t *testing.T, client pb.InferenceClient, cb *gobreaker.CircuitBreaker, maxSize int, maxWait time.Duration) *inferenceBatcher {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b := newInferenceBatcher(client, cb, maxSize, maxWait)
+	go b.run(ctx)
+	return b
+}
+
+func TestInferenceBatcher_CoalescesConcurrentRequests(t *testing.T) {
+	client := &mockBatchInferenceClient{}
+	// Un'attesa lunga: i batch partono perché si riempiono, non per timeout
+	b := startBatcher(t, client, gobreaker.NewCircuitBreaker(gobreaker.Settings{}), 4, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := float32(i)
+			if i%2 == 1 {
+				value = -value
+			}
+			features := make([]float32, 41)
+			features[0] = value
+			resp, err := b.predict(context.Background(), features)
+			if err != nil {
+				t.Errorf("Predizione %d fallita: %v", i, err)
+				return
+			}
+			// Ogni chiamante deve ricevere la risposta della propria metrica
+			if resp.Score != float64(value) || (resp.Prediction == -1) != (value < 0) {
+				t.Errorf("Predizione %d: ricevuta la risposta di un'altra metrica (score %g)", i, resp.Score)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if sizes := client.batchSizes(); len(sizes) != 2 || sizes[0] != 4 || sizes[1] != 4 {
+		t.Errorf("Le 8 richieste dovevano viaggiare in 2 batch da 4, batch: %v", sizes)
+	}
+}
+
+func TestInferenceBatcher_FlushesAfterMaxWait(t *testing.T) {
+	client := &mockBatchInferenceClient{}
+	b := startBatcher(t, client, gobreaker.NewCircuitBreaker(gobreaker.Settings{}), 32, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := b.predict(ctx, make([]float32, 41)); err != nil {
+		t.Fatalf("Una richiesta isolata doveva partire allo scadere dell'attesa: %v", err)
+	}
+	if sizes := client.batchSizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("Atteso un batch da 1, batch: %v", sizes)
+	}
+}
+
+func TestInferenceBatcher_FailuresFallBackPerMetric(t *testing.T) {
+	anomalyThreshold = 3
+	timeWindow = time.Minute
+	fallbackThreshold = 95.0
+
+	client := &mockBatchInferenceClient{fail: true}
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		ReadyToTrip: func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures >= 2 },
+	})
+	mockStore := &mockStorageClient{}
+	analysisServer := &server{
+		storageClient:  mockStore,
+		circuitBreaker: cb,
+		correlation:    newMemoryCorrelationStore(),
+		batcher:        startBatcher(t, client, cb, 3, time.Minute),
+	}
+
+	// Ogni batch fallito conta come un solo fallimento per il circuit breaker
+	metrics := make([]*pb.Metric, 3)
+	for i := range metrics {
+		metrics[i] = &pb.Metric{SourceClientId: "heavy", Features: make([]float32, 41)}
+		metrics[i].Features[4] = 1000 // src_bytes oltre la soglia storica
+	}
+	resp, err := analysisServer.AnalyzeMetrics(context.Background(), &pb.MetricBatch{Metrics: metrics})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if sizes := client.batchSizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("Le predizioni del batch dovevano partire in un'unica PredictBatch, batch: %v", sizes)
+	}
+	if cb.State() != gobreaker.StateClosed {
+		t.Errorf("Un solo batch fallito non doveva aprire il circuito")
+	}
+	// Ogni metrica è passata in fallback separatamente: la terza chiude la finestra della soglia
+	for i, r := range resp.Results {
+		if !r.Processed {
+			t.Errorf("La metrica %d doveva essere elaborata in fallback: %s", i, r.Message)
+		}
+	}
+	if mockStore.storeAlarmCalledCount != 1 || mockStore.lastAlarm.RuleId != fallbackRuleID {
+		t.Errorf("Le tre metriche in fallback dovevano generare un allarme %s, allarmi: %d", fallbackRuleID, mockStore.storeAlarmCalledCount)
+	}
+}
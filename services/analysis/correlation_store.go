@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// CorrelationStore conserva le finestre di correlazione dei client: gli event time delle
+// anomalie recenti su cui si decide se generare un allarme. Ogni client ha una finestra
+// per regola, indicizzata dalla chiave costruita da correlationKey.
+type CorrelationStore interface {
+	// RecordAnomaly registra un'anomalia del client con event time at secondo la configurazione
+	// della finestra. Se il conteggio raggiunge la soglia le anomalie della finestra vengono consumate
+	// e Triggered vale true. L'operazione è atomica: due istanze che registrano in parallelo
+	// la stessa anomalia non possono generare due allarmi.
+	RecordAnomaly(clientID string, at time.Time, cfg windowConfig) (windowResult, error)
+	// Take rimuove e restituisce le finestre dei client selezionati.
+	Take(selectClient func(clientID string) bool) (map[string]clientWindow, error)
+	// Merge unisce alle finestre esistenti quelle indicate, scartando duplicati e istanti fuori finestra.
+	// windowFor restituisce la configurazione di ogni chiave (quella della regola che l'ha prodotta).
+	// Restituisce il numero di client a cui è stata aggiunta almeno un'anomalia.
+	Merge(windows map[string]clientWindow, windowFor func(key string) windowConfig) (int, error)
+	// Shared indica se lo stato è condiviso tra le istanze di analisi (e quindi non va ceduto con l'handoff).
+	Shared() bool
+}
+
+// --- Implementazione in memoria ---
+
+// correlationShards è il numero di shard dello store in memoria. I client sono distribuiti
+// sugli shard per hash, così le anomalie di client diversi raramente contendono lo stesso lock.
+const correlationShards = 64
+
+// correlationShard è una porzione dello store in memoria con il proprio lock.
+type correlationShard struct {
+	mu      sync.Mutex
+	windows map[string]clientWindow
+}
+
+// memoryCorrelationStore conserva le finestre nella memoria del processo: è il comportamento
+// storico del servizio, perso a ogni riavvio e non condiviso tra le repliche.
+// I lock degli shard proteggono solo le mappe e non vengono mai tenuti durante chiamate di rete.
+type memoryCorrelationStore struct {
+	shards []*correlationShard
+}
+
+func newMemoryCorrelationStore() *memoryCorrelationStore {
+	return newShardedMemoryCorrelationStore(correlationShards)
+}
+
+func newShardedMemoryCorrelationStore(shards int) *memoryCorrelationStore {
+	m := &memoryCorrelationStore{shards: make([]*correlationShard, shards)}
+	for i := range m.shards {
+		m.shards[i] = &correlationShard{windows: make(map[string]clientWindow)}
+	}
+	return m
+}
+
+func (m *memoryCorrelationStore) shardFor(clientID string) *correlationShard {
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+func (m *memoryCorrelationStore) RecordAnomaly(clientID string, at time.Time, cfg windowConfig) (windowResult, error) {
+	shard := m.shardFor(clientID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	next, result := cfg.record(shard.windows[clientID], at)
+	shard.windows[clientID] = next
+	return result, nil
+}
+
+func (m *memoryCorrelationStore) Take(selectClient func(clientID string) bool) (map[string]clientWindow, error) {
+	taken := make(map[string]clientWindow)
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for clientID, window := range shard.windows {
+			if !selectClient(clientID) {
+				continue
+			}
+			delete(shard.windows, clientID)
+			if len(window.Timestamps) > 0 {
+				taken[clientID] = window
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return taken, nil
+}
+
+func (m *memoryCorrelationStore) Merge(windows map[string]clientWindow, windowFor func(key string) windowConfig) (int, error) {
+	imported := 0
+	for clientID, incoming := range windows {
+		cfg := windowFor(clientID)
+		shard := m.shardFor(clientID)
+		shard.mu.Lock()
+		merged, added := cfg.merge(shard.windows[clientID], incoming)
+		if added > 0 {
+			shard.windows[clientID] = merged
+			imported++
+		}
+		shard.mu.Unlock()
+	}
+	return imported, nil
+}
+
+func (m *memoryCorrelationStore) Shared() bool { return false }
+
+// --- Implementazione su Consul KV ---
+
+const (
+	// maxCASAttempts limita i tentativi di aggiornamento ottimistico di una finestra su Consul.
+	maxCASAttempts = 10
+	// casRetryBackoff è l'attesa massima (con jitter) prima di ritentare un check-and-set fallito.
+	casRetryBackoff = 10 * time.Millisecond
+)
+
+// consulCorrelationStore conserva le finestre nel KV store di Consul, una chiave per client.
+// Lo stato sopravvive ai riavvii ed è condiviso da tutte le repliche; gli aggiornamenti usano
+// check-and-set sul ModifyIndex, così le scritture concorrenti di due repliche non si sovrascrivono.
+type consulCorrelationStore struct {
+	kv     *consulapi.KV
+	prefix string
+}
+
+// consulWindow è il valore JSON salvato per ogni client.
+type consulWindow struct {
+	Timestamps   []int64 `json:"timestamps"`               // Istanti Unix in nanosecondi
+	MaxEventTime int64   `json:"max_event_time,omitempty"` // Istante Unix in nanosecondi
+}
+
+func newConsulCorrelationStore(client *consulapi.Client, prefix string) *consulCorrelationStore {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &consulCorrelationStore{kv: client.KV(), prefix: prefix}
+}
+
+func (c *consulCorrelationStore) key(clientID string) string {
+	return c.prefix + url.PathEscape(clientID)
+}
+
+func (c *consulCorrelationStore) clientID(key string) (string, error) {
+	return url.PathUnescape(strings.TrimPrefix(key, c.prefix))
+}
+
+func decodeWindow(pair *consulapi.KVPair) (clientWindow, error) {
+	if pair == nil || len(pair.Value) == 0 {
+		return clientWindow{}, nil
+	}
+	var w consulWindow
+	if err := json.Unmarshal(pair.Value, &w); err != nil {
+		return clientWindow{}, fmt.Errorf("invalid correlation window at %s: %w", pair.Key, err)
+	}
+	window := clientWindow{Timestamps: make([]time.Time, len(w.Timestamps))}
+	for i, nanos := range w.Timestamps {
+		window.Timestamps[i] = time.Unix(0, nanos)
+	}
+	if w.MaxEventTime != 0 {
+		window.MaxEventTime = time.Unix(0, w.MaxEventTime)
+	}
+	return window, nil
+}
+
+func encodeWindow(window clientWindow) []byte {
+	w := consulWindow{Timestamps: make([]int64, len(window.Timestamps))}
+	for i, ts := range window.Timestamps {
+		w.Timestamps[i] = ts.UnixNano()
+	}
+	if !window.MaxEventTime.IsZero() {
+		w.MaxEventTime = window.MaxEventTime.UnixNano()
+	}
+	data, _ := json.Marshal(w)
+	return data
+}
+
+// update applica fn alla finestra del client con un ciclo di check-and-set.
+// Se fn restituisce false la finestra è invariata e non viene scritta.
+func (c *consulCorrelationStore) update(clientID string, fn func(current clientWindow) (clientWindow, bool)) error {
+	key := c.key(clientID)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		pair, _, err := c.kv.Get(key, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read correlation window for '%s': %w", clientID, err)
+		}
+		current, err := decodeWindow(pair)
+		if err != nil {
+			return err
+		}
+		next, changed := fn(current)
+		if !changed {
+			return nil
+		}
+		var modifyIndex uint64 // 0 = crea la chiave solo se non esiste
+		if pair != nil {
+			modifyIndex = pair.ModifyIndex
+		}
+
+		ok, _, err := c.kv.CAS(&consulapi.KVPair{Key: key, Value: encodeWindow(next), ModifyIndex: modifyIndex}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to write correlation window for '%s': %w", clientID, err)
+		}
+		if ok {
+			return nil
+		}
+		// Un'altra replica ha modificato la finestra nel frattempo: rileggiamo e riproviamo,
+		// con un'attesa casuale per non ricollidere subito con lei
+		time.Sleep(time.Duration(rand.Int63n(int64(casRetryBackoff))))
+	}
+	return fmt.Errorf("too many concurrent updates to correlation window for '%s'", clientID)
+}
+
+func (c *consulCorrelationStore) RecordAnomaly(clientID string, at time.Time, cfg windowConfig) (windowResult, error) {
+	var result windowResult
+	err := c.update(clientID, func(current clientWindow) (clientWindow, bool) {
+		var next clientWindow
+		next, result = cfg.record(current, at)
+		return next, !result.Late
+	})
+	if err != nil {
+		return windowResult{}, err
+	}
+	return result, nil
+}
+
+func (c *consulCorrelationStore) Take(selectClient func(clientID string) bool) (map[string]clientWindow, error) {
+	pairs, _, err := c.kv.List(c.prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list correlation windows: %w", err)
+	}
+
+	taken := make(map[string]clientWindow)
+	for _, pair := range pairs {
+		clientID, err := c.clientID(pair.Key)
+		if err != nil || !selectClient(clientID) {
+			continue
+		}
+		window, err := decodeWindow(pair)
+		if err != nil {
+			return taken, err
+		}
+		// Se la finestra è cambiata dopo la lettura la lasciamo al prossimo tentativo
+		ok, _, err := c.kv.DeleteCAS(pair, nil)
+		if err != nil {
+			return taken, fmt.Errorf("failed to delete correlation window for '%s': %w", clientID, err)
+		}
+		if ok && len(window.Timestamps) > 0 {
+			taken[clientID] = window
+		}
+	}
+	return taken, nil
+}
+
+func (c *consulCorrelationStore) Merge(windows map[string]clientWindow, windowFor func(key string) windowConfig) (int, error) {
+	imported := 0
+	for clientID, incoming := range windows {
+		cfg := windowFor(clientID)
+		var added int
+		err := c.update(clientID, func(current clientWindow) (clientWindow, bool) {
+			var merged clientWindow
+			merged, added = cfg.merge(current, incoming)
+			return merged, added > 0
+		})
+		if err != nil {
+			return imported, err
+		}
+		if added > 0 {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+func (c *consulCorrelationStore) Shared() bool { return true }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// fakeConsulKV simula il KV store di Consul (GET, GET ?recurse, PUT e DELETE con ?cas),
+// quanto basta per far girare consulCorrelationStore senza un agente Consul reale.
+type fakeConsulKV struct {
+	mu    sync.Mutex
+	index uint64
+	pairs map[string]*consulapi.KVPair
+}
+
+func newFakeConsulKV() *fakeConsulKV {
+	return &fakeConsulKV{pairs: make(map[string]*consulapi.KVPair)}
+}
+
+func (f *fakeConsulKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+
+	switch r.Method {
+	case http.MethodGet:
+		var result []*consulapi.KVPair
+		if _, recurse := query["recurse"]; recurse {
+			for k, pair := range f.pairs {
+				if strings.HasPrefix(k, key) {
+					result = append(result, pair)
+				}
+			}
+			sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+		} else if pair, ok := f.pairs[key]; ok {
+			result = append(result, pair)
+		}
+		if len(result) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+
+	case http.MethodPut, http.MethodDelete:
+		existing, exists := f.pairs[key]
+		if casStr := query.Get("cas"); casStr != "" {
+			cas, _ := strconv.ParseUint(casStr, 10, 64)
+			if (cas == 0 && exists) || (cas != 0 && (!exists || existing.ModifyIndex != cas)) {
+				io.WriteString(w, "false")
+				return
+			}
+		}
+		f.index++
+		if r.Method == http.MethodDelete {
+			delete(f.pairs, key)
+		} else {
+			value, _ := io.ReadAll(r.Body)
+			f.pairs[key] = &consulapi.KVPair{Key: key, Value: value, ModifyIndex: f.index}
+		}
+		io.WriteString(w, "true")
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newFakeConsulClient avvia un fake KV e restituisce un client Consul che punta a esso.
+func newFakeConsulClient(t *testing.T) *consulapi.Client {
+	t.Helper()
+	srv := httptest.NewServer(newFakeConsulKV())
+	t.Cleanup(srv.Close)
+
+	config := consulapi.DefaultConfig()
+	config.Address = srv.Listener.Addr().String()
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		t.Fatalf("Impossibile creare il client Consul: %v", err)
+	}
+	return client
+}
+
+// correlationStores elenca le implementazioni dello store su cui girano i test di AnalyzeMetric.
+// Ogni chiamata alla factory restituisce uno store vuoto.
+func correlationStores(t *testing.T) map[string]func() CorrelationStore {
+	return map[string]func() CorrelationStore{
+		"memory": func() CorrelationStore { return newMemoryCorrelationStore() },
+		"consul": func() CorrelationStore {
+			return newConsulCorrelationStore(newFakeConsulClient(t), "ids/correlation/")
+		},
+	}
+}
+
+func TestCorrelationStore_WindowExpires(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			start := time.Now()
+
+			store.RecordAnomaly("client", start, windowConfig{Size: time.Minute, Threshold: 3})
+			store.RecordAnomaly("client", start.Add(10*time.Second), windowConfig{Size: time.Minute, Threshold: 3})
+			// Due minuti dopo le anomalie precedenti sono fuori finestra
+			result, err := store.RecordAnomaly("client", start.Add(2*time.Minute), windowConfig{Size: time.Minute, Threshold: 3})
+			if err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if result.Count != 1 || result.Triggered {
+				t.Errorf("Atteso conteggio 1 senza allarme, ricevuto %d (allarme: %v)", result.Count, result.Triggered)
+			}
+		})
+	}
+}
+
+func TestCorrelationStore_TakeAndMerge(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			now := time.Now()
+			store.RecordAnomaly("client/a", now, windowConfig{Size: time.Minute, Threshold: 10})
+			store.RecordAnomaly("client-b", now, windowConfig{Size: time.Minute, Threshold: 10})
+
+			taken, err := store.Take(func(clientID string) bool { return clientID == "client/a" })
+			if err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if len(taken) != 1 || len(taken["client/a"].Timestamps) != 1 {
+				t.Fatalf("Attesa la sola finestra di client/a, ricevuto %v", taken)
+			}
+			if again, _ := store.Take(func(clientID string) bool { return clientID == "client/a" }); len(again) != 0 {
+				t.Errorf("Una finestra già presa non deve essere restituita di nuovo")
+			}
+
+			imported, err := store.Merge(taken, func(string) windowConfig { return windowConfig{Size: time.Minute, Threshold: 10} })
+			if err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if imported != 1 {
+				t.Errorf("Atteso 1 client importato, ricevuti %d", imported)
+			}
+			if imported, _ := store.Merge(taken, func(string) windowConfig { return windowConfig{Size: time.Minute, Threshold: 10} }); imported != 0 {
+				t.Errorf("Reimportare gli stessi istanti non deve aggiungere anomalie")
+			}
+		})
+	}
+}
+
+func TestConsulCorrelationStore_SurvivesRestart(t *testing.T) {
+	client := newFakeConsulClient(t)
+	now := time.Now()
+
+	beforeRestart := newConsulCorrelationStore(client, "ids/correlation/")
+	beforeRestart.RecordAnomaly("attacker", now, windowConfig{Size: time.Minute, Threshold: 3})
+	beforeRestart.RecordAnomaly("attacker", now.Add(time.Second), windowConfig{Size: time.Minute, Threshold: 3})
+
+	// Una nuova istanza (o la stessa dopo un riavvio) ritrova la finestra
+	afterRestart := newConsulCorrelationStore(client, "ids/correlation/")
+	result, err := afterRestart.RecordAnomaly("attacker", now.Add(2*time.Second), windowConfig{Size: time.Minute, Threshold: 3})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if result.Count != 3 || !result.Triggered {
+		t.Errorf("La terza anomalia doveva far scattare l'allarme, conteggio %d (allarme: %v)", result.Count, result.Triggered)
+	}
+}
+
+func TestConsulCorrelationStore_ConcurrentReplicasTriggerOncePerThreshold(t *testing.T) {
+	client := newFakeConsulClient(t)
+	replicas := []CorrelationStore{
+		newConsulCorrelationStore(client, "ids/correlation/"),
+		newConsulCorrelationStore(client, "ids/correlation/"),
+	}
+
+	const perReplica = 15
+	const threshold = 3
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	alarms := 0
+	base := time.Now()
+	for r, store := range replicas {
+		wg.Add(1)
+		go func(r int, store CorrelationStore) {
+			defer wg.Done()
+			for i := 0; i < perReplica; i++ {
+				// Le due repliche ricevono anomalie intercalate, quindi fuori ordine tra loro
+				at := base.Add(time.Duration(r*perReplica+i) * time.Millisecond)
+				result, err := store.RecordAnomaly("attacker", at, windowConfig{Size: time.Minute, Threshold: threshold, AllowedLateness: time.Minute})
+				if err != nil {
+					t.Errorf("Errore inatteso: %v", err)
+					return
+				}
+				if result.Triggered {
+					mu.Lock()
+					alarms++
+					mu.Unlock()
+				}
+			}
+		}(r, store)
+	}
+	wg.Wait()
+
+	expected := len(replicas) * perReplica / threshold
+	if alarms != expected {
+		t.Errorf("Con %d anomalie e soglia %d erano attesi %d allarmi, generati %d", len(replicas)*perReplica, threshold, expected, alarms)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/consul"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// dependencyConn è la connessione verso una dipendenza (storage o inferenza) che segue il watcher
+// di Consul: quando l'istanza in uso viene deregistrata la connessione viene chiusa e riaperta verso
+// un'altra istanza sana. Implementa grpc.ClientConnInterface, quindi i client generati la usano
+// come una normale *grpc.ClientConn senza accorgersi dei cambi di istanza.
+type dependencyConn struct {
+	service string
+	dial    func(addr string) (*grpc.ClientConn, error)
+
+	mu   sync.RWMutex
+	addr string
+	conn *grpc.ClientConn // nil = nessuna istanza sana disponibile
+}
+
+// newDependencyConn parte dalla connessione già aperta verso addr, tipicamente l'istanza restituita
+// da WaitForInstance all'avvio; dial viene usata per le riconnessioni successive.
+func newDependencyConn(service, addr string, conn *grpc.ClientConn, dial func(addr string) (*grpc.ClientConn, error)) *dependencyConn {
+	return &dependencyConn{service: service, dial: dial, addr: addr, conn: conn}
+}
+
+// connectDependency attende la prima istanza sana del servizio e vi si connette; le riconnessioni
+// successive usano le stesse credenziali ma non attendono che la connessione sia pronta.
+func connectDependency(ctx context.Context, watcher *consul.ServiceWatcher, service string, creds credentials.TransportCredentials) *dependencyConn {
+	addr, err := watcher.WaitForInstance(ctx)
+	if err != nil {
+		log.Fatalf("FALLIMENTO CRITICO: Impossibile fare discovery di %s: %v", service, err)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor())}
+	conn, err := grpc.DialContext(ctx, addr, append(opts, grpc.WithBlock())...)
+	if err != nil {
+		log.Fatalf("FALLIMENTO CRITICO: Impossibile connettersi a %s: %v", service, err)
+	}
+	log.Printf("Connesso a %s.", service)
+	return newDependencyConn(service, addr, conn, func(addr string) (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, opts...)
+	})
+}
+
+// Invoke implementa grpc.ClientConnInterface sulla connessione corrente.
+func (d *dependencyConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	conn, err := d.current()
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implementa grpc.ClientConnInterface sulla connessione corrente.
+func (d *dependencyConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := d.current()
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+func (d *dependencyConn) current() (*grpc.ClientConn, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.conn == nil {
+		return nil, status.Errorf(codes.Unavailable, "no healthy instances of %s", d.service)
+	}
+	return d.conn, nil
+}
+
+// target restituisce l'indirizzo dell'istanza in uso (vuoto se non ce n'è nessuna).
+func (d *dependencyConn) target() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.addr
+}
+
+// follow applica i cambiamenti di membership finché il canale non viene chiuso.
+func (d *dependencyConn) follow(events <-chan consul.ServiceEvent) {
+	for ev := range events {
+		d.update(ev.Instances)
+	}
+}
+
+// update mantiene la connessione se l'istanza in uso è ancora sana; altrimenti la chiude
+// e si ricollega alla prima istanza disponibile. Senza istanze sane le chiamate falliscono
+// con UNAVAILABLE finché il watcher non ne segnala una nuova.
+func (d *dependencyConn) update(instances []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil && slices.Contains(instances, d.addr) {
+		return
+	}
+	if d.conn != nil {
+		log.Printf("Closing gRPC connection to deregistered %s instance %s", d.service, d.addr)
+		if err := d.conn.Close(); err != nil {
+			log.Printf("Error closing connection to %s: %v", d.addr, err)
+		}
+		d.conn, d.addr = nil, ""
+	}
+	for _, addr := range instances {
+		// Senza WithBlock la Dial non attende la connessione, quindi il lock resta preso per poco
+		conn, err := d.dial(addr)
+		if err != nil {
+			log.Printf("WARNING: impossibile connettersi a %s su %s: %v", d.service, addr, err)
+			continue
+		}
+		d.conn, d.addr = conn, addr
+		log.Printf("Connesso a %s su %s.", d.service, addr)
+		return
+	}
+}
+
+// Close chiude la connessione corrente.
+func (d *dependencyConn) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn, d.addr = nil, ""
+	return err
+}
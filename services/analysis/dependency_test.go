@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// newTestDependencyConn restituisce una dependencyConn collegata a addr e l'elenco degli indirizzi
+// a cui si è ricollegata. Le Dial non bloccano, quindi non serve un server in ascolto.
+func newTestDependencyConn(t *testing.T, addr string) (*dependencyConn, *[]string) {
+	t.Helper()
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	conn, err := dial(addr)
+	if err != nil {
+		t.Fatalf("Dial fallita: %v", err)
+	}
+	var dialed []string
+	d := newDependencyConn("storage-service", addr, conn, func(addr string) (*grpc.ClientConn, error) {
+		dialed = append(dialed, addr)
+		return dial(addr)
+	})
+	t.Cleanup(func() { d.Close() })
+	return d, &dialed
+}
+
+func TestDependencyConn_FollowsMembership(t *testing.T) {
+	d, dialed := newTestDependencyConn(t, "storage-1:50052")
+	first, _ := d.current()
+
+	// Finché l'istanza in uso resta sana la connessione non cambia
+	d.update([]string{"storage-0:50052", "storage-1:50052"})
+	if d.target() != "storage-1:50052" || len(*dialed) != 0 {
+		t.Fatalf("Con l'istanza ancora sana non doveva ricollegarsi, target %s, dial %v", d.target(), *dialed)
+	}
+
+	// Deregistrata l'istanza in uso, la connessione viene chiusa e riaperta verso un'altra
+	d.update([]string{"storage-2:50052"})
+	if d.target() != "storage-2:50052" {
+		t.Fatalf("Atteso il passaggio a storage-2, target %s", d.target())
+	}
+	if state := first.GetState(); state != connectivity.Shutdown {
+		t.Errorf("La connessione verso l'istanza deregistrata doveva essere chiusa, stato %s", state)
+	}
+
+	// Senza istanze sane le chiamate falliscono subito con UNAVAILABLE
+	d.update(nil)
+	_, err := pb.NewStorageClient(d).StoreMetric(context.Background(), &pb.Metric{})
+	if status.Code(err) != codes.Unavailable || d.target() != "" {
+		t.Errorf("Senza istanze atteso UNAVAILABLE, ottenuto %v (target %q)", err, d.target())
+	}
+
+	// La prima istanza che torna sana viene usata di nuovo
+	d.update([]string{"storage-3:50052"})
+	if d.target() != "storage-3:50052" {
+		t.Errorf("Atteso il ricollegamento a storage-3, target %q", d.target())
+	}
+	if want := "[storage-2:50052 storage-3:50052]"; fmt.Sprint(*dialed) != want {
+		t.Errorf("Dial attese %s, ottenute %v", want, *dialed)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/consul"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/hashring"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// rebalanceInterval è l'intervallo del controllo periodico che cede i client non più posseduti,
+	// per recuperare eventuali handoff falliti o metriche arrivate durante un cambio di topologia.
+	rebalanceInterval = 30 * time.Second
+	// handoffTimeout limita la durata di ogni chiamata verso un'altra istanza durante l'handoff.
+	handoffTimeout = 5 * time.Second
+)
+
+// ExportState cede all'istanza richiedente le finestre dei client che le appartengono
+// secondo la membership indicata. Le finestre esportate vengono rimosse localmente.
+func (s *server) ExportState(ctx context.Context, in *pb.ExportStateRequest) (*pb.CorrelationState, error) {
+	ring := hashring.New(ringVirtualNodes)
+	ring.Set(in.Members)
+
+	windows, err := s.takeWindows(func(key string) bool {
+		owner, ok := ring.Get(clientOfKey(key))
+		return ok && owner == in.TargetInstance
+	})
+	if err != nil {
+		log.Printf("[HANDOFF] ERROR: esportazione parziale verso %s: %v", in.TargetInstance, err)
+	}
+	log.Printf("[HANDOFF] Esportate %d finestre di correlazione verso %s.", len(windows), in.TargetInstance)
+	return &pb.CorrelationState{Windows: windows}, nil
+}
+
+// ImportState unisce alle finestre locali quelle cedute da un'altra istanza.
+func (s *server) ImportState(ctx context.Context, in *pb.CorrelationState) (*pb.ImportStateResponse, error) {
+	imported, err := s.mergeWindows(in.Windows)
+	if err != nil {
+		log.Printf("[HANDOFF] ERROR: importazione da %s fallita: %v", in.SourceInstance, err)
+		return nil, err
+	}
+	log.Printf("[HANDOFF] Importate %d finestre di correlazione da %s.", imported, in.SourceInstance)
+	return &pb.ImportStateResponse{ImportedClients: int32(imported)}, nil
+}
+
+// takeWindows rimuove dallo store e restituisce le finestre con le chiavi selezionate.
+func (s *server) takeWindows(selectKey func(key string) bool) ([]*pb.ClientWindow, error) {
+	taken, err := s.correlation.Take(selectKey)
+	windows := make([]*pb.ClientWindow, 0, len(taken))
+	for key, w := range taken {
+		window := &pb.ClientWindow{ClientId: key, AnomalyTimestamps: make([]int64, len(w.Timestamps))}
+		for i, ts := range w.Timestamps {
+			window.AnomalyTimestamps[i] = ts.UnixNano()
+		}
+		if !w.MaxEventTime.IsZero() {
+			window.MaxEventTime = w.MaxEventTime.UnixNano()
+		}
+		windows = append(windows, window)
+	}
+	return windows, err
+}
+
+// mergeWindows unisce allo store le finestre ricevute, scartando gli istanti già presenti
+// o fuori dalla finestra temporale. Restituisce il numero di client con almeno un'anomalia importata.
+func (s *server) mergeWindows(windows []*pb.ClientWindow) (int, error) {
+	incoming := make(map[string]clientWindow, len(windows))
+	for _, window := range windows {
+		merged := incoming[window.ClientId]
+		for _, nanos := range window.AnomalyTimestamps {
+			merged.Timestamps = append(merged.Timestamps, time.Unix(0, nanos))
+		}
+		sort.Slice(merged.Timestamps, func(i, j int) bool { return merged.Timestamps[i].Before(merged.Timestamps[j]) })
+		if window.MaxEventTime != 0 && time.Unix(0, window.MaxEventTime).After(merged.MaxEventTime) {
+			merged.MaxEventTime = time.Unix(0, window.MaxEventTime)
+		}
+		incoming[window.ClientId] = merged
+	}
+	// Ogni finestra va unita con la configurazione della sua regola: con quella globale una regola
+	// con una finestra più lunga perderebbe le anomalie più vecchie a ogni handoff
+	return s.correlation.Merge(incoming, s.rules.rules().windowFor)
+}
+
+// stateHandoff sposta lo stato di correlazione tra le istanze di analisi quando cambia
+// la membership dell'anello, così un attacco in corso non riparte da zero su un'altra istanza.
+// Usa lo stesso anello (e lo stesso numero di nodi virtuali) del collector.
+type stateHandoff struct {
+	server *server
+	self   string
+	ring   *hashring.Ring
+	// dial apre una connessione verso un'altra istanza di analisi; sostituibile nei test.
+	dial func(ctx context.Context, addr string) (pb.AnalysisServiceClient, func(), error)
+	// mu serializza i rebalance, che possono partire sia dagli eventi che dal ticker.
+	mu sync.Mutex
+}
+
+// newStateHandoff crea l'handoff dell'istanza self; creds sono le credenziali verso le altre istanze.
+func newStateHandoff(s *server, self string, creds credentials.TransportCredentials) *stateHandoff {
+	return &stateHandoff{
+		server: s,
+		self:   self,
+		ring:   hashring.New(ringVirtualNodes),
+		dial:   analysisPeerDialer(creds),
+	}
+}
+
+func analysisPeerDialer(creds credentials.TransportCredentials) func(ctx context.Context, addr string) (pb.AnalysisServiceClient, func(), error) {
+	return func(ctx context.Context, addr string) (pb.AnalysisServiceClient, func(), error) {
+		conn, err := grpc.DialContext(ctx, addr,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to analysis instance at %s: %w", addr, err)
+		}
+		return pb.NewAnalysisServiceClient(conn), func() { conn.Close() }, nil
+	}
+}
+
+// run segue i cambiamenti delle istanze di analisi e ribilancia lo stato a ogni variazione
+// dell'anello, oltre che periodicamente.
+func (h *stateHandoff) run(ctx context.Context, events <-chan consul.ServiceEvent) {
+	ticker := time.NewTicker(rebalanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			wasMember := h.isMember()
+			if !h.ring.Set(ev.Instances) {
+				continue
+			}
+			log.Printf("[HANDOFF] Membership dell'anello cambiata: %v", h.ring.Nodes())
+			if !wasMember && h.isMember() {
+				// Siamo appena entrati nell'anello: recuperiamo dalle altre istanze i client che ora ci appartengono
+				h.pullOwnedState(ctx)
+			}
+			h.rebalance(ctx)
+		case <-ticker.C:
+			h.rebalance(ctx)
+		}
+	}
+}
+
+func (h *stateHandoff) isMember() bool {
+	for _, node := range h.ring.Nodes() {
+		if node == h.self {
+			return true
+		}
+	}
+	return false
+}
+
+// rebalance cede ai nuovi proprietari le finestre dei client che non appartengono più a questa istanza.
+func (h *stateHandoff) rebalance(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Finché non siamo nell'anello (es. health check non ancora superato) ogni client risulterebbe
+	// di un'altra istanza: non cediamo nulla per non svuotare lo stato.
+	if !h.isMember() {
+		return
+	}
+	windows, err := h.server.takeWindows(func(key string) bool {
+		owner, ok := h.ring.Get(clientOfKey(key))
+		return ok && owner != h.self
+	})
+	if err != nil {
+		log.Printf("[HANDOFF] ERROR: lettura dello stato da cedere: %v", err)
+	}
+	h.pushWindows(ctx, h.ring, windows)
+}
+
+// handOffAll cede tutto lo stato alle altre istanze. Va chiamata allo spegnimento,
+// dopo la deregistrazione da Consul.
+func (h *stateHandoff) handOffAll(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var others []string
+	for _, node := range h.ring.Nodes() {
+		if node != h.self {
+			others = append(others, node)
+		}
+	}
+	windows, err := h.server.takeWindows(func(string) bool { return true })
+	if err != nil {
+		log.Printf("[HANDOFF] ERROR: lettura dello stato da cedere: %v", err)
+	}
+	if len(others) == 0 {
+		if len(windows) > 0 {
+			log.Printf("[HANDOFF] WARNING: nessun'altra istanza disponibile, %d finestre di correlazione andranno perse.", len(windows))
+		}
+		return
+	}
+
+	ring := hashring.New(ringVirtualNodes)
+	ring.Set(others)
+	h.pushWindows(ctx, ring, windows)
+}
+
+// pushWindows invia le finestre ai rispettivi proprietari secondo l'anello indicato.
+// Se un invio fallisce le finestre vengono reinserite localmente e ritentate al prossimo rebalance.
+func (h *stateHandoff) pushWindows(ctx context.Context, ring *hashring.Ring, windows []*pb.ClientWindow) {
+	byOwner := make(map[string][]*pb.ClientWindow)
+	for _, window := range windows {
+		owner, ok := ring.Get(clientOfKey(window.ClientId))
+		if !ok {
+			continue
+		}
+		byOwner[owner] = append(byOwner[owner], window)
+	}
+
+	for owner, ownerWindows := range byOwner {
+		if err := h.push(ctx, owner, ownerWindows); err != nil {
+			log.Printf("[HANDOFF] ERROR: impossibile cedere %d finestre a %s, verranno ritentate: %v", len(ownerWindows), owner, err)
+			if _, err := h.server.mergeWindows(ownerWindows); err != nil {
+				log.Printf("[HANDOFF] ERROR: impossibile reinserire le finestre non cedute: %v", err)
+			}
+			continue
+		}
+		log.Printf("[HANDOFF] Cedute %d finestre di correlazione a %s.", len(ownerWindows), owner)
+	}
+}
+
+func (h *stateHandoff) push(ctx context.Context, owner string, windows []*pb.ClientWindow) error {
+	callCtx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+
+	peer, closePeer, err := h.dial(callCtx, owner)
+	if err != nil {
+		return err
+	}
+	defer closePeer()
+
+	_, err = peer.ImportState(callCtx, &pb.CorrelationState{Windows: windows, SourceInstance: h.self})
+	return err
+}
+
+// pullOwnedState chiede alle altre istanze le finestre dei client che ora appartengono a questa istanza.
+func (h *stateHandoff) pullOwnedState(ctx context.Context) {
+	members := h.ring.Nodes()
+	for _, peerAddr := range members {
+		if peerAddr == h.self {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, handoffTimeout)
+		peer, closePeer, err := h.dial(callCtx, peerAddr)
+		if err != nil {
+			log.Printf("[HANDOFF] ERROR: impossibile contattare %s: %v", peerAddr, err)
+			cancel()
+			continue
+		}
+		state, err := peer.ExportState(callCtx, &pb.ExportStateRequest{Members: members, TargetInstance: h.self})
+		closePeer()
+		cancel()
+		if err != nil {
+			log.Printf("[HANDOFF] ERROR: impossibile recuperare lo stato da %s: %v", peerAddr, err)
+			continue
+		}
+		if _, err := h.server.mergeWindows(state.Windows); err != nil {
+			log.Printf("[HANDOFF] ERROR: impossibile importare lo stato di %s: %v", peerAddr, err)
+		}
+	}
+}
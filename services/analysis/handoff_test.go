@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/hashring"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// mockAnalysisPeer simula un'altra istanza di analisi che riceve lo stato ceduto.
+type mockAnalysisPeer struct {
+	pb.AnalysisServiceClient
+	mu       sync.Mutex
+	received []*pb.ClientWindow
+	fail     bool
+}
+
+func (m *mockAnalysisPeer) ImportState(ctx context.Context, in *pb.CorrelationState, opts ...grpc.CallOption) (*pb.ImportStateResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return nil, errors.New("simulated peer failure")
+	}
+	m.received = append(m.received, in.Windows...)
+	return &pb.ImportStateResponse{ImportedClients: int32(len(in.Windows))}, nil
+}
+
+// newHandoffTestServer crea un server con uno store in memoria e restituisce anche
+// lo store, per poterlo ispezionare e popolare direttamente.
+func newHandoffTestServer() (*server, *memoryCorrelationStore) {
+	store := newMemoryCorrelationStore()
+	return &server{correlation: store}, store
+}
+
+// set sostituisce la finestra di un client; usato solo dai test.
+func (m *memoryCorrelationStore) set(clientID string, timestamps []time.Time) {
+	shard := m.shardFor(clientID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.windows[clientID] = clientWindow{Timestamps: timestamps, MaxEventTime: timestamps[len(timestamps)-1]}
+}
+
+// get restituisce la finestra di un client; usato solo dai test.
+func (m *memoryCorrelationStore) get(clientID string) ([]time.Time, bool) {
+	shard := m.shardFor(clientID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	window, ok := shard.windows[clientID]
+	return window.Timestamps, ok
+}
+
+// clients restituisce le chiavi con una finestra nello store; usato solo dai test.
+func (m *memoryCorrelationStore) clients() []string {
+	var ids []string
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for clientID := range shard.windows {
+			ids = append(ids, clientID)
+		}
+		shard.mu.Unlock()
+	}
+	return ids
+}
+
+func newTestHandoff(s *server, self string, peer *mockAnalysisPeer) *stateHandoff {
+	h := newStateHandoff(s, self, insecure.NewCredentials())
+	h.dial = func(ctx context.Context, addr string) (pb.AnalysisServiceClient, func(), error) {
+		return peer, func() {}, nil
+	}
+	return h
+}
+
+func TestImportState_MergesWithLocalWindow(t *testing.T) {
+	anomalyThreshold = 3
+	timeWindow = 1 * time.Minute
+	s, store := newHandoffTestServer()
+
+	now := time.Now()
+	local := now.Add(-10 * time.Second)
+	store.set("attacker", []time.Time{local})
+
+	_, err := s.ImportState(context.Background(), &pb.CorrelationState{Windows: []*pb.ClientWindow{{
+		ClientId: "attacker",
+		AnomalyTimestamps: []int64{
+			local.UnixNano(),                     // Già presente: non deve essere duplicato
+			now.Add(-5 * time.Second).UnixNano(), // Nuovo
+			now.Add(-2 * time.Minute).UnixNano(), // Fuori finestra: scartato
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+
+	if window, _ := store.get("attacker"); len(window) != 2 {
+		t.Errorf("Attese 2 anomalie dopo l'importazione, trovate %d", len(window))
+	}
+}
+
+func TestImportState_UsesRuleWindow(t *testing.T) {
+	// La regola ha una finestra di 10 minuti: l'handoff non deve troncarla a quella globale di 60s
+	anomalyThreshold = 3
+	timeWindow = 1 * time.Minute
+	windowKind = slidingWindow
+	allowedLateness = 0
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, `
+rules:
+  - id: slow_scan
+    expression: count > 100
+    correlation:
+      window_seconds: 600
+      threshold: 5
+`)
+	engine, err := newRuleEngine(path)
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	s, store := newHandoffTestServer()
+	s.rules = engine
+
+	now := time.Now()
+	timestamps := []int64{now.Add(-5 * time.Minute).UnixNano(), now.Add(-10 * time.Second).UnixNano()}
+	ruleKey := correlationKey("slow_scan", "attacker")
+	mlKey := correlationKey(mlRuleID, "attacker")
+	_, err = s.ImportState(context.Background(), &pb.CorrelationState{Windows: []*pb.ClientWindow{
+		{ClientId: ruleKey, AnomalyTimestamps: timestamps, MaxEventTime: timestamps[1]},
+		{ClientId: mlKey, AnomalyTimestamps: timestamps, MaxEventTime: timestamps[1]},
+	}})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+
+	if window, _ := store.get(ruleKey); len(window) != 2 {
+		t.Errorf("La finestra di 10 minuti della regola doveva conservare 2 anomalie, trovate %d", len(window))
+	}
+	if window, _ := store.get(mlKey); len(window) != 1 {
+		t.Errorf("La finestra globale di 60s del modello doveva conservare 1 anomalia, trovate %d", len(window))
+	}
+}
+
+func TestImportedState_TriggersAlarmAtThreshold(t *testing.T) {
+	// Un client con 2 anomalie su un'istanza che passa a un'altra istanza deve far scattare
+	// l'allarme alla terza anomalia, come se fosse rimasto sulla stessa istanza.
+	anomalyThreshold = 3
+	timeWindow = 1 * time.Minute
+
+	oldOwner, oldStore := newHandoffTestServer()
+	now := time.Now()
+	key := correlationKey(mlRuleID, "attacker")
+	oldStore.set(key, []time.Time{now.Add(-2 * time.Second), now.Add(-1 * time.Second)})
+
+	mockStore := &mockStorageClient{}
+	newOwner := &server{
+		storageClient:   mockStore,
+		inferenceClient: &mockInferenceClient{prediction: -1},
+		circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		correlation:     newMemoryCorrelationStore(),
+	}
+
+	state, err := oldOwner.ExportState(context.Background(), &pb.ExportStateRequest{
+		Members:        []string{"analysis-new:50053"},
+		TargetInstance: "analysis-new:50053",
+	})
+	if err != nil {
+		t.Fatalf("Errore inatteso in ExportState: %v", err)
+	}
+	if _, ok := oldStore.get(key); ok {
+		t.Errorf("La finestra esportata doveva essere rimossa dall'istanza di origine")
+	}
+	if _, err := newOwner.ImportState(context.Background(), state); err != nil {
+		t.Fatalf("Errore inatteso in ImportState: %v", err)
+	}
+
+	_, err = newOwner.AnalyzeMetric(context.Background(), &pb.Metric{SourceClientId: "attacker", Features: make([]float32, 41)})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if mockStore.storeAlarmCalledCount != 1 {
+		t.Errorf("L'allarme doveva scattare alla terza anomalia complessiva, StoreAlarm chiamato %d volte", mockStore.storeAlarmCalledCount)
+	}
+}
+
+func TestExportState_OnlyExportsClientsOwnedByTarget(t *testing.T) {
+	timeWindow = 1 * time.Minute
+	ringVirtualNodes = hashring.DefaultVirtualNodes
+	s, store := newHandoffTestServer()
+	members := []string{"analysis-1:50053", "analysis-2:50053"}
+	ring := hashring.New(hashring.DefaultVirtualNodes)
+	ring.Set(members)
+
+	for i := 0; i < 50; i++ {
+		// Le finestre sono per regola: l'istanza proprietaria dipende solo dal client
+		store.set(correlationKey(mlRuleID, fmt.Sprintf("client-%d", i)), []time.Time{time.Now()})
+		store.set(correlationKey("syn_flood", fmt.Sprintf("client-%d", i)), []time.Time{time.Now()})
+	}
+
+	state, err := s.ExportState(context.Background(), &pb.ExportStateRequest{Members: members, TargetInstance: "analysis-2:50053"})
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	for _, window := range state.Windows {
+		if owner, _ := ring.Get(clientOfKey(window.ClientId)); owner != "analysis-2:50053" {
+			t.Errorf("Esportato il client %s che appartiene a %s", window.ClientId, owner)
+		}
+	}
+	for _, key := range store.clients() {
+		if owner, _ := ring.Get(clientOfKey(key)); owner == "analysis-2:50053" {
+			t.Errorf("Il client %s appartiene al destinatario ma non è stato esportato", clientOfKey(key))
+		}
+	}
+	if len(state.Windows) == 0 || len(store.clients()) == 0 {
+		t.Errorf("Con due istanze i client dovevano dividersi tra le due (esportati %d, rimasti %d)", len(state.Windows), len(store.clients()))
+	}
+}
+
+func TestRebalance_PushesClientsToNewOwner(t *testing.T) {
+	timeWindow = 1 * time.Minute
+	ringVirtualNodes = hashring.DefaultVirtualNodes
+	s, store := newHandoffTestServer()
+	for i := 0; i < 50; i++ {
+		store.set(fmt.Sprintf("client-%d", i), []time.Time{time.Now()})
+	}
+
+	peer := &mockAnalysisPeer{}
+	h := newTestHandoff(s, "analysis-1:50053", peer)
+
+	// Da soli possediamo tutti i client: nessuna cessione
+	h.ring.Set([]string{"analysis-1:50053"})
+	h.rebalance(context.Background())
+	if len(peer.received) != 0 {
+		t.Fatalf("Nessuna finestra doveva essere ceduta, cedute %d", len(peer.received))
+	}
+
+	// Entra una nuova istanza: le cediamo i suoi client
+	h.ring.Set([]string{"analysis-1:50053", "analysis-2:50053"})
+	h.rebalance(context.Background())
+
+	if len(peer.received) == 0 {
+		t.Fatalf("Alcune finestre dovevano essere cedute alla nuova istanza")
+	}
+	if len(peer.received)+len(store.clients()) != 50 {
+		t.Errorf("Nessuna finestra doveva andare persa: cedute %d, rimaste %d", len(peer.received), len(store.clients()))
+	}
+	for _, window := range peer.received {
+		if owner, _ := h.ring.Get(window.ClientId); owner != "analysis-2:50053" {
+			t.Errorf("Ceduto il client %s che appartiene ancora a %s", window.ClientId, owner)
+		}
+	}
+}
+
+func TestRebalance_KeepsStateWhenPeerUnreachable(t *testing.T) {
+	timeWindow = 1 * time.Minute
+	ringVirtualNodes = hashring.DefaultVirtualNodes
+	s, store := newHandoffTestServer()
+	for i := 0; i < 50; i++ {
+		store.set(fmt.Sprintf("client-%d", i), []time.Time{time.Now()})
+	}
+
+	peer := &mockAnalysisPeer{fail: true}
+	h := newTestHandoff(s, "analysis-1:50053", peer)
+	h.ring.Set([]string{"analysis-1:50053", "analysis-2:50053"})
+	h.rebalance(context.Background())
+
+	if len(store.clients()) != 50 {
+		t.Errorf("Con il peer irraggiungibile lo stato doveva restare locale, rimasti %d client su 50", len(store.clients()))
+	}
+}
+
+func TestRebalance_SkippedWhenNotAMember(t *testing.T) {
+	timeWindow = 1 * time.Minute
+	ringVirtualNodes = hashring.DefaultVirtualNodes
+	s, store := newHandoffTestServer()
+	store.set("client-1", []time.Time{time.Now()})
+
+	peer := &mockAnalysisPeer{}
+	h := newTestHandoff(s, "analysis-1:50053", peer)
+	// La nostra istanza non è ancora sana su Consul: l'anello contiene solo l'altra
+	h.ring.Set([]string{"analysis-2:50053"})
+	h.rebalance(context.Background())
+
+	if len(peer.received) != 0 || len(store.clients()) != 1 {
+		t.Errorf("Un'istanza fuori dall'anello non deve cedere il proprio stato")
+	}
+}
@@ -2,97 +2,238 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/consul"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/dedup"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/hashring"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/iforest"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/mtls"
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/tracing"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
 	"github.com/sony/gobreaker"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 // --- PARAMETRI CONFIGURABILI PER LA CORRELAZIONE ---
 var (
 	anomalyThreshold  int
-	timeWindow        time.Duration
-	fallbackThreshold float64 // NUOVA VARIABILE
+	timeWindow        time.Duration  // Dimensione della finestra, o gap di inattività per le finestre session
+	fallbackThreshold float64        // Soglia storica, usata solo finché la baseline statistica non è pronta
+	ringVirtualNodes  int            // Deve coincidere con quello del collector per calcolare gli stessi proprietari
+	windowKind        windowType     // sliding, tumbling o session
+	allowedLateness   time.Duration  // Ritardo massimo tollerato per le anomalie fuori ordine
+	maxClockSkew      time.Duration  // Anticipo massimo dell'event time rispetto all'orologio locale
+	maxEventAge       time.Duration  // Età massima dell'event time (0 = nessun limite)
+	rejectSkewed      bool           // true: le metriche con timestamp implausibile vengono rifiutate invece che segnalate
+	scoreBands        []severityBand // Fasce di punteggio del modello per la gravità degli allarmi ML
 )
 
+// correlationWindow restituisce la configurazione corrente delle finestre di correlazione.
+func correlationWindow() windowConfig {
+	return windowConfig{
+		Type:            windowKind,
+		Size:            timeWindow,
+		Threshold:       anomalyThreshold,
+		AllowedLateness: allowedLateness,
+	}
+}
+
+// eventTime restituisce l'istante a cui si riferisce la metrica. Le metriche senza timestamp
+// usano l'istante di arrivo. Se il timestamp è implausibile rispetto all'orologio locale
+// restituisce anche il motivo, e la metrica non deve essere correlata.
+func eventTime(in *pb.Metric, now time.Time) (time.Time, string) {
+	if in.Timestamp == 0 {
+		return now, ""
+	}
+	at := time.Unix(in.Timestamp, 0)
+	if at.Sub(now) > maxClockSkew {
+		return at, fmt.Sprintf("timestamp %s is %s ahead of the analysis clock", at.UTC().Format(time.RFC3339), at.Sub(now).Round(time.Second))
+	}
+	if maxEventAge > 0 && now.Sub(at) > maxEventAge {
+		return at, fmt.Sprintf("timestamp %s is older than %s", at.UTC().Format(time.RFC3339), maxEventAge)
+	}
+	return at, ""
+}
+
+// discoveryTimeout limita l'attesa delle dipendenze (storage e inferenza) all'avvio.
+const discoveryTimeout = 30 * time.Second
+
 type server struct {
 	pb.UnimplementedAnalysisServiceServer
-	storageClient     pb.StorageClient
-	inferenceClient   pb.InferenceClient
-	circuitBreaker    *gobreaker.CircuitBreaker
-	suspiciousClients map[string][]time.Time
-	mu                sync.Mutex
+	storageClient   pb.StorageClient
+	inferenceClient pb.InferenceClient
+	circuitBreaker  *gobreaker.CircuitBreaker
+	correlation     CorrelationStore
+	rules           *ruleEngine
+	baseline        *baselineDetector // Rilevatore statistico per la modalità degradata (nil = solo soglia)
+	batcher         *inferenceBatcher // Micro-batching delle predizioni (nil = una Predict per metrica)
+	localModel      *iforest.Model    // Modello esportato usato se l'inferenza non risponde (nil = nessuno)
+	outbox          *outbox           // Decisioni da consegnare allo storage (nil = scrittura diretta)
+	seen            *dedup.Window     // metric_id già analizzati (nil = nessuna deduplica)
 }
 
 func (s *server) AnalyzeMetric(ctx context.Context, in *pb.Metric) (*pb.AnalysisResponse, error) {
-	log.Printf("[DEBUG] === INIZIO ANALISI per client %s ===", in.SourceClientId)
+	return s.analyzeMetric(ctx, in, nil)
+}
 
-	if len(in.Features) != 41 {
-		log.Printf("[DEBUG] Metrica scartata: numero di feature non valido (%d)", len(in.Features))
-		return &pb.AnalysisResponse{Processed: true, Message: "Metric skipped (incomplete features)"}, nil
+// predict ottiene la predizione del modello per una metrica. pending, se non è nil, è una
+// predizione già richiesta al batcher; altrimenti la richiesta parte ora, in batch se il
+// micro-batching è attivo o con una singola Predict sotto il circuit breaker.
+func (s *server) predict(ctx context.Context, features []float32, pending <-chan predictionResult) (*pb.InferenceResponse, error) {
+	if pending != nil {
+		return awaitPrediction(ctx, pending)
+	}
+	if s.batcher != nil {
+		return s.batcher.predict(ctx, features)
 	}
-
-	isAnomaly := false
-	analysisSource := ""
-	infResp := &pb.InferenceResponse{}
-
 	response, err := s.circuitBreaker.Execute(func() (interface{}, error) {
 		log.Println("[DEBUG] Chiamata al servizio di inferenza (dentro Circuit Breaker)...")
-		req := &pb.InferenceRequest{Features: in.Features}
+		req := &pb.InferenceRequest{Features: features}
 		return s.inferenceClient.Predict(ctx, req)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return response.(*pb.InferenceResponse), nil
+}
 
+// scoreLocally valuta la metrica con il modello esportato, quando il servizio di inferenza non è disponibile.
+func (s *server) scoreLocally(features []float32) (*pb.InferenceResponse, error) {
+	score, err := s.localModel.Score(features)
 	if err != nil {
-		log.Printf("[DEBUG] Chiamata a inferenza FALLITA o circuito APERTO. Errore: %v", err)
-		analysisSource = "Threshold (Fallback)"
-
-		var triggerValue float64
-		// Usiamo 'in.Value' come prima fonte, se non c'è usiamo Features[4]
-		// Questo rende la logica più robusta
-		if in.Value != 0 {
-			triggerValue = in.Value
-		} else if len(in.Features) > 4 {
-			triggerValue = float64(in.Features[4])
-		}
+		return nil, err
+	}
+	return &pb.InferenceResponse{
+		Prediction:   iforest.Predict(score),
+		Score:        score,
+		ModelName:    s.localModel.Name,
+		ModelVersion: s.localModel.Version,
+	}, nil
+}
+
+// analyzeMetric analizza una metrica; pending è l'eventuale predizione già richiesta (vedi predict).
+// Una metrica con un metric_id già analizzato (un sensore o il collector che ritentano dopo un
+// timeout) viene confermata senza analizzarla di nuovo, così non conta due volte nelle finestre.
+func (s *server) analyzeMetric(ctx context.Context, in *pb.Metric, pending <-chan predictionResult) (*pb.AnalysisResponse, error) {
+	switch s.seen.Begin(in.MetricId) {
+	case dedup.Done:
+		log.Printf("[DEBUG] Metrica %s di %s già analizzata, ripetizione ignorata.", in.MetricId, in.SourceClientId)
+		return &pb.AnalysisResponse{Processed: true, Message: "Duplicate metric ignored (already analyzed)"}, nil
+	case dedup.Pending:
+		return &pb.AnalysisResponse{Processed: false, Message: "Duplicate metric (analysis in progress)"}, status.Errorf(codes.Aborted, "metric %s is already being analyzed", in.MetricId)
+	}
+	resp, err := s.evaluateMetric(ctx, in, pending)
+	if err != nil || !resp.Processed {
+		s.seen.Abort(in.MetricId)
+	} else {
+		s.seen.Finish(in.MetricId)
+	}
+	return resp, err
+}
+
+// evaluateMetric valuta la metrica con il modello e le regole, aggiorna le finestre di correlazione
+// e affida allo storage la metrica o gli allarmi risultanti.
+func (s *server) evaluateMetric(ctx context.Context, in *pb.Metric, pending <-chan predictionResult) (*pb.AnalysisResponse, error) {
+	log.Printf("[DEBUG] === INIZIO ANALISI per client %s ===", in.SourceClientId)
+
+	// Vettori incompleti e valori fuori schema (es. codici categorici assenti dal vocabolario)
+	// falserebbero il modello: il collector li mette in quarantena, qui non vengono mai analizzati
+	if err := features.Validate(in.Features); err != nil {
+		log.Printf("WARNING: metrica di %s rifiutata: %v", in.SourceClientId, err)
+		return &pb.AnalysisResponse{Processed: false, Message: "Metric rejected: " + err.Error()}, status.Errorf(codes.InvalidArgument, "invalid metric features: %v", err)
+	}
 
-		log.Printf("[DEBUG] Fallback: triggerValue=%.2f, fallbackThreshold=%.2f", triggerValue, fallbackThreshold)
-		if triggerValue > fallbackThreshold {
-			isAnomaly = true
-			log.Println("[DEBUG] Fallback ha rilevato un'ANOMALIA.")
-		} else {
-			log.Println("[DEBUG] Fallback ha rilevato metrica NORMALE.")
+	at, skew := eventTime(in, time.Now())
+	if skew != "" {
+		if rejectSkewed {
+			log.Printf("WARNING: metrica di %s rifiutata: %s", in.SourceClientId, skew)
+			return &pb.AnalysisResponse{Processed: false, Message: "Metric rejected: " + skew}, status.Errorf(codes.InvalidArgument, "implausible metric timestamp: %s", skew)
 		}
+		log.Printf("WARNING: metrica di %s con timestamp implausibile, esclusa dalla correlazione: %s", in.SourceClientId, skew)
+	}
+
+	// Il modello ML e le regole valutano la metrica in modo indipendente: ogni regola che scatta
+	// (il modello è trattato come una regola) ha la propria finestra di correlazione.
+	var matched []*rule
+	fallback := false
+	inference, err := s.predict(ctx, in.Features, pending)
+	if err != nil && s.localModel != nil {
+		// Primo livello di fallback: lo stesso modello, esportato e valutato in Go
+		log.Printf("[DEBUG] Chiamata a inferenza FALLITA o circuito APERTO (%v): uso il modello locale.", err)
+		inference, err = s.scoreLocally(in.Features)
+	}
+	if err != nil {
+		log.Printf("[DEBUG] Chiamata a inferenza FALLITA o circuito APERTO. Errore: %v", err)
+		fallback = true
 	} else {
-		analysisSource = "ML Model"
-		infResp = response.(*pb.InferenceResponse)
-		log.Printf("[DEBUG] Chiamata a inferenza RIUSCITA. Predizione del modello: %d", infResp.Prediction)
-		if infResp.Prediction == -1 {
-			isAnomaly = true
+		log.Printf("[DEBUG] Chiamata a inferenza RIUSCITA. Predizione del modello: %d (score %.4f)", inference.Prediction, inference.Score)
+		if inference.Prediction == -1 {
+			r := mlRule
+			// La gravità dell'anomalia dipende da quanto è basso il punteggio del modello
+			if severity, ok := severityForScore(scoreBands, inference.Score); ok {
+				graded := *mlRule
+				graded.Severity = severity
+				r = &graded
+			}
+			matched = append(matched, r)
 		}
 	}
 
-	if !isAnomaly {
-		log.Printf("[DEBUG] Decisione finale: NORMALE. Sorgente: %s. Invio a StoreMetric...", analysisSource)
-		storeCtx := ctx
-		if analysisSource == "Threshold (Fallback)" {
-			storeCtx = context.Background()
+	inputs := ruleInputs(in)
+	ruleSet := s.rules.rules()
+	if !fallback && len(matched) == 0 {
+		// Solo le metriche giudicate normali dal modello alimentano la baseline statistica
+		s.baseline.observe(in.SourceClientId, inputs)
+	}
+	if fallback {
+		deviation, anomalous, ready := s.baseline.detect(in.SourceClientId, inputs)
+		switch {
+		case anomalous:
+			log.Printf("[DEBUG] Baseline superata: %s=%g (media %g, z=%.1f)", deviation.Feature, deviation.Value, deviation.Mean, deviation.Z)
+			matched = append(matched, baselineRule)
+		case !ready && !ruleSet.hasFallback:
+			// Senza una baseline pronta né regole di fallback resta solo la soglia storica
+			if r := builtinFallbackRule(fallbackThreshold); r.matches(inputs) {
+				matched = append(matched, r)
+			}
 		}
-		_, err = s.storageClient.StoreMetric(storeCtx, in)
-		if err != nil {
+	}
+	for _, r := range ruleSet.rules {
+		if r.fallbackOnly && !fallback {
+			continue
+		}
+		if r.matches(inputs) {
+			log.Printf("[DEBUG] Regola '%s' soddisfatta: %s", r.ID, r.expr.source)
+			matched = append(matched, r)
+		}
+	}
+
+	// Con l'inferenza non disponibile lo storage non deve dipendere dal contesto della richiesta
+	storeCtx := ctx
+	if fallback {
+		storeCtx = context.Background()
+	}
+
+	if len(matched) == 0 {
+		log.Printf("[DEBUG] Decisione finale: NORMALE. Invio a StoreMetric...")
+		if err := s.storeMetric(storeCtx, in); err != nil {
 			log.Printf("ERROR: could not store metric: %v", err)
 			return &pb.AnalysisResponse{Processed: false, Message: "Failed to store metric"}, err
 		}
@@ -100,59 +241,108 @@ func (s *server) AnalyzeMetric(ctx context.Context, in *pb.Metric) (*pb.Analysis
 	}
 
 	// Se arriviamo qui, la metrica è stata classificata come anomala
-	log.Printf("[DEBUG] Decisione finale: ANOMALA. Sorgente: %s. Avvio logica di correlazione...", analysisSource)
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	log.Printf("[DEBUG] Decisione finale: ANOMALA per %s. Avvio logica di correlazione...", ruleIDs(matched))
 
-	now := time.Now()
-	clientHistory := s.suspiciousClients[in.SourceClientId]
-	var validTimestamps []time.Time
-	for _, ts := range clientHistory {
-		if now.Sub(ts) < timeWindow {
-			validTimestamps = append(validTimestamps, ts)
-		}
+	// Le anomalie con timestamp implausibile o arrivate oltre il watermark vengono salvate
+	// ma non correlate, per non far scattare (o mancare) allarmi su istanti sbagliati.
+	if skew != "" {
+		return s.storeUncorrelated(storeCtx, in, "Anomalous metric with implausible timestamp stored without correlation: "+skew)
 	}
-	validTimestamps = append(validTimestamps, now)
-	s.suspiciousClients[in.SourceClientId] = validTimestamps
-
-	log.Printf("[DEBUG] Stato client '%s': %d anomalie recenti.", in.SourceClientId, len(validTimestamps))
-	log.Printf("[DEBUG] Controllo soglia: %d (attuali) >= %d (soglia)?", len(validTimestamps), anomalyThreshold)
 
-	if len(validTimestamps) >= anomalyThreshold {
-		log.Printf("[DEBUG] SOGLIA SUPERATA! Generazione allarme critico.")
-		s.suspiciousClients[in.SourceClientId] = []time.Time{} // Resetta la storia
+	var alarms []*pb.Alarm
+	late := 0
+	for _, r := range matched {
+		cfg := r.window()
+		result, err := s.correlation.RecordAnomaly(correlationKey(r.ID, in.SourceClientId), at, cfg)
+		if err != nil {
+			log.Printf("ERROR: could not update correlation window: %v", err)
+			return &pb.AnalysisResponse{Processed: false, Message: "Failed to update correlation state"}, err
+		}
+		if result.Late {
+			log.Printf("WARNING: anomalia di %s per la regola '%s' in ritardo oltre il watermark (event time %s), esclusa dalla correlazione.", in.SourceClientId, r.ID, at.UTC().Format(time.RFC3339))
+			late++
+			continue
+		}
 
-		alarm := &pb.Alarm{
-			RuleId:        fmt.Sprintf("correlated_anomaly_by_%s", strings.ToLower(strings.ReplaceAll(analysisSource, " ", "_"))),
-			ClientId:      in.SourceClientId,
-			Description:   fmt.Sprintf("Correlated anomaly detected for client %s by %s", in.SourceClientId, analysisSource),
-			Timestamp:     time.Now().Unix(),
-			TriggerMetric: in,
+		log.Printf("[DEBUG] Stato client '%s', regola '%s': %d anomalie nella finestra %s.", in.SourceClientId, r.ID, result.Count, cfg.Type)
+		log.Printf("[DEBUG] Controllo soglia: %d (attuali) >= %d (soglia)?", result.Count, cfg.Threshold)
+		if result.Triggered {
+			log.Printf("[DEBUG] SOGLIA SUPERATA per la regola '%s'! Generazione allarme %s.", r.ID, r.Severity)
+			alarm := &pb.Alarm{
+				RuleId:        r.ID,
+				ClientId:      in.SourceClientId,
+				Description:   fmt.Sprintf("Correlated anomaly detected for client %s: %s", in.SourceClientId, r.Description),
+				Timestamp:     at.Unix(),
+				TriggerMetric: in,
+				Severity:      r.Severity,
+			}
+			if inference != nil {
+				// Il punteggio del modello permette di ordinare gli allarmi di qualunque regola
+				alarm.Score = &inference.Score
+				alarm.ModelName = inference.ModelName
+				alarm.ModelVersion = inference.ModelVersion
+			}
+			alarms = append(alarms, alarm)
 		}
-		storeCtx := ctx
-		if analysisSource == "Threshold (Fallback)" {
-			storeCtx = context.Background()
+	}
+
+	if len(alarms) == 0 {
+		if late == len(matched) {
+			return s.storeUncorrelated(storeCtx, in, "Late anomalous metric stored without correlation (beyond allowed lateness)")
 		}
-		_, err = s.storageClient.StoreAlarm(storeCtx, alarm)
-		if err != nil {
+		log.Printf("[DEBUG] Soglia NON superata. Salvo come metrica sospetta.")
+		return s.storeUncorrelated(storeCtx, in, "Suspicious metric recorded, alarm not triggered")
+	}
+
+	triggered := make([]string, 0, len(alarms))
+	for _, alarm := range alarms {
+		if err := s.storeAlarm(storeCtx, alarm); err != nil {
 			log.Printf("ERROR: could not store alarm: %v", err)
 			return &pb.AnalysisResponse{Processed: false, Message: "Failed to store alarm"}, err
 		}
-		return &pb.AnalysisResponse{Processed: true, Message: fmt.Sprintf("Correlated anomaly detected by %s and stored", analysisSource)}, nil
+		triggered = append(triggered, alarm.RuleId)
 	}
+	return &pb.AnalysisResponse{Processed: true, Message: fmt.Sprintf("Correlated anomaly detected by %s and stored", strings.Join(triggered, ", "))}, nil
+}
 
-	log.Printf("[DEBUG] Soglia NON superata. Salvo come metrica sospetta.")
-	storeCtx := ctx
-	if analysisSource == "Threshold (Fallback)" {
-		storeCtx = context.Background()
-	}
-	_, err = s.storageClient.StoreMetric(storeCtx, in)
-	if err != nil {
+// storeUncorrelated salva una metrica anomala che non ha generato un allarme.
+func (s *server) storeUncorrelated(ctx context.Context, in *pb.Metric, message string) (*pb.AnalysisResponse, error) {
+	if err := s.storeMetric(ctx, in); err != nil {
 		log.Printf("ERROR: could not store suspicious metric: %v", err)
 		return &pb.AnalysisResponse{Processed: false, Message: "Failed to store metric"}, err
 	}
-	return &pb.AnalysisResponse{Processed: true, Message: "Suspicious metric recorded, alarm not triggered"}, nil
+	return &pb.AnalysisResponse{Processed: true, Message: message}, nil
+}
+
+// AnalyzeMetrics analizza un batch di metriche inoltrato dal collector.
+// L'errore su una singola metrica non interrompe il batch: viene riportato
+// nell'esito corrispondente, così il collector può rispondere record per record.
+func (s *server) AnalyzeMetrics(ctx context.Context, in *pb.MetricBatch) (*pb.BatchAnalysisResponse, error) {
+	log.Printf("[DEBUG] Ricevuto batch di %d metriche.", len(in.Metrics))
+
+	// Le metriche del batch vanno analizzate in ordine per la correlazione, ma le loro predizioni
+	// possono essere richieste tutte subito e viaggiare insieme verso il servizio di inferenza.
+	pending := make([]<-chan predictionResult, len(in.Metrics))
+	if s.batcher != nil {
+		for i, metric := range in.Metrics {
+			if len(metric.Features) == features.NumFeatures {
+				pending[i] = s.batcher.submit(ctx, metric.Features)
+			}
+		}
+	}
+
+	results := make([]*pb.AnalysisResponse, 0, len(in.Metrics))
+	for i, metric := range in.Metrics {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := s.analyzeMetric(ctx, metric, pending[i])
+		if err != nil {
+			resp = &pb.AnalysisResponse{Processed: false, Message: err.Error()}
+		}
+		results = append(results, resp)
+	}
+	return &pb.BatchAnalysisResponse{Results: results}, nil
 }
 
 func main() {
@@ -176,6 +366,30 @@ func main() {
 	if err != nil {
 		log.Fatalf("Invalid FALLBACK_THRESHOLD: %v", err)
 	}
+	ringVirtualNodes, err = strconv.Atoi(getEnv("HASH_RING_VIRTUAL_NODES", strconv.Itoa(hashring.DefaultVirtualNodes)))
+	if err != nil || ringVirtualNodes <= 0 {
+		log.Fatalf("Invalid HASH_RING_VIRTUAL_NODES: %v", err)
+	}
+	windowKind, err = parseWindowType(getEnv("CORRELATION_WINDOW_TYPE", "sliding"))
+	if err != nil {
+		log.Fatalf("Invalid CORRELATION_WINDOW_TYPE: %v", err)
+	}
+	allowedLateness = getEnvSeconds("ALLOWED_LATENESS_SECONDS", "10")
+	maxClockSkew = getEnvSeconds("MAX_CLOCK_SKEW_SECONDS", "60")
+	maxEventAge = getEnvSeconds("MAX_EVENT_AGE_SECONDS", "86400")
+	switch policy := getEnv("SKEWED_TIMESTAMP_POLICY", "flag"); policy {
+	case "flag":
+		rejectSkewed = false
+	case "reject":
+		rejectSkewed = true
+	default:
+		log.Fatalf("Invalid SKEWED_TIMESTAMP_POLICY: %s (valori ammessi: flag, reject)", policy)
+	}
+	scoreBands, err = parseSeverityBands(getEnv("ML_SEVERITY_BANDS", defaultSeverityBands))
+	if err != nil {
+		log.Fatalf("Invalid ML_SEVERITY_BANDS: %v", err)
+	}
+	log.Printf("Correlazione in event time: finestra %s di %s, soglia %d, lateness %s.", windowKind, timeWindow, anomalyThreshold, allowedLateness)
 
 	consulAddr := getEnv("CONSUL_ADDR", "localhost:8500")
 	jaegerAddr := getEnv("JAEGER_ADDR", "localhost:4317")
@@ -203,38 +417,119 @@ func main() {
 		},
 	}
 	cb := gobreaker.NewCircuitBreaker(st)
+	// mTLS verso collector, storage, inferenza e le altre istanze (senza file i collegamenti restano in chiaro)
+	tlsFiles, err := mtls.Load(mtls.Files{
+		CertFile: getEnv("TLS_CERT_FILE", ""),
+		KeyFile:  getEnv("TLS_KEY_FILE", ""),
+		CAFile:   getEnv("TLS_CA_FILE", ""),
+	})
+	if err != nil {
+		log.Fatalf("FALLIMENTO CRITICO: Impossibile caricare il materiale TLS: %v", err)
+	}
+	tlsReloadStr := getEnv("TLS_RELOAD_SECONDS", "30")
+	tlsReload, err := strconv.Atoi(tlsReloadStr)
+	if err != nil || tlsReload < 0 {
+		log.Fatalf("Invalid TLS_RELOAD_SECONDS: %s", tlsReloadStr)
+	}
+	go tlsFiles.Watch(context.Background(), time.Duration(tlsReload)*time.Second)
+	checkTLSName := ""
+	if tlsFiles.Enabled() {
+		checkTLSName = serviceName
+	}
 	log.Println("Registrazione a Consul...")
 	serviceID := fmt.Sprintf("%s-%s", serviceName, os.Getenv("HOSTNAME"))
-	consulClient := consul.RegisterService(consulAddr, serviceName, serviceID, port)
-	defer consul.DeregisterService(consulClient, serviceID)
+	consulClient := consul.RegisterService(consulAddr, serviceName, serviceID, port, checkTLSName)
+	// La deregistrazione può avvenire sia allo spegnimento controllato che all'uscita da main
+	deregister := sync.OnceFunc(func() { consul.DeregisterService(consulClient, serviceID) })
+	defer deregister()
 	log.Println("Registrato a Consul con successo.")
-	storageSvcAddr, err := consul.DiscoverService(consulClient, storageServiceName)
-	if err != nil {
-		log.Fatalf("FALLIMENTO CRITICO: Impossibile fare discovery di %s: %v", storageServiceName, err)
+	var correlationStore CorrelationStore
+	switch storeType := getEnv("CORRELATION_STORE", "memory"); storeType {
+	case "memory":
+		correlationStore = newMemoryCorrelationStore()
+	case "consul":
+		correlationStore = newConsulCorrelationStore(consulClient, getEnv("CORRELATION_KV_PREFIX", "ids/correlation/"))
+	default:
+		log.Fatalf("Invalid CORRELATION_STORE: %s (valori ammessi: memory, consul)", storeType)
 	}
-	storageConn, err := grpc.Dial(storageSvcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock(), grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()))
+	log.Printf("Store di correlazione: %s", getEnv("CORRELATION_STORE", "memory"))
+
+	// Le regole di rilevamento vengono ricaricate a caldo quando i file cambiano
+	rules, err := newRuleEngine(getEnv("RULES_PATH", ""))
 	if err != nil {
-		log.Fatalf("FALLIMENTO CRITICO: Impossibile connettersi a %s: %v", storageServiceName, err)
+		log.Fatalf("FALLIMENTO CRITICO: Impossibile caricare le regole: %v", err)
 	}
-	defer storageConn.Close()
-	storageClient := pb.NewStorageClient(storageConn)
-	log.Printf("Connesso a %s.", storageServiceName)
-	inferenceSvcAddr, err := consul.DiscoverService(consulClient, inferenceServiceName)
+	log.Printf("Regole di rilevamento: %s", rules.rules().describe())
+
+	baselineFeatures, err := parseBaselineFeatures(getEnv("BASELINE_FEATURES", strings.Join(defaultBaselineFeatures, ",")))
 	if err != nil {
-		log.Fatalf("FALLIMENTO CRITICO: Impossibile fare discovery di %s: %v", inferenceServiceName, err)
+		log.Fatalf("Invalid BASELINE_FEATURES: %v", err)
 	}
-	inferenceConn, err := grpc.Dial(inferenceSvcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock(), grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()))
-	if err != nil {
-		log.Fatalf("FALLIMENTO CRITICO: Impossibile connettersi a %s: %v", inferenceServiceName, err)
+	baselineAlpha, err := strconv.ParseFloat(getEnv("BASELINE_ALPHA", "0.05"), 64)
+	if err != nil || baselineAlpha <= 0 || baselineAlpha > 1 {
+		log.Fatalf("Invalid BASELINE_ALPHA: %v", getEnv("BASELINE_ALPHA", "0.05"))
+	}
+	baselineZ, err := strconv.ParseFloat(getEnv("BASELINE_Z_THRESHOLD", "4"), 64)
+	if err != nil || baselineZ <= 0 {
+		log.Fatalf("Invalid BASELINE_Z_THRESHOLD: %v", getEnv("BASELINE_Z_THRESHOLD", "4"))
 	}
+	baselineMinSamples, err := strconv.Atoi(getEnv("BASELINE_MIN_SAMPLES", "30"))
+	if err != nil || baselineMinSamples <= 0 {
+		log.Fatalf("Invalid BASELINE_MIN_SAMPLES: %v", getEnv("BASELINE_MIN_SAMPLES", "30"))
+	}
+	baseline := newBaselineDetector(baselineConfig{
+		Features:   baselineFeatures,
+		Alpha:      baselineAlpha,
+		ZThreshold: baselineZ,
+		MinSamples: baselineMinSamples,
+	})
+	log.Printf("Baseline statistica per la modalità degradata: %d feature, alpha %g, soglia z %g, %d campioni minimi.", len(baselineFeatures), baselineAlpha, baselineZ, baselineMinSamples)
+
+	// Il modello esportato è il primo fallback quando il servizio di inferenza non risponde;
+	// senza file si passa direttamente alla baseline statistica e alla soglia.
+	var localModel *iforest.Model
+	if path := getEnv("LOCAL_MODEL_PATH", ""); path != "" {
+		localModel, err = iforest.Load(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			log.Printf("WARNING: modello locale %s assente, fallback solo statistico.", path)
+		case err != nil:
+			log.Fatalf("FALLIMENTO CRITICO: Impossibile caricare il modello locale %s: %v", path, err)
+		case localModel.VocabularyVersion != "" && localModel.VocabularyVersion != features.Default().Version:
+			log.Fatalf("FALLIMENTO CRITICO: il modello locale usa il vocabolario %s, le metriche il %s", localModel.VocabularyVersion, features.Default().Version)
+		default:
+			log.Printf("Modello locale per il fallback: %s %s (%d alberi).", localModel.Name, localModel.Version, len(localModel.Trees))
+		}
+	}
+
+	// I watcher mantengono aggiornate in background le istanze sane di storage e inferenza:
+	// all'avvio attendiamo la prima istanza disponibile invece di interrogare Consul in un ciclo di retry,
+	// poi le connessioni seguono i cambi di membership e si spostano se l'istanza viene deregistrata.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	storageWatcher := consul.NewServiceWatcher(consulClient, storageServiceName)
+	storageEvents := storageWatcher.Subscribe()
+	storageWatcher.Start(watchCtx)
+	inferenceWatcher := consul.NewServiceWatcher(consulClient, inferenceServiceName)
+	inferenceEvents := inferenceWatcher.Subscribe()
+	inferenceWatcher.Start(watchCtx)
+
+	discoveryCtx, cancelDiscovery := context.WithTimeout(watchCtx, discoveryTimeout)
+	defer cancelDiscovery()
+	storageConn := connectDependency(discoveryCtx, storageWatcher, storageServiceName, tlsFiles.ClientCredentials(storageServiceName))
+	defer storageConn.Close()
+	go storageConn.follow(storageEvents)
+	storageClient := pb.NewStorageClient(storageConn)
+	inferenceConn := connectDependency(discoveryCtx, inferenceWatcher, inferenceServiceName, tlsFiles.ClientCredentials(inferenceServiceName))
 	defer inferenceConn.Close()
+	go inferenceConn.follow(inferenceEvents)
 	inferenceClient := pb.NewInferenceClient(inferenceConn)
-	log.Printf("Connesso a %s.", inferenceServiceName)
 	lis, err := net.Listen("tcp", ":"+portStr)
 	if err != nil {
 		log.Fatalf("FALLIMENTO CRITICO: failed to listen: %v", err)
 	}
 	s := grpc.NewServer(
+		grpc.Creds(tlsFiles.ServerCredentials()),
 		grpc.UnaryInterceptor(
 			tracing.NewConditionalUnaryInterceptor(
 				otelgrpc.UnaryServerInterceptor(),
@@ -244,13 +539,82 @@ func main() {
 	)
 
 	serverInstance := &server{
-		storageClient:     storageClient,
-		inferenceClient:   inferenceClient,
-		circuitBreaker:    cb,
-		suspiciousClients: make(map[string][]time.Time),
-		mu:                sync.Mutex{},
+		storageClient:   storageClient,
+		inferenceClient: inferenceClient,
+		circuitBreaker:  cb,
+		correlation:     correlationStore,
+		rules:           rules,
+		baseline:        baseline,
+		localModel:      localModel,
+		seen:            newDedupWindow(),
+	}
+	// Con INFERENCE_BATCH_SIZE=1 ogni metrica usa una Predict separata
+	batchSize, err := strconv.Atoi(getEnv("INFERENCE_BATCH_SIZE", "32"))
+	if err != nil || batchSize <= 0 {
+		log.Fatalf("Invalid INFERENCE_BATCH_SIZE: %v", getEnv("INFERENCE_BATCH_SIZE", "32"))
+	}
+	batchWaitMs, err := strconv.Atoi(getEnv("INFERENCE_BATCH_MAX_WAIT_MS", "5"))
+	if err != nil || batchWaitMs < 0 {
+		log.Fatalf("Invalid INFERENCE_BATCH_MAX_WAIT_MS: %v", getEnv("INFERENCE_BATCH_MAX_WAIT_MS", "5"))
+	}
+	if batchSize > 1 {
+		serverInstance.batcher = newInferenceBatcher(inferenceClient, cb, batchSize, time.Duration(batchWaitMs)*time.Millisecond)
+		go serverInstance.batcher.run(watchCtx)
+		log.Printf("Micro-batching dell'inferenza: fino a %d metriche, attesa massima %dms.", batchSize, batchWaitMs)
+	}
+	// Le decisioni passano dall'outbox locale prima di essere confermate al collector
+	outboxWorkers, err := strconv.Atoi(getEnv("OUTBOX_WORKERS", "4"))
+	if err != nil || outboxWorkers <= 0 {
+		log.Fatalf("Invalid OUTBOX_WORKERS: %v", getEnv("OUTBOX_WORKERS", "4"))
+	}
+	outbox, err := openOutbox(getEnv("OUTBOX_PATH", "outbox.db"), storageClient, outboxWorkers)
+	if err != nil {
+		log.Fatalf("FALLIMENTO CRITICO: Impossibile aprire l'outbox: %v", err)
 	}
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	forwarded := make(chan struct{})
+	go func() {
+		outbox.run(outboxCtx)
+		close(forwarded)
+	}()
+	defer func() {
+		stopOutbox()
+		<-forwarded
+		outbox.Close()
+	}()
+	serverInstance.outbox = outbox
+	log.Printf("Outbox verso lo storage: %d decisioni in attesa di consegna, %d forwarder.", outbox.pending(), outboxWorkers)
 	pb.RegisterAnalysisServiceServer(s, serverInstance)
+	go rules.watch(watchCtx, getEnvSeconds("RULES_RELOAD_INTERVAL_SECONDS", "10"))
+
+	// L'handoff segue le istanze di analisi registrate su Consul e cede lo stato di correlazione
+	// dei client che, a ogni cambio di membership, passano a un'altra istanza.
+	// Con uno store condiviso (Consul KV) lo stato è già visibile a tutte le istanze e l'handoff non serve.
+	hostname, _ := os.Hostname()
+	handoff := newStateHandoff(serverInstance, fmt.Sprintf("%s:%d", hostname, port), tlsFiles.ClientCredentials(serviceName))
+	analysisWatcher := consul.NewServiceWatcher(consulClient, serviceName)
+	analysisEvents := analysisWatcher.Subscribe()
+	analysisWatcher.Start(watchCtx)
+	if !correlationStore.Shared() {
+		go handoff.run(watchCtx, analysisEvents)
+	}
+
+	// Allo spegnimento ci deregistriamo prima di cedere lo stato, così il collector smette di inviarci
+	// nuovi client mentre le finestre vengono trasferite alle altre istanze.
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		log.Println("Richiesta di spegnimento ricevuta...")
+		deregister()
+		if !correlationStore.Shared() {
+			log.Println("Cessione dello stato di correlazione alle altre istanze...")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*handoffTimeout)
+			handoff.handOffAll(shutdownCtx)
+			cancel()
+		}
+		s.GracefulStop()
+	}()
 
 	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
 	log.Printf("Analysis service in ascolto su %v", lis.Addr())
@@ -265,3 +629,26 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// newDedupWindow crea la finestra dei metric_id già analizzati (DEDUP_WINDOW_SECONDS=0 la disattiva).
+func newDedupWindow() *dedup.Window {
+	window := getEnvSeconds("DEDUP_WINDOW_SECONDS", "300")
+	maxIDs, err := strconv.Atoi(getEnv("DEDUP_MAX_IDS", "100000"))
+	if err != nil || maxIDs <= 0 {
+		log.Fatalf("Invalid DEDUP_MAX_IDS: %v", getEnv("DEDUP_MAX_IDS", "100000"))
+	}
+	if window == 0 {
+		return nil
+	}
+	log.Printf("Deduplica dei metric_id: finestra %s, fino a %d ID.", window, maxIDs)
+	return dedup.NewWindow(window, maxIDs)
+}
+
+// getEnvSeconds legge una durata espressa in secondi interi non negativi.
+func getEnvSeconds(key, fallback string) time.Duration {
+	seconds, err := strconv.Atoi(getEnv(key, fallback))
+	if err != nil || seconds < 0 {
+		log.Fatalf("Invalid %s: %v", key, getEnv(key, fallback))
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+)
+
+// storageRoundTrip simula la latenza di una chiamata gRPC verso lo storage.
+const storageRoundTrip = time.Millisecond
+
+// slowStorageClient simula uno storage remoto: ogni scrittura costa un round-trip.
+type slowStorageClient struct {
+	pb.StorageClient
+	metrics atomic.Int64
+	alarms  atomic.Int64
+}
+
+func (m *slowStorageClient) StoreMetric(ctx context.Context, in *pb.Metric, opts ...grpc.CallOption) (*pb.StorageResponse, error) {
+	time.Sleep(storageRoundTrip)
+	m.metrics.Add(1)
+	return &pb.StorageResponse{Success: true}, nil
+}
+
+func (m *slowStorageClient) StoreAlarm(ctx context.Context, in *pb.Alarm, opts ...grpc.CallOption) (*pb.StorageResponse, error) {
+	time.Sleep(storageRoundTrip)
+	m.alarms.Add(1)
+	return &pb.StorageResponse{Success: true}, nil
+}
+
+// runConcurrentClients esegue b.N analisi distribuite su clients goroutine, una per client,
+// e riporta il throughput in metriche al secondo.
+func runConcurrentClients(b *testing.B, s *server, clients int) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(clientID string) {
+			defer wg.Done()
+			metric := &pb.Metric{SourceClientId: clientID, Features: make([]float32, 41)}
+			for next.Add(1) <= int64(b.N) {
+				if _, err := s.AnalyzeMetric(context.Background(), metric); err != nil {
+					b.Errorf("Errore inatteso: %v", err)
+					return
+				}
+			}
+		}(fmt.Sprintf("client-%d", c))
+	}
+	wg.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "metrics/s")
+}
+
+// BenchmarkAnalyzeMetric_ConcurrentClients misura il throughput del percorso di correlazione
+// con metriche tutte anomale e uno storage lento. Poiché nessun lock è tenuto durante le
+// chiamate allo storage, il throughput deve crescere quasi linearmente con i client.
+func BenchmarkAnalyzeMetric_ConcurrentClients(b *testing.B) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	anomalyThreshold = 5
+	timeWindow = time.Minute
+
+	for _, clients := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			s := &server{
+				storageClient:   &slowStorageClient{},
+				inferenceClient: &mockInferenceClient{prediction: -1},
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newMemoryCorrelationStore(),
+			}
+			runConcurrentClients(b, s, clients)
+		})
+	}
+}
+
+// BenchmarkMemoryCorrelationStore_RecordAnomaly confronta lo store con un solo lock
+// e quello a shard sotto contesa, con ogni goroutine che registra anomalie per un proprio client.
+func BenchmarkMemoryCorrelationStore_RecordAnomaly(b *testing.B) {
+	for _, shards := range []int{1, correlationShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			store := newShardedMemoryCorrelationStore(shards)
+			var id atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				clientID := fmt.Sprintf("client-%d", id.Add(1))
+				for pb.Next() {
+					store.RecordAnomaly(clientID, time.Now(), windowConfig{Size: time.Minute, Threshold: 100})
+				}
+			})
+		})
+	}
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"errors"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/dedup"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/iforest"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
 	"github.com/sony/gobreaker"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // --- Mock e Strutture di Supporto (Corretti) ---
@@ -34,6 +38,7 @@ func (m *mockStorageClient) StoreAlarm(ctx context.Context, in *pb.Alarm, opts .
 type mockInferenceClient struct {
 	pb.InferenceClient
 	prediction int32
+	score      float64
 }
 
 // CORREZIONE: La risposta del mock non contiene più il campo 'Label'
@@ -41,128 +46,360 @@ func (m *mockInferenceClient) Predict(ctx context.Context, in *pb.InferenceReque
 	if m.prediction == 0 {
 		return nil, errors.New("simulated inference failure")
 	}
-	// Restituisce solo il campo 'prediction', come fa il vero servizio Python
-	return &pb.InferenceResponse{Prediction: m.prediction}, nil
+	// Restituisce predizione e punteggio, come fa il vero servizio Python
+	return &pb.InferenceResponse{Prediction: m.prediction, Score: m.score, ModelName: "isolation_forest", ModelVersion: "test"}, nil
 }
 
 // --- TEST AGGIORNATI ---
 
 func TestAnalyzeMetric_NormalMetric(t *testing.T) {
-	// Setup
-	mockStore := &mockStorageClient{}
-	// CORREZIONE: Il mock non ha più bisogno del campo 'label'
-	mockInference := &mockInferenceClient{prediction: 1} // 1 = Normale
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			mockStore := &mockStorageClient{}
+			// CORREZIONE: Il mock non ha più bisogno del campo 'label'
+			mockInference := &mockInferenceClient{prediction: 1} // 1 = Normale
 
-	analysisServer := &server{
-		storageClient:     mockStore,
-		inferenceClient:   mockInference,
-		circuitBreaker:    gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
-		suspiciousClients: make(map[string][]time.Time),
-		mu:                sync.Mutex{},
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: mockInference,
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newStore(),
+			}
+
+			normalMetric := &pb.Metric{Features: make([]float32, 41)}
+
+			// Esecuzione
+			_, err := analysisServer.AnalyzeMetric(context.Background(), normalMetric)
+
+			// Verifica
+			if err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if mockStore.storeMetricCalledCount != 1 {
+				t.Errorf("StoreMetric doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+			}
+			if mockStore.storeAlarmCalledCount != 0 {
+				t.Errorf("StoreAlarm NON doveva essere chiamato")
+			}
+		})
 	}
+}
 
-	normalMetric := &pb.Metric{Features: make([]float32, 41)}
+func TestAnalyzeMetric_TriggersAlarm_AfterThreshold(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// --- SETUP: Impostiamo le variabili globali usate dalla funzione ---
+			anomalyThreshold = 3
+			timeWindow = 1 * time.Minute
 
-	// Esecuzione
-	_, err := analysisServer.AnalyzeMetric(context.Background(), normalMetric)
+			// Setup dei mock
+			mockStore := &mockStorageClient{}
+			mockInference := &mockInferenceClient{prediction: -1} // -1 = Anomalia
 
-	// Verifica
-	if err != nil {
-		t.Fatalf("Errore inatteso: %v", err)
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: mockInference,
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newStore(),
+			}
+
+			anomalousMetric := &pb.Metric{SourceClientId: "test-client", Features: make([]float32, 41)}
+
+			// Esecuzione
+			for i := 1; i <= 3; i++ {
+				_, err := analysisServer.AnalyzeMetric(context.Background(), anomalousMetric)
+				if err != nil {
+					t.Fatalf("Errore inatteso alla chiamata %d: %v", i, err)
+				}
+			}
+
+			// Verifica
+			if mockStore.storeMetricCalledCount != 2 {
+				t.Errorf("StoreMetric doveva essere chiamato 2 volte per le metriche sospette, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+			}
+			if mockStore.storeAlarmCalledCount != 1 {
+				t.Errorf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+			}
+		})
 	}
-	if mockStore.storeMetricCalledCount != 1 {
-		t.Errorf("StoreMetric doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+}
+
+func TestAnalyzeMetric_Fallback_TriggersAlarm_AfterThreshold(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// --- SETUP: Impostiamo le variabili globali ---
+			anomalyThreshold = 3
+			timeWindow = 1 * time.Minute
+			fallbackThreshold = 95.0
+
+			// Setup dei mock
+			mockStore := &mockStorageClient{}
+			mockInference := &mockInferenceClient{prediction: 0} // 0 = Fallimento
+
+			cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{})
+			for i := 0; i < 10; i++ {
+				_, _ = cb.Execute(func() (interface{}, error) { return nil, errors.New("fail") })
+			}
+			if cb.State() != gobreaker.StateOpen {
+				t.Fatalf("Il Circuit Breaker non è aperto")
+			}
+
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: mockInference,
+				circuitBreaker:  cb,
+				correlation:     newStore(),
+			}
+
+			metricForFallback := &pb.Metric{SourceClientId: "test-client", Features: make([]float32, 41)}
+			// NOTA: Il test precedente non impostava 'Value', ora lo facciamo per coerenza
+			// anche se il codice attuale lo prende da Features[4]
+			metricForFallback.Value = 100.0
+			metricForFallback.Features[4] = 100.0
+
+			// Esecuzione
+			for i := 1; i <= 3; i++ {
+				_, err := analysisServer.AnalyzeMetric(context.Background(), metricForFallback)
+				if err != nil {
+					t.Fatalf("Errore inatteso alla chiamata %d: %v", i, err)
+				}
+			}
+
+			// Verifica
+			if mockStore.storeMetricCalledCount != 2 {
+				t.Errorf("StoreMetric doveva essere chiamato 2 volte, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+			}
+			if mockStore.storeAlarmCalledCount != 1 {
+				t.Errorf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+			}
+			expectedRuleId := fallbackRuleID
+			if mockStore.lastAlarm.RuleId != expectedRuleId {
+				t.Errorf("L'allarme doveva avere RuleId '%s', ma ha '%s'", expectedRuleId, mockStore.lastAlarm.RuleId)
+			}
+		})
 	}
-	if mockStore.storeAlarmCalledCount != 0 {
-		t.Errorf("StoreAlarm NON doveva essere chiamato")
+}
+
+func TestAnalyzeMetrics_Batch_ReportsPerRecordResults(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			mockStore := &mockStorageClient{}
+			mockInference := &mockInferenceClient{prediction: 1} // 1 = Normale
+
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: mockInference,
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newStore(),
+			}
+
+			batch := &pb.MetricBatch{Metrics: []*pb.Metric{
+				{SourceClientId: "client-a", Features: make([]float32, 41)},
+				{SourceClientId: "client-b", Features: make([]float32, 10)}, // Feature incomplete
+				{SourceClientId: "client-c", Features: make([]float32, 41)},
+			}}
+
+			// Esecuzione
+			resp, err := analysisServer.AnalyzeMetrics(context.Background(), batch)
+
+			// Verifica
+			if err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if len(resp.Results) != len(batch.Metrics) {
+				t.Fatalf("Attesi %d esiti, ricevuti %d", len(batch.Metrics), len(resp.Results))
+			}
+			if resp.Results[1].Processed || !strings.Contains(resp.Results[1].Message, "expected 41 features, got 10") {
+				t.Errorf("Il secondo record doveva essere rifiutato per feature incomplete, esito %+v", resp.Results[1])
+			}
+			if mockStore.storeMetricCalledCount != 2 {
+				t.Errorf("StoreMetric doveva essere chiamato 2 volte, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+			}
+		})
 	}
 }
 
-func TestAnalyzeMetric_TriggersAlarm_AfterThreshold(t *testing.T) {
-	// --- SETUP: Impostiamo le variabili globali usate dalla funzione ---
-	anomalyThreshold = 3
-	timeWindow = 1 * time.Minute
+func TestAnalyzeMetric_CorrelatesReplayedMetricsByEventTime(t *testing.T) {
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			anomalyThreshold = 3
+			timeWindow = 1 * time.Minute
+			windowKind = slidingWindow
+			allowedLateness = 0
+			maxEventAge = 0
 
-	// Setup dei mock
-	mockStore := &mockStorageClient{}
-	mockInference := &mockInferenceClient{prediction: -1} // -1 = Anomalia
+			mockStore := &mockStorageClient{}
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: &mockInferenceClient{prediction: -1},
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newStore(),
+			}
 
-	analysisServer := &server{
-		storageClient:     mockStore,
-		inferenceClient:   mockInference,
-		circuitBreaker:    gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
-		suspiciousClients: make(map[string][]time.Time),
-		mu:                sync.Mutex{},
+			// Record di un'ora fa riprodotti tutti insieme: arrivano a pochi istanti l'uno dall'altro
+			// ma nell'event time distano due minuti, quindi non vanno correlati
+			replayStart := time.Now().Add(-time.Hour).Unix()
+			for i := int64(0); i < 3; i++ {
+				metric := &pb.Metric{SourceClientId: "replayed", Timestamp: replayStart + i*120, Features: make([]float32, 41)}
+				if _, err := analysisServer.AnalyzeMetric(context.Background(), metric); err != nil {
+					t.Fatalf("Errore inatteso: %v", err)
+				}
+			}
+			if mockStore.storeAlarmCalledCount != 0 {
+				t.Fatalf("Anomalie distanti due minuti nell'event time non dovevano generare allarmi")
+			}
+
+			// Tre anomalie entro un minuto di event time generano l'allarme, datato all'event time
+			for i := int64(1); i <= 3; i++ {
+				metric := &pb.Metric{SourceClientId: "replayed", Timestamp: replayStart + 300 + i*10, Features: make([]float32, 41)}
+				if _, err := analysisServer.AnalyzeMetric(context.Background(), metric); err != nil {
+					t.Fatalf("Errore inatteso: %v", err)
+				}
+			}
+			if mockStore.storeAlarmCalledCount != 1 {
+				t.Fatalf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+			}
+			if mockStore.lastAlarm.Timestamp != replayStart+330 {
+				t.Errorf("L'allarme doveva avere l'event time della metrica che lo ha generato")
+			}
+		})
 	}
+}
 
-	anomalousMetric := &pb.Metric{SourceClientId: "test-client", Features: make([]float32, 41)}
+func TestAnalyzeMetric_DuplicateMetricIDCountsOnce(t *testing.T) {
+	anomalyThreshold = 2
+	timeWindow = time.Minute
+	windowKind = slidingWindow
+	allowedLateness = 0
+	mockStore := &mockStorageClient{}
+	analysisServer := &server{
+		storageClient:   mockStore,
+		inferenceClient: &mockInferenceClient{prediction: -1},
+		circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		correlation:     newMemoryCorrelationStore(),
+		seen:            dedup.NewWindow(time.Minute, 100),
+	}
 
-	// Esecuzione
-	for i := 1; i <= 3; i++ {
-		_, err := analysisServer.AnalyzeMetric(context.Background(), anomalousMetric)
-		if err != nil {
-			t.Fatalf("Errore inatteso alla chiamata %d: %v", i, err)
+	// Il sensore ritenta la stessa metrica dopo un timeout: la ripetizione non è una nuova anomalia
+	metric := &pb.Metric{MetricId: "m1", SourceClientId: "retrying", Features: make([]float32, 41)}
+	for i := 0; i < 3; i++ {
+		resp, err := analysisServer.AnalyzeMetric(context.Background(), metric)
+		if err != nil || !resp.Processed {
+			t.Fatalf("Tentativo %d: errore inatteso %v", i+1, err)
 		}
 	}
+	if mockStore.storeAlarmCalledCount != 0 || mockStore.storeMetricCalledCount != 1 {
+		t.Fatalf("Le ripetizioni dovevano essere ignorate (metriche %d, allarmi %d)", mockStore.storeMetricCalledCount, mockStore.storeAlarmCalledCount)
+	}
 
-	// Verifica
-	if mockStore.storeMetricCalledCount != 2 {
-		t.Errorf("StoreMetric doveva essere chiamato 2 volte per le metriche sospette, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+	for _, id := range []string{"m2", "m3"} {
+		analysisServer.AnalyzeMetric(context.Background(), &pb.Metric{MetricId: id, SourceClientId: "retrying", Features: make([]float32, 41)})
 	}
 	if mockStore.storeAlarmCalledCount != 1 {
-		t.Errorf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+		t.Errorf("Tre metriche distinte dovevano generare l'allarme, allarmi %d", mockStore.storeAlarmCalledCount)
 	}
 }
 
-func TestAnalyzeMetric_Fallback_TriggersAlarm_AfterThreshold(t *testing.T) {
-	// --- SETUP: Impostiamo le variabili globali ---
-	anomalyThreshold = 3
+func TestAnalyzeMetric_SkewedTimestamp(t *testing.T) {
+	anomalyThreshold = 2
 	timeWindow = 1 * time.Minute
-	fallbackThreshold = 95.0
+	maxClockSkew = time.Minute
+	future := time.Now().Add(time.Hour).Unix()
 
-	// Setup dei mock
-	mockStore := &mockStorageClient{}
-	mockInference := &mockInferenceClient{prediction: 0} // 0 = Fallimento
+	t.Run("flag", func(t *testing.T) {
+		rejectSkewed = false
+		mockStore := &mockStorageClient{}
+		analysisServer := &server{
+			storageClient:   mockStore,
+			inferenceClient: &mockInferenceClient{prediction: -1},
+			circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+			correlation:     newMemoryCorrelationStore(),
+		}
+		for i := 0; i < 3; i++ {
+			metric := &pb.Metric{SourceClientId: "skewed", Timestamp: future, Features: make([]float32, 41)}
+			if _, err := analysisServer.AnalyzeMetric(context.Background(), metric); err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+		}
+		if mockStore.storeAlarmCalledCount != 0 || mockStore.storeMetricCalledCount != 3 {
+			t.Errorf("Le metriche con timestamp nel futuro dovevano essere salvate senza correlazione (metriche %d, allarmi %d)",
+				mockStore.storeMetricCalledCount, mockStore.storeAlarmCalledCount)
+		}
+	})
 
-	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{})
-	for i := 0; i < 10; i++ {
-		_, _ = cb.Execute(func() (interface{}, error) { return nil, errors.New("fail") })
+	t.Run("reject", func(t *testing.T) {
+		rejectSkewed = true
+		defer func() { rejectSkewed = false }()
+		mockStore := &mockStorageClient{}
+		analysisServer := &server{
+			storageClient:   mockStore,
+			inferenceClient: &mockInferenceClient{prediction: -1},
+			circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+			correlation:     newMemoryCorrelationStore(),
+		}
+		metric := &pb.Metric{SourceClientId: "skewed", Timestamp: future, Features: make([]float32, 41)}
+		_, err := analysisServer.AnalyzeMetric(context.Background(), metric)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Atteso errore InvalidArgument, ricevuto %v", err)
+		}
+		if mockStore.storeMetricCalledCount != 0 {
+			t.Errorf("Una metrica rifiutata non doveva essere salvata")
+		}
+	})
+}
+
+// localTestModel è una foresta di un albero che isola subito le metriche con src_bytes > 1000.
+const localTestModel = `{
+  "format": "ids-iforest/v1", "model_name": "local-test", "model_version": "1",
+  "n_features": 41, "max_samples": 256, "offset": -0.5,
+  "trees": [{
+    "children_left":  [1, -1, -1],
+    "children_right": [2, -1, -1],
+    "feature":        [4, -2, -2],
+    "threshold":      [1000, -2, -2],
+    "n_node_samples": [256, 255, 1]
+  }]
+}`
+
+func TestAnalyzeMetric_InferenceDown_UsesLocalModelBeforeThreshold(t *testing.T) {
+	anomalyThreshold = 1
+	timeWindow = time.Minute
+	fallbackThreshold = 95.0
+
+	model, err := iforest.Read(strings.NewReader(localTestModel))
+	if err != nil {
+		t.Fatalf("Modello di test non valido: %v", err)
 	}
-	if cb.State() != gobreaker.StateOpen {
-		t.Fatalf("Il Circuit Breaker non è aperto")
+	mockStore := &mockStorageClient{}
+	analysisServer := &server{
+		storageClient:   mockStore,
+		inferenceClient: &mockInferenceClient{prediction: 0}, // 0 = Fallimento
+		circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		correlation:     newMemoryCorrelationStore(),
+		localModel:      model,
 	}
 
-	analysisServer := &server{
-		storageClient:     mockStore,
-		inferenceClient:   mockInference,
-		circuitBreaker:    cb,
-		suspiciousClients: make(map[string][]time.Time),
-		mu:                sync.Mutex{},
-	}
-
-	metricForFallback := &pb.Metric{SourceClientId: "test-client", Features: make([]float32, 41)}
-	// NOTA: Il test precedente non impostava 'Value', ora lo facciamo per coerenza
-	// anche se il codice attuale lo prende da Features[4]
-	metricForFallback.Value = 100.0
-	metricForFallback.Features[4] = 100.0
-
-	// Esecuzione
-	for i := 1; i <= 3; i++ {
-		_, err := analysisServer.AnalyzeMetric(context.Background(), metricForFallback)
-		if err != nil {
-			t.Fatalf("Errore inatteso alla chiamata %d: %v", i, err)
-		}
+	// 500 byte superano la soglia storica ma per il modello sono normali
+	normal := &pb.Metric{SourceClientId: "client", Features: make([]float32, 41)}
+	normal.Features[4] = 500
+	if _, err := analysisServer.AnalyzeMetric(context.Background(), normal); err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if mockStore.storeAlarmCalledCount != 0 {
+		t.Fatalf("Con il modello locale la soglia storica non doveva essere applicata")
 	}
 
-	// Verifica
-	if mockStore.storeMetricCalledCount != 2 {
-		t.Errorf("StoreMetric doveva essere chiamato 2 volte, ma è stato chiamato %d volte", mockStore.storeMetricCalledCount)
+	anomalous := &pb.Metric{SourceClientId: "client", Features: make([]float32, 41)}
+	anomalous.Features[4] = 5000
+	if _, err := analysisServer.AnalyzeMetric(context.Background(), anomalous); err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
 	}
-	if mockStore.storeAlarmCalledCount != 1 {
-		t.Errorf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+	if mockStore.storeAlarmCalledCount != 1 || mockStore.lastAlarm.RuleId != mlRuleID {
+		t.Fatalf("Il modello locale doveva generare un allarme %s, allarmi: %d", mlRuleID, mockStore.storeAlarmCalledCount)
 	}
-	expectedRuleId := "correlated_anomaly_by_threshold_(fallback)"
-	if mockStore.lastAlarm.RuleId != expectedRuleId {
-		t.Errorf("L'allarme doveva avere RuleId '%s', ma ha '%s'", expectedRuleId, mockStore.lastAlarm.RuleId)
+	if mockStore.lastAlarm.ModelName != "local-test" || mockStore.lastAlarm.Score == nil {
+		t.Errorf("L'allarme doveva riportare il modello locale e il suo punteggio, ricevuto %+v", mockStore.lastAlarm)
 	}
 }
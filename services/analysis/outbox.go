@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Outbox transazionale verso lo storage: ogni decisione (metrica da salvare o allarme) viene scritta
+// in un file locale (bbolt) prima di rispondere al collector, e dei forwarder in background la
+// consegnano allo storage ritentando con backoff esponenziale finché non viene accettata.
+// Così un allarme già conteggiato nella finestra di correlazione non va perso se lo storage è
+// irraggiungibile per qualche istante, né se l'analisi si riavvia prima della consegna.
+//
+// Le scritture concorrenti sono raggruppate in un'unica transazione (bbolt Batch), così il costo
+// dell'fsync si divide tra le richieste in corso invece di pesare su ognuna. Gli elementi sono
+// ripartiti per client tra OUTBOX_WORKERS forwarder: ogni client è servito sempre dallo stesso, che
+// ne consegna gli elementi nell'ordine delle decisioni, mentre i client diversi procedono in parallelo.
+//
+// Ogni elemento ha una chiave di idempotenza, inviata nell'header idempotency-key: se la risposta
+// dello storage va persa e l'elemento viene consegnato di nuovo, lo storage lo riconosce e non lo
+// duplica. Gli elementi rifiutati dallo storage come non validi non vengono ritentati, ma spostati
+// in un bucket a parte per poterli esaminare.
+
+// idempotencyHeader è l'header gRPC con la chiave di idempotenza (letto dallo storage).
+const idempotencyHeader = "idempotency-key"
+
+// Tipi di elemento dell'outbox.
+const (
+	outboxMetric = "metric"
+	outboxAlarm  = "alarm"
+)
+
+const (
+	outboxMinBackoff      = 200 * time.Millisecond
+	outboxMaxBackoff      = 30 * time.Second
+	outboxDeliveryTimeout = 5 * time.Second
+	outboxScanSize        = 256 // Elementi letti dal disco per ogni scansione
+	outboxQueueSize       = 64  // Elementi affidati a un forwarder e non ancora consegnati
+)
+
+var (
+	outboxPendingBucket  = []byte("pending")
+	outboxRejectedBucket = []byte("rejected")
+)
+
+// outboxEntry è una decisione in attesa di essere consegnata allo storage.
+type outboxEntry struct {
+	Key       string    `json:"key"` // Chiave di idempotenza
+	Kind      string    `json:"kind"`
+	Partition string    `json:"partition,omitempty"` // Client della decisione: ne determina il forwarder
+	Payload   []byte    `json:"payload"`             // Messaggio protobuf serializzato
+	Created   time.Time `json:"created"`
+	// Valorizzato solo per gli elementi rifiutati dallo storage
+	Error string `json:"error,omitempty"`
+}
+
+// pendingEntry è un elemento letto dal bucket pending con la sua chiave.
+type pendingEntry struct {
+	key   []byte
+	entry *outboxEntry
+}
+
+type outbox struct {
+	db      *bolt.DB
+	storage pb.StorageClient
+	workers int           // Forwarder concorrenti
+	notify  chan struct{} // Sveglia la scansione quando arriva un nuovo elemento
+}
+
+func openOutbox(path string, storage pb.StorageClient, workers int) (*outbox, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open outbox %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{outboxPendingBucket, outboxRejectedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize outbox %s: %w", path, err)
+	}
+	return &outbox{db: db, storage: storage, workers: max(workers, 1), notify: make(chan struct{}, 1)}, nil
+}
+
+func (o *outbox) Close() error {
+	return o.db.Close()
+}
+
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key) // Non fallisce (vedi crypto/rand.Read)
+	return hex.EncodeToString(key)
+}
+
+// add scrive la decisione del client partition nell'outbox. Quando torna senza errori la decisione
+// è su disco; le chiamate concorrenti condividono la stessa transazione.
+func (o *outbox) add(kind, partition string, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(outboxEntry{Key: newIdempotencyKey(), Kind: kind, Partition: partition, Payload: payload, Created: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	// Batch può rieseguire la funzione se un'altra del gruppo fallisce: assegna allora una nuova sequenza
+	err = o.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxPendingBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		// Sequenza a larghezza fissa: il cursore restituisce gli elementi nell'ordine di inserimento
+		return b.Put([]byte(fmt.Sprintf("%020d", seq)), value)
+	})
+	if err != nil {
+		return fmt.Errorf("append to outbox: %w", err)
+	}
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending restituisce il numero di decisioni ancora da consegnare.
+func (o *outbox) pending() int {
+	n := 0
+	o.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(outboxPendingBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// scan restituisce fino a limit elementi da consegnare successivi alla chiave after (nil = dall'inizio)
+// e l'ultima chiave esaminata. Gli elementi illeggibili vengono spostati tra quelli rifiutati per non
+// bloccare i successivi.
+func (o *outbox) scan(after []byte, limit int) (entries []pendingEntry, last []byte, err error) {
+	unreadable := make(map[string][]byte)
+	err = o.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxPendingBucket).Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(entries) < limit; k, v = c.Next() {
+			last = bytes.Clone(k)
+			entry := &outboxEntry{}
+			if json.Unmarshal(v, entry) != nil {
+				unreadable[string(k)] = bytes.Clone(v)
+				continue
+			}
+			entries = append(entries, pendingEntry{key: last, entry: entry})
+		}
+		return nil
+	})
+	if err != nil || len(unreadable) == 0 {
+		return entries, last, err
+	}
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		for key, value := range unreadable {
+			log.Printf("ERROR: elemento dell'outbox %s illeggibile, spostato tra i rifiutati", key)
+			if err := tx.Bucket(outboxRejectedBucket).Put([]byte(key), value); err != nil {
+				return err
+			}
+			if err := tx.Bucket(outboxPendingBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return entries, last, err
+}
+
+// done rimuove l'elemento consegnato; se rejected non è nil lo conserva tra quelli rifiutati.
+// Come add, le chiamate concorrenti dei forwarder condividono la stessa transazione.
+func (o *outbox) done(key []byte, entry *outboxEntry, rejected error) error {
+	return o.db.Batch(func(tx *bolt.Tx) error {
+		if rejected != nil {
+			entry.Error = rejected.Error()
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(outboxRejectedBucket).Put(key, value); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(outboxPendingBucket).Delete(key)
+	})
+}
+
+// deliver invia l'elemento allo storage con la sua chiave di idempotenza.
+func (o *outbox) deliver(ctx context.Context, entry *outboxEntry) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, idempotencyHeader, entry.Key)
+	switch entry.Kind {
+	case outboxMetric:
+		metric := &pb.Metric{}
+		if err := proto.Unmarshal(entry.Payload, metric); err != nil {
+			return status.Errorf(codes.InvalidArgument, "decode metric: %v", err)
+		}
+		_, err := o.storage.StoreMetric(ctx, metric)
+		return err
+	case outboxAlarm:
+		alarm := &pb.Alarm{}
+		if err := proto.Unmarshal(entry.Payload, alarm); err != nil {
+			return status.Errorf(codes.InvalidArgument, "decode alarm: %v", err)
+		}
+		_, err := o.storage.StoreAlarm(ctx, alarm)
+		return err
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown outbox entry kind %q", entry.Kind)
+	}
+}
+
+// permanent indica gli errori per cui ritentare la consegna non serve: l'elemento stesso non è valido.
+func permanent(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// partition restituisce il forwarder, tra n, che consegna gli elementi del client.
+func partition(client string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(client))
+	return int(h.Sum32() % uint32(n))
+}
+
+// run consegna gli elementi finché ctx non termina. Ogni elemento viene affidato una sola volta al
+// forwarder del suo client, che lo ritenta finché non viene accettato o rifiutato dallo storage:
+// un elemento che non può essere consegnato blocca solo i successivi dei client dello stesso
+// forwarder, così lo storage riceve gli elementi di ogni client nell'ordine in cui sono state prese
+// le decisioni. La distribuzione non attende mai un forwarder con la coda piena (vedi dispatch).
+func (o *outbox) run(ctx context.Context) {
+	queues := make([]chan pendingEntry, o.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan pendingEntry, outboxQueueSize)
+		wg.Add(1)
+		go func(queue <-chan pendingEntry) {
+			defer wg.Done()
+			o.forward(ctx, queue)
+		}(queues[i])
+	}
+	defer wg.Wait()
+
+	var last []byte                       // Tutti gli elementi fino a last sono stati affidati; le chiavi sono crescenti
+	handed := make([][]byte, len(queues)) // Ultima chiave affidata a ciascun forwarder
+	backoff := outboxMinBackoff
+	for {
+		var full bool
+		var err error
+		last, full, err = o.dispatch(queues, last, handed)
+		if err != nil {
+			log.Printf("ERROR: lettura dell'outbox fallita: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, outboxMaxBackoff)
+			continue
+		}
+		backoff = outboxMinBackoff
+
+		// Con tutti gli elementi affidati si attende una nuova decisione; se qualche coda era piena
+		// si riprova anche dopo un breve intervallo, quando il forwarder avrà liberato spazio.
+		var retry <-chan time.Time
+		if full {
+			retry = time.After(outboxMinBackoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-retry:
+		}
+	}
+}
+
+// dispatch affida ai forwarder gli elementi successivi a last senza mai bloccarsi: quando la coda di
+// un forwarder è piena i suoi elementi restano su disco per la prossima passata, mentre quelli degli
+// altri forwarder proseguono. Un forwarder fermo su uno storage irraggiungibile non ritarda quindi
+// la consegna degli altri client. handed evita di affidare due volte gli elementi già in coda.
+// Restituisce la nuova chiave fino alla quale tutti gli elementi sono stati affidati e se qualche
+// coda era piena.
+func (o *outbox) dispatch(queues []chan pendingEntry, last []byte, handed [][]byte) ([]byte, bool, error) {
+	full := make([]bool, len(queues))
+	nFull := 0
+	after := last
+	// Quando tutte le code sono piene non serve leggere oltre
+	for nFull < len(queues) {
+		entries, scanned, err := o.scan(after, outboxScanSize)
+		if err != nil {
+			return last, nFull > 0, err
+		}
+		if scanned == nil {
+			break
+		}
+		for _, p := range entries {
+			i := partition(p.entry.Partition, len(queues))
+			if !full[i] && bytes.Compare(p.key, handed[i]) > 0 {
+				select {
+				case queues[i] <- p:
+					handed[i] = p.key
+				default:
+					// Gli elementi successivi dello stesso forwarder attendono la prossima passata per non perdere l'ordine
+					full[i] = true
+					nFull++
+				}
+			}
+			// last avanza solo finché nessuna coda è piena: la passata successiva riparte da lì
+			if nFull == 0 {
+				last = p.key
+			}
+		}
+		if nFull == 0 {
+			last = scanned
+		}
+		after = scanned
+	}
+	return last, nFull > 0, nil
+}
+
+// forward consegna in ordine gli elementi ricevuti dalla coda finché ctx non termina.
+func (o *outbox) forward(ctx context.Context, queue <-chan pendingEntry) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-queue:
+			if !o.forwardEntry(ctx, p.key, p.entry) {
+				return
+			}
+		}
+	}
+}
+
+// forwardEntry ritenta la consegna dell'elemento finché lo storage non lo accetta o rifiuta.
+// Restituisce false se ctx termina prima.
+func (o *outbox) forwardEntry(ctx context.Context, key []byte, entry *outboxEntry) bool {
+	backoff := outboxMinBackoff
+	for {
+		deliverCtx, cancel := context.WithTimeout(ctx, outboxDeliveryTimeout)
+		err := o.deliver(deliverCtx, entry)
+		cancel()
+		switch {
+		case err == nil:
+			err = o.done(key, entry, nil)
+		case permanent(err):
+			log.Printf("ERROR: lo storage ha rifiutato un elemento dell'outbox (%s %s), non verrà ritentato: %v", entry.Kind, entry.Key, err)
+			err = o.done(key, entry, err)
+		default:
+			log.Printf("WARNING: consegna allo storage fallita (%s %s), nuovo tentativo tra %s: %v", entry.Kind, entry.Key, backoff, err)
+		}
+		if err == nil {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, outboxMaxBackoff)
+	}
+}
+
+// storeMetric affida la metrica allo storage: tramite l'outbox se configurato, altrimenti direttamente.
+func (s *server) storeMetric(ctx context.Context, in *pb.Metric) error {
+	if s.outbox != nil {
+		return s.outbox.add(outboxMetric, in.SourceClientId, in)
+	}
+	_, err := s.storageClient.StoreMetric(ctx, in)
+	return err
+}
+
+// storeAlarm affida l'allarme allo storage: tramite l'outbox se configurato, altrimenti direttamente.
+func (s *server) storeAlarm(ctx context.Context, alarm *pb.Alarm) error {
+	if s.outbox != nil {
+		return s.outbox.add(outboxAlarm, alarm.ClientId, alarm)
+	}
+	_, err := s.storageClient.StoreAlarm(ctx, alarm)
+	return err
+}
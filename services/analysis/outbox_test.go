@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// flakyStorageClient simula uno storage che fallisce finché err non è nil (o sempre per gli allarmi
+// del client down) e registra le chiavi di idempotenza delle consegne riuscite.
+type flakyStorageClient struct {
+	pb.StorageClient
+	mu     sync.Mutex
+	err    error
+	down   string
+	keys   []string
+	alarms []*pb.Alarm
+}
+
+func (f *flakyStorageClient) store(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	f.keys = append(f.keys, md.Get(idempotencyHeader)...)
+	return nil
+}
+
+func (f *flakyStorageClient) StoreMetric(ctx context.Context, in *pb.Metric, opts ...grpc.CallOption) (*pb.StorageResponse, error) {
+	if err := f.store(ctx); err != nil {
+		return nil, err
+	}
+	return &pb.StorageResponse{Success: true}, nil
+}
+
+func (f *flakyStorageClient) StoreAlarm(ctx context.Context, in *pb.Alarm, opts ...grpc.CallOption) (*pb.StorageResponse, error) {
+	if in.ClientId != "" && in.ClientId == f.down {
+		return nil, status.Error(codes.Unavailable, "partition down")
+	}
+	if err := f.store(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.alarms = append(f.alarms, in)
+	f.mu.Unlock()
+	return &pb.StorageResponse{Success: true}, nil
+}
+
+func (f *flakyStorageClient) setError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+func (f *flakyStorageClient) delivered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.keys)
+}
+
+func openTestOutbox(t *testing.T, path string, storage pb.StorageClient) *outbox {
+	t.Helper()
+	o, err := openOutbox(path, storage, 4)
+	if err != nil {
+		t.Fatalf("Impossibile aprire l'outbox: %v", err)
+	}
+	return o
+}
+
+// runOutbox avvia il forwarder e restituisce la funzione che lo ferma e chiude l'outbox.
+func runOutbox(o *outbox) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+		o.Close()
+	}
+}
+
+func waitDelivered(t *testing.T, storage *flakyStorageClient, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if storage.delivered() >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Consegnati %d elementi allo storage, attesi %d", storage.delivered(), n)
+}
+
+func TestOutbox_AlarmSurvivesStorageOutage(t *testing.T) {
+	anomalyThreshold = 1
+	timeWindow = time.Minute
+	storage := &flakyStorageClient{err: status.Error(codes.Unavailable, "storage down")}
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), storage)
+	stop := runOutbox(o)
+	defer stop()
+	analysisServer := &server{
+		storageClient:   storage,
+		inferenceClient: &mockInferenceClient{prediction: -1, score: -0.2},
+		circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		correlation:     newMemoryCorrelationStore(),
+		outbox:          o,
+	}
+
+	// La finestra scatta e viene azzerata mentre lo storage è giù: l'allarme resta nell'outbox
+	metric := &pb.Metric{SourceClientId: "outage", Features: make([]float32, 41)}
+	resp, err := analysisServer.AnalyzeMetric(context.Background(), metric)
+	if err != nil || !resp.Processed {
+		t.Fatalf("Con l'outbox la decisione doveva essere accettata anche con lo storage giù: %v", err)
+	}
+	if o.pending() != 1 {
+		t.Fatalf("L'allarme doveva essere in attesa nell'outbox")
+	}
+
+	storage.setError(nil)
+	waitDelivered(t, storage, 1)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.alarms) != 1 || storage.alarms[0].ClientId != "outage" {
+		t.Fatalf("L'allarme doveva essere consegnato una volta, consegnati %d", len(storage.alarms))
+	}
+	if storage.keys[0] == "" {
+		t.Errorf("La consegna doveva riportare la chiave di idempotenza")
+	}
+}
+
+func TestOutbox_PendingEntriesSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	storage := &flakyStorageClient{err: errors.New("connection refused")}
+	o := openTestOutbox(t, path, storage)
+	o.add(outboxAlarm, "c", &pb.Alarm{ClientId: "c", RuleId: "r1"})
+	o.add(outboxMetric, "c", &pb.Metric{SourceClientId: "c"})
+	o.Close()
+
+	storage.setError(nil)
+	reopened := openTestOutbox(t, path, storage)
+	if n := reopened.pending(); n != 2 {
+		t.Fatalf("Dopo il riavvio attesi 2 elementi in attesa, trovati %d", n)
+	}
+	stop := runOutbox(reopened)
+	defer stop()
+	waitDelivered(t, storage, 2)
+	if storage.keys[0] == storage.keys[1] {
+		t.Errorf("Ogni elemento doveva avere una chiave di idempotenza distinta")
+	}
+}
+
+func TestOutbox_RejectedEntryDoesNotBlockQueue(t *testing.T) {
+	storage := &flakyStorageClient{err: status.Error(codes.InvalidArgument, "bad alarm")}
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), storage)
+	o.add(outboxAlarm, "c", &pb.Alarm{ClientId: "c", RuleId: "invalid"})
+
+	entries, _, err := o.scan(nil, 1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Elemento atteso nell'outbox: %v", err)
+	}
+	err = o.deliver(context.Background(), entries[0].entry)
+	if !permanent(err) {
+		t.Fatalf("InvalidArgument doveva essere un errore permanente: %v", err)
+	}
+	o.done(entries[0].key, entries[0].entry, err)
+
+	storage.setError(nil)
+	o.add(outboxAlarm, "c", &pb.Alarm{ClientId: "c", RuleId: "valid"})
+	stop := runOutbox(o)
+	defer stop()
+	waitDelivered(t, storage, 1)
+	if storage.alarms[0].RuleId != "valid" {
+		t.Errorf("Dopo l'elemento rifiutato doveva essere consegnato il successivo")
+	}
+}
+
+func TestOutbox_ConcurrentAddsAreAllPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	o := openTestOutbox(t, path, &flakyStorageClient{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client := fmt.Sprintf("client-%d", i%10)
+			if err := o.add(outboxMetric, client, &pb.Metric{SourceClientId: client}); err != nil {
+				t.Errorf("add fallita: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	o.Close()
+
+	reopened := openTestOutbox(t, path, &flakyStorageClient{})
+	defer reopened.Close()
+	if n := reopened.pending(); n != 100 {
+		t.Fatalf("Attesi 100 elementi su disco, trovati %d", n)
+	}
+}
+
+func TestOutbox_PreservesPerClientOrder(t *testing.T) {
+	storage := &flakyStorageClient{}
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), storage)
+	for i := 0; i < 60; i++ {
+		client := fmt.Sprintf("client-%d", i%6)
+		o.add(outboxAlarm, client, &pb.Alarm{ClientId: client, Timestamp: int64(i)})
+	}
+	stop := runOutbox(o)
+	defer stop()
+	waitDelivered(t, storage, 60)
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	last := make(map[string]int64)
+	for _, alarm := range storage.alarms {
+		if previous, ok := last[alarm.ClientId]; ok && alarm.Timestamp < previous {
+			t.Fatalf("Allarmi di %s consegnati fuori ordine: %d dopo %d", alarm.ClientId, alarm.Timestamp, previous)
+		}
+		last[alarm.ClientId] = alarm.Timestamp
+	}
+}
+
+func TestOutbox_UndeliverableClientDoesNotBlockOthers(t *testing.T) {
+	storage := &flakyStorageClient{}
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), storage)
+	// Due client serviti da forwarder diversi: il primo non può essere consegnato
+	blocked, other := "client-0", ""
+	for i := 1; other == ""; i++ {
+		if c := fmt.Sprintf("client-%d", i); partition(c, o.workers) != partition(blocked, o.workers) {
+			other = c
+		}
+	}
+	storage.down = blocked
+	o.add(outboxAlarm, blocked, &pb.Alarm{ClientId: blocked})
+	o.add(outboxAlarm, other, &pb.Alarm{ClientId: other})
+
+	stop := runOutbox(o)
+	defer stop()
+	waitDelivered(t, storage, 1)
+	if storage.alarms[0].ClientId != other {
+		t.Errorf("Doveva essere consegnato l'allarme di %s, consegnato quello di %s", other, storage.alarms[0].ClientId)
+	}
+	// La rimozione dell'elemento consegnato segue la consegna di qualche millisecondo (bbolt Batch)
+	deadline := time.Now().Add(time.Second)
+	for o.pending() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := o.pending(); n != 1 {
+		t.Errorf("L'allarme di %s doveva restare in attesa, elementi in attesa: %d", blocked, n)
+	}
+}
+
+func TestOutbox_FullQueueDoesNotStallOtherClients(t *testing.T) {
+	storage := &flakyStorageClient{}
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), storage)
+	blocked, other := "client-0", ""
+	for i := 1; other == ""; i++ {
+		if c := fmt.Sprintf("client-%d", i); partition(c, o.workers) != partition(blocked, o.workers) {
+			other = c
+		}
+	}
+	// Il client bloccato riempie la coda del suo forwarder; l'allarme dell'altro client arriva dopo
+	storage.down = blocked
+	for i := 0; i < outboxQueueSize+10; i++ {
+		o.add(outboxAlarm, blocked, &pb.Alarm{ClientId: blocked, Timestamp: int64(i)})
+	}
+	o.add(outboxAlarm, other, &pb.Alarm{ClientId: other})
+
+	stop := runOutbox(o)
+	defer stop()
+	waitDelivered(t, storage, 1)
+
+	// Anche le decisioni successive dell'altro client proseguono mentre la coda resta piena
+	o.add(outboxAlarm, other, &pb.Alarm{ClientId: other, Timestamp: 1})
+	waitDelivered(t, storage, 2)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	for _, alarm := range storage.alarms {
+		if alarm.ClientId != other {
+			t.Errorf("Consegnato un allarme del client bloccato %s", alarm.ClientId)
+		}
+	}
+}
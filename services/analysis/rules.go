@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleVariables associa ogni nome utilizzabile nelle espressioni alla sua posizione
+// nel vettore costruito da ruleInputs: le 41 feature dello schema seguite da value.
+var ruleVariables = func() map[string]int {
+	vars := make(map[string]int, features.NumFeatures+1)
+	for i, name := range features.Names {
+		vars[name] = i
+	}
+	vars["value"] = features.NumFeatures
+	return vars
+}()
+
+// ruleInputs prepara i valori delle variabili delle espressioni per una metrica.
+func ruleInputs(in *pb.Metric) []float64 {
+	inputs := make([]float64, features.NumFeatures+1)
+	for i, f := range in.Features {
+		if i < features.NumFeatures {
+			inputs[i] = float64(f)
+		}
+	}
+	inputs[features.NumFeatures] = in.Value
+	return inputs
+}
+
+const (
+	// mlRuleID identifica le anomalie segnalate dal modello di inferenza.
+	mlRuleID = "ml_model"
+	// fallbackRuleID è la soglia storica, usata come ultima risorsa quando l'inferenza non è
+	// disponibile, la baseline del client non è ancora pronta e nessuna regola di fallback è definita.
+	fallbackRuleID = "threshold_fallback"
+)
+
+var (
+	ruleIDPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+	ruleSeverities   = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+	ruleFileSuffixes = []string{".yaml", ".yml"}
+)
+
+// ruleFile è il formato YAML di un file di regole:
+//
+//	rules:
+//	  - id: syn_flood
+//	    description: Molte connessioni con errori SYN
+//	    severity: high
+//	    expression: serror_rate > 0.8 && count > 100
+//	    mode: always            # always (default) oppure fallback: solo se l'inferenza non è disponibile
+//	    correlation:            # opzionale, i campi mancanti usano la configurazione globale
+//	      threshold: 3
+//	      window_seconds: 60
+//	      window_type: sliding
+//	      allowed_lateness_seconds: 10
+type ruleFile struct {
+	Rules []ruleSpec `yaml:"rules"`
+}
+
+type ruleSpec struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	Severity    string `yaml:"severity"`
+	Expression  string `yaml:"expression"`
+	Mode        string `yaml:"mode"`
+	Enabled     *bool  `yaml:"enabled"`
+	Correlation struct {
+		Threshold              int    `yaml:"threshold"`
+		WindowSeconds          int    `yaml:"window_seconds"`
+		WindowType             string `yaml:"window_type"`
+		AllowedLatenessSeconds *int   `yaml:"allowed_lateness_seconds"`
+	} `yaml:"correlation"`
+}
+
+// rule è una regola di rilevamento pronta per la valutazione.
+type rule struct {
+	ID          string
+	Description string
+	Severity    string
+	// expr è nil per la regola del modello ML, che non valuta feature ma la predizione.
+	expr *compiledExpr
+	// fallbackOnly indica che la regola si valuta solo quando l'inferenza non è disponibile.
+	fallbackOnly bool
+
+	// Impostazioni di correlazione proprie della regola; i valori nulli usano quelle globali.
+	threshold       int
+	size            time.Duration
+	windowKind      *windowType
+	allowedLateness *time.Duration
+}
+
+// matches valuta la regola sui valori preparati da ruleInputs.
+func (r *rule) matches(inputs []float64) bool {
+	return r.expr != nil && r.expr.eval(inputs)
+}
+
+// window restituisce la configurazione di correlazione della regola.
+func (r *rule) window() windowConfig {
+	cfg := correlationWindow()
+	if r.threshold > 0 {
+		cfg.Threshold = r.threshold
+	}
+	if r.size > 0 {
+		cfg.Size = r.size
+	}
+	if r.windowKind != nil {
+		cfg.Type = *r.windowKind
+	}
+	if r.allowedLateness != nil {
+		cfg.AllowedLateness = *r.allowedLateness
+	}
+	return cfg
+}
+
+// mlRule rappresenta le anomalie segnalate dal modello, correlate con la configurazione globale.
+var mlRule = &rule{ID: mlRuleID, Description: "Anomaly detected by the ML model", Severity: "high"}
+
+// builtinFallbackRule riproduce la soglia storica usata quando l'inferenza non è disponibile:
+// si confronta value, o src_bytes se value non è valorizzato.
+func builtinFallbackRule(threshold float64) *rule {
+	expr, err := compileExpr(fmt.Sprintf("(value != 0 && value > %g) || (value == 0 && src_bytes > %g)", threshold, threshold), ruleVariables)
+	if err != nil {
+		panic(err) // L'espressione è costante: un errore qui è un bug
+	}
+	return &rule{
+		ID:           fallbackRuleID,
+		Description:  fmt.Sprintf("Threshold %g exceeded while inference is unavailable", threshold),
+		Severity:     "medium",
+		expr:         expr,
+		fallbackOnly: true,
+	}
+}
+
+// compileRule valida una regola letta da file.
+func compileRule(spec ruleSpec) (*rule, error) {
+	if !ruleIDPattern.MatchString(spec.ID) {
+		return nil, fmt.Errorf("invalid rule id %q (lettere minuscole, cifre, '_', '.', '-')", spec.ID)
+	}
+	if spec.ID == mlRuleID {
+		return nil, fmt.Errorf("rule id %q is reserved for the ML model", spec.ID)
+	}
+	r := &rule{ID: spec.ID, Description: spec.Description, Severity: strings.ToLower(spec.Severity)}
+	if r.Severity == "" {
+		r.Severity = "medium"
+	}
+	if !ruleSeverities[r.Severity] {
+		return nil, fmt.Errorf("rule %s: invalid severity %q (valori ammessi: low, medium, high, critical)", spec.ID, spec.Severity)
+	}
+	if r.Description == "" {
+		r.Description = spec.Expression
+	}
+	switch spec.Mode {
+	case "", "always":
+	case "fallback":
+		r.fallbackOnly = true
+	default:
+		return nil, fmt.Errorf("rule %s: invalid mode %q (valori ammessi: always, fallback)", spec.ID, spec.Mode)
+	}
+
+	expr, err := compileExpr(spec.Expression, ruleVariables)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: invalid expression: %w", spec.ID, err)
+	}
+	r.expr = expr
+
+	c := spec.Correlation
+	if c.Threshold < 0 || c.WindowSeconds < 0 {
+		return nil, fmt.Errorf("rule %s: correlation threshold and window must not be negative", spec.ID)
+	}
+	r.threshold = c.Threshold
+	r.size = time.Duration(c.WindowSeconds) * time.Second
+	if c.WindowType != "" {
+		kind, err := parseWindowType(c.WindowType)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", spec.ID, err)
+		}
+		r.windowKind = &kind
+	}
+	if c.AllowedLatenessSeconds != nil {
+		if *c.AllowedLatenessSeconds < 0 {
+			return nil, fmt.Errorf("rule %s: allowed lateness must not be negative", spec.ID)
+		}
+		lateness := time.Duration(*c.AllowedLatenessSeconds) * time.Second
+		r.allowedLateness = &lateness
+	}
+	return r, nil
+}
+
+// ruleSet è un insieme di regole caricate insieme; viene sostituito per intero a ogni reload.
+type ruleSet struct {
+	rules []*rule
+	// hasFallback indica se almeno una regola è definita per il funzionamento senza inferenza.
+	hasFallback bool
+	digest      [sha256.Size]byte // Impronta dei file da cui è stato caricato
+}
+
+// newRuleSet compila le regole indicate.
+func newRuleSet(specs []ruleSpec) (*ruleSet, error) {
+	set := &ruleSet{}
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.Enabled != nil && !*spec.Enabled {
+			continue
+		}
+		r, err := compileRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("duplicate rule id %q", r.ID)
+		}
+		seen[r.ID] = true
+		set.hasFallback = set.hasFallback || r.fallbackOnly
+		set.rules = append(set.rules, r)
+	}
+	return set, nil
+}
+
+// ruleEngine mantiene l'insieme di regole corrente e lo ricarica quando i file cambiano.
+type ruleEngine struct {
+	path    string
+	current atomic.Pointer[ruleSet]
+}
+
+// newRuleEngine carica le regole da path, un file YAML o una directory di file YAML.
+// Con path vuoto si usano solo le regole predefinite.
+func newRuleEngine(path string) (*ruleEngine, error) {
+	e := &ruleEngine{path: path}
+	set, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	e.current.Store(set)
+	return e, nil
+}
+
+// rules restituisce l'insieme di regole corrente. Un server senza motore di regole
+// (es. nei test) non ha regole.
+func (e *ruleEngine) rules() *ruleSet {
+	if e == nil {
+		return &ruleSet{}
+	}
+	return e.current.Load()
+}
+
+// ruleFiles elenca i file delle regole in ordine alfabetico.
+func (e *ruleEngine) ruleFiles() ([]string, error) {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{e.path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(e.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, suffix := range ruleFileSuffixes {
+			if !d.IsDir() && strings.HasSuffix(d.Name(), suffix) {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// load legge e compila tutti i file delle regole.
+func (e *ruleEngine) load() (*ruleSet, error) {
+	if e.path == "" {
+		return &ruleSet{}, nil
+	}
+	files, err := e.ruleFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rule files in %s: %w", e.path, err)
+	}
+
+	hash := sha256.New()
+	var specs []ruleSpec
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule file %s: %w", file, err)
+		}
+		hash.Write([]byte(file))
+		hash.Write(data)
+
+		var parsed ruleFile
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&parsed); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid rule file %s: %w", file, err)
+		}
+		specs = append(specs, parsed.Rules...)
+	}
+
+	set, err := newRuleSet(specs)
+	if err != nil {
+		return nil, err
+	}
+	copy(set.digest[:], hash.Sum(nil))
+	return set, nil
+}
+
+// reload ricarica le regole se i file sono cambiati. In caso di errore le regole correnti
+// restano attive, così un file scritto a metà o sbagliato non disattiva il rilevamento.
+func (e *ruleEngine) reload() (bool, error) {
+	set, err := e.load()
+	if err != nil {
+		return false, err
+	}
+	if set.digest == e.current.Load().digest {
+		return false, nil
+	}
+	e.current.Store(set)
+	return true, nil
+}
+
+// watch controlla periodicamente i file delle regole e li ricarica quando cambiano.
+// Un intervallo nullo disattiva il reload.
+func (e *ruleEngine) watch(ctx context.Context, interval time.Duration) {
+	if e.path == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := e.reload()
+			if err != nil {
+				log.Printf("ERROR: regole non ricaricate, restano attive le precedenti: %v", err)
+				continue
+			}
+			if changed {
+				log.Printf("Regole ricaricate da %s: %s", e.path, e.rules().describe())
+			}
+		}
+	}
+}
+
+// describe elenca gli ID delle regole attive, per i log.
+func (s *ruleSet) describe() string {
+	return fmt.Sprintf("%d regole [%s]", len(s.rules), ruleIDs(s.rules))
+}
+
+// ruleIDs elenca gli ID delle regole, per log e messaggi.
+func ruleIDs(rules []*rule) string {
+	ids := make([]string, len(rules))
+	for i, r := range rules {
+		ids[i] = r.ID
+	}
+	return strings.Join(ids, ", ")
+}
+
+// correlationKey è la chiave con cui lo store conserva la finestra di un client per una regola.
+// Gli ID delle regole non contengono '/', quindi la chiave si separa senza ambiguità.
+func correlationKey(ruleID, clientID string) string {
+	return ruleID + "/" + clientID
+}
+
+// ruleOfKey estrae la regola da una chiave di correlazione ("" per le chiavi senza regola).
+func ruleOfKey(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// windowFor restituisce la configurazione di correlazione della finestra con chiave key: quella
+// della regola che l'ha prodotta, o quella globale per il modello ML e le regole non più definite.
+func (s *ruleSet) windowFor(key string) windowConfig {
+	id := ruleOfKey(key)
+	for _, r := range s.rules {
+		if r.ID == id {
+			return r.window()
+		}
+	}
+	return correlationWindow()
+}
+
+// clientOfKey estrae il client da una chiave di correlazione: è il client, non la regola,
+// a determinare l'istanza proprietaria sull'anello.
+func clientOfKey(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
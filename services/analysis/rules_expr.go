@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Linguaggio delle espressioni delle regole: confronti e aritmetica su variabili numeriche
+// (le feature NSL-KDD per nome e il campo value della metrica), combinati con && || !.
+//
+//	serror_rate > 0.8 && count > 100
+//	(src_bytes + dst_bytes) / 1000 >= 50 || !(logged_in == 1)
+//
+// Le espressioni sono compilate al caricamento delle regole: un nome sconosciuto o un errore
+// di tipo (es. un numero usato come condizione) fanno fallire il caricamento, non la valutazione.
+
+// exprKind è il tipo di un'espressione: numerica o booleana.
+type exprKind int
+
+const (
+	numberExpr exprKind = iota
+	boolExpr
+)
+
+func (k exprKind) String() string {
+	if k == boolExpr {
+		return "boolean"
+	}
+	return "number"
+}
+
+// exprNode è un nodo dell'albero sintattico compilato. vars contiene i valori delle variabili
+// nell'ordine in cui sono state risolte in compilazione.
+type exprNode interface {
+	kind() exprKind
+	num(vars []float64) float64
+	truth(vars []float64) bool
+}
+
+type numberLit float64
+
+func (n numberLit) kind() exprKind        { return numberExpr }
+func (n numberLit) num([]float64) float64 { return float64(n) }
+func (n numberLit) truth([]float64) bool  { return n != 0 }
+
+// varRef è l'indice di una variabile in vars.
+type varRef int
+
+func (v varRef) kind() exprKind             { return numberExpr }
+func (v varRef) num(vars []float64) float64 { return vars[v] }
+func (v varRef) truth(vars []float64) bool  { return vars[v] != 0 }
+
+type notExpr struct{ operand exprNode }
+
+func (n notExpr) kind() exprKind             { return boolExpr }
+func (n notExpr) num(vars []float64) float64 { return boolToFloat(n.truth(vars)) }
+func (n notExpr) truth(vars []float64) bool  { return !n.operand.truth(vars) }
+
+type negExpr struct{ operand exprNode }
+
+func (n negExpr) kind() exprKind             { return numberExpr }
+func (n negExpr) num(vars []float64) float64 { return -n.operand.num(vars) }
+func (n negExpr) truth(vars []float64) bool  { return n.num(vars) != 0 }
+
+type binaryExpr struct {
+	op          string
+	left, right exprNode
+}
+
+func (b binaryExpr) kind() exprKind {
+	switch b.op {
+	case "+", "-", "*", "/":
+		return numberExpr
+	}
+	return boolExpr
+}
+
+func (b binaryExpr) num(vars []float64) float64 {
+	switch b.op {
+	case "+":
+		return b.left.num(vars) + b.right.num(vars)
+	case "-":
+		return b.left.num(vars) - b.right.num(vars)
+	case "*":
+		return b.left.num(vars) * b.right.num(vars)
+	case "/":
+		divisor := b.right.num(vars)
+		if divisor == 0 {
+			return 0 // Le feature di rate possono essere nulle: evitiamo Inf e NaN
+		}
+		return b.left.num(vars) / divisor
+	}
+	return boolToFloat(b.truth(vars))
+}
+
+func (b binaryExpr) truth(vars []float64) bool {
+	switch b.op {
+	case "&&":
+		return b.left.truth(vars) && b.right.truth(vars)
+	case "||":
+		return b.left.truth(vars) || b.right.truth(vars)
+	case "<":
+		return b.left.num(vars) < b.right.num(vars)
+	case "<=":
+		return b.left.num(vars) <= b.right.num(vars)
+	case ">":
+		return b.left.num(vars) > b.right.num(vars)
+	case ">=":
+		return b.left.num(vars) >= b.right.num(vars)
+	case "==":
+		return b.left.num(vars) == b.right.num(vars)
+	case "!=":
+		return b.left.num(vars) != b.right.num(vars)
+	}
+	return b.num(vars) != 0
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compiledExpr è un'espressione booleana pronta per essere valutata.
+type compiledExpr struct {
+	source string
+	root   exprNode
+}
+
+// eval valuta l'espressione sui valori delle variabili, nelle posizioni indicate a compileExpr.
+func (e *compiledExpr) eval(vars []float64) bool {
+	return e.root.truth(vars)
+}
+
+// compileExpr compila un'espressione booleana. variables associa a ogni nome ammesso
+// la sua posizione nel vettore passato a eval.
+func compileExpr(source string, variables map[string]int) (*compiledExpr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, variables: variables}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != eofToken {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	if root.kind() != boolExpr {
+		return nil, fmt.Errorf("expression must be a condition, got a %s", root.kind())
+	}
+	return &compiledExpr{source: source, root: root}, nil
+}
+
+// --- Analisi lessicale ---
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	numberToken
+	identToken
+	opToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "+", "-", "*", "/", "!", "(", ")"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.' || source[i] == 'e' || source[i] == 'E' ||
+				((source[i] == '+' || source[i] == '-') && (source[i-1] == 'e' || source[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: numberToken, text: source[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: identToken, text: source[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: opToken, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: eofToken, pos: len(source)}), nil
+}
+
+// --- Analisi sintattica (discesa ricorsiva, dalla precedenza più bassa alla più alta) ---
+
+type exprParser struct {
+	tokens    []token
+	pos       int
+	variables map[string]int
+}
+
+func (p *exprParser) peek() token { return p.tokens[p.pos] }
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != eofToken {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consuma il prossimo token se è uno degli operatori indicati.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != opToken {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// binary costruisce un nodo binario verificando i tipi degli operandi.
+func binary(op string, left, right exprNode, operand exprKind) (exprNode, error) {
+	if left.kind() != operand || right.kind() != operand {
+		return nil, fmt.Errorf("operator %s needs %s operands", op, operand)
+	}
+	return binaryExpr{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			left, err = binary("||", left, right, boolExpr)
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	for err == nil {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		var right exprNode
+		if right, err = p.parseComparison(); err == nil {
+			left, err = binary("&&", left, right, boolExpr)
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("<=", ">=", "==", "!=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return binary(op, left, right, numberExpr)
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	for err == nil {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		var right exprNode
+		if right, err = p.parseProduct(); err == nil {
+			left, err = binary(op, left, right, numberExpr)
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		var right exprNode
+		if right, err = p.parseUnary(); err == nil {
+			left, err = binary(op, left, right, numberExpr)
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "!" {
+			if operand.kind() != boolExpr {
+				return nil, fmt.Errorf("operator ! needs a boolean operand")
+			}
+			return notExpr{operand}, nil
+		}
+		if operand.kind() != numberExpr {
+			return nil, fmt.Errorf("operator - needs a number operand")
+		}
+		return negExpr{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case numberToken:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return numberLit(value), nil
+	case identToken:
+		index, ok := p.variables[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %q at position %d", tok.text, tok.pos)
+		}
+		return varRef(index), nil
+	case opToken:
+		if tok.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ) at position %d", p.peek().pos)
+			}
+			return inner, nil
+		}
+	case eofToken:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
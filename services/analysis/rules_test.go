@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+)
+
+// metricWith crea una metrica completa con le feature indicate per nome.
+func metricWith(clientID string, values map[string]float32) *pb.Metric {
+	metric := &pb.Metric{SourceClientId: clientID, Features: make([]float32, features.NumFeatures)}
+	for name, value := range values {
+		metric.Features[ruleVariables[name]] = value
+	}
+	return metric
+}
+
+func TestCompileExpr_Evaluation(t *testing.T) {
+	metric := metricWith("c", map[string]float32{"serror_rate": 0.9, "count": 150, "src_bytes": 300, "dst_bytes": 700})
+	inputs := ruleInputs(metric)
+
+	cases := map[string]bool{
+		"serror_rate > 0.8 && count > 100":         true,
+		"serror_rate > 0.8 && count > 200":         false,
+		"serror_rate > 0.95 || count >= 150":       true,
+		"!(count == 150)":                          false,
+		"(src_bytes + dst_bytes) / 1000 >= 1":      true,
+		"src_bytes * 2 - dst_bytes < 0":            true,
+		"-count < -100 && logged_in == 0":          true,
+		"src_bytes / rerror_rate > 1":              false, // Divisione per zero vale 0
+		"value != 0 || serror_rate > 1.5e-1":       true,
+		"count > 100 && serror_rate > 0.5 || land": false,
+	}
+	for source, expected := range cases {
+		expr, err := compileExpr(source, ruleVariables)
+		if source == "count > 100 && serror_rate > 0.5 || land" {
+			if err == nil {
+				t.Errorf("%q: una feature numerica non può essere usata come condizione", source)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: errore di compilazione inatteso: %v", source, err)
+			continue
+		}
+		if got := expr.eval(inputs); got != expected {
+			t.Errorf("%q: atteso %v, ottenuto %v", source, expected, got)
+		}
+	}
+}
+
+func TestCompileExpr_Errors(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"count",               // Non è una condizione
+		"unknown_feature > 1", // Nome sconosciuto
+		"count > 1 &&",        // Espressione incompleta
+		"(count > 1",          // Parentesi non chiusa
+		"count > 1 > 2",       // Confronti non concatenabili
+		"!count",              // ! su un numero
+		"count > 1 && 2",      // && su un numero
+		"count > 1 ; drop",    // Carattere non ammesso
+		"serror_rate >> 0.5",  // Operatore non valido
+	} {
+		if _, err := compileExpr(source, ruleVariables); err == nil {
+			t.Errorf("%q doveva essere rifiutata", source)
+		}
+	}
+}
+
+func writeRules(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Impossibile scrivere il file delle regole: %v", err)
+	}
+}
+
+func TestRuleEngine_LoadsShippedRules(t *testing.T) {
+	engine, err := newRuleEngine("rules")
+	if err != nil {
+		t.Fatalf("Le regole distribuite con il servizio non sono valide: %v", err)
+	}
+	// In modalità degradata decide la baseline statistica: una regola di fallback distribuita
+	// di default disattiverebbe la soglia di ultima istanza per i client senza baseline
+	if engine.rules().hasFallback {
+		t.Errorf("Le regole distribuite non devono definire regole di fallback")
+	}
+}
+
+func TestRuleEngine_HotReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	writeRules(t, path, `
+rules:
+  - id: syn_flood
+    severity: high
+    expression: serror_rate > 0.8 && count > 100
+`)
+	engine, err := newRuleEngine(dir)
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	if changed, _ := engine.reload(); changed {
+		t.Errorf("Senza modifiche ai file il reload non deve sostituire le regole")
+	}
+
+	// Una modifica valida sostituisce le regole
+	writeRules(t, path, `
+rules:
+  - id: syn_flood
+    severity: critical
+    expression: serror_rate > 0.5
+`)
+	changed, err := engine.reload()
+	if err != nil || !changed {
+		t.Fatalf("Il reload doveva applicare le nuove regole (cambiate: %v, errore: %v)", changed, err)
+	}
+	if r := engine.rules().rules[0]; r.Severity != "critical" || r.expr.source != "serror_rate > 0.5" {
+		t.Errorf("Regola non aggiornata: %+v", r)
+	}
+
+	// Una modifica non valida viene scartata e restano attive le regole precedenti
+	writeRules(t, path, `
+rules:
+  - id: syn_flood
+    expression: serror_rate >
+`)
+	if _, err := engine.reload(); err == nil {
+		t.Fatalf("Il reload di una regola non valida doveva fallire")
+	}
+	if r := engine.rules().rules[0]; r.Severity != "critical" {
+		t.Errorf("Dopo un reload fallito dovevano restare attive le regole precedenti")
+	}
+}
+
+func TestRuleEngine_RejectsInvalidRules(t *testing.T) {
+	for name, content := range map[string]string{
+		"id duplicato":         "rules:\n  - {id: a, expression: count > 1}\n  - {id: a, expression: count > 2}\n",
+		"id riservato":         "rules:\n  - {id: ml_model, expression: count > 1}\n",
+		"id con slash":         "rules:\n  - {id: a/b, expression: count > 1}\n",
+		"severity sconosciuta": "rules:\n  - {id: a, severity: urgent, expression: count > 1}\n",
+		"finestra sconosciuta": "rules:\n  - {id: a, expression: count > 1, correlation: {window_type: hopping}}\n",
+		"campo sconosciuto":    "rules:\n  - {id: a, expresion: count > 1}\n",
+	} {
+		path := filepath.Join(t.TempDir(), "rules.yaml")
+		writeRules(t, path, content)
+		if _, err := newRuleEngine(path); err == nil {
+			t.Errorf("%s: il caricamento doveva fallire", name)
+		}
+	}
+}
+
+func TestAnalyzeMetric_RuleTriggersAlarmWithItsOwnSettings(t *testing.T) {
+	anomalyThreshold = 10 // La soglia globale non deve valere per la regola
+	timeWindow = 1 * time.Minute
+	windowKind = slidingWindow
+	allowedLateness = 0
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, `
+rules:
+  - id: syn_flood
+    severity: high
+    expression: serror_rate > 0.8 && count > 100
+    correlation:
+      threshold: 2
+`)
+	engine, err := newRuleEngine(path)
+	if err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+
+	for name, newStore := range correlationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			mockStore := &mockStorageClient{}
+			analysisServer := &server{
+				storageClient:   mockStore,
+				inferenceClient: &mockInferenceClient{prediction: 1}, // Il modello considera il traffico normale
+				circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+				correlation:     newStore(),
+				rules:           engine,
+			}
+
+			synFlood := metricWith("attacker", map[string]float32{"serror_rate": 1, "count": 200})
+			for i := 0; i < 2; i++ {
+				if _, err := analysisServer.AnalyzeMetric(context.Background(), synFlood); err != nil {
+					t.Fatalf("Errore inatteso: %v", err)
+				}
+			}
+			if mockStore.storeAlarmCalledCount != 1 {
+				t.Fatalf("StoreAlarm doveva essere chiamato 1 volta, ma è stato chiamato %d volte", mockStore.storeAlarmCalledCount)
+			}
+			if mockStore.lastAlarm.RuleId != "syn_flood" || mockStore.lastAlarm.Severity != "high" {
+				t.Errorf("L'allarme doveva riportare la regola e la sua gravità, ricevuto %s/%s", mockStore.lastAlarm.RuleId, mockStore.lastAlarm.Severity)
+			}
+
+			// Con l'inferenza disponibile la regola di fallback non viene valutata
+			heavy := metricWith("heavy", map[string]float32{"src_bytes": 1000})
+			if _, err := analysisServer.AnalyzeMetric(context.Background(), heavy); err != nil {
+				t.Fatalf("Errore inatteso: %v", err)
+			}
+			if mockStore.storeMetricCalledCount != 2 {
+				t.Errorf("Attese 2 metriche salvate (1 sospetta, 1 normale), salvate %d", mockStore.storeMetricCalledCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// severityBand assegna una gravità alle anomalie del modello con punteggio fino a MaxScore.
+// Il punteggio è la decision_function dell'Isolation Forest: più è basso, più la metrica è anomala.
+type severityBand struct {
+	Severity string
+	MaxScore float64
+}
+
+// defaultSeverityBands è la configurazione predefinita delle fasce di punteggio.
+const defaultSeverityBands = "critical:-0.15,high:-0.08,medium:-0.03"
+
+// parseSeverityBands legge un elenco "gravità:punteggio massimo" separato da virgole.
+// Le fasce vengono ordinate per punteggio crescente; un elenco vuoto disattiva le fasce.
+func parseSeverityBands(list string) ([]severityBand, error) {
+	var bands []severityBand
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		severity, bound, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("band %q must be in the form severity:max_score", item)
+		}
+		severity = strings.TrimSpace(severity)
+		if !ruleSeverities[severity] {
+			return nil, fmt.Errorf("unknown severity %q (valori ammessi: low, medium, high, critical)", severity)
+		}
+		maxScore, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score for band %q: %v", severity, err)
+		}
+		bands = append(bands, severityBand{Severity: severity, MaxScore: maxScore})
+	}
+	sort.Slice(bands, func(i, j int) bool { return bands[i].MaxScore < bands[j].MaxScore })
+	for i := 1; i < len(bands); i++ {
+		if bands[i].MaxScore == bands[i-1].MaxScore {
+			return nil, fmt.Errorf("bands %q and %q have the same max score", bands[i-1].Severity, bands[i].Severity)
+		}
+	}
+	return bands, nil
+}
+
+// severityForScore restituisce la gravità della fascia più bassa che contiene il punteggio.
+// Le anomalie oltre tutte le fasce sono low; senza fasce configurate ok è false.
+func severityForScore(bands []severityBand, score float64) (severity string, ok bool) {
+	if len(bands) == 0 {
+		return "", false
+	}
+	for _, band := range bands {
+		if score <= band.MaxScore {
+			return band.Severity, true
+		}
+	}
+	return "low", true
+}
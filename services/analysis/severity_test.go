@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/sony/gobreaker"
+)
+
+func TestSeverityBands_GradeScores(t *testing.T) {
+	// L'ordine nella configurazione non conta
+	bands, err := parseSeverityBands("medium:-0.03, critical:-0.15, high:-0.08")
+	if err != nil {
+		t.Fatalf("Fasce valide rifiutate: %v", err)
+	}
+	cases := map[float64]string{-0.3: "critical", -0.15: "critical", -0.1: "high", -0.05: "medium", -0.01: "low"}
+	for score, want := range cases {
+		if got, _ := severityForScore(bands, score); got != want {
+			t.Errorf("Punteggio %g: gravità %q, attesa %q", score, got, want)
+		}
+	}
+
+	for _, invalid := range []string{"urgent:-0.1", "high", "high:abc", "high:-0.1,critical:-0.1"} {
+		if _, err := parseSeverityBands(invalid); err == nil {
+			t.Errorf("La configurazione %q doveva essere rifiutata", invalid)
+		}
+	}
+	if _, ok := severityForScore(nil, -1); ok {
+		t.Errorf("Senza fasce la gravità del modello non deve essere modificata")
+	}
+}
+
+func TestAnalyzeMetric_MLAlarmCarriesScoreAndGradedSeverity(t *testing.T) {
+	anomalyThreshold = 1
+	timeWindow = time.Minute
+	bands, err := parseSeverityBands(defaultSeverityBands)
+	if err != nil {
+		t.Fatalf("Fasce predefinite non valide: %v", err)
+	}
+	scoreBands = bands
+	defer func() { scoreBands = nil }()
+
+	mockStore := &mockStorageClient{}
+	analysisServer := &server{
+		storageClient:   mockStore,
+		inferenceClient: &mockInferenceClient{prediction: -1, score: -0.2},
+		circuitBreaker:  gobreaker.NewCircuitBreaker(gobreaker.Settings{}),
+		correlation:     newMemoryCorrelationStore(),
+	}
+
+	if _, err := analysisServer.AnalyzeMetric(context.Background(), &pb.Metric{SourceClientId: "attacker", Features: make([]float32, 41)}); err != nil {
+		t.Fatalf("Errore inatteso: %v", err)
+	}
+	alarm := mockStore.lastAlarm
+	if alarm == nil || alarm.RuleId != mlRuleID {
+		t.Fatalf("Doveva essere generato un allarme del modello, ricevuto %+v", alarm)
+	}
+	if alarm.Severity != "critical" {
+		t.Errorf("Un punteggio di -0.2 doveva dare gravità critical, ricevuta %q", alarm.Severity)
+	}
+	if alarm.Score == nil || *alarm.Score != -0.2 || alarm.ModelName != "isolation_forest" || alarm.ModelVersion != "test" {
+		t.Errorf("L'allarme doveva riportare punteggio e modello, ricevuto score=%v model=%s@%s", alarm.Score, alarm.ModelName, alarm.ModelVersion)
+	}
+	if mlRule.Severity != "high" {
+		t.Errorf("La gravità della regola del modello condivisa non deve essere modificata, ora %q", mlRule.Severity)
+	}
+}
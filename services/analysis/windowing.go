@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// windowType è il tipo di finestra usato per correlare le anomalie di un client.
+type windowType int
+
+const (
+	// slidingWindow: allarme se in un qualsiasi intervallo lungo Size cadono almeno Threshold anomalie.
+	slidingWindow windowType = iota
+	// tumblingWindow: il tempo è diviso in intervalli fissi e disgiunti lunghi Size; le anomalie
+	// si contano solo all'interno dello stesso intervallo.
+	tumblingWindow
+	// sessionWindow: le anomalie distanti meno di Size l'una dall'altra formano una sessione;
+	// una pausa di almeno Size chiude la sessione.
+	sessionWindow
+)
+
+func parseWindowType(s string) (windowType, error) {
+	switch s {
+	case "sliding":
+		return slidingWindow, nil
+	case "tumbling":
+		return tumblingWindow, nil
+	case "session":
+		return sessionWindow, nil
+	}
+	return 0, fmt.Errorf("unknown window type %q (valori ammessi: sliding, tumbling, session)", s)
+}
+
+func (t windowType) String() string {
+	switch t {
+	case tumblingWindow:
+		return "tumbling"
+	case sessionWindow:
+		return "session"
+	}
+	return "sliding"
+}
+
+// windowConfig descrive come correlare le anomalie in event time.
+type windowConfig struct {
+	Type windowType
+	// Size è la durata della finestra (sliding, tumbling) o il gap di inattività che chiude una sessione.
+	Size      time.Duration
+	Threshold int
+	// AllowedLateness è il ritardo massimo, rispetto all'event time più recente del client,
+	// con cui un'anomalia fuori ordine viene ancora correlata.
+	AllowedLateness time.Duration
+}
+
+// clientWindow è lo stato di correlazione di un client.
+type clientWindow struct {
+	Timestamps   []time.Time // Event time delle anomalie ancora utili, in ordine crescente
+	MaxEventTime time.Time   // Event time più recente visto, da cui deriva il watermark
+}
+
+// windowResult è l'esito della registrazione di un'anomalia.
+type windowResult struct {
+	Count     int  // Anomalie nella finestra che contiene quella registrata
+	Triggered bool // Count ha raggiunto la soglia: le anomalie della finestra sono state consumate
+	Late      bool // L'anomalia è arrivata oltre il watermark e non è stata correlata
+}
+
+// watermark è l'event time sotto il quale le anomalie del client sono considerate in ritardo.
+func (c windowConfig) watermark(w clientWindow) time.Time {
+	if w.MaxEventTime.IsZero() {
+		return time.Time{}
+	}
+	return w.MaxEventTime.Add(-c.AllowedLateness)
+}
+
+// record inserisce nella finestra l'anomalia con event time at e restituisce il nuovo stato.
+// Lo stato ricevuto non viene modificato.
+func (c windowConfig) record(w clientWindow, at time.Time) (clientWindow, windowResult) {
+	if at.Before(c.watermark(w)) {
+		return w, windowResult{Late: true}
+	}
+
+	i := sort.Search(len(w.Timestamps), func(k int) bool { return w.Timestamps[k].After(at) })
+	timestamps := make([]time.Time, 0, len(w.Timestamps)+1)
+	timestamps = append(timestamps, w.Timestamps[:i]...)
+	timestamps = append(timestamps, at)
+	timestamps = append(timestamps, w.Timestamps[i:]...)
+
+	next := clientWindow{Timestamps: timestamps, MaxEventTime: w.MaxEventTime}
+	if at.After(next.MaxEventTime) {
+		next.MaxEventTime = at
+	}
+
+	lo, hi := c.span(timestamps, i)
+	result := windowResult{Count: hi - lo}
+	if result.Count >= c.Threshold {
+		// Le anomalie che hanno generato l'allarme non contano per i successivi
+		result.Triggered = true
+		next.Timestamps = append(timestamps[:lo:lo], timestamps[hi:]...)
+	}
+	return c.prune(next), result
+}
+
+// span restituisce gli estremi [lo, hi) delle anomalie che cadono nella stessa finestra
+// dell'anomalia in posizione i. Per le finestre sliding sceglie l'intervallo più popolato.
+func (c windowConfig) span(ts []time.Time, i int) (int, int) {
+	at := ts[i]
+	switch c.Type {
+	case tumblingWindow:
+		start := at.Truncate(c.Size)
+		end := start.Add(c.Size)
+		lo := sort.Search(len(ts), func(k int) bool { return !ts[k].Before(start) })
+		hi := sort.Search(len(ts), func(k int) bool { return !ts[k].Before(end) })
+		return lo, hi
+	case sessionWindow:
+		lo, hi := i, i+1
+		for lo > 0 && ts[lo].Sub(ts[lo-1]) < c.Size {
+			lo--
+		}
+		for hi < len(ts) && ts[hi].Sub(ts[hi-1]) < c.Size {
+			hi++
+		}
+		return lo, hi
+	default:
+		// Ogni intervallo [ts[lo], ts[lo]+Size) che contiene at è un candidato
+		bestLo, bestHi := i, i+1
+		for lo := i; lo >= 0 && at.Sub(ts[lo]) < c.Size; lo-- {
+			end := ts[lo].Add(c.Size)
+			hi := sort.Search(len(ts), func(k int) bool { return !ts[k].Before(end) })
+			if hi-lo > bestHi-bestLo {
+				bestLo, bestHi = lo, hi
+			}
+		}
+		return bestLo, bestHi
+	}
+}
+
+// prune scarta le anomalie che non possono più finire nella stessa finestra di un'anomalia
+// futura, dato che quelle oltre il watermark non vengono più correlate.
+func (c windowConfig) prune(w clientWindow) clientWindow {
+	watermark := c.watermark(w)
+	kept := make([]time.Time, 0, len(w.Timestamps))
+	switch c.Type {
+	case tumblingWindow:
+		for _, ts := range w.Timestamps {
+			if ts.Truncate(c.Size).Add(c.Size).After(watermark) {
+				kept = append(kept, ts)
+			}
+		}
+	case sessionWindow:
+		// Una sessione è chiusa se la sua ultima anomalia dista dal watermark almeno il gap
+		for lo := 0; lo < len(w.Timestamps); {
+			hi := lo + 1
+			for hi < len(w.Timestamps) && w.Timestamps[hi].Sub(w.Timestamps[hi-1]) < c.Size {
+				hi++
+			}
+			if watermark.Sub(w.Timestamps[hi-1]) < c.Size {
+				kept = append(kept, w.Timestamps[lo:hi]...)
+			}
+			lo = hi
+		}
+	default:
+		for _, ts := range w.Timestamps {
+			if watermark.Sub(ts) < c.Size {
+				kept = append(kept, ts)
+			}
+		}
+	}
+	w.Timestamps = kept
+	return w
+}
+
+// merge unisce allo stato esistente quello ricevuto da un'altra istanza. Gli istanti sono trattati
+// come multinsieme (per ogni istante si tiene la molteplicità massima tra i due), così reimportare
+// lo stesso stato non duplica le anomalie ma più anomalie nello stesso secondo non vanno perse.
+// Restituisce il nuovo stato e quante anomalie di incoming sono state effettivamente aggiunte.
+func (c windowConfig) merge(existing, incoming clientWindow) (clientWindow, int) {
+	counts := make(map[int64]int, len(existing.Timestamps))
+	for _, ts := range existing.Timestamps {
+		counts[ts.UnixNano()]++
+	}
+	merged := clientWindow{
+		Timestamps:   append([]time.Time(nil), existing.Timestamps...),
+		MaxEventTime: existing.MaxEventTime,
+	}
+	if incoming.MaxEventTime.After(merged.MaxEventTime) {
+		merged.MaxEventTime = incoming.MaxEventTime
+	}
+
+	added := 0
+	incomingCounts := make(map[int64]int, len(incoming.Timestamps))
+	for _, ts := range incoming.Timestamps {
+		incomingCounts[ts.UnixNano()]++
+		if incomingCounts[ts.UnixNano()] <= counts[ts.UnixNano()] {
+			continue
+		}
+		merged.Timestamps = append(merged.Timestamps, ts)
+		if ts.After(merged.MaxEventTime) {
+			merged.MaxEventTime = ts
+		}
+		added++
+	}
+	sort.Slice(merged.Timestamps, func(i, j int) bool { return merged.Timestamps[i].Before(merged.Timestamps[j]) })
+
+	// Conta come aggiunte solo le anomalie importate che restano dentro la finestra
+	pruned := c.prune(merged)
+	kept := c.prune(clientWindow{Timestamps: existing.Timestamps, MaxEventTime: merged.MaxEventTime})
+	if added > len(pruned.Timestamps)-len(kept.Timestamps) {
+		added = len(pruned.Timestamps) - len(kept.Timestamps)
+	}
+	if added < 0 {
+		added = 0
+	}
+	return pruned, added
+}
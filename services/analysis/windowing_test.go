@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// recordAll registra in ordine le anomalie agli istanti indicati (in secondi da base)
+// e restituisce l'esito di ciascuna.
+func recordAll(cfg windowConfig, base time.Time, seconds ...int) []windowResult {
+	var w clientWindow
+	results := make([]windowResult, len(seconds))
+	for i, sec := range seconds {
+		w, results[i] = cfg.record(w, base.Add(time.Duration(sec)*time.Second))
+	}
+	return results
+}
+
+func triggeredAt(results []windowResult) []int {
+	var idx []int
+	for i, r := range results {
+		if r.Triggered {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func TestWindow_SlidingCorrelatesOutOfOrderEvents(t *testing.T) {
+	cfg := windowConfig{Type: slidingWindow, Size: time.Minute, Threshold: 3, AllowedLateness: 30 * time.Second}
+	base := time.Unix(1_700_000_000, 0)
+
+	// La seconda anomalia arriva dopo la terza ma entro la lateness: le tre cadono in 60 secondi
+	results := recordAll(cfg, base, 0, 50, 20)
+	if got := triggeredAt(results); len(got) != 1 || got[0] != 2 {
+		t.Errorf("L'allarme doveva scattare alla terza anomalia, scattato a %v", got)
+	}
+}
+
+func TestWindow_LateEventsAreNotCorrelated(t *testing.T) {
+	cfg := windowConfig{Type: slidingWindow, Size: time.Minute, Threshold: 2, AllowedLateness: 10 * time.Second}
+	base := time.Unix(1_700_000_000, 0)
+
+	results := recordAll(cfg, base, 100, 80)
+	if !results[1].Late || results[1].Triggered {
+		t.Errorf("Un'anomalia 20s prima del watermark doveva essere segnalata in ritardo, esito %+v", results[1])
+	}
+}
+
+func TestWindow_TumblingDoesNotCrossBoundaries(t *testing.T) {
+	cfg := windowConfig{Type: tumblingWindow, Size: time.Minute, Threshold: 3}
+	base := time.Unix(1_700_000_000, 0).Truncate(time.Minute)
+
+	// 50s, 55s e 65s sono entro 60 secondi ma in due intervalli diversi
+	if got := triggeredAt(recordAll(cfg, base, 50, 55, 65)); len(got) != 0 {
+		t.Errorf("Anomalie in intervalli diversi non devono essere correlate, allarmi a %v", got)
+	}
+	if got := triggeredAt(recordAll(cfg, base, 60, 70, 119)); len(got) != 1 {
+		t.Errorf("Tre anomalie nello stesso intervallo dovevano generare un allarme, allarmi a %v", got)
+	}
+}
+
+func TestWindow_SessionExtendsWhileActive(t *testing.T) {
+	cfg := windowConfig{Type: sessionWindow, Size: 30 * time.Second, Threshold: 4}
+	base := time.Unix(1_700_000_000, 0)
+
+	// Anomalie ogni 20s: la sessione resta aperta oltre qualunque finestra fissa di 30s
+	if got := triggeredAt(recordAll(cfg, base, 0, 20, 40, 60)); len(got) != 1 || got[0] != 3 {
+		t.Errorf("La sessione doveva raggiungere la soglia alla quarta anomalia, allarmi a %v", got)
+	}
+	// Una pausa di 30s chiude la sessione e il conteggio riparte
+	if got := triggeredAt(recordAll(cfg, base, 0, 20, 40, 70)); len(got) != 0 {
+		t.Errorf("La pausa doveva chiudere la sessione, allarmi a %v", got)
+	}
+}
+
+func TestWindow_MergeIsIdempotentAndKeepsSameSecondEvents(t *testing.T) {
+	cfg := windowConfig{Type: slidingWindow, Size: time.Minute, Threshold: 10}
+	at := time.Unix(1_700_000_000, 0)
+	incoming := clientWindow{Timestamps: []time.Time{at, at}, MaxEventTime: at}
+
+	merged, added := cfg.merge(clientWindow{}, incoming)
+	if added != 2 || len(merged.Timestamps) != 2 {
+		t.Fatalf("Due anomalie nello stesso secondo dovevano essere importate entrambe, aggiunte %d", added)
+	}
+	merged, added = cfg.merge(merged, incoming)
+	if added != 0 || len(merged.Timestamps) != 2 {
+		t.Errorf("Reimportare lo stesso stato non deve duplicare le anomalie, aggiunte %d", added)
+	}
+}
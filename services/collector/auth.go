@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Autenticazione dei sensori: il SourceClientId di una metrica non è più dichiarato dal
+// sensore ma deve coincidere con l'identità autenticata della chiamata, ottenuta da
+//   - una API key emessa con cmd/sensor-admin ("x-api-key: <key>" o "authorization: ApiKey <key>");
+//   - un JWT HS256 emesso con cmd/sensor-admin, con sub = client ID ("authorization: Bearer <token>");
+//   - il Common Name del certificato client verificato dall'mTLS.
+// Un JWT è accettato solo se il suo jti è registrato nello store delle credenziali, per lo
+// stesso client e non revocato: il segreto di firma resta al collector e a sensor-admin, e un
+// token firmato ma mai emesso viene rifiutato. Le credenziali esplicite hanno la precedenza sul certificato, così un gateway con un
+// proprio certificato può inoltrare le metriche di sensori diversi con i loro token.
+
+// Metodi di autenticazione accettati (SENSOR_AUTH).
+const (
+	authAPIKey = "api-key"
+	authJWT    = "jwt"
+	authMTLS   = "mtls"
+)
+
+const (
+	authorizationHeader = "authorization"
+	apiKeyHeader        = "x-api-key"
+)
+
+// Chiamate rifiutate per motivo (missing_credentials, invalid_credentials, revoked, expired, identity_mismatch).
+var authFailures = expvar.NewMap("collector_auth_failures")
+
+type sensorAuth struct {
+	methods   map[string]bool
+	store     *sensorauth.Store
+	jwtSecret []byte
+}
+
+// newSensorAuth configura l'autenticazione con i metodi elencati (separati da virgola).
+// Un elenco senza metodi (es. ",") è un errore: per disattivare l'autenticazione SENSOR_AUTH
+// va lasciato vuoto, e il collector non la configura affatto.
+func newSensorAuth(methods string, store *sensorauth.Store, jwtSecret []byte) (*sensorAuth, error) {
+	a := &sensorAuth{methods: make(map[string]bool), store: store, jwtSecret: jwtSecret}
+	for _, m := range splitList(methods) {
+		switch m {
+		case authAPIKey, authMTLS:
+		case authJWT:
+			if len(jwtSecret) == 0 {
+				return nil, errors.New("jwt authentication requires SENSOR_JWT_SECRET_FILE")
+			}
+		default:
+			return nil, fmt.Errorf("unknown sensor authentication method %q (valori ammessi: %s, %s, %s)", m, authAPIKey, authJWT, authMTLS)
+		}
+		a.methods[m] = true
+	}
+	if len(a.methods) == 0 {
+		return nil, fmt.Errorf("no sensor authentication method in %q (valori ammessi: %s, %s, %s)", methods, authAPIKey, authJWT, authMTLS)
+	}
+	return a, nil
+}
+
+// authenticate restituisce l'identità del sensore che ha aperto la chiamata. Con
+// l'autenticazione disattivata l'identità è vuota e ogni SourceClientId è accettato.
+func (a *sensorAuth) authenticate(ctx context.Context) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(apiKeyHeader); len(keys) > 0 {
+		return a.apiKeyIdentity(keys[0])
+	}
+	if values := md.Get(authorizationHeader); len(values) > 0 {
+		scheme, credential, _ := strings.Cut(values[0], " ")
+		switch strings.ToLower(scheme) {
+		case "bearer":
+			return a.jwtIdentity(strings.TrimSpace(credential))
+		case "apikey":
+			return a.apiKeyIdentity(strings.TrimSpace(credential))
+		}
+		return "", authFailure("invalid_credentials", codes.Unauthenticated, "unsupported authorization scheme %q", scheme)
+	}
+	if a.methods[authMTLS] {
+		if identity := peerIdentity(ctx); identity != "" {
+			return identity, nil
+		}
+	}
+	return "", authFailure("missing_credentials", codes.Unauthenticated, "sensor credentials required (accepted: %s)", strings.Join(a.accepted(), ", "))
+}
+
+func (a *sensorAuth) apiKeyIdentity(key string) (string, error) {
+	if !a.methods[authAPIKey] {
+		return "", authFailure("invalid_credentials", codes.Unauthenticated, "API key authentication is not enabled")
+	}
+	cred, err := a.store.AuthenticateAPIKey(key)
+	if err != nil {
+		return "", credentialFailure(err)
+	}
+	return cred.ClientID, nil
+}
+
+func (a *sensorAuth) jwtIdentity(token string) (string, error) {
+	if !a.methods[authJWT] {
+		return "", authFailure("invalid_credentials", codes.Unauthenticated, "JWT authentication is not enabled")
+	}
+	claims, err := sensorauth.VerifyJWT(a.jwtSecret, token, time.Now())
+	if errors.Is(err, sensorauth.ErrTokenExpired) {
+		return "", credentialFailure(sensorauth.ErrExpired)
+	}
+	if err != nil {
+		return "", authFailure("invalid_credentials", codes.Unauthenticated, "invalid token: %v", err)
+	}
+	cred, err := a.store.AuthenticateToken(claims)
+	if err != nil {
+		return "", credentialFailure(err)
+	}
+	return cred.ClientID, nil
+}
+
+// peerIdentity restituisce il Common Name del certificato client verificato dall'mTLS.
+func peerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+// accepted restituisce i metodi abilitati (nessuno con l'autenticazione disattivata).
+func (a *sensorAuth) accepted() []string {
+	var methods []string
+	if a == nil {
+		return methods
+	}
+	for _, m := range []string{authAPIKey, authJWT, authMTLS} {
+		if a.methods[m] {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// bind lega la metrica all'identità autenticata: un SourceClientId vuoto diventa l'identità,
+// uno diverso viene rifiutato con PERMISSION_DENIED.
+func (a *sensorAuth) bind(identity string, metric *pb.Metric) error {
+	if a == nil {
+		return nil
+	}
+	if metric.SourceClientId == "" {
+		metric.SourceClientId = identity
+		return nil
+	}
+	if metric.SourceClientId != identity {
+		log.Printf("WARNING: sensor %s tried to send a metric as %s.", identity, metric.SourceClientId)
+		return authFailure("identity_mismatch", codes.PermissionDenied, "authenticated as %s, cannot send metrics for client %s", identity, metric.SourceClientId)
+	}
+	return nil
+}
+
+func credentialFailure(err error) error {
+	switch {
+	case errors.Is(err, sensorauth.ErrRevoked):
+		return authFailure("revoked", codes.Unauthenticated, "credential revoked")
+	case errors.Is(err, sensorauth.ErrExpired):
+		return authFailure("expired", codes.Unauthenticated, "credential expired")
+	}
+	return authFailure("invalid_credentials", codes.Unauthenticated, "invalid credential")
+}
+
+func authFailure(reason string, code codes.Code, format string, args ...any) error {
+	authFailures.Add(reason, 1)
+	return status.Errorf(code, format, args...)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var testJWTSecret = []byte("collector-test-secret")
+
+// newTestAuth configura l'autenticazione con i metodi indicati e uno store temporaneo.
+func newTestAuth(t *testing.T, methods string) (*sensorAuth, *sensorauth.Store) {
+	t.Helper()
+	store, err := sensorauth.OpenStore(filepath.Join(t.TempDir(), "sensor-keys.json"))
+	if err != nil {
+		t.Fatalf("OpenStore fallita: %v", err)
+	}
+	auth, err := newSensorAuth(methods, store, testJWTSecret)
+	if err != nil {
+		t.Fatalf("newSensorAuth fallita: %v", err)
+	}
+	return auth, store
+}
+
+// incoming restituisce il contesto di una chiamata con i metadati indicati (coppie chiave, valore).
+func incoming(kv ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+// withClientCert aggiunge al contesto un peer mTLS con il Common Name indicato.
+func withClientCert(ctx context.Context, commonName string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func TestNewSensorAuth_RejectsInvalidConfig(t *testing.T) {
+	store, _ := sensorauth.OpenStore(filepath.Join(t.TempDir(), "sensor-keys.json"))
+	tests := []struct {
+		name      string
+		methods   string
+		jwtSecret []byte
+	}{
+		{"nessun metodo", ",", testJWTSecret},
+		{"solo spazi", " , ", testJWTSecret},
+		{"metodo sconosciuto", "api-key,password", testJWTSecret},
+		{"jwt senza segreto", "jwt", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if auth, err := newSensorAuth(tt.methods, store, tt.jwtSecret); err == nil {
+				t.Errorf("SENSOR_AUTH=%q doveva essere rifiutato, ottenuto %+v", tt.methods, auth)
+			}
+		})
+	}
+
+	// Con l'autenticazione disattivata (nil) l'elenco dei metodi è vuoto
+	var disabled *sensorAuth
+	if methods := disabled.accepted(); len(methods) != 0 {
+		t.Errorf("Nessun metodo atteso con l'autenticazione disattivata, ottenuto %v", methods)
+	}
+}
+
+func TestSensorAuth_Authenticate(t *testing.T) {
+	auth, store := newTestAuth(t, "api-key,jwt,mtls")
+	apiKey, _, err := store.IssueAPIKey("sensor-1", 0)
+	if err != nil {
+		t.Fatalf("IssueAPIKey fallita: %v", err)
+	}
+	token, _, err := store.IssueJWT(testJWTSecret, "sensor-2", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueJWT fallita: %v", err)
+	}
+	revokedKey, revoked, _ := store.IssueAPIKey("sensor-4", 0)
+	store.Revoke(revoked.ID)
+	// Firmati con il segreto del collector ma mai emessi tramite lo store
+	forged, _ := sensorauth.SignJWT(testJWTSecret, sensorauth.Claims{Subject: "sensor-2", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	forgedID, _ := sensorauth.SignJWT(testJWTSecret, sensorauth.Claims{Subject: "sensor-2", ID: "never-issued"})
+	wrongSecret, _ := sensorauth.SignJWT([]byte("other-secret"), sensorauth.Claims{Subject: "sensor-2"})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		identity string
+		code     codes.Code
+	}{
+		{"API key in x-api-key", incoming("x-api-key", apiKey), "sensor-1", codes.OK},
+		{"API key in authorization", incoming("authorization", "ApiKey "+apiKey), "sensor-1", codes.OK},
+		{"JWT emesso", incoming("authorization", "Bearer "+token), "sensor-2", codes.OK},
+		{"certificato mTLS", withClientCert(context.Background(), "sensor-3"), "sensor-3", codes.OK},
+		{"credenziale esplicita prima del certificato", withClientCert(incoming("x-api-key", apiKey), "gateway"), "sensor-1", codes.OK},
+		{"API key sconosciuta", incoming("x-api-key", "ids_unknown_key"), "", codes.Unauthenticated},
+		{"API key revocata", incoming("x-api-key", revokedKey), "", codes.Unauthenticated},
+		{"JWT senza jti", incoming("authorization", "Bearer "+forged), "", codes.Unauthenticated},
+		{"JWT mai emesso", incoming("authorization", "Bearer "+forgedID), "", codes.Unauthenticated},
+		{"JWT con un altro segreto", incoming("authorization", "Bearer "+wrongSecret), "", codes.Unauthenticated},
+		{"schema sconosciuto", incoming("authorization", "Basic dXNlcg=="), "", codes.Unauthenticated},
+		{"nessuna credenziale", context.Background(), "", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := auth.authenticate(tt.ctx)
+			if status.Code(err) != tt.code || identity != tt.identity {
+				t.Errorf("Attesi %q e %s, ottenuti %q e %v", tt.identity, tt.code, identity, err)
+			}
+		})
+	}
+}
+
+func TestSensorAuth_DisabledMethodsAreRejected(t *testing.T) {
+	auth, store := newTestAuth(t, "mtls")
+	apiKey, _, _ := store.IssueAPIKey("sensor-1", 0)
+	token, _, _ := store.IssueJWT(testJWTSecret, "sensor-1", time.Hour)
+
+	for _, ctx := range []context.Context{
+		incoming("x-api-key", apiKey),
+		incoming("authorization", "Bearer "+token),
+	} {
+		if _, err := auth.authenticate(ctx); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Con SENSOR_AUTH=mtls le credenziali esplicite vanno rifiutate, errore %v", err)
+		}
+	}
+}
+
+func TestSensorAuth_Bind(t *testing.T) {
+	auth, _ := newTestAuth(t, "api-key")
+
+	metric := validMetric("sensor-1")
+	if err := auth.bind("sensor-1", metric); err != nil {
+		t.Errorf("La metrica del client autenticato doveva essere accettata: %v", err)
+	}
+	if err := auth.bind("sensor-1", validMetric("sensor-2")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Una metrica di un altro client doveva essere rifiutata con PERMISSION_DENIED, errore %v", err)
+	}
+	anonymous := &pb.Metric{}
+	if err := auth.bind("sensor-1", anonymous); err != nil || anonymous.SourceClientId != "sensor-1" {
+		t.Errorf("Un SourceClientId vuoto doveva diventare l'identità autenticata, ottenuto %q (%v)", anonymous.SourceClientId, err)
+	}
+
+	var disabled *sensorAuth
+	if err := disabled.bind("", validMetric("sensor-2")); err != nil {
+		t.Errorf("Con l'autenticazione disattivata ogni SourceClientId è accettato: %v", err)
+	}
+}
@@ -1,52 +1,135 @@
 package main
 
 import (
+	"bytes"
 	"context"
-
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"hash/fnv"
+	"io"
 	"log"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/consul"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/dedup"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/hashring"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/mtls"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/tracing"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
 	consulapi "github.com/hashicorp/consul/api"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
+// dialTimeout limita l'attesa della connessione verso un'istanza, così un'istanza
+// irraggiungibile non blocca il passaggio alla replica successiva.
+const dialTimeout = 3 * time.Second
+
 type server struct {
 	pb.UnimplementedMetricsCollectorServer
-	consulClient        *consulapi.Client
-	analysisServiceName string
+	// Anello di hashing consistente delle istanze di analisi e numero di istanze candidate per client
+	ring              *hashring.Ring
+	replicationFactor int
+	// Numero massimo di metriche inoltrate in un singolo batch ad AnalyzeMetrics
+	batchSize int
 	// Manteniamo un pool di connessioni per riutilizzarle
 	analysisConns   map[string]*grpc.ClientConn
 	analysisConnsMu sync.RWMutex
+	// Credenziali verso le istanze di analisi (mTLS, o in chiaro se non configurato)
+	analysisCreds credentials.TransportCredentials
+	// metric_id già inoltrati con successo, per scartare i tentativi ripetuti dei sensori (nil = nessuna deduplica)
+	seen *dedup.Window
+	// Log delle metriche accettate durante un'interruzione dell'analisi (nil = nessuno store-and-forward)
+	wal *metricWAL
+	// Limiti di frequenza per client e globali (nil = nessun controllo di ammissione)
+	admission *admission
+	// Autenticazione dei sensori (nil = SourceClientId dichiarato dal sensore)
+	auth *sensorAuth
+	// Validazione delle metriche in ingresso e quarantena di quelle rifiutate (nil = nessuna quarantena)
+	validator  *metricValidator
+	quarantine *quarantineStore
 }
 
-// getAnalysisClientForMetric implementa il Consistent Hashing
+// getAnalysisClientForMetric implementa il Consistent Hashing: restituisce un client verso
+// l'istanza proprietaria del client ID, o verso la prima replica raggiungibile se il proprietario non risponde.
 func (s *server) getAnalysisClientForMetric(ctx context.Context, clientID string) (pb.AnalysisServiceClient, error) {
-	// 1. Scopri tutte le istanze sane disponibili
-	analysisAddrs, err := consul.DiscoverAllServices(s.consulClient, s.analysisServiceName)
-	if err != nil || len(analysisAddrs) == 0 {
-		return nil, fmt.Errorf("could not discover any healthy analysis service: %v", err)
+	targetAddrs, err := s.resolveAnalysisAddrs(clientID)
+	if err != nil {
+		return nil, err
+	}
+	return s.getFirstAvailableAnalysisClient(ctx, targetAddrs, clientID)
+}
+
+// resolveAnalysisAddrs restituisce le istanze di analisi responsabili di un client, in ordine di preferenza.
+// Il primo indirizzo è il proprietario sull'anello, i successivi (fino al fattore di replica) sono le repliche.
+func (s *server) resolveAnalysisAddrs(clientID string) ([]string, error) {
+	// L'anello è aggiornato da watchAnalysisInstances: qui non interroghiamo mai Consul,
+	// così la richiesta non resta bloccata se non ci sono istanze sane.
+	targetAddrs := s.ring.GetN(clientID, s.replicationFactor)
+	if len(targetAddrs) == 0 {
+		return nil, fmt.Errorf("could not discover any healthy analysis service")
+	}
+	return targetAddrs, nil
+}
+
+// watchAnalysisInstances riallinea l'anello a ogni cambiamento delle istanze sane di analisi
+// e chiude le connessioni verso le istanze deregistrate.
+func (s *server) watchAnalysisInstances(events <-chan consul.ServiceEvent) {
+	for ev := range events {
+		if s.ring.Set(ev.Instances) {
+			log.Printf("Analysis ring membership changed: %v", s.ring.Nodes())
+		}
+		for _, addr := range ev.Removed {
+			s.closeAnalysisConn(addr)
+		}
+	}
+}
+
+// closeAnalysisConn chiude e rimuove dal pool la connessione verso un'istanza.
+func (s *server) closeAnalysisConn(targetAddr string) {
+	s.analysisConnsMu.Lock()
+	conn, ok := s.analysisConns[targetAddr]
+	delete(s.analysisConns, targetAddr)
+	s.analysisConnsMu.Unlock()
+
+	if ok {
+		log.Printf("Closing gRPC connection to deregistered analysis instance %s", targetAddr)
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing connection to %s: %v", targetAddr, err)
+		}
 	}
+}
 
-	// 2. Calcola l'hash del client ID per scegliere un'istanza in modo deterministico
-	h := fnv.New32a()
-	h.Write([]byte(clientID))
-	hash := h.Sum32()
-	index := int(hash % uint32(len(analysisAddrs)))
-	targetAddr := analysisAddrs[index]
+// getFirstAvailableAnalysisClient prova le istanze nell'ordine indicato e restituisce la prima raggiungibile.
+func (s *server) getFirstAvailableAnalysisClient(ctx context.Context, targetAddrs []string, clientID string) (pb.AnalysisServiceClient, error) {
+	var lastErr error
+	for i, targetAddr := range targetAddrs {
+		analysisClient, err := s.getAnalysisClient(ctx, targetAddr, clientID)
+		if err == nil {
+			if i > 0 {
+				log.Printf("WARNING: owner %s unreachable, using replica %s for client %s", targetAddrs[0], targetAddr, clientID)
+			}
+			return analysisClient, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
 
+// getAnalysisClient restituisce un client verso l'istanza indicata, riutilizzando il pool di connessioni.
+func (s *server) getAnalysisClient(ctx context.Context, targetAddr, clientID string) (pb.AnalysisServiceClient, error) {
 	// 3. Controlla se abbiamo già una connessione a quell'istanza nel nostro pool
 	s.analysisConnsMu.RLock()
 	conn, ok := s.analysisConns[targetAddr]
@@ -66,8 +149,10 @@ func (s *server) getAnalysisClientForMetric(ctx context.Context, clientID string
 	}
 
 	log.Printf("Creating new gRPC connection to analysis service at %s (for client %s)", targetAddr, clientID)
-	conn, err = grpc.DialContext(ctx, targetAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, targetAddr,
+		grpc.WithTransportCredentials(s.analysisCreds),
 		grpc.WithBlock(),
 		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
 	)
@@ -82,11 +167,50 @@ func (s *server) getAnalysisClientForMetric(ctx context.Context, clientID string
 func (s *server) SendMetric(ctx context.Context, in *pb.Metric) (*pb.CollectorResponse, error) {
 	log.Printf("Received metric from %s.", in.SourceClientId)
 
+	identity, err := s.auth.authenticate(ctx)
+	if err == nil {
+		err = s.auth.bind(identity, in)
+	}
+	if err != nil {
+		return &pb.CollectorResponse{Accepted: false, Message: status.Convert(err).Message()}, err
+	}
+	if err := s.admission.admit(in.SourceClientId); err != nil {
+		setRetryAfter(ctx, err)
+		return &pb.CollectorResponse{Accepted: false, Message: status.Convert(err).Message()}, err
+	}
+	if r := s.validator.validate(in, time.Now()); r != nil {
+		s.reject(in, r)
+		return &pb.CollectorResponse{Accepted: false, Message: r.message()}, r.status(in)
+	}
+
+	assignMetricID(in)
+	switch s.seen.Begin(in.MetricId) {
+	case dedup.Done:
+		log.Printf("Duplicate metric %s from %s ignored.", in.MetricId, in.SourceClientId)
+		return &pb.CollectorResponse{Accepted: true, Message: duplicateMessage}, nil
+	case dedup.Pending:
+		return &pb.CollectorResponse{Accepted: false, Message: inProgressMessage}, status.Errorf(codes.Aborted, "metric %s is already being processed", in.MetricId)
+	}
+
+	var resp *pb.CollectorResponse
+	if s.wal.buffering() {
+		// Il log non è ancora vuoto: la metrica lo segue per non superare quelle arrivate prima
+		resp, err = s.bufferMetric(in)
+	} else if resp, err = s.forwardMetric(ctx, in); err != nil && analysisUnavailable(err) && s.wal != nil {
+		log.Printf("Analysis unavailable, buffering metric from %s.", in.SourceClientId)
+		resp, err = s.bufferMetric(in)
+	}
+	s.finish(in.MetricId, err == nil && resp.Accepted)
+	return resp, err
+}
+
+// forwardMetric inoltra la metrica all'istanza di analisi responsabile del client.
+func (s *server) forwardMetric(ctx context.Context, in *pb.Metric) (*pb.CollectorResponse, error) {
 	// Usa il nuovo metodo di selezione basato sul client ID
 	analysisClient, err := s.getAnalysisClientForMetric(ctx, in.SourceClientId)
 	if err != nil {
 		log.Printf("ERROR: Failed to get analysis client: %v", err)
-		return &pb.CollectorResponse{Accepted: false, Message: "Upstream analysis service unavailable"}, err
+		return &pb.CollectorResponse{Accepted: false, Message: "Upstream analysis service unavailable"}, status.Errorf(codes.Unavailable, "upstream analysis service unavailable: %v", err)
 	}
 
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -106,6 +230,226 @@ func (s *server) SendMetric(ctx context.Context, in *pb.Metric) (*pb.CollectorRe
 	}, nil
 }
 
+// Esiti delle metriche con un metric_id già ricevuto.
+const (
+	duplicateMessage  = "Duplicate metric ignored (already accepted)"
+	inProgressMessage = "Duplicate metric (already being processed)"
+)
+
+// assignMetricID assegna un ID alle metriche dei sensori che non lo inviano, così analisi e
+// storage possono comunque riconoscere le ripetizioni dovute ai tentativi interni della pipeline.
+func assignMetricID(metric *pb.Metric) {
+	if metric.MetricId != "" {
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id) // Non fallisce (vedi crypto/rand.Read)
+	metric.MetricId = hex.EncodeToString(id)
+}
+
+// finish chiude la deduplica di una metrica: se non è stata accettata, un nuovo tentativo verrà inoltrato.
+func (s *server) finish(metricID string, accepted bool) {
+	if accepted {
+		s.seen.Finish(metricID)
+	} else {
+		s.seen.Abort(metricID)
+	}
+}
+
+// pendingBatch raccoglie le metriche di uno stream destinate alla stessa istanza di analisi,
+// insieme alla loro posizione originale nello stream.
+type pendingBatch struct {
+	targets []string
+	metrics []*pb.Metric
+	indexes []int
+}
+
+// SendMetrics riceve uno stream di metriche dal sensore e le inoltra in batch
+// all'istanza di analisi responsabile di ciascun client. Alla chiusura dello
+// stream restituisce l'esito di ogni singolo record; se alcuni record sono stati
+// limitati, il trailer retry-after indica quanto attendere prima di ritentarli.
+func (s *server) SendMetrics(stream pb.MetricsCollector_SendMetricsServer) error {
+	ctx := stream.Context()
+	// L'identità è quella di chi ha aperto lo stream: ogni record deve appartenere a quel client
+	identity, err := s.auth.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	var results []*pb.RecordResult
+	pending := make(map[string]*pendingBatch)
+	var retryAfter time.Duration // Attesa più lunga tra i record limitati
+
+	for {
+		metric, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("ERROR: metrics stream interrupted: %v", err)
+			return err
+		}
+
+		index := len(results)
+		results = append(results, &pb.RecordResult{Index: int32(index)})
+
+		if err := s.auth.bind(identity, metric); err != nil {
+			results[index].Message = status.Convert(err).Message()
+			continue
+		}
+		if err := s.admission.admit(metric.SourceClientId); err != nil {
+			results[index].Message = status.Convert(err).Message()
+			if delay, ok := retryDelay(err); ok {
+				retryAfter = max(retryAfter, delay)
+			}
+			continue
+		}
+		if r := s.validator.validate(metric, time.Now()); r != nil {
+			s.reject(metric, r)
+			results[index].Message = r.message()
+			continue
+		}
+		assignMetricID(metric)
+		switch s.seen.Begin(metric.MetricId) {
+		case dedup.Done:
+			results[index].Accepted = true
+			results[index].Message = duplicateMessage
+			continue
+		case dedup.Pending:
+			results[index].Message = inProgressMessage
+			continue
+		}
+
+		if s.wal.buffering() {
+			// Il log non è ancora vuoto: la metrica lo segue per non superare quelle arrivate prima
+			s.bufferRecord(metric, results[index])
+			continue
+		}
+		targetAddrs, err := s.resolveAnalysisAddrs(metric.SourceClientId)
+		if err != nil && s.wal != nil {
+			s.bufferRecord(metric, results[index])
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to get analysis client: %v", err)
+			results[index].Message = "Upstream analysis service unavailable"
+			s.seen.Abort(metric.MetricId)
+			continue
+		}
+
+		// Raggruppiamo per lista di istanze candidate: i client dello stesso batch
+		// condividono proprietario e repliche, quindi anche l'eventuale failover.
+		key := strings.Join(targetAddrs, ",")
+		batch, ok := pending[key]
+		if !ok {
+			batch = &pendingBatch{targets: targetAddrs}
+			pending[key] = batch
+		}
+		batch.metrics = append(batch.metrics, metric)
+		batch.indexes = append(batch.indexes, index)
+
+		if len(batch.metrics) >= s.batchSize {
+			s.forwardBatch(ctx, batch, results)
+			delete(pending, key)
+		}
+	}
+
+	// Inoltriamo i batch parziali rimasti in sospeso alla chiusura dello stream
+	for _, batch := range pending {
+		s.forwardBatch(ctx, batch, results)
+	}
+
+	accepted := 0
+	for _, r := range results {
+		if r.Accepted {
+			accepted++
+		}
+	}
+	log.Printf("Metrics stream completed: %d/%d records accepted.", accepted, len(results))
+	if retryAfter > 0 {
+		stream.SetTrailer(retryAfterMetadata(retryAfter))
+	}
+
+	return stream.SendAndClose(&pb.BatchCollectorResponse{
+		Received: int32(len(results)),
+		Accepted: int32(accepted),
+		Results:  results,
+	})
+}
+
+// bufferRecord scrive nel log una metrica dello stream e ne riporta l'esito.
+func (s *server) bufferRecord(metric *pb.Metric, result *pb.RecordResult) {
+	resp, err := s.bufferMetric(metric)
+	result.Accepted = resp.Accepted
+	result.Message = resp.Message
+	s.finish(metric.MetricId, err == nil)
+}
+
+// forwardBatch invia un batch alla prima istanza di analisi candidata raggiungibile
+// e riporta gli esiti nelle posizioni originali dello stream. Se nessuna istanza è
+// raggiungibile le metriche vengono conservate nel log (store-and-forward).
+func (s *server) forwardBatch(ctx context.Context, batch *pendingBatch, results []*pb.RecordResult) {
+	defer func() {
+		for i, metric := range batch.metrics {
+			s.finish(metric.MetricId, results[batch.indexes[i]].Accepted)
+		}
+	}()
+	reject := func(message string) {
+		for _, index := range batch.indexes {
+			results[index].Accepted = false
+			results[index].Message = message
+		}
+	}
+
+	analysisResp, err := s.sendBatch(ctx, batch)
+	if err != nil && analysisUnavailable(err) && s.wal != nil {
+		// Nessuna istanza raggiungibile: le metriche restano nel log del collector
+		if err := s.wal.append(batch.metrics...); err != nil {
+			log.Printf("ERROR: could not buffer batch of %d metrics: %v", len(batch.metrics), err)
+			reject("Upstream analysis service unavailable and " + status.Convert(err).Message())
+			return
+		}
+		for _, index := range batch.indexes {
+			results[index].Accepted = true
+			results[index].Message = bufferedMessage
+		}
+		return
+	}
+	if err != nil {
+		reject("Failed to forward metric: " + status.Convert(err).Message())
+		return
+	}
+
+	for i, r := range analysisResp.Results {
+		results[batch.indexes[i]].Accepted = r.Processed
+		results[batch.indexes[i]].Message = r.Message
+	}
+	log.Printf("Batch of %d metrics forwarded successfully.", len(batch.metrics))
+}
+
+// sendBatch invia un batch alla prima istanza di analisi candidata raggiungibile.
+// Se nessuna istanza è raggiungibile l'errore è UNAVAILABLE.
+func (s *server) sendBatch(ctx context.Context, batch *pendingBatch) (*pb.BatchAnalysisResponse, error) {
+	analysisClient, err := s.getFirstAvailableAnalysisClient(ctx, batch.targets, batch.metrics[0].SourceClientId)
+	if err != nil {
+		log.Printf("ERROR: Failed to get analysis client: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "upstream analysis service unavailable: %v", err)
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	analysisResp, err := analysisClient.AnalyzeMetrics(ctxWithTimeout, &pb.MetricBatch{Metrics: batch.metrics})
+	if err != nil {
+		log.Printf("ERROR: could not forward batch of %d metrics to analysis service: %v", len(batch.metrics), err)
+		return nil, err
+	}
+	if len(analysisResp.Results) != len(batch.metrics) {
+		log.Printf("ERROR: analysis service returned %d results for a batch of %d metrics", len(analysisResp.Results), len(batch.metrics))
+		return nil, status.Errorf(codes.Internal, "incomplete response from analysis service")
+	}
+	return analysisResp, nil
+}
+
 func main() {
 	consulAddr := getEnv("CONSUL_ADDR", "localhost:8500")
 	jaegerAddr := getEnv("JAEGER_ADDR", "localhost:4317")
@@ -126,9 +470,29 @@ func main() {
 		}
 	}()
 
+	// mTLS (TLS_CERT_FILE, TLS_KEY_FILE, TLS_CA_FILE; senza file il servizio resta in chiaro)
+	tlsFiles, err := mtls.Load(mtls.Files{
+		CertFile: getEnv("TLS_CERT_FILE", ""),
+		KeyFile:  getEnv("TLS_KEY_FILE", ""),
+		CAFile:   getEnv("TLS_CA_FILE", ""),
+	})
+	if err != nil {
+		log.Fatalf("Failed to load TLS material: %v", err)
+	}
+	tlsReloadStr := getEnv("TLS_RELOAD_SECONDS", "30")
+	tlsReload, err := strconv.Atoi(tlsReloadStr)
+	if err != nil || tlsReload < 0 {
+		log.Fatalf("Invalid TLS_RELOAD_SECONDS: %s", tlsReloadStr)
+	}
+	go tlsFiles.Watch(context.Background(), time.Duration(tlsReload)*time.Second)
+	checkTLSName := ""
+	if tlsFiles.Enabled() {
+		checkTLSName = serviceName
+	}
+
 	log.Println("Registrazione a Consul...")
 	serviceID := fmt.Sprintf("%s-%s", serviceName, os.Getenv("HOSTNAME"))
-	consulClientForRegistration := consul.RegisterService(consulAddr, serviceName, serviceID, port)
+	consulClientForRegistration := consul.RegisterService(consulAddr, serviceName, serviceID, port, checkTLSName)
 	defer consul.DeregisterService(consulClientForRegistration, serviceID)
 	log.Println("Registrato a Consul con successo.")
 
@@ -140,25 +504,152 @@ func main() {
 	}
 	analysisServiceName := getEnv("ANALYSIS_SERVICE_NAME", "analysis-service")
 
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	analysisWatcher := consul.NewServiceWatcher(consulClientForDiscovery, analysisServiceName)
+	analysisEvents := analysisWatcher.Subscribe()
+	analysisWatcher.Start(watchCtx)
+	virtualNodesStr := getEnv("HASH_RING_VIRTUAL_NODES", strconv.Itoa(hashring.DefaultVirtualNodes))
+	virtualNodes, err := strconv.Atoi(virtualNodesStr)
+	if err != nil || virtualNodes <= 0 {
+		log.Fatalf("Invalid HASH_RING_VIRTUAL_NODES: %s", virtualNodesStr)
+	}
+	replicationFactorStr := getEnv("HASH_RING_REPLICATION_FACTOR", "1")
+	replicationFactor, err := strconv.Atoi(replicationFactorStr)
+	if err != nil || replicationFactor <= 0 {
+		log.Fatalf("Invalid HASH_RING_REPLICATION_FACTOR: %s", replicationFactorStr)
+	}
+	batchSizeStr := getEnv("BATCH_MAX_SIZE", "100")
+	batchSize, err := strconv.Atoi(batchSizeStr)
+	if err != nil || batchSize <= 0 {
+		log.Fatalf("Invalid BATCH_MAX_SIZE: %s", batchSizeStr)
+	}
+
 	lis, err := net.Listen("tcp", ":"+portStr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
 	s := grpc.NewServer(
+		grpc.Creds(tlsFiles.ServerCredentials()),
 		grpc.UnaryInterceptor(
 			tracing.NewConditionalUnaryInterceptor(
 				otelgrpc.UnaryServerInterceptor(),
 				"/grpc.health.v1.Health/Check",
 			),
 		),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
 	)
 
-	pb.RegisterMetricsCollectorServer(s, &server{
-		consulClient:        consulClientForDiscovery,
-		analysisServiceName: analysisServiceName,
-		analysisConns:       make(map[string]*grpc.ClientConn),
-	})
+	// Finestra dei metric_id già inoltrati (DEDUP_WINDOW_SECONDS=0 la disattiva)
+	dedupWindowStr := getEnv("DEDUP_WINDOW_SECONDS", "300")
+	dedupWindow, err := strconv.Atoi(dedupWindowStr)
+	if err != nil || dedupWindow < 0 {
+		log.Fatalf("Invalid DEDUP_WINDOW_SECONDS: %s", dedupWindowStr)
+	}
+	dedupMaxStr := getEnv("DEDUP_MAX_IDS", "100000")
+	dedupMax, err := strconv.Atoi(dedupMaxStr)
+	if err != nil || dedupMax <= 0 {
+		log.Fatalf("Invalid DEDUP_MAX_IDS: %s", dedupMaxStr)
+	}
+
+	collector := &server{
+		ring:              hashring.New(virtualNodes),
+		replicationFactor: replicationFactor,
+		batchSize:         batchSize,
+		analysisConns:     make(map[string]*grpc.ClientConn),
+		analysisCreds:     tlsFiles.ClientCredentials(analysisServiceName),
+	}
+	if dedupWindow > 0 {
+		collector.seen = dedup.NewWindow(time.Duration(dedupWindow)*time.Second, dedupMax)
+	}
+
+	// Validazione in ingresso: timestamp plausibili rispetto all'orologio del collector
+	maxSkewStr := getEnv("MAX_CLOCK_SKEW_SECONDS", "60")
+	maxSkew, err := strconv.Atoi(maxSkewStr)
+	if err != nil || maxSkew < 0 {
+		log.Fatalf("Invalid MAX_CLOCK_SKEW_SECONDS: %s", maxSkewStr)
+	}
+	maxAgeStr := getEnv("MAX_EVENT_AGE_SECONDS", "86400")
+	maxAge, err := strconv.Atoi(maxAgeStr)
+	if err != nil || maxAge < 0 {
+		log.Fatalf("Invalid MAX_EVENT_AGE_SECONDS: %s", maxAgeStr)
+	}
+	collector.validator = &metricValidator{
+		vocabulary:   features.Default(),
+		maxClockSkew: time.Duration(maxSkew) * time.Second,
+		maxEventAge:  time.Duration(maxAge) * time.Second,
+	}
+
+	// Quarantena delle metriche rifiutate (QUARANTINE_PATH vuoto la disattiva)
+	if quarantinePath := getEnv("QUARANTINE_PATH", "collector-quarantine.db"); quarantinePath != "" {
+		capacityStr := getEnv("QUARANTINE_MAX_ENTRIES", "10000")
+		capacity, err := strconv.Atoi(capacityStr)
+		if err != nil || capacity <= 0 {
+			log.Fatalf("Invalid QUARANTINE_MAX_ENTRIES: %s", capacityStr)
+		}
+		quarantine, err := openQuarantine(quarantinePath, capacity)
+		if err != nil {
+			log.Fatalf("Failed to open quarantine: %v", err)
+		}
+		defer quarantine.Close()
+		collector.quarantine = quarantine
+		log.Printf("Quarantine of rejected metrics enabled: %s (%d/%d entries).", quarantinePath, quarantine.len(), capacity)
+	}
+
+	// Store-and-forward durante le interruzioni dell'analisi (WAL_PATH vuoto lo disattiva)
+	if walPath := getEnv("WAL_PATH", "collector-wal.db"); walPath != "" {
+		walCapacityStr := getEnv("WAL_MAX_ENTRIES", "100000")
+		walCapacity, err := strconv.Atoi(walCapacityStr)
+		if err != nil || walCapacity <= 0 {
+			log.Fatalf("Invalid WAL_MAX_ENTRIES: %s", walCapacityStr)
+		}
+		wal, err := openMetricWAL(walPath, walCapacity, getEnv("WAL_OVERFLOW_POLICY", overflowDropOldest), getEnv("WAL_REPLAY_ORDER", replayFIFO))
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		defer wal.Close()
+		collector.wal = wal
+		go collector.drainWAL(watchCtx)
+		log.Printf("Store-and-forward enabled: %s (%d/%d metrics buffered).", walPath, wal.len(), walCapacity)
+	}
+
+	// Controllo di ammissione: limiti per gruppo di client, limite globale e priorità
+	collector.admission, err = newAdmission(getEnv("RATE_LIMIT_GROUPS", ""), getEnv("RATE_LIMIT_CLIENTS", ""), getEnv("GLOBAL_RATE_LIMIT", ""))
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+
+	// Autenticazione dei sensori (SENSOR_AUTH vuoto la disattiva)
+	if methods := getEnv("SENSOR_AUTH", ""); methods != "" {
+		store, err := sensorauth.OpenStore(getEnv("SENSOR_KEYS_PATH", "sensor-keys.json"))
+		if err != nil {
+			log.Fatalf("Failed to open sensor credential store: %v", err)
+		}
+		var jwtSecret []byte
+		if secretFile := getEnv("SENSOR_JWT_SECRET_FILE", ""); secretFile != "" {
+			secret, err := os.ReadFile(secretFile)
+			if err != nil {
+				log.Fatalf("Failed to read SENSOR_JWT_SECRET_FILE: %v", err)
+			}
+			jwtSecret = bytes.TrimSpace(secret)
+		}
+		if collector.auth, err = newSensorAuth(methods, store, jwtSecret); err != nil {
+			log.Fatalf("Invalid SENSOR_AUTH: %v", err)
+		}
+		reloadStr := getEnv("SENSOR_AUTH_RELOAD_SECONDS", "10")
+		reload, err := strconv.Atoi(reloadStr)
+		if err != nil || reload < 0 {
+			log.Fatalf("Invalid SENSOR_AUTH_RELOAD_SECONDS: %s", reloadStr)
+		}
+		go store.Watch(watchCtx, time.Duration(reload)*time.Second)
+		log.Printf("Sensor authentication enabled (%s), %d credentials loaded.", strings.Join(collector.auth.accepted(), ", "), store.Len())
+	}
+	if metricsPort := getEnv("METRICS_PORT", "9091"); metricsPort != "" {
+		go serveMetrics(getEnv("METRICS_HOST", "127.0.0.1"), metricsPort, collector.quarantine)
+	}
+	go collector.watchAnalysisInstances(analysisEvents)
+	pb.RegisterMetricsCollectorServer(s, collector)
 
 	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
 
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/hashring"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAnalysis è un'istanza di analisi in ascolto su loopback che registra i batch ricevuti.
+// Le metriche con Value < 0 risultano non elaborate; err, se impostato, fa fallire ogni chiamata.
+type fakeAnalysis struct {
+	pb.UnimplementedAnalysisServiceServer
+	addr string
+
+	mu      sync.Mutex
+	batches [][]*pb.Metric
+	err     error
+}
+
+func startFakeAnalysis(t *testing.T) *fakeAnalysis {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeAnalysis{addr: lis.Addr().String()}
+	s := grpc.NewServer()
+	pb.RegisterAnalysisServiceServer(s, f)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return f
+}
+
+func (f *fakeAnalysis) AnalyzeMetric(ctx context.Context, in *pb.Metric) (*pb.AnalysisResponse, error) {
+	resp, err := f.AnalyzeMetrics(ctx, &pb.MetricBatch{Metrics: []*pb.Metric{in}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Results[0], nil
+}
+
+func (f *fakeAnalysis) AnalyzeMetrics(ctx context.Context, in *pb.MetricBatch) (*pb.BatchAnalysisResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.batches = append(f.batches, in.Metrics)
+	resp := &pb.BatchAnalysisResponse{}
+	for _, m := range in.Metrics {
+		if m.Value < 0 {
+			resp.Results = append(resp.Results, &pb.AnalysisResponse{Processed: false, Message: "not processed"})
+			continue
+		}
+		resp.Results = append(resp.Results, &pb.AnalysisResponse{Processed: true, Message: "analyzed " + m.SourceClientId})
+	}
+	return resp, nil
+}
+
+func (f *fakeAnalysis) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeAnalysis) received() [][]*pb.Metric {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]*pb.Metric(nil), f.batches...)
+}
+
+// newTestServer crea un collector che inoltra alle istanze indicate, senza Consul.
+func newTestServer(t *testing.T, analysis ...*fakeAnalysis) *server {
+	t.Helper()
+	ring := hashring.New(50)
+	var addrs []string
+	for _, a := range analysis {
+		addrs = append(addrs, a.addr)
+	}
+	ring.Set(addrs)
+	s := &server{
+		ring:              ring,
+		replicationFactor: 2,
+		batchSize:         100,
+		analysisConns:     make(map[string]*grpc.ClientConn),
+		analysisCreds:     insecure.NewCredentials(),
+		validator:         &metricValidator{vocabulary: features.Default(), maxClockSkew: time.Minute},
+	}
+	t.Cleanup(func() {
+		for _, conn := range s.analysisConns {
+			conn.Close()
+		}
+	})
+	return s
+}
+
+// validMetric restituisce una metrica che supera la validazione.
+func validMetric(clientID string) *pb.Metric {
+	return &pb.Metric{SourceClientId: clientID, Type: "network_traffic", Timestamp: time.Now().Unix(), Features: make([]float32, features.NumFeatures)}
+}
+
+// fakeMetricsStream simula lo stream SendMetrics di un sensore.
+type fakeMetricsStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	metrics []*pb.Metric
+	resp    *pb.BatchCollectorResponse
+	trailer metadata.MD
+}
+
+func (f *fakeMetricsStream) Context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+func (f *fakeMetricsStream) Recv() (*pb.Metric, error) {
+	if len(f.metrics) == 0 {
+		return nil, io.EOF
+	}
+	m := f.metrics[0]
+	f.metrics = f.metrics[1:]
+	return m, nil
+}
+
+func (f *fakeMetricsStream) SetTrailer(md metadata.MD) {
+	f.trailer = metadata.Join(f.trailer, md)
+}
+
+func (f *fakeMetricsStream) SendAndClose(resp *pb.BatchCollectorResponse) error {
+	f.resp = resp
+	return nil
+}
+
+func sendStream(t *testing.T, s *server, metrics ...*pb.Metric) *pb.BatchCollectorResponse {
+	t.Helper()
+	stream := &fakeMetricsStream{metrics: metrics}
+	if err := s.SendMetrics(stream); err != nil {
+		t.Fatalf("SendMetrics fallita: %v", err)
+	}
+	return stream.resp
+}
+
+func TestSendMetrics_PerRecordResults(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+
+	notProcessed := validMetric("client-c")
+	notProcessed.Value = -1
+	invalid := validMetric("client-b")
+	invalid.Features = make([]float32, 10)
+
+	resp := sendStream(t, s, validMetric("client-a"), invalid, notProcessed, validMetric("client-d"))
+
+	if resp.Received != 4 || resp.Accepted != 2 || len(resp.Results) != 4 {
+		t.Fatalf("Attesi 4 record ricevuti e 2 accettati, ottenuto %+v", resp)
+	}
+	want := []struct {
+		accepted bool
+		message  string
+	}{
+		{true, "analyzed client-a"},
+		{false, "Metric rejected (feature_count)"},
+		{false, "not processed"},
+		{true, "analyzed client-d"},
+	}
+	for i, w := range want {
+		r := resp.Results[i]
+		if r.Index != int32(i) || r.Accepted != w.accepted || !strings.Contains(r.Message, w.message) {
+			t.Errorf("Record %d: atteso accepted=%v %q, ottenuto %+v", i, w.accepted, w.message, r)
+		}
+	}
+}
+
+func TestSendMetrics_AnalysisFailureRejectsBatch(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	analysis.setErr(status.Error(codes.Internal, "boom"))
+	s := newTestServer(t, analysis)
+
+	resp := sendStream(t, s, validMetric("client-a"), validMetric("client-b"))
+	if resp.Accepted != 0 {
+		t.Fatalf("Senza WAL un errore dell'analisi doveva rifiutare i record, ottenuto %+v", resp)
+	}
+	for _, r := range resp.Results {
+		if !strings.Contains(r.Message, "Failed to forward metric") {
+			t.Errorf("Messaggio inatteso per il record %d: %q", r.Index, r.Message)
+		}
+	}
+}
+
+func TestSendMetrics_GroupsByCandidateList(t *testing.T) {
+	instances := []*fakeAnalysis{startFakeAnalysis(t), startFakeAnalysis(t), startFakeAnalysis(t)}
+	s := newTestServer(t, instances...)
+
+	var metrics []*pb.Metric
+	candidateLists := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		m := validMetric(fmt.Sprintf("client-%d", i))
+		metrics = append(metrics, m)
+		candidateLists[strings.Join(s.ring.GetN(m.SourceClientId, s.replicationFactor), ",")] = true
+	}
+	resp := sendStream(t, s, metrics...)
+	if resp.Accepted != int32(len(metrics)) {
+		t.Fatalf("Tutti i record dovevano essere accettati, ottenuto %d/%d", resp.Accepted, len(metrics))
+	}
+
+	// Un batch per lista di candidati, inviato al proprietario, con soli client di quella lista
+	batches := 0
+	for _, instance := range instances {
+		for _, batch := range instance.received() {
+			batches++
+			key := strings.Join(s.ring.GetN(batch[0].SourceClientId, s.replicationFactor), ",")
+			if !strings.HasPrefix(key, instance.addr+",") {
+				t.Errorf("Il batch di %s doveva andare al proprietario, ricevuto da %s", batch[0].SourceClientId, instance.addr)
+			}
+			for _, m := range batch[1:] {
+				if other := strings.Join(s.ring.GetN(m.SourceClientId, s.replicationFactor), ","); other != key {
+					t.Errorf("Il batch mescola liste di candidati diverse: %s e %s", key, other)
+				}
+			}
+		}
+	}
+	if batches != len(candidateLists) {
+		t.Errorf("Attesi %d batch (uno per lista di candidati), ricevuti %d", len(candidateLists), batches)
+	}
+}
+
+func TestSendMetrics_SplitsBatchesAtMaxSize(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+	s.batchSize = 2
+
+	resp := sendStream(t, s, validMetric("client-a"), validMetric("client-a"), validMetric("client-a"))
+	if resp.Accepted != 3 {
+		t.Fatalf("Tutti i record dovevano essere accettati, ottenuto %+v", resp)
+	}
+	if got := len(analysis.received()); got != 2 {
+		t.Errorf("Con BATCH_MAX_SIZE=2 attesi 2 batch per 3 metriche, ricevuti %d", got)
+	}
+}
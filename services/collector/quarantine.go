@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Quarantena: le metriche rifiutate dalla validazione vengono conservate su disco (bbolt) con il
+// codice del motivo, così un sensore mal configurato si può diagnosticare a partire da ciò che ha
+// inviato davvero. La quarantena ha una capacità massima: a quarantena piena si scartano le voci
+// più vecchie. Le voci si consultano su METRICS_PORT in /quarantine (?reason=...&client=...&limit=N).
+
+var quarantineBucket = []byte("quarantine")
+
+// quarantineEntry è una metrica rifiutata con il motivo del rifiuto.
+type quarantineEntry struct {
+	Seq        uint64          `json:"seq"`
+	ReceivedAt int64           `json:"received_at"`
+	ClientID   string          `json:"source_client_id"`
+	Reason     string          `json:"reason"`
+	Field      string          `json:"field"`
+	Detail     string          `json:"detail"`
+	Metric     json.RawMessage `json:"metric"`
+}
+
+type quarantineStore struct {
+	db       *bolt.DB
+	capacity int
+
+	mu      sync.Mutex
+	size    int
+	dropped int // Voci scartate per overflow dall'avvio
+}
+
+func openQuarantine(path string, capacity int) (*quarantineStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open quarantine %s: %w", path, err)
+	}
+	q := &quarantineStore{db: db, capacity: capacity}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(quarantineBucket)
+		if err != nil {
+			return err
+		}
+		q.size = b.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize quarantine %s: %w", path, err)
+	}
+	return q, nil
+}
+
+func (q *quarantineStore) Close() error {
+	return q.db.Close()
+}
+
+func (q *quarantineStore) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// add mette in quarantena la metrica rifiutata. Con la quarantena disattivata (nil) non fa nulla.
+func (q *quarantineStore) add(metric *pb.Metric, r *rejection, now time.Time) error {
+	if q == nil {
+		return nil
+	}
+	// protojson rappresenta anche NaN e infiniti, che sono spesso proprio il motivo del rifiuto
+	raw, err := protojson.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	entry := quarantineEntry{
+		ReceivedAt: now.Unix(),
+		ClientID:   metric.SourceClientId,
+		Reason:     r.Reason,
+		Field:      r.Field,
+		Detail:     r.Detail,
+		Metric:     raw,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := 0
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quarantineBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.Seq = seq
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		// Sequenza a larghezza fissa: il cursore restituisce le voci nell'ordine di arrivo
+		if err := b.Put([]byte(fmt.Sprintf("%020d", seq)), value); err != nil {
+			return err
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && q.size+1-dropped > q.capacity; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			dropped++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("write to quarantine: %w", err)
+	}
+	q.size += 1 - dropped
+	if dropped > 0 {
+		// Un sensore che invia solo metriche non valide riempie la quarantena: si segnala alla prima
+		// voce scartata e poi ogni 1000, per non sommergere i log
+		previous := q.dropped
+		q.dropped += dropped
+		if previous == 0 || previous/1000 != q.dropped/1000 {
+			log.Printf("WARNING: quarantine full, oldest entries dropped (%d since start).", q.dropped)
+		}
+	}
+	return nil
+}
+
+// recent restituisce fino a limit voci, dalla più recente, filtrate per motivo e client (vuoti = tutti).
+func (q *quarantineStore) recent(limit int, reason, clientID string) ([]quarantineEntry, error) {
+	entries := []quarantineEntry{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(quarantineBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < limit; k, v = c.Prev() {
+			var entry quarantineEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				log.Printf("ERROR: unreadable quarantine entry %s skipped: %v", k, err)
+				continue
+			}
+			if (reason != "" && entry.Reason != reason) || (clientID != "" && entry.ClientID != clientID) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// ServeHTTP elenca le voci in quarantena in JSON.
+func (q *quarantineStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	entries, err := q.recent(limit, r.URL.Query().Get("reason"), r.URL.Query().Get("client"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"total": q.len(), "entries": entries})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// quarantined restituisce i metric_id delle voci, dalla più recente.
+func quarantined(t *testing.T, q *quarantineStore, limit int, reason, clientID string) string {
+	t.Helper()
+	entries, err := q.recent(limit, reason, clientID)
+	if err != nil {
+		t.Fatalf("recent fallita: %v", err)
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		var metric struct {
+			MetricID string `json:"metricId"`
+		}
+		json.Unmarshal(e.Metric, &metric)
+		ids[i] = metric.MetricID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestQuarantine_CapacityDropsOldest(t *testing.T) {
+	q := openTestQuarantine(t, 3)
+	for _, m := range walMetrics("a", 5) {
+		if err := q.add(m, &rejection{Reason: reasonFutureTimestamp}, time.Now()); err != nil {
+			t.Fatalf("add fallita: %v", err)
+		}
+	}
+	if q.len() != 3 || q.dropped != 2 {
+		t.Fatalf("Attese 3 voci e 2 scartate, trovate %d e %d", q.len(), q.dropped)
+	}
+	if got := quarantined(t, q, 10, "", ""); got != "[a-4 a-3 a-2]" {
+		t.Errorf("Dovevano restare le voci più recenti, trovate %s", got)
+	}
+}
+
+func TestQuarantine_SizeSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.db")
+	q, err := openQuarantine(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range walMetrics("a", 4) {
+		q.add(m, &rejection{Reason: reasonFutureTimestamp}, time.Now())
+	}
+	q.Close()
+
+	// Riaperta con una capacità minore, la quarantena scarta le voci in eccesso alla prossima aggiunta
+	reopened, err := openQuarantine(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.len() != 4 {
+		t.Fatalf("Dopo la riapertura attese 4 voci, trovate %d", reopened.len())
+	}
+	reopened.add(validMetric("b"), &rejection{Reason: reasonFutureTimestamp}, time.Now())
+	if reopened.len() != 3 {
+		t.Errorf("Oltre la capacità attese 3 voci, trovate %d", reopened.len())
+	}
+}
+
+func TestQuarantine_RecentFilters(t *testing.T) {
+	q := openTestQuarantine(t, 100)
+	add := func(clientID, id, reason string) {
+		m := validMetric(clientID)
+		m.MetricId = id
+		if err := q.add(m, &rejection{Reason: reason}, time.Now()); err != nil {
+			t.Fatalf("add fallita: %v", err)
+		}
+	}
+	add("a", "a-1", reasonFutureTimestamp)
+	add("b", "b-1", reasonStaleTimestamp)
+	add("a", "a-2", reasonStaleTimestamp)
+	add("a", "a-3", reasonFutureTimestamp)
+
+	tests := []struct {
+		limit          int
+		reason, client string
+		want           string
+	}{
+		{10, "", "", "[a-3 a-2 b-1 a-1]"},
+		{2, "", "", "[a-3 a-2]"},
+		{10, reasonStaleTimestamp, "", "[a-2 b-1]"},
+		{10, "", "a", "[a-3 a-2 a-1]"},
+		{10, reasonFutureTimestamp, "a", "[a-3 a-1]"},
+		{10, reasonMissingTimestamp, "", "[]"},
+	}
+	for _, tt := range tests {
+		if got := quarantined(t, q, tt.limit, tt.reason, tt.client); got != tt.want {
+			t.Errorf("limit=%d reason=%q client=%q: attese %s, trovate %s", tt.limit, tt.reason, tt.client, tt.want, got)
+		}
+	}
+}
+
+func TestQuarantine_ServeHTTP(t *testing.T) {
+	q := openTestQuarantine(t, 100)
+	for _, m := range walMetrics("a", 3) {
+		q.add(m, &rejection{Reason: reasonFutureTimestamp}, time.Now())
+	}
+
+	tests := []struct {
+		query   string
+		code    int
+		entries int
+	}{
+		{"", http.StatusOK, 3},
+		{"?limit=2", http.StatusOK, 2},
+		{"?client=b", http.StatusOK, 0},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{"?limit=abc", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		q.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/quarantine"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%q: atteso %d, ottenuto %d", tt.query, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Total   int               `json:"total"`
+			Entries []quarantineEntry `json:"entries"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Total != 3 || len(body.Entries) != tt.entries {
+			t.Errorf("%q: attese %d voci su 3, ottenuto %+v (%v)", tt.query, tt.entries, body, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Controllo di ammissione: ogni metrica consuma un token dal bucket del suo client
+// (SourceClientId), con il limite del gruppo a cui il client appartiene, e uno dal bucket
+// globale del collector. Quando il bucket globale si svuota, i gruppi a priorità più bassa
+// vengono scartati per primi (vedi pkg/ratelimit). Le metriche rifiutate ricevono
+// RESOURCE_EXHAUSTED con l'attesa consigliata prima di ritentare; negli stream SendMetrics l'attesa
+// più lunga tra i record limitati è nel trailer retry-after.
+
+// defaultGroup è il gruppo dei client che non corrispondono a nessuna regola di RATE_LIMIT_CLIENTS.
+const defaultGroup = "default"
+
+// retryAfterHeader riporta l'attesa consigliata in secondi, per i client che non leggono i dettagli dello status.
+const retryAfterHeader = "retry-after"
+
+// Contatori esportati su /debug/vars (METRICS_PORT).
+var (
+	admittedByGroup  = expvar.NewMap("collector_admitted_by_group")
+	throttledByGroup = expvar.NewMap("collector_throttled_by_group")
+	// Metriche rifiutate per motivo: client_limit, global_limit o shed
+	throttledByReason = expvar.NewMap("collector_throttled_by_reason")
+)
+
+// clientRule assegna a un gruppo i client il cui ID corrisponde al pattern (sintassi di path.Match).
+type clientRule struct {
+	pattern string
+	group   string
+}
+
+type admission struct {
+	limiter *ratelimit.Limiter
+	groups  map[string]ratelimit.Group
+	rules   []clientRule // Vince la prima regola che corrisponde
+}
+
+// newAdmission costruisce il controllo di ammissione dalla configurazione:
+//   - groups: "nome:richieste_al_secondo:burst[:priorità]" separati da virgola, priorità low, normal o high;
+//   - clients: "pattern:gruppo" separati da virgola, es. "db-*:critical";
+//   - global: "richieste_al_secondo:burst" (vuoto = nessun limite globale).
+//
+// Il gruppo default, se non configurato, non ha limiti per client e ha priorità normal.
+func newAdmission(groups, clients, global string) (*admission, error) {
+	a := &admission{groups: map[string]ratelimit.Group{
+		defaultGroup: {Name: defaultGroup, Priority: ratelimit.Normal},
+	}}
+	for _, item := range splitList(groups) {
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("group %q must be in the form name:rate:burst[:priority]", item)
+		}
+		limit, err := parseLimit(fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %v", fields[0], err)
+		}
+		priority := ratelimit.Normal
+		if len(fields) == 4 {
+			if priority, err = parsePriority(fields[3]); err != nil {
+				return nil, fmt.Errorf("group %q: %v", fields[0], err)
+			}
+		}
+		a.groups[fields[0]] = ratelimit.Group{Name: fields[0], Limit: limit, Priority: priority}
+	}
+	for _, item := range splitList(clients) {
+		pattern, group, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("client rule %q must be in the form pattern:group", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("client rule %q: invalid pattern: %v", item, err)
+		}
+		if _, ok := a.groups[group]; !ok {
+			return nil, fmt.Errorf("client rule %q: unknown group %q", item, group)
+		}
+		a.rules = append(a.rules, clientRule{pattern: pattern, group: group})
+	}
+	var globalLimit ratelimit.Limit
+	if global = strings.TrimSpace(global); global != "" {
+		rate, burst, ok := strings.Cut(global, ":")
+		if !ok {
+			return nil, fmt.Errorf("global limit %q must be in the form rate:burst", global)
+		}
+		var err error
+		if globalLimit, err = parseLimit(rate, burst); err != nil {
+			return nil, fmt.Errorf("global limit: %v", err)
+		}
+	}
+	a.limiter = ratelimit.New(globalLimit)
+	return a, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseLimit(rate, burst string) (ratelimit.Limit, error) {
+	r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || r < 0 {
+		return ratelimit.Limit{}, fmt.Errorf("invalid rate %q", rate)
+	}
+	b, err := strconv.ParseFloat(strings.TrimSpace(burst), 64)
+	if err != nil || b < 0 {
+		return ratelimit.Limit{}, fmt.Errorf("invalid burst %q", burst)
+	}
+	return ratelimit.Limit{Rate: r, Burst: b}, nil
+}
+
+func parsePriority(p string) (ratelimit.Priority, error) {
+	switch strings.TrimSpace(p) {
+	case "low":
+		return ratelimit.Low, nil
+	case "normal":
+		return ratelimit.Normal, nil
+	case "high":
+		return ratelimit.High, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q (valori ammessi: low, normal, high)", p)
+}
+
+// groupFor restituisce il gruppo del client.
+func (a *admission) groupFor(clientID string) ratelimit.Group {
+	for _, rule := range a.rules {
+		if ok, _ := path.Match(rule.pattern, clientID); ok {
+			return a.groups[rule.group]
+		}
+	}
+	return a.groups[defaultGroup]
+}
+
+// admit decide se ammettere una metrica del client e aggiorna i contatori.
+// Se la metrica è rifiutata restituisce un errore RESOURCE_EXHAUSTED con l'attesa consigliata.
+func (a *admission) admit(clientID string) error {
+	if a == nil {
+		return nil
+	}
+	group := a.groupFor(clientID)
+	decision := a.limiter.Allow(clientID, group)
+	if decision.Allowed {
+		admittedByGroup.Add(group.Name, 1)
+		return nil
+	}
+	throttledByGroup.Add(group.Name, 1)
+	throttledByReason.Add(decision.Reason.String(), 1)
+
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded for client %s (%s), retry after %s", clientID, decision.Reason, decision.RetryAfter))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(decision.RetryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// setRetryAfter riporta nell'header della risposta unaria l'attesa consigliata da un errore di admit.
+func setRetryAfter(ctx context.Context, err error) {
+	delay, ok := retryDelay(err)
+	if !ok {
+		return
+	}
+	grpc.SetHeader(ctx, retryAfterMetadata(delay))
+}
+
+// retryAfterMetadata restituisce l'attesa in secondi interi, almeno 1, come metadato retry-after.
+func retryAfterMetadata(delay time.Duration) metadata.MD {
+	seconds := int(delay.Round(time.Second) / time.Second)
+	return metadata.Pairs(retryAfterHeader, strconv.Itoa(max(seconds, 1)))
+}
+
+// retryDelay estrae l'attesa consigliata dai dettagli RetryInfo di uno status.
+func retryDelay(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// serveMetrics espone i contatori del collector (expvar) su /debug/vars e, se attiva, la
+// quarantena delle metriche rifiutate su /quarantine. L'endpoint non è autenticato e la
+// quarantena contiene le metriche così come inviate dai sensori: va esposto solo su
+// un'interfaccia di amministrazione (host di default 127.0.0.1).
+func serveMetrics(host, port string, quarantine *quarantineStore) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	if quarantine != nil {
+		mux.Handle("/quarantine", quarantine)
+	}
+	addr := net.JoinHostPort(host, port)
+	log.Printf("Collector counters available at %s/debug/vars", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("ERROR: metrics endpoint stopped: %v", err)
+	}
+}
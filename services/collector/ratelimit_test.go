@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startCollector espone il collector su loopback e restituisce un client collegato.
+func startCollector(t *testing.T, s *server) pb.MetricsCollectorClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsCollectorServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsCollectorClient(conn)
+}
+
+func TestNewAdmission_RejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name                    string
+		groups, clients, global string
+		wantErr                 string
+	}{
+		{"gruppo senza burst", "bulk:10", "", "", "name:rate:burst"},
+		{"rate non numerico", "bulk:x:10", "", "", "invalid rate"},
+		{"burst negativo", "bulk:10:-1", "", "", "invalid burst"},
+		{"priorità sconosciuta", "bulk:10:20:urgent", "", "", "unknown priority"},
+		{"regola senza gruppo", "", "db-*", "", "pattern:group"},
+		{"gruppo sconosciuto", "bulk:10:20", "db-*:critical", "", `unknown group "critical"`},
+		{"pattern non valido", "", "[db:default", "", "invalid pattern"},
+		{"limite globale senza burst", "", "", "1000", "rate:burst"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newAdmission(tt.groups, tt.clients, tt.global)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Atteso un errore con %q, ottenuto %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAdmission_GroupForFirstMatchingRule(t *testing.T) {
+	a, err := newAdmission("critical:200:400:high, bulk:10:20:low", "db-bulk-*:bulk,db-*:critical,db-bulk-2:critical", "")
+	if err != nil {
+		t.Fatalf("Configurazione valida rifiutata: %v", err)
+	}
+	tests := map[string]string{
+		"db-bulk-1": "bulk",     // La prima regola che corrisponde vince
+		"db-bulk-2": "bulk",     // Anche se una regola successiva è più specifica
+		"db-main":   "critical", // Seconda regola
+		"web-1":     defaultGroup,
+	}
+	for clientID, want := range tests {
+		if got := a.groupFor(clientID).Name; got != want {
+			t.Errorf("groupFor(%s) = %s, atteso %s", clientID, got, want)
+		}
+	}
+	if g := a.groupFor("web-1"); g.Limit.Rate != 0 {
+		t.Errorf("Il gruppo default non configurato non deve avere limiti per client: %+v", g.Limit)
+	}
+}
+
+func TestSendMetric_ThrottledWithRetryInfoAndHeader(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+	var err error
+	if s.admission, err = newAdmission("slow:0.5:1", "sensor-*:slow", ""); err != nil {
+		t.Fatal(err)
+	}
+	client := startCollector(t, s)
+
+	if _, err := client.SendMetric(context.Background(), validMetric("sensor-1")); err != nil {
+		t.Fatalf("La prima metrica rientra nel burst: %v", err)
+	}
+	var header metadata.MD
+	_, err = client.SendMetric(context.Background(), validMetric("sensor-1"), grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Oltre il burst atteso RESOURCE_EXHAUSTED, ottenuto %v", err)
+	}
+	// Il limiter usa l'orologio reale: tra le due richieste passa qualche istante
+	if delay, ok := retryDelay(err); !ok || delay <= time.Second || delay > 2*time.Second {
+		t.Errorf("Con 0.5 richieste/s RetryInfo doveva indicare circa 2s, ottenuto %s (presente: %v)", delay, ok)
+	}
+	if got := header.Get(retryAfterHeader); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Header %s atteso 2, ottenuto %v", retryAfterHeader, got)
+	}
+}
+
+func TestSendMetrics_ThrottledRecordsSetRetryAfterTrailer(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+	var err error
+	if s.admission, err = newAdmission("slow:0.25:2", "sensor-*:slow", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &fakeMetricsStream{metrics: []*pb.Metric{validMetric("sensor-1"), validMetric("sensor-1"), validMetric("sensor-1"), validMetric("other")}}
+	if err := s.SendMetrics(stream); err != nil {
+		t.Fatalf("SendMetrics fallita: %v", err)
+	}
+	if stream.resp.Accepted != 3 || stream.resp.Results[2].Accepted || !strings.Contains(stream.resp.Results[2].Message, "rate limit exceeded") {
+		t.Fatalf("Atteso il terzo record di sensor-1 limitato, ottenuto %+v", stream.resp.Results)
+	}
+	if got := stream.trailer.Get(retryAfterHeader); len(got) != 1 || got[0] != "4" {
+		t.Errorf("Trailer %s atteso 4, ottenuto %v", retryAfterHeader, got)
+	}
+
+	// Senza record limitati il trailer non c'è
+	stream = &fakeMetricsStream{metrics: []*pb.Metric{validMetric("other")}}
+	if err := s.SendMetrics(stream); err != nil {
+		t.Fatalf("SendMetrics fallita: %v", err)
+	}
+	if got := stream.trailer.Get(retryAfterHeader); len(got) != 0 {
+		t.Errorf("Senza record limitati il trailer %s non doveva esserci, ottenuto %v", retryAfterHeader, got)
+	}
+}
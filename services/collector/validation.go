@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Validazione all'ingresso: una metrica arriva all'analisi solo se ha un client, un timestamp
+// plausibile e 41 feature che rispettano lo schema condiviso con l'addestramento (valori finiti
+// e non negativi, frazioni in [0, 1], flag binari, codici categorici del vocabolario). Le metriche
+// rifiutate finiscono in quarantena con il codice del motivo e il sensore riceve INVALID_ARGUMENT
+// con la violazione (BadRequest) e il codice (ErrorInfo) nei dettagli dello status.
+
+// Motivi di rifiuto del collector; quelli delle feature sono i codici di pkg/features.
+const (
+	reasonMissingClientID  = "missing_client_id"
+	reasonMissingTimestamp = "missing_timestamp"
+	reasonFutureTimestamp  = "future_timestamp"
+	reasonStaleTimestamp   = "stale_timestamp"
+)
+
+// errorDomain identifica il collector negli ErrorInfo restituiti ai sensori.
+const errorDomain = "collector.ids"
+
+// Metriche rifiutate dalla validazione per motivo.
+var rejectedByReason = expvar.NewMap("collector_rejected_by_reason")
+
+// rejection è il motivo per cui una metrica non ha superato la validazione.
+type rejection struct {
+	Reason string // Codice stabile del motivo (es. future_timestamp, unknown_category)
+	Field  string // Campo non valido (es. timestamp, features[2])
+	Detail string
+}
+
+func (r *rejection) Error() string {
+	return fmt.Sprintf("%s: %s", r.Field, r.Detail)
+}
+
+// status restituisce l'errore gRPC per il sensore.
+func (r *rejection) status(metric *pb.Metric) error {
+	st := status.Newf(codes.InvalidArgument, "metric rejected (%s): %s", r.Reason, r)
+	metadata := map[string]string{"source_client_id": metric.SourceClientId}
+	if metric.MetricId != "" {
+		metadata["metric_id"] = metric.MetricId
+	}
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: r.Reason, Domain: errorDomain, Metadata: metadata},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: r.Field, Description: r.Detail}}},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// message è il messaggio per la risposta, o per l'esito del record in uno stream.
+func (r *rejection) message() string {
+	return fmt.Sprintf("Metric rejected (%s): %s", r.Reason, r)
+}
+
+type metricValidator struct {
+	vocabulary   *features.Vocabulary
+	maxClockSkew time.Duration // Anticipo massimo del timestamp rispetto all'orologio del collector
+	maxEventAge  time.Duration // Età massima del timestamp (0 = nessun limite)
+}
+
+// validate restituisce il primo motivo per cui la metrica non è accettabile, o nil.
+func (v *metricValidator) validate(metric *pb.Metric, now time.Time) *rejection {
+	if metric.SourceClientId == "" {
+		return &rejection{Reason: reasonMissingClientID, Field: "source_client_id", Detail: "source client ID is required"}
+	}
+
+	if metric.Timestamp <= 0 {
+		return &rejection{Reason: reasonMissingTimestamp, Field: "timestamp", Detail: fmt.Sprintf("timestamp %d is not a valid Unix time", metric.Timestamp)}
+	}
+	at := time.Unix(metric.Timestamp, 0)
+	if ahead := at.Sub(now); ahead > v.maxClockSkew {
+		return &rejection{Reason: reasonFutureTimestamp, Field: "timestamp", Detail: fmt.Sprintf("timestamp %s is %s ahead of the collector clock (max %s)", at.UTC().Format(time.RFC3339), ahead.Round(time.Second), v.maxClockSkew)}
+	}
+	if age := now.Sub(at); v.maxEventAge > 0 && age > v.maxEventAge {
+		return &rejection{Reason: reasonStaleTimestamp, Field: "timestamp", Detail: fmt.Sprintf("timestamp %s is older than %s", at.UTC().Format(time.RFC3339), v.maxEventAge)}
+	}
+
+	var verr *features.ValidationError
+	if err := v.vocabulary.Validate(metric.Features); errors.As(err, &verr) {
+		if verr.Index < 0 {
+			return &rejection{Reason: verr.Code, Field: "features", Detail: verr.Reason}
+		}
+		return &rejection{Reason: verr.Code, Field: fmt.Sprintf("features[%d]", verr.Index), Detail: features.Names[verr.Index] + ": " + verr.Reason}
+	}
+	return nil
+}
+
+// reject conta e mette in quarantena una metrica rifiutata.
+func (s *server) reject(metric *pb.Metric, r *rejection) {
+	rejectedByReason.Add(r.Reason, 1)
+	log.Printf("WARNING: metric from %s rejected (%s): %v", metric.SourceClientId, r.Reason, r)
+	if err := s.quarantine.add(metric, r, time.Now()); err != nil {
+		log.Printf("ERROR: rejected metric from %s not quarantined: %v", metric.SourceClientId, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricValidator_Validate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := &metricValidator{vocabulary: features.Default(), maxClockSkew: time.Minute, maxEventAge: time.Hour}
+
+	// metricAt restituisce una metrica valida con il timestamp indicato e una feature modificata.
+	metricAt := func(ts int64, index int, value float32) *pb.Metric {
+		m := &pb.Metric{SourceClientId: "sensor-1", Timestamp: ts, Features: make([]float32, features.NumFeatures)}
+		if index >= 0 {
+			m.Features[index] = value
+		}
+		return m
+	}
+	valid := func(ts int64) *pb.Metric { return metricAt(ts, -1, 0) }
+
+	tests := []struct {
+		name   string
+		metric *pb.Metric
+		reason string // Vuoto = metrica accettata
+		field  string
+	}{
+		{"metrica valida", valid(now.Unix()), "", ""},
+		{"client mancante", &pb.Metric{Timestamp: now.Unix(), Features: make([]float32, features.NumFeatures)}, reasonMissingClientID, "source_client_id"},
+		{"timestamp mancante", valid(0), reasonMissingTimestamp, "timestamp"},
+		{"timestamp negativo", valid(-5), reasonMissingTimestamp, "timestamp"},
+		{"anticipo pari al limite", valid(now.Add(time.Minute).Unix()), "", ""},
+		{"timestamp nel futuro", valid(now.Add(time.Minute + time.Second).Unix()), reasonFutureTimestamp, "timestamp"},
+		{"età pari al limite", valid(now.Add(-time.Hour).Unix()), "", ""},
+		{"timestamp troppo vecchio", valid(now.Add(-time.Hour - time.Second).Unix()), reasonStaleTimestamp, "timestamp"},
+		{"numero di feature errato", &pb.Metric{SourceClientId: "sensor-1", Timestamp: now.Unix(), Features: make([]float32, 10)}, features.CodeFeatureCount, "features"},
+		{"valore non finito", metricAt(now.Unix(), features.SrcBytes, float32(math.NaN())), features.CodeNotFinite, "features[4]"},
+		{"infinito", metricAt(now.Unix(), features.Duration, float32(math.Inf(1))), features.CodeNotFinite, "features[0]"},
+		{"valore negativo", metricAt(now.Unix(), features.DstBytes, -1), features.CodeNegative, "features[5]"},
+		{"frazione oltre 1", metricAt(now.Unix(), features.SerrorRate, 1.5), features.CodeOutOfRange, "features[24]"},
+		{"flag non binario", metricAt(now.Unix(), features.Land, 2), features.CodeOutOfRange, "features[6]"},
+		{"categoria sconosciuta", metricAt(now.Unix(), features.ProtocolType, 999), features.CodeUnknownCategory, "features[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := v.validate(tt.metric, now)
+			if tt.reason == "" {
+				if r != nil {
+					t.Fatalf("La metrica doveva essere accettata, rifiutata con %s: %v", r.Reason, r)
+				}
+				return
+			}
+			if r == nil || r.Reason != tt.reason || r.Field != tt.field {
+				t.Fatalf("Atteso il rifiuto %s sul campo %s, ottenuto %+v", tt.reason, tt.field, r)
+			}
+		})
+	}
+}
+
+func TestMetricValidator_NoMaxEventAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := &metricValidator{vocabulary: features.Default(), maxClockSkew: time.Minute}
+	old := &pb.Metric{SourceClientId: "sensor-1", Timestamp: now.AddDate(-1, 0, 0).Unix(), Features: make([]float32, features.NumFeatures)}
+	if r := v.validate(old, now); r != nil {
+		t.Errorf("Con MAX_EVENT_AGE_SECONDS=0 ogni timestamp passato è accettato, rifiutata con %v", r)
+	}
+}
+
+func TestRejection_StatusCarriesReasonAndField(t *testing.T) {
+	r := &rejection{Reason: reasonFutureTimestamp, Field: "timestamp", Detail: "too far ahead"}
+	st := status.Convert(r.status(&pb.Metric{SourceClientId: "sensor-1", MetricId: "m-1"}))
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("Atteso INVALID_ARGUMENT, ottenuto %s", st.Code())
+	}
+	var info *errdetails.ErrorInfo
+	var badRequest *errdetails.BadRequest
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.BadRequest:
+			badRequest = d
+		}
+	}
+	if info == nil || info.Reason != reasonFutureTimestamp || info.Domain != errorDomain || info.Metadata["metric_id"] != "m-1" {
+		t.Errorf("ErrorInfo inatteso: %+v", info)
+	}
+	if badRequest == nil || len(badRequest.FieldViolations) != 1 || badRequest.FieldViolations[0].Field != "timestamp" {
+		t.Errorf("BadRequest inatteso: %+v", badRequest)
+	}
+}
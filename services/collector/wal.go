@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Store-and-forward: quando nessuna istanza di analisi risponde, il collector scrive le metriche
+// accettate in un write-ahead log su disco (bbolt) e conferma al sensore; un drainer in background
+// le inoltra all'analisi appena un'istanza torna disponibile. Finché il log contiene metriche anche
+// quelle nuove passano dal log, così l'analisi le riceve nell'ordine di arrivo e le finestre di
+// correlazione in event time non le scartano come ritardatarie.
+//
+// Il log ha una capacità massima: a log pieno si scarta la metrica più vecchia (drop-oldest)
+// oppure si rifiuta quella nuova con RESOURCE_EXHAUSTED (reject). Al ripristino le metriche
+// vengono inoltrate dalla più vecchia (fifo, l'ordine in cui la correlazione le attende) o dalla
+// più recente (newest-first, per avere subito il quadro attuale dopo un'interruzione lunga, a costo
+// che le metriche più vecchie arrivino oltre il ritardo tollerato dalle finestre e vengano scartate).
+//
+// Solo gli errori dovuti all'indisponibilità dell'analisi (UNAVAILABLE, DEADLINE_EXCEEDED,
+// RESOURCE_EXHAUSTED) vengono ritentati. Un batch rifiutato con qualunque altro errore non verrebbe
+// mai accettato e bloccherebbe il log, e con esso tutto il traffico che lo segue: le sue metriche
+// finiscono in quarantena con il motivo analysis_rejected.
+
+// Politiche di overflow (WAL_OVERFLOW_POLICY).
+const (
+	overflowDropOldest = "drop-oldest"
+	overflowReject     = "reject"
+)
+
+// Ordini di inoltro al ripristino (WAL_REPLAY_ORDER).
+const (
+	replayFIFO        = "fifo"
+	replayNewestFirst = "newest-first"
+)
+
+// reasonAnalysisRejected è il motivo di quarantena delle metriche del log rifiutate dall'analisi.
+const reasonAnalysisRejected = "analysis_rejected"
+
+const (
+	walMinBackoff = 500 * time.Millisecond
+	walMaxBackoff = 10 * time.Second
+)
+
+var walBucket = []byte("metrics")
+
+// errWALFull è restituito da append quando il log è pieno e la politica è reject.
+var errWALFull = status.Error(codes.ResourceExhausted, "analysis unavailable and collector buffer full, retry later")
+
+type metricWAL struct {
+	db          *bolt.DB
+	capacity    int
+	dropOldest  bool
+	newestFirst bool
+	notify      chan struct{} // Sveglia il drainer quando arriva una metrica
+
+	mu      sync.Mutex
+	size    int
+	dropped int // Metriche scartate per overflow dall'avvio
+}
+
+func openMetricWAL(path string, capacity int, overflow, order string) (*metricWAL, error) {
+	if overflow != overflowDropOldest && overflow != overflowReject {
+		return nil, fmt.Errorf("unknown overflow policy %q (expected %s or %s)", overflow, overflowDropOldest, overflowReject)
+	}
+	if order != replayFIFO && order != replayNewestFirst {
+		return nil, fmt.Errorf("unknown replay order %q (expected %s or %s)", order, replayFIFO, replayNewestFirst)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log %s: %w", path, err)
+	}
+	w := &metricWAL{
+		db:          db,
+		capacity:    capacity,
+		dropOldest:  overflow == overflowDropOldest,
+		newestFirst: order == replayNewestFirst,
+		notify:      make(chan struct{}, 1),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(walBucket)
+		if err != nil {
+			return err
+		}
+		w.size = b.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize write-ahead log %s: %w", path, err)
+	}
+	return w, nil
+}
+
+func (w *metricWAL) Close() error {
+	return w.db.Close()
+}
+
+// buffering indica se il log contiene metriche ancora da inoltrare.
+func (w *metricWAL) buffering() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size > 0
+}
+
+func (w *metricWAL) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// append scrive le metriche in coda al log. Quando torna senza errori le metriche sono su disco.
+func (w *metricWAL) append(metrics ...*pb.Metric) error {
+	values := make([][]byte, len(metrics))
+	for i, m := range metrics {
+		value, err := proto.Marshal(m)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dropOldest && w.size+len(metrics) > w.capacity {
+		return errWALFull
+	}
+	dropped := 0
+	err := w.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(walBucket)
+		for _, value := range values {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			// Sequenza a larghezza fissa: il cursore restituisce le metriche nell'ordine di arrivo
+			if err := b.Put([]byte(fmt.Sprintf("%020d", seq)), value); err != nil {
+				return err
+			}
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && w.size+len(values)-dropped > w.capacity; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			dropped++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("append to write-ahead log: %w", err)
+	}
+	w.size += len(values) - dropped
+	if dropped > 0 {
+		w.dropped += dropped
+		log.Printf("WARNING: collector buffer full, %d oldest metrics dropped (%d since start).", dropped, w.dropped)
+	}
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// walEntry è una metrica del log con la sua chiave.
+type walEntry struct {
+	key    []byte
+	metric *pb.Metric
+}
+
+// peek restituisce fino a n metriche nell'ordine di inoltro, senza toglierle dal log.
+// Le metriche illeggibili vengono scartate.
+func (w *metricWAL) peek(n int) ([]walEntry, error) {
+	var entries []walEntry
+	var corrupt [][]byte
+	err := w.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(walBucket).Cursor()
+		first, step := c.First, c.Next
+		if w.newestFirst {
+			first, step = c.Last, c.Prev
+		}
+		for k, v := first(); k != nil && len(entries) < n; k, v = step() {
+			metric := &pb.Metric{}
+			if err := proto.Unmarshal(v, metric); err != nil {
+				log.Printf("ERROR: unreadable metric %s in write-ahead log dropped: %v", k, err)
+				corrupt = append(corrupt, append([]byte(nil), k...))
+				continue
+			}
+			entries = append(entries, walEntry{key: append([]byte(nil), k...), metric: metric})
+		}
+		return nil
+	})
+	if err == nil && len(corrupt) > 0 {
+		err = w.remove(corrupt)
+	}
+	return entries, err
+}
+
+// remove toglie dal log le metriche inoltrate. Le chiavi già scartate per overflow vengono ignorate.
+func (w *metricWAL) remove(keys [][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	removed := 0
+	err := w.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(walBucket)
+		for _, k := range keys {
+			if b.Get(k) == nil {
+				continue
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.size -= removed
+	return nil
+}
+
+// analysisUnavailable indica un errore dovuto all'indisponibilità dell'analisi, per cui la metrica
+// va conservata nel log invece di essere rifiutata.
+func analysisUnavailable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// retryable indica gli errori dell'analisi per cui l'inoltro di una metrica del log va ritentato:
+// l'analisi è irraggiungibile, non ha risposto in tempo o è sovraccarica.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// bufferMetric scrive la metrica nel log e prepara la risposta per il sensore.
+func (s *server) bufferMetric(in *pb.Metric) (*pb.CollectorResponse, error) {
+	if err := s.wal.append(in); err != nil {
+		log.Printf("ERROR: could not buffer metric from %s: %v", in.SourceClientId, err)
+		if status.Code(err) == codes.ResourceExhausted {
+			return &pb.CollectorResponse{Accepted: false, Message: "Collector buffer full"}, err
+		}
+		return &pb.CollectorResponse{Accepted: false, Message: "Failed to buffer metric"}, status.Errorf(codes.Unavailable, "could not buffer metric: %v", err)
+	}
+	return &pb.CollectorResponse{Accepted: true, Message: bufferedMessage}, nil
+}
+
+const bufferedMessage = "Metric buffered, will be forwarded when analysis is available"
+
+// drainWAL inoltra all'analisi le metriche del log finché ctx non termina, con backoff
+// esponenziale mentre l'analisi resta irraggiungibile.
+func (s *server) drainWAL(ctx context.Context) {
+	backoff := walMinBackoff
+	for {
+		entries, err := s.wal.peek(s.batchSize)
+		if err == nil && len(entries) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wal.notify:
+			}
+			continue
+		}
+		if err == nil {
+			err = s.replayWAL(ctx, entries)
+		}
+		if err == nil {
+			backoff = walMinBackoff
+			continue
+		}
+
+		log.Printf("WARNING: %d buffered metrics not yet forwarded, retrying in %s: %v", s.wal.len(), backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, walMaxBackoff)
+	}
+}
+
+// replayWAL inoltra un gruppo di metriche del log, in batch per istanza di analisi, e toglie dal
+// log quelle consegnate o rifiutate dall'analisi (queste ultime in quarantena). Si ferma al primo
+// batch da ritentare per rispettare l'ordine di inoltro.
+func (s *server) replayWAL(ctx context.Context, entries []walEntry) error {
+	var batches []*pendingBatch
+	var keys [][][]byte
+	byTargets := make(map[string]int)
+	for _, e := range entries {
+		targetAddrs, err := s.resolveAnalysisAddrs(e.metric.SourceClientId)
+		if err != nil {
+			break // Nessuna istanza: le metriche da qui in poi restano nel log
+		}
+		key := strings.Join(targetAddrs, ",")
+		i, ok := byTargets[key]
+		if !ok {
+			i = len(batches)
+			byTargets[key] = i
+			batches = append(batches, &pendingBatch{targets: targetAddrs})
+			keys = append(keys, nil)
+		}
+		batches[i].metrics = append(batches[i].metrics, e.metric)
+		keys[i] = append(keys[i], e.key)
+	}
+	if len(batches) == 0 {
+		return status.Error(codes.Unavailable, "no analysis instance available")
+	}
+
+	for i, batch := range batches {
+		resp, err := s.sendBatch(ctx, batch)
+		if err != nil && (retryable(err) || ctx.Err() != nil) {
+			return err
+		}
+		if err != nil {
+			log.Printf("ERROR: analysis rejected %d buffered metrics, moving them to quarantine: %v", len(batch.metrics), err)
+			r := &rejection{Reason: reasonAnalysisRejected, Field: "metric", Detail: fmt.Sprintf("analysis returned %s: %s", status.Code(err), status.Convert(err).Message())}
+			for _, metric := range batch.metrics {
+				s.reject(metric, r)
+			}
+		} else {
+			for j, r := range resp.Results {
+				if !r.Processed {
+					log.Printf("WARNING: buffered metric %s from %s not processed by analysis: %s", batch.metrics[j].MetricId, batch.metrics[j].SourceClientId, r.Message)
+				}
+			}
+		}
+		if err := s.wal.remove(keys[i]); err != nil {
+			return err
+		}
+		if resp != nil {
+			log.Printf("Forwarded %d buffered metrics to analysis.", len(batch.metrics))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func openTestWAL(t *testing.T, capacity int, overflow string) *metricWAL {
+	t.Helper()
+	w, err := openMetricWAL(filepath.Join(t.TempDir(), "wal.db"), capacity, overflow, replayFIFO)
+	if err != nil {
+		t.Fatalf("Impossibile aprire il log: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func openTestQuarantine(t *testing.T, capacity int) *quarantineStore {
+	t.Helper()
+	q, err := openQuarantine(filepath.Join(t.TempDir(), "quarantine.db"), capacity)
+	if err != nil {
+		t.Fatalf("Impossibile aprire la quarantena: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// walMetrics restituisce n metriche del client con metric_id progressivi.
+func walMetrics(clientID string, n int) []*pb.Metric {
+	metrics := make([]*pb.Metric, n)
+	for i := range metrics {
+		metrics[i] = validMetric(clientID)
+		metrics[i].MetricId = fmt.Sprintf("%s-%d", clientID, i)
+	}
+	return metrics
+}
+
+// peekIDs restituisce i metric_id delle prime n metriche del log.
+func peekIDs(t *testing.T, w *metricWAL, n int) []string {
+	t.Helper()
+	entries, err := w.peek(n)
+	if err != nil {
+		t.Fatalf("peek fallita: %v", err)
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.metric.MetricId
+	}
+	return ids
+}
+
+func TestMetricWAL_AppendPeekRemove(t *testing.T) {
+	w := openTestWAL(t, 10, overflowReject)
+	if err := w.append(walMetrics("a", 3)...); err != nil {
+		t.Fatalf("append fallita: %v", err)
+	}
+	if !w.buffering() || w.len() != 3 {
+		t.Fatalf("Attese 3 metriche nel log, trovate %d", w.len())
+	}
+
+	entries, _ := w.peek(2)
+	if got := peekIDs(t, w, 2); fmt.Sprint(got) != "[a-0 a-1]" {
+		t.Fatalf("peek doveva restituire le metriche più vecchie in ordine di arrivo, ottenuto %v", got)
+	}
+	if w.len() != 3 {
+		t.Errorf("peek non deve togliere metriche dal log")
+	}
+	if err := w.remove([][]byte{entries[0].key, entries[1].key}); err != nil {
+		t.Fatalf("remove fallita: %v", err)
+	}
+	// Una chiave già rimossa viene ignorata e non altera il conteggio
+	if err := w.remove([][]byte{entries[0].key}); err != nil || w.len() != 1 {
+		t.Fatalf("Attesa 1 metrica nel log, trovate %d (errore %v)", w.len(), err)
+	}
+	if got := peekIDs(t, w, 10); fmt.Sprint(got) != "[a-2]" {
+		t.Errorf("Nel log doveva restare a-2, trovato %v", got)
+	}
+}
+
+func TestMetricWAL_Overflow(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr codes.Code
+		wantIDs string
+	}{
+		{overflowDropOldest, codes.OK, "[a-1 a-2]"},
+		{overflowReject, codes.ResourceExhausted, "[a-0 a-1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			w := openTestWAL(t, 2, tt.policy)
+			metrics := walMetrics("a", 3)
+			if err := w.append(metrics[:2]...); err != nil {
+				t.Fatalf("append fallita: %v", err)
+			}
+			if err := w.append(metrics[2]); status.Code(err) != tt.wantErr {
+				t.Fatalf("A log pieno atteso %s, ottenuto %v", tt.wantErr, err)
+			}
+			if got := peekIDs(t, w, 10); w.len() != 2 || fmt.Sprint(got) != tt.wantIDs {
+				t.Errorf("Nel log attese %s, trovate %v (len %d)", tt.wantIDs, got, w.len())
+			}
+		})
+	}
+}
+
+func TestMetricWAL_ReplayOrder(t *testing.T) {
+	tests := []struct {
+		order   string
+		wantIDs string
+	}{
+		{replayFIFO, "[a-0 a-1 a-2]"},
+		{replayNewestFirst, "[a-4 a-3 a-2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			w, err := openMetricWAL(filepath.Join(t.TempDir(), "wal.db"), 10, overflowReject, tt.order)
+			if err != nil {
+				t.Fatalf("Impossibile aprire il log: %v", err)
+			}
+			defer w.Close()
+			w.append(walMetrics("a", 5)...)
+			if got := peekIDs(t, w, 3); fmt.Sprint(got) != tt.wantIDs {
+				t.Errorf("Con l'ordine %s attese %s, ottenute %v", tt.order, tt.wantIDs, got)
+			}
+		})
+	}
+
+	if _, err := openMetricWAL(filepath.Join(t.TempDir(), "wal.db"), 10, overflowReject, "random"); err == nil {
+		t.Errorf("Un ordine di inoltro sconosciuto doveva essere rifiutato")
+	}
+}
+
+func TestReplayWAL_NewestFirstForwardsMostRecent(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+	w, err := openMetricWAL(filepath.Join(t.TempDir(), "wal.db"), 100, overflowReject, replayNewestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s.wal = w
+	s.wal.append(walMetrics("a", 5)...)
+
+	entries, _ := s.wal.peek(2)
+	if err := s.replayWAL(context.Background(), entries); err != nil {
+		t.Fatalf("replayWAL fallita: %v", err)
+	}
+	batches := analysis.received()
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].MetricId != "a-4" || batches[0][1].MetricId != "a-3" {
+		t.Fatalf("Attese per prime le metriche più recenti a-4 e a-3, ricevuti %v", batches)
+	}
+	if got := peekIDs(t, s.wal, 10); fmt.Sprint(got) != "[a-2 a-1 a-0]" {
+		t.Errorf("Nel log dovevano restare a-2, a-1 e a-0, trovate %v", got)
+	}
+}
+
+func TestMetricWAL_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	w, err := openMetricWAL(path, 10, overflowDropOldest, replayFIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(walMetrics("a", 2)...)
+	w.Close()
+
+	reopened, err := openMetricWAL(path, 10, overflowDropOldest, replayFIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := peekIDs(t, reopened, 10); reopened.len() != 2 || fmt.Sprint(got) != "[a-0 a-1]" {
+		t.Errorf("Dopo la riapertura attese a-0 e a-1, trovate %v (len %d)", got, reopened.len())
+	}
+}
+
+func TestReplayWAL_ForwardsInArrivalOrder(t *testing.T) {
+	analysis := startFakeAnalysis(t)
+	s := newTestServer(t, analysis)
+	s.wal = openTestWAL(t, 100, overflowReject)
+	s.wal.append(walMetrics("a", 5)...)
+
+	entries, _ := s.wal.peek(s.batchSize)
+	if err := s.replayWAL(context.Background(), entries); err != nil {
+		t.Fatalf("replayWAL fallita: %v", err)
+	}
+	if s.wal.len() != 0 {
+		t.Errorf("Le metriche inoltrate dovevano uscire dal log, rimaste %d", s.wal.len())
+	}
+	batches := analysis.received()
+	if len(batches) != 1 || len(batches[0]) != 5 {
+		t.Fatalf("Atteso un batch di 5 metriche, ricevuti %d batch", len(batches))
+	}
+	for i, m := range batches[0] {
+		if want := fmt.Sprintf("a-%d", i); m.MetricId != want {
+			t.Errorf("Posizione %d: attesa %s, ricevuta %s", i, want, m.MetricId)
+		}
+	}
+}
+
+func TestReplayWAL_RetriesOnlyTransientErrors(t *testing.T) {
+	tests := []struct {
+		code        codes.Code
+		wantErr     bool
+		wantBuffer  int
+		quarantined int
+	}{
+		{codes.Unavailable, true, 3, 0},
+		{codes.DeadlineExceeded, true, 3, 0},
+		{codes.ResourceExhausted, true, 3, 0},
+		{codes.Internal, false, 0, 3},
+		{codes.InvalidArgument, false, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			analysis := startFakeAnalysis(t)
+			analysis.setErr(status.Error(tt.code, "boom"))
+			s := newTestServer(t, analysis)
+			s.wal = openTestWAL(t, 100, overflowReject)
+			s.quarantine = openTestQuarantine(t, 100)
+			s.wal.append(walMetrics("a", 3)...)
+
+			entries, _ := s.wal.peek(s.batchSize)
+			err := s.replayWAL(context.Background(), entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Errore atteso %v, ottenuto %v", tt.wantErr, err)
+			}
+			if s.wal.len() != tt.wantBuffer || s.quarantine.len() != tt.quarantined {
+				t.Fatalf("Attese %d metriche nel log e %d in quarantena, trovate %d e %d", tt.wantBuffer, tt.quarantined, s.wal.len(), s.quarantine.len())
+			}
+			if tt.quarantined > 0 {
+				entries, _ := s.quarantine.recent(10, reasonAnalysisRejected, "")
+				if len(entries) != tt.quarantined {
+					t.Errorf("Le metriche rifiutate dovevano avere il motivo %s", reasonAnalysisRejected)
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import os
 import signal
 import time
 import grpc
+import hashlib
 import joblib
 import numpy as np
 from consul import Consul
@@ -49,7 +50,58 @@ def init_tracer(service_name):
     logging.info(f"Tracer provider inizializzato per il servizio '{service_name}', esporta a {jaeger_endpoint}")
 
 
-def register_to_consul(hostname, service_name, service_port):
+class TLSMaterial:
+    """Certificato, chiave e bundle CA per l'mTLS, ricaricati quando i file cambiano (rotazione)."""
+
+    def __init__(self, cert_file, key_file, ca_file, reload_seconds):
+        self.files = (cert_file, key_file, ca_file)
+        self.reload_seconds = reload_seconds
+        self.contents = self._read()
+        self.checked = time.monotonic()
+
+    @classmethod
+    def from_env(cls):
+        """Materiale da TLS_CERT_FILE, TLS_KEY_FILE e TLS_CA_FILE; None se il TLS non è configurato."""
+        files = [os.getenv(name, '') for name in ('TLS_CERT_FILE', 'TLS_KEY_FILE', 'TLS_CA_FILE')]
+        if not any(files):
+            return None
+        if not all(files):
+            raise ValueError("Il TLS richiede certificato, chiave e bundle CA")
+        return cls(*files, reload_seconds=int(os.getenv('TLS_RELOAD_SECONDS', 30)))
+
+    def _read(self):
+        contents = []
+        for path in self.files:
+            with open(path, 'rb') as f:
+                contents.append(f.read())
+        return tuple(contents)
+
+    def _configuration(self):
+        cert, key, ca = self.contents
+        return grpc.ssl_server_certificate_configuration([(key, cert)], root_certificates=ca)
+
+    def _fetch(self):
+        # Chiamata da gRPC a ogni nuovo handshake: None mantiene la configurazione in uso
+        if self.reload_seconds <= 0 or time.monotonic() - self.checked < self.reload_seconds:
+            return None
+        self.checked = time.monotonic()
+        try:
+            contents = self._read()
+        except OSError as e:
+            logging.error(f"Materiale TLS non ricaricato, resta in uso il precedente: {e}")
+            return None
+        if contents == self.contents:
+            return None
+        self.contents = contents
+        logging.info(f"Certificato e bundle CA ricaricati da {self.files[0]}.")
+        return self._configuration()
+
+    def server_credentials(self):
+        """Credenziali del server: i client devono presentare un certificato firmato dalla CA."""
+        return grpc.dynamic_ssl_server_credentials(self._configuration(), self._fetch, require_client_authentication=True)
+
+
+def register_to_consul(hostname, service_name, service_port, use_tls=False):
     """Registra il servizio a Consul con retry."""
     consul_host = os.getenv('CONSUL_HOST', 'consul')
     consul_port = int(os.getenv('CONSUL_PORT', 8500))
@@ -60,7 +112,9 @@ def register_to_consul(hostname, service_name, service_port):
 
     check_config = {
         "GRPC": f"{hostname}:{service_port}",
-        "GRPCUseTLS": False,
+        # Con l'mTLS l'agent Consul presenta il proprio certificato (enable_agent_tls_for_checks)
+        "GRPCUseTLS": use_tls,
+        "TLSServerName": service_name if use_tls else "",
         "Interval": "10s",
         "DeregisterCriticalServiceAfter": "1m"
     }
@@ -92,22 +146,61 @@ def deregister_from_consul(consul_client, service_id):
         logging.info("Servizio de-registrato.")
 
 
+def model_version(model_path):
+    """Versione del modello: MODEL_VERSION se impostata, altrimenti l'impronta del file del modello."""
+    version = os.getenv('MODEL_VERSION')
+    if version:
+        return version
+    digest = hashlib.sha256()
+    with open(model_path, 'rb') as f:
+        for chunk in iter(lambda: f.read(1 << 20), b''):
+            digest.update(chunk)
+    return 'sha256:' + digest.hexdigest()[:12]
+
+
 class InferenceService(inference_pb2_grpc.InferenceServicer):
-    def __init__(self, model):
+    def __init__(self, model, model_name, model_version):
         self.model = model
+        self.model_name = model_name
+        self.model_version = model_version
 
     def Predict(self, request, context):
         try:
             features = np.array(request.features).reshape(1, -1)
-            prediction = self.model.predict(features)
-            result = int(prediction[0])
-            return inference_pb2.InferenceResponse(prediction=result)
+            score = float(self.model.decision_function(features)[0])
+            return self._response(score)
         except Exception as e:
             logging.error(f"Errore durante la predizione: {e}")
             context.set_code(grpc.StatusCode.INTERNAL)
             context.set_details("Errore interno del server durante la predizione.")
             return inference_pb2.InferenceResponse()
 
+    def PredictBatch(self, request, context):
+        # Un'unica chiamata al modello per tutte le metriche: il costo per chiamata si paga una volta sola
+        if not request.requests:
+            return inference_pb2.InferenceBatchResponse()
+        lengths = {len(r.features) for r in request.requests}
+        if len(lengths) != 1:
+            context.abort(grpc.StatusCode.INVALID_ARGUMENT, f"Le richieste hanno un numero di feature diverso: {sorted(lengths)}")
+        try:
+            features = np.array([r.features for r in request.requests])
+            scores = self.model.decision_function(features)
+            return inference_pb2.InferenceBatchResponse(responses=[self._response(float(score)) for score in scores])
+        except Exception as e:
+            logging.error(f"Errore durante la predizione batch: {e}")
+            context.set_code(grpc.StatusCode.INTERNAL)
+            context.set_details("Errore interno del server durante la predizione batch.")
+            return inference_pb2.InferenceBatchResponse()
+
+    def _response(self, score):
+        # decision_function è negativo per le anomalie: predict equivale al suo segno
+        return inference_pb2.InferenceResponse(
+            prediction=-1 if score < 0 else 1,
+            score=score,
+            model_name=self.model_name,
+            model_version=self.model_version,
+        )
+
 
 def serve():
     """Funzione principale che avvia il server gRPC."""
@@ -120,9 +213,12 @@ def serve():
     grpc_server_instrumentor.instrument()
 
     # Carica il modello
+    model_path = os.getenv('MODEL_PATH', 'isolation_forest_model.joblib')
+    model_name = os.getenv('MODEL_NAME', 'isolation_forest')
     try:
-        model = joblib.load('isolation_forest_model.joblib')
-        logging.info("Modello di inferenza caricato.")
+        model = joblib.load(model_path)
+        version = model_version(model_path)
+        logging.info(f"Modello di inferenza '{model_name}' caricato (versione {version}).")
     except Exception as e:
         logging.critical(f"Impossibile caricare il modello: {e}")
         return
@@ -131,7 +227,7 @@ def serve():
     server = grpc.server(futures.ThreadPoolExecutor(max_workers=10))
 
     # Aggiungi il servizio di inferenza
-    inference_pb2_grpc.add_InferenceServicer_to_server(InferenceService(model), server)
+    inference_pb2_grpc.add_InferenceServicer_to_server(InferenceService(model, model_name, version), server)
 
     # Configura e aggiungi il servizio di health check
     health_servicer = health.HealthServicer()
@@ -143,14 +239,18 @@ def serve():
 
     # Metti il server in ascolto
     service_port = int(os.getenv('GRPC_PORT', 5000))
-    server.add_insecure_port(f'[::]:{service_port}')
+    tls_material = TLSMaterial.from_env()
+    if tls_material:
+        server.add_secure_port(f'[::]:{service_port}', tls_material.server_credentials())
+    else:
+        server.add_insecure_port(f'[::]:{service_port}')
 
     server.start()
     logging.info(f'Server gRPC in ascolto sulla porta {service_port}.')
 
     # Registra a Consul SOLO DOPO che il server è effettivamente partito
     hostname = os.getenv('HOSTNAME', 'localhost')
-    consul_client, service_id = register_to_consul(hostname, service_name, service_port)
+    consul_client, service_id = register_to_consul(hostname, service_name, service_port, use_tls=tls_material is not None)
 
     # Gestisci la chiusura pulita
     def handle_shutdown(signum, frame):
@@ -24,7 +24,7 @@ _sym_db = _symbol_database.Default()
 
 
 
-DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\x0finference.proto\x12\x05proto\"$\n\x10InferenceRequest\x12\x10\n\x08\x66\x65\x61tures\x18\x01 \x03(\x02\"\'\n\x11InferenceResponse\x12\x12\n\nprediction\x18\x01 \x01(\x05\x32I\n\tInference\x12<\n\x07Predict\x12\x17.proto.InferenceRequest\x1a\x18.proto.InferenceResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3')
+DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\x0finference.proto\x12\x05proto\"$\n\x10InferenceRequest\x12\x10\n\x08\x66\x65\x61tures\x18\x01 \x03(\x02\"a\n\x11InferenceResponse\x12\x12\n\nprediction\x18\x01 \x01(\x05\x12\r\n\x05score\x18\x02 \x01(\x01\x12\x12\n\nmodel_name\x18\x03 \x01(\t\x12\x15\n\rmodel_version\x18\x04 \x01(\t\"B\n\x15InferenceBatchRequest\x12)\n\x08requests\x18\x01 \x03(\x0b\x32\x17.proto.InferenceRequest\"E\n\x16InferenceBatchResponse\x12+\n\tresponses\x18\x01 \x03(\x0b\x32\x18.proto.InferenceResponse2\x96\x01\n\tInference\x12<\n\x07Predict\x12\x17.proto.InferenceRequest\x1a\x18.proto.InferenceResponse\x12K\n\x0cPredictBatch\x12\x1c.proto.InferenceBatchRequest\x1a\x1d.proto.InferenceBatchResponseB+Z)github.com/ANGEL0CADUTO/IDS_project/protob\x06proto3')
 
 _globals = globals()
 _builder.BuildMessageAndEnumDescriptors(DESCRIPTOR, _globals)
@@ -35,7 +35,11 @@ if not _descriptor._USE_C_DESCRIPTORS:
   _globals['_INFERENCEREQUEST']._serialized_start=26
   _globals['_INFERENCEREQUEST']._serialized_end=62
   _globals['_INFERENCERESPONSE']._serialized_start=64
-  _globals['_INFERENCERESPONSE']._serialized_end=103
-  _globals['_INFERENCE']._serialized_start=105
-  _globals['_INFERENCE']._serialized_end=178
+  _globals['_INFERENCERESPONSE']._serialized_end=161
+  _globals['_INFERENCEBATCHREQUEST']._serialized_start=163
+  _globals['_INFERENCEBATCHREQUEST']._serialized_end=229
+  _globals['_INFERENCEBATCHRESPONSE']._serialized_start=231
+  _globals['_INFERENCEBATCHRESPONSE']._serialized_end=300
+  _globals['_INFERENCE']._serialized_start=303
+  _globals['_INFERENCE']._serialized_end=453
 # @@protoc_insertion_point(module_scope)
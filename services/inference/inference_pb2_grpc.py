@@ -40,6 +40,11 @@ class InferenceStub(object):
                 request_serializer=inference__pb2.InferenceRequest.SerializeToString,
                 response_deserializer=inference__pb2.InferenceResponse.FromString,
                 _registered_method=True)
+        self.PredictBatch = channel.unary_unary(
+                '/proto.Inference/PredictBatch',
+                request_serializer=inference__pb2.InferenceBatchRequest.SerializeToString,
+                response_deserializer=inference__pb2.InferenceBatchResponse.FromString,
+                _registered_method=True)
 
 
 class InferenceServicer(object):
@@ -53,6 +58,13 @@ class InferenceServicer(object):
         context.set_details('Method not implemented!')
         raise NotImplementedError('Method not implemented!')
 
+    def PredictBatch(self, request, context):
+        """RPC per ottenere le predizioni di più set di feature con una sola chiamata
+        """
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
 
 def add_InferenceServicer_to_server(servicer, server):
     rpc_method_handlers = {
@@ -61,6 +73,11 @@ def add_InferenceServicer_to_server(servicer, server):
                     request_deserializer=inference__pb2.InferenceRequest.FromString,
                     response_serializer=inference__pb2.InferenceResponse.SerializeToString,
             ),
+            'PredictBatch': grpc.unary_unary_rpc_method_handler(
+                    servicer.PredictBatch,
+                    request_deserializer=inference__pb2.InferenceBatchRequest.FromString,
+                    response_serializer=inference__pb2.InferenceBatchResponse.SerializeToString,
+            ),
     }
     generic_handler = grpc.method_handlers_generic_handler(
             'proto.Inference', rpc_method_handlers)
@@ -99,3 +116,30 @@ class Inference(object):
             timeout,
             metadata,
             _registered_method=True)
+
+    @staticmethod
+    def PredictBatch(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(
+            request,
+            target,
+            '/proto.Inference/PredictBatch',
+            inference__pb2.InferenceBatchRequest.SerializeToString,
+            inference__pb2.InferenceBatchResponse.FromString,
+            options,
+            channel_credentials,
+            insecure,
+            call_credentials,
+            compression,
+            wait_for_ready,
+            timeout,
+            metadata,
+            _registered_method=True)
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+)
+
+// Backend è il database in cui il servizio salva e da cui rilegge metriche e allarmi.
+// Le RPC gestiscono validazione, paginazione e stream; il backend solo la persistenza.
+type Backend interface {
+	WriteMetric(ctx context.Context, m *pb.Metric) error
+	WriteAlarm(ctx context.Context, a *pb.Alarm) error
+	// QueryAlarms restituisce fino a q.Limit allarmi ordinati per (timestamp, client, regola,
+	// metrica scatenante), dal più recente se q.Desc.
+	QueryAlarms(ctx context.Context, q alarmQuery) ([]*pb.Alarm, error)
+	// QueryMetrics restituisce fino a q.Limit metriche ordinate per (timestamp, client, tipo).
+	QueryMetrics(ctx context.Context, q metricQuery) ([]*pb.Metric, error)
+	// Close scrive i dati ancora in sospeso e rilascia le risorse.
+	Close() error
+}
+
+// Backend disponibili, selezionati con STORAGE_BACKEND.
+const (
+	backendInfluxDB = "influxdb"
+	backendEmbedded = "embedded"
+)
+
+// Modalità di durabilità delle scritture, selezionate con STORAGE_DURABILITY.
+// Con "confirmed" StoreMetric e StoreAlarm rispondono solo dopo la conferma del backend;
+// con "async" InfluxDB accoda i punti e li scrive a lotti, senza riportare gli errori al chiamante.
+// Il backend embedded scrive sempre in modo sincrono.
+const (
+	durabilityConfirmed = "confirmed"
+	durabilityAsync     = "async"
+)
+
+// seekKey è la posizione di un elemento nell'ordine di una query: una query con After restituisce
+// solo gli elementi che lo seguono. Con Key vuota contano solo i timestamp successivi a Time.
+type seekKey struct {
+	Time time.Time
+	Key  []string
+}
+
+// timeBounds è un intervallo [Start, End); End zero indica nessun limite superiore.
+type timeBounds struct {
+	Start, End time.Time
+}
+
+func (b timeBounds) contains(t time.Time) bool {
+	return !t.Before(b.Start) && (b.End.IsZero() || t.Before(b.End))
+}
+
+type alarmQuery struct {
+	ClientID, RuleID, Severity string // Vuoti per non filtrare
+	Range                      timeBounds
+	After                      *seekKey
+	Desc                       bool
+	Limit                      int
+}
+
+type metricQuery struct {
+	ClientID, Type string
+	Range          timeBounds
+	After          *seekKey
+	Limit          int
+}
+
+// alarmSortKey e metricSortKey sono le chiavi di ordinamento a parità di timestamp. Sono anche
+// l'identità dell'elemento nel backend: il metric_id (della metrica scatenante, per gli allarmi)
+// distingue gli elementi dello stesso client e tipo o regola nello stesso secondo, e un elemento
+// consegnato di nuovo sostituisce quello già salvato.
+func alarmSortKey(a *pb.Alarm) []string {
+	return []string{a.ClientId, a.RuleId, a.TriggerMetric.GetMetricId()}
+}
+func metricSortKey(m *pb.Metric) []string { return []string{m.SourceClientId, m.Type, m.MetricId} }
+
+// follows indica se l'elemento (t, key) segue la posizione s nell'ordine crescente
+// (o decrescente se desc) di timestamp e chiave.
+func (s *seekKey) follows(t time.Time, key []string, desc bool) bool {
+	if !t.Equal(s.Time) {
+		return t.After(s.Time) != desc
+	}
+	for i := range s.Key {
+		if c := strings.Compare(key[i], s.Key[i]); c != 0 {
+			return (c > 0) != desc
+		}
+	}
+	return false
+}
+
+// newBackend crea il backend indicato dalla configurazione.
+func newBackend(kind string) (Backend, error) {
+	switch kind {
+	case backendInfluxDB:
+		return newInfluxBackend(influxURL, influxToken, influxOrg, influxBucket, influxAlarmsBucket, storageDurability == durabilityConfirmed), nil
+	case backendEmbedded:
+		return openEmbeddedBackend(getEnv("STORAGE_PATH", "storage.db"))
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (expected %s or %s)", kind, backendInfluxDB, backendEmbedded)
+	}
+}
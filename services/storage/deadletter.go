@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Le scritture che il backend rifiuta finiscono in un file locale (dead-letter), una riga JSON per
+// elemento con il messaggio protobuf originale, e vengono ritentate periodicamente finché il backend
+// non le accetta. Ritentare è sicuro: metriche e allarmi hanno una chiave (timestamp, client, tipo o
+// regola) e una seconda scrittura della stessa chiave sostituisce la prima.
+//
+// Durante un tentativo il file viene rinominato (suffisso .retrying), così le nuove scritture fallite
+// continuano ad accodarsi senza attendere il backend. Se lo storage si ferma a metà tentativo,
+// il file rinominato viene ripreso al tentativo successivo.
+
+// Tipi di elemento nel dead-letter.
+const (
+	deadLetterMetric = "metric"
+	deadLetterAlarm  = "alarm"
+)
+
+// deadLetterWriteTimeout limita ogni scrittura ritentata.
+const deadLetterWriteTimeout = 10 * time.Second
+
+// deadLetter è una scrittura fallita in attesa di essere ritentata.
+type deadLetter struct {
+	Kind    string    `json:"kind"`
+	Key     string    `json:"key,omitempty"` // Chiave di idempotenza della richiesta originale
+	Payload []byte    `json:"payload"`       // Messaggio protobuf serializzato
+	Failed  time.Time `json:"failed"`
+	Error   string    `json:"error"`
+}
+
+// message restituisce il messaggio protobuf salvato nell'elemento.
+func (d deadLetter) message() (proto.Message, error) {
+	var msg proto.Message
+	switch d.Kind {
+	case deadLetterMetric:
+		msg = &pb.Metric{}
+	case deadLetterAlarm:
+		msg = &pb.Alarm{}
+	default:
+		return nil, fmt.Errorf("unknown dead-letter kind %q", d.Kind)
+	}
+	if err := proto.Unmarshal(d.Payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+type deadLetterQueue struct {
+	path string
+	mu   sync.Mutex // Protegge il file principale
+}
+
+func openDeadLetterQueue(path string) (*deadLetterQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create dead-letter directory: %w", err)
+	}
+	return &deadLetterQueue{path: path}, nil
+}
+
+func (q *deadLetterQueue) retryingPath() string { return q.path + ".retrying" }
+
+// add accoda una scrittura fallita. Il file è sincronizzato su disco prima di tornare.
+func (q *deadLetterQueue) add(kind string, msg proto.Message, key string, cause error) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return appendDeadLetters(q.path, []deadLetter{{Kind: kind, Key: key, Payload: payload, Failed: time.Now().UTC(), Error: cause.Error()}})
+}
+
+func appendDeadLetters(path string, letters []deadLetter) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, d := range letters {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// drain ritenta in ordine gli elementi accodati con write. Al primo errore si ferma, perché il backend
+// è con ogni probabilità ancora irraggiungibile, e rimette in coda quelli non scritti.
+func (q *deadLetterQueue) drain(write func(deadLetter) error) (written, remaining int, err error) {
+	retrying := q.retryingPath()
+	q.mu.Lock()
+	if _, statErr := os.Stat(retrying); errors.Is(statErr, os.ErrNotExist) {
+		// Nessun tentativo interrotto da riprendere: si prende in carico il file principale
+		if err := os.Rename(q.path, retrying); err != nil {
+			q.mu.Unlock()
+			if errors.Is(err, os.ErrNotExist) {
+				return 0, 0, nil
+			}
+			return 0, 0, err
+		}
+	}
+	q.mu.Unlock()
+
+	letters, err := readDeadLetters(retrying)
+	if err != nil {
+		return 0, 0, err
+	}
+	var pending []deadLetter
+	for i, d := range letters {
+		if err := write(d); err != nil {
+			pending = letters[i:]
+			break
+		}
+		written++
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(pending) > 0 {
+		if err := appendDeadLetters(q.path, pending); err != nil {
+			return written, len(pending), err
+		}
+	}
+	return written, len(pending), os.Remove(retrying)
+}
+
+func readDeadLetters(path string) ([]deadLetter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var d deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			// Riga troncata da un arresto durante la scrittura: non è recuperabile
+			log.Printf("WARNING: skipping malformed dead-letter entry in %s: %v", path, err)
+			continue
+		}
+		letters = append(letters, d)
+	}
+	return letters, scanner.Err()
+}
+
+// deadLetterError registra la scrittura fallita nel dead-letter e restituisce l'errore per il chiamante.
+func (s *server) deadLetterError(ctx context.Context, kind string, msg proto.Message, key string, cause error) error {
+	if s.deadLetters == nil {
+		return writeError(ctx, cause, false)
+	}
+	if err := s.deadLetters.add(kind, msg, key, cause); err != nil {
+		log.Printf("ERROR: could not queue failed %s write in dead-letter file: %v", kind, err)
+		return writeError(ctx, cause, false)
+	}
+	return writeError(ctx, cause, true)
+}
+
+// replayDeadLetter scrive di nuovo un elemento del dead-letter. Gli allarmi scritti ora vengono
+// anche inoltrati agli stream, che non li avevano ricevuti quando la scrittura era fallita.
+func (s *server) replayDeadLetter(ctx context.Context, d deadLetter) error {
+	msg, err := d.message()
+	if err != nil {
+		log.Printf("WARNING: dropping unreadable dead-letter entry: %v", err)
+		return nil
+	}
+	if s.applied.Seen(d.Key) {
+		return nil // Nel frattempo il client l'ha consegnato di nuovo con successo
+	}
+	switch m := msg.(type) {
+	case *pb.Metric:
+		if s.storedMetrics.Seen(m.MetricId) {
+			return nil
+		}
+		if err = s.backend.WriteMetric(ctx, m); err == nil {
+			s.storedMetrics.Finish(m.MetricId)
+		}
+	case *pb.Alarm:
+		if err = s.backend.WriteAlarm(ctx, m); err == nil {
+			s.publishAlarm(m)
+		}
+	}
+	if err == nil {
+		s.applied.Finish(d.Key)
+	}
+	return err
+}
+
+// retryDeadLetters ritenta le scritture fallite ogni interval, finché ctx non termina.
+func (s *server) retryDeadLetters(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		written, remaining, err := s.deadLetters.drain(func(d deadLetter) error {
+			writeCtx, cancel := context.WithTimeout(ctx, deadLetterWriteTimeout)
+			defer cancel()
+			return s.replayDeadLetter(writeCtx, d)
+		})
+		if err != nil {
+			log.Printf("ERROR: dead-letter retry failed: %v", err)
+		}
+		if written > 0 || remaining > 0 {
+			log.Printf("Dead-letter retry: %d written, %d still pending", written, remaining)
+		}
+	}
+}
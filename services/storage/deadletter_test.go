@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingBackend simula un backend irraggiungibile finché down è vero.
+type failingBackend struct {
+	Backend
+	down atomic.Bool
+}
+
+func (b *failingBackend) WriteMetric(ctx context.Context, m *pb.Metric) error {
+	if b.down.Load() {
+		return errors.New("connection refused")
+	}
+	return b.Backend.WriteMetric(ctx, m)
+}
+
+func (b *failingBackend) WriteAlarm(ctx context.Context, a *pb.Alarm) error {
+	if b.down.Load() {
+		return errors.New("connection refused")
+	}
+	return b.Backend.WriteAlarm(ctx, a)
+}
+
+func newFailingServer(t *testing.T) (*server, *failingBackend) {
+	t.Helper()
+	s := newEmbeddedServer(t)
+	backend := &failingBackend{Backend: s.backend}
+	backend.down.Store(true)
+	queue, err := openDeadLetterQueue(filepath.Join(t.TempDir(), "deadletter.jsonl"))
+	if err != nil {
+		t.Fatalf("Impossibile aprire il dead-letter: %v", err)
+	}
+	s.backend, s.deadLetters = backend, queue
+	return s, backend
+}
+
+func drainDeadLetters(t *testing.T, s *server) (written, remaining int) {
+	t.Helper()
+	written, remaining, err := s.deadLetters.drain(func(d deadLetter) error {
+		return s.replayDeadLetter(context.Background(), d)
+	})
+	if err != nil {
+		t.Fatalf("Ritentativo del dead-letter fallito: %v", err)
+	}
+	return written, remaining
+}
+
+func TestStoreAlarm_FailedWriteReturnsErrorAndIsRetried(t *testing.T) {
+	s, backend := newFailingServer(t)
+	sub, _, _ := s.alarms.subscribe(nil)
+
+	_, err := s.StoreAlarm(context.Background(), &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Una scrittura fallita doveva restituire UNAVAILABLE, ricevuto %v", err)
+	}
+	if _, err := s.StoreMetric(context.Background(), &pb.Metric{SourceClientId: "c", Type: "cpu", Timestamp: 100}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Una scrittura fallita doveva restituire UNAVAILABLE, ricevuto %v", err)
+	}
+	select {
+	case <-sub.events:
+		t.Fatalf("Un allarme non salvato non deve arrivare agli stream")
+	default:
+	}
+
+	// Backend ancora giù: gli elementi restano in coda
+	if written, remaining := drainDeadLetters(t, s); written != 0 || remaining != 2 {
+		t.Fatalf("Con il backend giù: scritti %d, in coda %d (attesi 0 e 2)", written, remaining)
+	}
+
+	backend.down.Store(false)
+	if written, remaining := drainDeadLetters(t, s); written != 2 || remaining != 0 {
+		t.Fatalf("Con il backend tornato: scritti %d, in coda %d (attesi 2 e 0)", written, remaining)
+	}
+	alarms, err := s.backend.QueryAlarms(context.Background(), alarmQuery{Limit: 10})
+	if err != nil || len(alarms) != 1 || alarms[0].RuleId != "r1" {
+		t.Fatalf("L'allarme ritentato doveva essere salvato: %v (errore %v)", alarms, err)
+	}
+	metrics, err := s.backend.QueryMetrics(context.Background(), metricQuery{Limit: 10})
+	if err != nil || len(metrics) != 1 {
+		t.Fatalf("La metrica ritentata doveva essere salvata: %v (errore %v)", metrics, err)
+	}
+	select {
+	case ev := <-sub.events:
+		if ev.alarm.RuleId != "r1" {
+			t.Errorf("Inoltrato l'allarme sbagliato: %+v", ev.alarm)
+		}
+	default:
+		t.Errorf("L'allarme salvato al ritentativo doveva arrivare agli stream")
+	}
+	if written, remaining := drainDeadLetters(t, s); written != 0 || remaining != 0 {
+		t.Errorf("Il dead-letter doveva essere vuoto: scritti %d, in coda %d", written, remaining)
+	}
+}
+
+func TestDeadLetterQueue_ResumesInterruptedRetry(t *testing.T) {
+	s, backend := newFailingServer(t)
+	s.StoreAlarm(context.Background(), &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100})
+	s.StoreAlarm(context.Background(), &pb.Alarm{ClientId: "c", RuleId: "r2", Timestamp: 200})
+
+	// Arresto a metà di un ritentativo: gli elementi sono già nel file .retrying
+	if err := os.Rename(s.deadLetters.path, s.deadLetters.retryingPath()); err != nil {
+		t.Fatalf("Impossibile simulare il ritentativo interrotto: %v", err)
+	}
+
+	backend.down.Store(false)
+	s.StoreAlarm(context.Background(), &pb.Alarm{ClientId: "c", RuleId: "r3", Timestamp: 300})
+	if written, _ := drainDeadLetters(t, s); written != 2 {
+		t.Errorf("Il primo ritentativo doveva riprendere i 2 allarmi interrotti, scritti %d", written)
+	}
+	alarms, err := s.backend.QueryAlarms(context.Background(), alarmQuery{Limit: 10})
+	if err != nil || len(alarms) != 3 {
+		t.Errorf("Attesi 3 allarmi dopo la ripresa del ritentativo, trovati %d (errore %v)", len(alarms), err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+)
+
+// embeddedBackend salva metriche e allarmi in un file locale (bbolt), senza servizi esterni:
+// pensato per installazioni piccole e per i test. Ogni elemento è il messaggio protobuf serializzato,
+// quindi viene riletto esattamente come è stato ricevuto. Le chiavi sono ordinate per timestamp e
+// poi per la chiave di ordinamento, come le query: le scansioni partono dall'intervallo richiesto,
+// mentre i filtri su client, regola e gravità si applicano leggendo.
+
+var (
+	embeddedMetricsBucket = []byte("metrics")
+	embeddedAlarmsBucket  = []byte("alarms")
+)
+
+type embeddedBackend struct {
+	db *bolt.DB
+}
+
+func openEmbeddedBackend(path string) (*embeddedBackend, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open embedded storage %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{embeddedMetricsBucket, embeddedAlarmsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize embedded storage %s: %w", path, err)
+	}
+	return &embeddedBackend{db: db}, nil
+}
+
+// timeKey codifica un istante in modo che l'ordine dei byte segua quello temporale, anche prima del 1970.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano())^(1<<63))
+	return key
+}
+
+// embeddedKey è la chiave di un elemento: timestamp seguito dalla chiave di ordinamento (che
+// termina con il metric_id della metrica o della metrica scatenante dell'allarme). Come in InfluxDB, un elemento con la stessa chiave sostituisce
+// il precedente.
+func embeddedKey(t time.Time, sortKey []string) []byte {
+	key := timeKey(t)
+	for _, part := range sortKey {
+		key = append(key, part...)
+		key = append(key, 0) // Separatore: "a" precede "ab" come nel confronto tra stringhe
+	}
+	return key
+}
+
+func (b *embeddedBackend) put(bucket []byte, key []byte, msg proto.Message) error {
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+func (b *embeddedBackend) WriteMetric(ctx context.Context, m *pb.Metric) error {
+	return b.put(embeddedMetricsBucket, embeddedKey(time.Unix(m.Timestamp, 0), metricSortKey(m)), m)
+}
+
+func (b *embeddedBackend) WriteAlarm(ctx context.Context, a *pb.Alarm) error {
+	return b.put(embeddedAlarmsBucket, embeddedKey(time.Unix(a.Timestamp, 0), alarmSortKey(a)), a)
+}
+
+func (b *embeddedBackend) Close() error {
+	return b.db.Close()
+}
+
+// scan visita in ordine gli elementi del bucket nell'intervallo, finché visit restituisce true.
+func (b *embeddedBackend) scan(bucket []byte, bounds timeBounds, desc bool, visit func(value []byte) (bool, error)) error {
+	start := timeKey(bounds.Start)
+	var end []byte
+	if !bounds.End.IsZero() {
+		end = timeKey(bounds.End)
+	}
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		var k, v []byte
+		if desc {
+			if end == nil {
+				k, v = c.Last()
+			} else if k, v = c.Seek(end); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil; k, v = step(c, desc) {
+			if bytes.Compare(k, start) < 0 {
+				if desc {
+					return nil
+				}
+				continue
+			}
+			if end != nil && bytes.Compare(k, end) >= 0 {
+				if desc {
+					continue
+				}
+				return nil
+			}
+			more, err := visit(v)
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func step(c *bolt.Cursor, desc bool) ([]byte, []byte) {
+	if desc {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+func (b *embeddedBackend) QueryAlarms(ctx context.Context, q alarmQuery) ([]*pb.Alarm, error) {
+	var alarms []*pb.Alarm
+	err := b.scan(embeddedAlarmsBucket, q.Range, q.Desc, func(value []byte) (bool, error) {
+		a := &pb.Alarm{}
+		if err := proto.Unmarshal(value, a); err != nil {
+			return false, err
+		}
+		if (q.ClientID != "" && a.ClientId != q.ClientID) || (q.RuleID != "" && a.RuleId != q.RuleID) ||
+			(q.Severity != "" && a.Severity != q.Severity) {
+			return true, nil
+		}
+		if q.After != nil && !q.After.follows(time.Unix(a.Timestamp, 0), alarmSortKey(a), q.Desc) {
+			return true, nil
+		}
+		alarms = append(alarms, a)
+		return len(alarms) < q.Limit, ctx.Err()
+	})
+	return alarms, err
+}
+
+func (b *embeddedBackend) QueryMetrics(ctx context.Context, q metricQuery) ([]*pb.Metric, error) {
+	var metrics []*pb.Metric
+	err := b.scan(embeddedMetricsBucket, q.Range, false, func(value []byte) (bool, error) {
+		m := &pb.Metric{}
+		if err := proto.Unmarshal(value, m); err != nil {
+			return false, err
+		}
+		if (q.ClientID != "" && m.SourceClientId != q.ClientID) || (q.Type != "" && m.Type != q.Type) {
+			return true, nil
+		}
+		if q.After != nil && !q.After.follows(time.Unix(m.Timestamp, 0), metricSortKey(m), false) {
+			return true, nil
+		}
+		metrics = append(metrics, m)
+		return len(metrics) < q.Limit, ctx.Err()
+	})
+	return metrics, err
+}
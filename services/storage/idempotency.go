@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// idempotencyHeader è l'header gRPC con cui i client (l'outbox dell'analisi) identificano una
+// scrittura: le consegne ripetute con la stessa chiave vengono confermate senza scrivere di nuovo,
+// così un allarme ritentato non compare due volte negli stream di WatchAlarms.
+const idempotencyHeader = "idempotency-key"
+
+// idempotencyMaxKeys limita la memoria usata per le chiavi (e per i metric_id) anche con molte
+// scritture nella finestra. Le chiavi sono in memoria: dopo un riavvio una consegna ripetuta viene
+// scritta di nuovo, ma sostituisce l'elemento con la stessa chiave di ordinamento invece di duplicarlo.
+const idempotencyMaxKeys = 100000
+
+// idempotencyKey restituisce la chiave della richiesta, vuota se il client non l'ha inviata.
+func idempotencyKey(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, idempotencyHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/dedup"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestStoreAlarm_DuplicateDeliveryIsNotPublishedTwice(t *testing.T) {
+	s := newEmbeddedServer(t)
+	s.applied = dedup.NewWindow(time.Minute, 10)
+	sub, _, _ := s.alarms.subscribe(nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyHeader, "k1"))
+	alarm := &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100}
+	for i := 0; i < 2; i++ {
+		if resp, err := s.StoreAlarm(ctx, alarm); err != nil || !resp.Success {
+			t.Fatalf("Consegna %d fallita: %v", i+1, err)
+		}
+	}
+	if len(sub.events) != 1 {
+		t.Errorf("Una consegna ripetuta con la stessa chiave doveva essere inoltrata una sola volta, inoltrate %d", len(sub.events))
+	}
+
+	// Senza chiave ogni consegna è una scrittura nuova
+	storeAlarms(t, s, alarm, alarm)
+	if len(sub.events) != 3 {
+		t.Errorf("Le consegne senza chiave non devono essere deduplicate, inoltrate %d", len(sub.events))
+	}
+}
+
+func TestStoreMetric_DuplicateMetricIDIsNotWrittenTwice(t *testing.T) {
+	s := newEmbeddedServer(t)
+	backend := &failingBackend{Backend: s.backend}
+	s.backend = backend
+	s.storedMetrics = dedup.NewWindow(time.Minute, 10)
+
+	metric := &pb.Metric{MetricId: "m1", SourceClientId: "c", Type: "cpu", Timestamp: 100}
+	if _, err := s.StoreMetric(context.Background(), metric); err != nil {
+		t.Fatalf("StoreMetric fallita: %v", err)
+	}
+	// Con il backend giù una ripetizione riconosciuta non arriva nemmeno al backend
+	backend.down.Store(true)
+	resp, err := s.StoreMetric(context.Background(), metric)
+	if err != nil || !resp.Success {
+		t.Errorf("Una metrica con un metric_id già salvato doveva essere confermata senza scriverla: %v", err)
+	}
+	if _, err := s.StoreMetric(context.Background(), &pb.Metric{MetricId: "m2", SourceClientId: "c", Type: "cpu", Timestamp: 100}); err == nil {
+		t.Errorf("Una metrica con un metric_id nuovo doveva essere scritta (e fallire con il backend giù)")
+	}
+}
+
+func TestStoreMetric_MetricIDIsPartOfTheStoredKey(t *testing.T) {
+	// Senza finestre di deduplica, come dopo un riavvio: conta solo il record salvato
+	s := newEmbeddedServer(t)
+	first := &pb.Metric{MetricId: "m1", SourceClientId: "c", Type: "cpu", Timestamp: 100, Value: 1}
+	second := &pb.Metric{MetricId: "m2", SourceClientId: "c", Type: "cpu", Timestamp: 100, Value: 2}
+	for _, m := range []*pb.Metric{first, second, first} {
+		if _, err := s.StoreMetric(context.Background(), m); err != nil {
+			t.Fatalf("StoreMetric fallita: %v", err)
+		}
+	}
+
+	// Pagine da un elemento: il cursore distingue le metriche dello stesso secondo
+	var ids []string
+	req := &pb.QueryMetricsRequest{ClientId: "c", PageSize: 1}
+	for {
+		resp, err := s.QueryMetrics(context.Background(), req)
+		if err != nil {
+			t.Fatalf("QueryMetrics fallita: %v", err)
+		}
+		for _, m := range resp.Metrics {
+			ids = append(ids, m.MetricId)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(ids) != 2 || ids[0] != "m1" || ids[1] != "m2" {
+		t.Errorf("Attese le metriche m1 e m2 una volta ciascuna, ottenute %v", ids)
+	}
+}
+
+func TestStoreAlarm_TriggerMetricIsPartOfTheStoredKey(t *testing.T) {
+	s := newEmbeddedServer(t)
+	burst := func(metricID string) *pb.Alarm {
+		return &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100, TriggerMetric: &pb.Metric{MetricId: metricID, SourceClientId: "c", Timestamp: 100}}
+	}
+	// Tre allarmi della stessa regola nello stesso secondo, l'ultimo consegnato di nuovo
+	storeAlarms(t, s, burst("m1"), burst("m2"), burst("m3"), burst("m3"))
+
+	var ids []string
+	req := &pb.ListAlarmsRequest{ClientId: "c", PageSize: 1}
+	for {
+		resp, err := s.ListAlarms(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ListAlarms fallita: %v", err)
+		}
+		for _, a := range resp.Alarms {
+			ids = append(ids, a.TriggerMetric.GetMetricId())
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(ids) != 3 || ids[0] != "m3" || ids[1] != "m2" || ids[2] != "m1" {
+		t.Errorf("Attesi gli allarmi di m3, m2 e m1 una volta ciascuno, ottenuti %v", ids)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/query"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// rawFeaturesField è il campo con il vettore codificato da features.EncodeRaw.
+const rawFeaturesField = "features_raw"
+
+// Colonne Flux delle chiavi di ordinamento (vedi alarmSortKey e metricSortKey).
+var (
+	alarmSortColumns  = []string{"client_id", "rule_id", "trigger_metric_id"}
+	metricSortColumns = []string{"client_id", "_measurement", "metric_id"}
+)
+
+// influxBackend salva metriche e allarmi in due bucket di InfluxDB: ogni metrica o allarme è un
+// punto, con client, regola e gravità come TAG e il resto come CAMPI. Le query usano Flux.
+//
+// Con durabilità confermata ogni punto è scritto con l'API bloccante e l'esito torna al chiamante;
+// con durabilità asincrona i punti passano dal buffer del client e gli errori finiscono solo nel log.
+type influxBackend struct {
+	client influxdb2.Client
+	// Valorizzate solo con durabilità confermata
+	confirmedMetrics api.WriteAPIBlocking
+	confirmedAlarms  api.WriteAPIBlocking
+	// Valorizzate solo con durabilità asincrona
+	writeMetrics  api.WriteAPI
+	writeAlarms   api.WriteAPI
+	queryAPI      api.QueryAPI
+	metricsBucket string
+	alarmsBucket  string
+}
+
+func newInfluxBackend(url, token, org, metricsBucket, alarmsBucket string, confirmed bool) *influxBackend {
+	client := influxdb2.NewClient(url, token)
+	b := &influxBackend{
+		client:        client,
+		queryAPI:      client.QueryAPI(org),
+		metricsBucket: metricsBucket,
+		alarmsBucket:  alarmsBucket,
+	}
+	if confirmed {
+		b.confirmedMetrics = client.WriteAPIBlocking(org, metricsBucket)
+		b.confirmedAlarms = client.WriteAPIBlocking(org, alarmsBucket)
+		return b
+	}
+
+	b.writeMetrics = client.WriteAPI(org, metricsBucket)
+	b.writeAlarms = client.WriteAPI(org, alarmsBucket)
+	go func() {
+		for err := range b.writeMetrics.Errors() {
+			log.Printf("InfluxDB write error: %s\n", err.Error())
+		}
+	}()
+	go func() {
+		for err := range b.writeAlarms.Errors() {
+			log.Printf("InfluxDB (alarms) write error: %s\n", err.Error())
+		}
+	}()
+	return b
+}
+
+// metricPointTime è l'istante del punto di una metrica: il suo timestamp più una frazione di
+// secondo derivata dal metric_id. Un punto è identificato da serie e istante, quindi due metriche
+// dello stesso client e tipo nello stesso secondo restano distinte, mentre la stessa metrica
+// consegnata di nuovo (anche dopo un riavvio) sostituisce il punto già scritto.
+// Le query troncano l'istante al secondo: l'ordine resta quello di metricSortKey.
+func metricPointTime(m *pb.Metric) time.Time {
+	return pointTime(m.Timestamp, m.MetricId)
+}
+
+// alarmPointTime è l'istante del punto di un allarme, con la frazione di secondo derivata dal
+// metric_id della metrica scatenante: una raffica di allarmi della stessa regola e dello stesso
+// client nello stesso secondo non si sovrascrive (vedi metricPointTime).
+func alarmPointTime(a *pb.Alarm) time.Time {
+	return pointTime(a.Timestamp, a.TriggerMetric.GetMetricId())
+}
+
+func pointTime(timestamp int64, id string) time.Time {
+	if id == "" {
+		return time.Unix(timestamp, 0)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return time.Unix(timestamp, int64(h.Sum64()%uint64(time.Second)))
+}
+
+func (b *influxBackend) WriteMetric(ctx context.Context, in *pb.Metric) error {
+	// Creiamo UN SOLO punto per la metrica, identificato dal suo istante (timestamp e metric_id) e client_id
+	p := influxdb2.NewPointWithMeasurement(in.Type).
+		AddTag("client_id", in.SourceClientId).
+		SetTime(metricPointTime(in))
+
+	// Controlliamo se la metrica ha le feature complete
+	if len(in.Features) == features.NumFeatures {
+		addFeatureFields(p, "", in.Features)
+	} else {
+		// Fallback per le metriche semplici senza le 41 feature
+		p.AddField("value", in.Value)
+	}
+	// L'ID è un campo e non un TAG: un TAG per metrica moltiplicherebbe le serie (vedi metricPointTime)
+	if in.MetricId != "" {
+		p.AddField("metric_id", in.MetricId)
+	}
+
+	// Scriviamo il punto singolo (che ora contiene più campi)
+	return writePoint(ctx, b.confirmedMetrics, b.writeMetrics, p)
+}
+
+func (b *influxBackend) WriteAlarm(ctx context.Context, in *pb.Alarm) error {
+	// Creiamo UN SOLO punto per l'allarme
+	p := influxdb2.NewPointWithMeasurement("alarm").
+		AddTag("rule_id", in.RuleId). // Aggiungiamo la regola come TAG per poter raggruppare!
+		AddTag("client_id", in.ClientId).
+		SetTime(alarmPointTime(in))
+	if in.Severity != "" {
+		p.AddTag("severity", in.Severity)
+	}
+
+	// Aggiungiamo i dettagli dell'allarme come CAMPI
+	p.AddField("description", in.Description)
+	// Il punteggio del modello permette di ordinare gli allarmi; il modello che l'ha prodotto è un TAG
+	if in.Score != nil {
+		p.AddField("score", *in.Score)
+	}
+	if in.ModelName != "" {
+		p.AddTag("model_name", in.ModelName)
+	}
+	if in.ModelVersion != "" {
+		p.AddTag("model_version", in.ModelVersion)
+	}
+
+	// Se l'allarme contiene la metrica che l'ha scatenato, salviamo anche le sue feature
+	if in.TriggerMetric != nil && len(in.TriggerMetric.Features) == features.NumFeatures {
+		addFeatureFields(p, "trigger_", in.TriggerMetric.Features)
+	} else if in.TriggerMetric != nil {
+		p.AddField("trigger_value", in.TriggerMetric.Value)
+	}
+	if in.TriggerMetric.GetMetricId() != "" {
+		p.AddField("trigger_metric_id", in.TriggerMetric.MetricId)
+	}
+
+	// Scriviamo il punto singolo nel bucket degli allarmi
+	return writePoint(ctx, b.confirmedAlarms, b.writeAlarms, p)
+}
+
+// writePoint scrive il punto con l'API bloccante se presente, altrimenti lo accoda a quella asincrona.
+func writePoint(ctx context.Context, confirmed api.WriteAPIBlocking, async api.WriteAPI, p *write.Point) error {
+	if confirmed != nil {
+		return confirmed.WritePoint(ctx, p)
+	}
+	async.WritePoint(p)
+	return nil
+}
+
+func (b *influxBackend) Close() error {
+	if b.writeMetrics != nil {
+		b.writeMetrics.Flush()
+		b.writeAlarms.Flush()
+	}
+	b.client.Close()
+	return nil
+}
+
+// addFeatureFields aggiunge al punto tutte le feature dello schema, ognuna come CAMPO con il
+// proprio nome NSL-KDD (preceduto da prefix). Le feature categoriche restano codici numerici:
+// il TAG vocabulary indica con quale versione del vocabolario decodificarle.
+func addFeatureFields(p *write.Point, prefix string, values []float32) {
+	p.AddTag(prefix+"vocabulary", features.Default().Version)
+	for i, name := range features.Names {
+		p.AddField(prefix+name, float64(values[i]))
+	}
+	// I float64 di InfluxDB rappresentano già ogni float32, ma il campo raw conserva il vettore
+	// così come è arrivato e si rilegge con una sola query (features.DecodeRaw)
+	if storeRawFeatures {
+		p.AddField(prefix+rawFeaturesField, features.EncodeRaw(values))
+	}
+}
+
+func (b *influxBackend) QueryAlarms(ctx context.Context, q alarmQuery) ([]*pb.Alarm, error) {
+	filters := fluxEquals(map[string]string{"_measurement": "alarm", "client_id": q.ClientID, "rule_id": q.RuleID, "severity": q.Severity})
+	records, err := b.runQuery(ctx, pageQuery(b.alarmsBucket, fluxRange(q.Range), filters, fluxSeek(q.After, alarmSortColumns, q.Desc), alarmSortColumns, q.Desc, q.Limit))
+	if err != nil {
+		return nil, err
+	}
+	alarms := make([]*pb.Alarm, len(records))
+	for i, r := range records {
+		alarms[i] = alarmFromRecord(r)
+	}
+	return alarms, nil
+}
+
+func (b *influxBackend) QueryMetrics(ctx context.Context, q metricQuery) ([]*pb.Metric, error) {
+	filters := fluxEquals(map[string]string{"_measurement": q.Type, "client_id": q.ClientID})
+	records, err := b.runQuery(ctx, pageQuery(b.metricsBucket, fluxRange(q.Range), filters, fluxSeek(q.After, metricSortColumns, false), metricSortColumns, false, q.Limit))
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]*pb.Metric, len(records))
+	for i, r := range records {
+		metrics[i] = metricFromRecord(r)
+	}
+	return metrics, nil
+}
+
+// fluxString restituisce s come stringa letterale Flux.
+func fluxString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// fluxRange restituisce la clausola range per l'intervallo richiesto.
+func fluxRange(r timeBounds) string {
+	clause := "range(start: " + fluxTime(r.Start)
+	if !r.End.IsZero() {
+		clause += ", stop: " + fluxTime(r.End)
+	}
+	return clause + ")"
+}
+
+// fluxEquals restituisce il filtro di uguaglianza per le colonne con un valore richiesto.
+func fluxEquals(columns map[string]string) string {
+	names := make([]string, 0, len(columns))
+	for name, value := range columns {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var conds []string
+	for _, name := range names {
+		conds = append(conds, fmt.Sprintf(`r[%s] == %s`, fluxString(name), fluxString(columns[name])))
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return "|> filter(fn: (r) => " + strings.Join(conds, " and ") + ")\n"
+}
+
+// fluxSeek restituisce la condizione Flux sui record che seguono after nell'ordine
+// (_time, columns...), crescente o decrescente.
+func fluxSeek(after *seekKey, columns []string, desc bool) string {
+	if after == nil {
+		return ""
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	cond := ""
+	// Si costruisce dall'ultima colonna verso la prima: c1 > v1 or (c1 == v1 and (c2 > v2 ...))
+	for i := len(after.Key) - 1; i >= 0; i-- {
+		col, val := fmt.Sprintf("r[%s]", fluxString(columns[i])), fluxString(after.Key[i])
+		next := fmt.Sprintf("%s %s %s", col, op, val)
+		if cond != "" {
+			next = fmt.Sprintf("%s or (%s == %s and (%s))", next, col, val, cond)
+		}
+		cond = next
+	}
+	timeCond := fmt.Sprintf("r._time %s %s", op, fluxTime(after.Time))
+	if cond == "" {
+		return timeCond
+	}
+	return fmt.Sprintf("%s or (r._time == %s and (%s))", timeCond, fluxTime(after.Time), cond)
+}
+
+// pageQuery compone la query di una pagina: filtri, pivot dei campi in colonne, cursore,
+// ordinamento e limite. Il cursore segue il pivot perché può confrontare anche dei campi (metric_id),
+// e l'istante è troncato al secondo come il timestamp degli elementi (vedi metricPointTime).
+func pageQuery(bucket, rangeClause, filters, seek string, sortColumns []string, desc bool, limit int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "from(bucket: %s)\n|> %s\n%s", fluxString(bucket), rangeClause, filters)
+	columns := append([]string{"_time"}, sortColumns...)
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = fluxString(c)
+	}
+	b.WriteString(`|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")` + "\n")
+	b.WriteString("|> truncateTimeColumn(unit: 1s)\n")
+	b.WriteString("|> group()\n")
+	if seek != "" {
+		fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", seek)
+	}
+	fmt.Fprintf(&b, "|> sort(columns: [%s], desc: %t)\n", strings.Join(quoted, ", "), desc)
+	fmt.Fprintf(&b, "|> limit(n: %d)\n", limit)
+	return b.String()
+}
+
+// runQuery esegue una query e restituisce i record, uno per punto.
+func (b *influxBackend) runQuery(ctx context.Context, flux string) ([]*query.FluxRecord, error) {
+	result, err := b.queryAPI.Query(ctx, flux)
+	if err != nil {
+		return nil, fmt.Errorf("influxdb query: %w", err)
+	}
+	defer result.Close()
+	var records []*query.FluxRecord
+	for result.Next() {
+		records = append(records, result.Record())
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("influxdb query: %w", err)
+	}
+	return records, nil
+}
+
+func stringValue(values map[string]interface{}, key string) string {
+	v, _ := values[key].(string)
+	return v
+}
+
+func floatValue(values map[string]interface{}, key string) (float64, bool) {
+	v, ok := values[key].(float64)
+	return v, ok
+}
+
+// featuresFromRecord ricostruisce il vettore delle feature salvato con prefix: dal campo raw se
+// presente, altrimenti dai 41 campi con i nomi dello schema. Restituisce nil se mancano.
+func featuresFromRecord(values map[string]interface{}, prefix string) []float32 {
+	if raw := stringValue(values, prefix+rawFeaturesField); raw != "" {
+		if v, err := features.DecodeRaw(raw); err == nil {
+			return v
+		}
+	}
+	v := make([]float32, features.NumFeatures)
+	for i, name := range features.Names {
+		value, ok := floatValue(values, prefix+name)
+		if !ok {
+			return nil
+		}
+		v[i] = float32(value)
+	}
+	return v
+}
+
+func metricFromRecord(r *query.FluxRecord) *pb.Metric {
+	values := r.Values()
+	metric := &pb.Metric{
+		SourceClientId: stringValue(values, "client_id"),
+		Type:           r.Measurement(),
+		Timestamp:      r.Time().Unix(),
+		Features:       featuresFromRecord(values, ""),
+		MetricId:       stringValue(values, "metric_id"),
+	}
+	metric.Value, _ = floatValue(values, "value")
+	return metric
+}
+
+func alarmFromRecord(r *query.FluxRecord) *pb.Alarm {
+	values := r.Values()
+	alarm := &pb.Alarm{
+		RuleId:       stringValue(values, "rule_id"),
+		ClientId:     stringValue(values, "client_id"),
+		Description:  stringValue(values, "description"),
+		Timestamp:    r.Time().Unix(),
+		Severity:     stringValue(values, "severity"),
+		ModelName:    stringValue(values, "model_name"),
+		ModelVersion: stringValue(values, "model_version"),
+	}
+	if score, ok := floatValue(values, "score"); ok {
+		alarm.Score = &score
+	}
+	if trigger := featuresFromRecord(values, "trigger_"); trigger != nil {
+		alarm.TriggerMetric = &pb.Metric{SourceClientId: alarm.ClientId, Timestamp: alarm.Timestamp, Features: trigger}
+	} else if value, ok := floatValue(values, "trigger_value"); ok {
+		alarm.TriggerMetric = &pb.Metric{SourceClientId: alarm.ClientId, Timestamp: alarm.Timestamp, Value: value}
+	}
+	if alarm.TriggerMetric != nil {
+		alarm.TriggerMetric.MetricId = stringValue(values, "trigger_metric_id")
+	}
+	return alarm
+}
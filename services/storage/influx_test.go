@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/features"
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"github.com/influxdata/influxdb-client-go/v2/api/query"
+)
+
+func TestFluxString_EscapesInjection(t *testing.T) {
+	got := fluxString(`a" or true or "${x}\`)
+	if want := `"a\" or true or \"\${x}\\"`; got != want {
+		t.Errorf("fluxString = %s, atteso %s", got, want)
+	}
+}
+
+func TestFluxSeek_ComparesSortKeyLexicographically(t *testing.T) {
+	at := time.Unix(10, 0)
+	got := fluxSeek(&seekKey{Time: at, Key: []string{"c", "r"}}, alarmSortColumns, true)
+	want := `r._time < 1970-01-01T00:00:10Z or (r._time == 1970-01-01T00:00:10Z and (r["client_id"] < "c" or (r["client_id"] == "c" and (r["rule_id"] < "r"))))`
+	if got != want {
+		t.Errorf("fluxSeek =\n%s\natteso\n%s", got, want)
+	}
+	if got := fluxSeek(&seekKey{Time: at}, alarmSortColumns, false); got != "r._time > 1970-01-01T00:00:10Z" {
+		t.Errorf("Senza colonne il cursore confronta solo il timestamp, ottenuto %s", got)
+	}
+}
+
+func TestMetricPointTime_DistinctPerMetricIDWithinTheSecond(t *testing.T) {
+	m1 := &pb.Metric{MetricId: "m1", Timestamp: 100}
+	m2 := &pb.Metric{MetricId: "m2", Timestamp: 100}
+	at := metricPointTime(m1)
+	if at.Unix() != 100 {
+		t.Errorf("L'istante del punto deve restare nel secondo del timestamp, ottenuto %s", at)
+	}
+	if !at.Equal(metricPointTime(&pb.Metric{MetricId: "m1", Timestamp: 100})) {
+		t.Errorf("Una metrica consegnata di nuovo deve sostituire lo stesso punto")
+	}
+	if at.Equal(metricPointTime(m2)) {
+		t.Errorf("Metriche diverse nello stesso secondo non devono sovrascriversi")
+	}
+	if !metricPointTime(&pb.Metric{Timestamp: 100}).Equal(time.Unix(100, 0)) {
+		t.Errorf("Senza metric_id il punto resta sul secondo del timestamp")
+	}
+}
+
+func TestAlarmPointTime_DistinctPerTriggerMetricWithinTheSecond(t *testing.T) {
+	alarm := func(metricID string) *pb.Alarm {
+		return &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100, TriggerMetric: &pb.Metric{MetricId: metricID}}
+	}
+	at := alarmPointTime(alarm("m1"))
+	if at.Unix() != 100 || !at.Equal(alarmPointTime(alarm("m1"))) {
+		t.Errorf("L'allarme della stessa metrica deve restare sullo stesso punto nel secondo del timestamp, ottenuto %s", at)
+	}
+	if at.Equal(alarmPointTime(alarm("m2"))) {
+		t.Errorf("Allarmi di metriche diverse nello stesso secondo non devono sovrascriversi")
+	}
+	if !alarmPointTime(&pb.Alarm{Timestamp: 100}).Equal(time.Unix(100, 0)) {
+		t.Errorf("Senza metrica scatenante il punto resta sul secondo del timestamp")
+	}
+}
+
+func TestAlarmFromRecord_RebuildsAlarmAndID(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	values := map[string]interface{}{
+		"_time": at, "_measurement": "alarm", "client_id": "attacker", "rule_id": "ml_model",
+		"severity": "high", "description": "anomalia", "score": -0.2, "model_name": "isolation_forest",
+	}
+	for i, name := range features.Names {
+		values["trigger_"+name] = float64(i)
+	}
+	alarm := withID(alarmFromRecord(query.NewFluxRecord(0, values)))
+
+	if alarm.ClientId != "attacker" || alarm.RuleId != "ml_model" || alarm.Severity != "high" || alarm.Timestamp != at.Unix() {
+		t.Errorf("Allarme ricostruito in modo errato: %+v", alarm)
+	}
+	if alarm.Score == nil || *alarm.Score != -0.2 {
+		t.Errorf("Punteggio non ricostruito: %v", alarm.Score)
+	}
+	if alarm.TriggerMetric == nil || len(alarm.TriggerMetric.Features) != features.NumFeatures || alarm.TriggerMetric.Features[features.Count] != features.Count {
+		t.Errorf("Feature della metrica scatenante non ricostruite: %+v", alarm.TriggerMetric)
+	}
+	id, err := decodeAlarmID(alarm.Id)
+	if err != nil || id.Time != at.UnixNano() || id.ClientID != "attacker" || id.RuleID != "ml_model" {
+		t.Errorf("L'id dell'allarme non identifica il punto: %+v (errore %v)", id, err)
+	}
+}
+
+func TestMetricFromRecord_PrefersRawVector(t *testing.T) {
+	v := make([]float32, features.NumFeatures)
+	v[features.SerrorRate] = 0.1
+	values := map[string]interface{}{
+		"_time": time.Unix(5, 0), "_measurement": "network_traffic", "client_id": "c",
+		rawFeaturesField: features.EncodeRaw(v),
+	}
+	metric := metricFromRecord(query.NewFluxRecord(0, values))
+	if metric.Type != "network_traffic" || len(metric.Features) != features.NumFeatures || metric.Features[features.SerrorRate] != v[features.SerrorRate] {
+		t.Errorf("Metrica ricostruita in modo errato: %+v", metric)
+	}
+
+	// Senza tutte le feature dello schema il vettore non viene ricostruito
+	delete(values, rawFeaturesField)
+	values["duration"] = 1.0
+	if metric := metricFromRecord(query.NewFluxRecord(0, values)); metric.Features != nil {
+		t.Errorf("Un vettore incompleto non doveva essere ricostruito: %v", metric.Features)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -10,14 +11,16 @@ import (
 	"time"
 
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/consul"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/dedup"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/mtls"
 	"github.com/ANGEL0CADUTO/IDS_project/pkg/tracing"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -26,75 +29,73 @@ var (
 	influxOrg          = getEnv("INFLUXDB_ORG", "ids-project")
 	influxBucket       = getEnv("INFLUXDB_BUCKET", "metrics")
 	influxAlarmsBucket = getEnv("INFLUXDB_ALARMS_BUCKET", "alarms")
+	// Con STORE_RAW_FEATURES=true si salva anche il vettore originale codificato senza perdita
+	storeRawFeatures  = getEnv("STORE_RAW_FEATURES", "false") == "true"
+	storageDurability = getEnv("STORAGE_DURABILITY", durabilityConfirmed)
 )
 
 type server struct {
 	pb.UnimplementedStorageServer
-	influxWriteAPI       api.WriteAPI
-	influxWriteAPIAlarms api.WriteAPI
+	backend Backend
+	// Allarmi inoltrati in tempo reale agli stream di WatchAlarms
+	alarms *alarmBroker
+	// Scritture fallite da ritentare (nil: gli errori tornano solo al chiamante)
+	deadLetters *deadLetterQueue
+	// Chiavi di idempotenza e metric_id delle scritture già riuscite (nil: nessuna deduplica)
+	applied       *dedup.Window
+	storedMetrics *dedup.Window
 }
 
 // --- StoreMetric salva metrica ---
 func (s *server) StoreMetric(ctx context.Context, in *pb.Metric) (*pb.StorageResponse, error) {
-	// Creiamo UN SOLO punto per la metrica, identificato dal suo timestamp e client_id
-	p := influxdb2.NewPointWithMeasurement(in.Type).
-		AddTag("client_id", in.SourceClientId).
-		SetTime(time.Unix(in.Timestamp, 0))
-
-	// Controlliamo se la metrica ha le feature complete
-	if len(in.Features) == 41 {
-		// --- MODIFICA CHIAVE ---
-		// Aggiungiamo OGNI feature come un CAMPO SEPARATO allo STESSO punto.
-		// Diamo loro nomi significativi per poterli usare nelle query.
-		p.AddField("duration", float64(in.Features[0]))
-		p.AddField("protocol_type", float64(in.Features[1]))
-		p.AddField("service", float64(in.Features[2]))
-		p.AddField("flag", float64(in.Features[3]))
-		p.AddField("src_bytes", float64(in.Features[4]))
-		p.AddField("dst_bytes", float64(in.Features[5]))
-		// ... potremmo aggiungerle tutte e 41, ma queste sono sufficienti per i test
-		p.AddField("count", float64(in.Features[22]))
-		p.AddField("srv_count", float64(in.Features[23]))
-		p.AddField("serror_rate", float64(in.Features[24]))
-		p.AddField("srv_serror_rate", float64(in.Features[25]))
-	} else {
-		// Fallback per le metriche semplici senza le 41 feature
-		p.AddField("value", in.Value)
-	}
-
-	// Scriviamo il punto singolo (che ora contiene più campi)
-	s.influxWriteAPI.WritePoint(p)
+	key := idempotencyKey(ctx)
+	if s.applied.Seen(key) || s.storedMetrics.Seen(in.MetricId) {
+		return &pb.StorageResponse{Success: true, Message: "Metric already stored"}, nil
+	}
+	if err := s.backend.WriteMetric(ctx, in); err != nil {
+		log.Printf("ERROR: could not store metric from %s: %v", in.SourceClientId, err)
+		return nil, s.deadLetterError(ctx, deadLetterMetric, in, key, err)
+	}
+	s.applied.Finish(key)
+	s.storedMetrics.Finish(in.MetricId)
 	log.Printf("Stored metric from %s", in.SourceClientId)
 	return &pb.StorageResponse{Success: true, Message: "Metric stored"}, nil
 }
 
 // --- StoreAlarm salva allarme ---
 func (s *server) StoreAlarm(ctx context.Context, in *pb.Alarm) (*pb.StorageResponse, error) {
-	// Creiamo UN SOLO punto per l'allarme
-	p := influxdb2.NewPointWithMeasurement("alarm").
-		AddTag("rule_id", in.RuleId). // Aggiungiamo la regola come TAG per poter raggruppare!
-		AddTag("client_id", in.ClientId).
-		SetTime(time.Unix(in.Timestamp, 0))
-
-	// Aggiungiamo i dettagli dell'allarme come CAMPI
-	p.AddField("description", in.Description)
-
-	// Se l'allarme contiene la metrica che l'ha scatenato, salviamo anche alcune sue feature
-	if in.TriggerMetric != nil && len(in.TriggerMetric.Features) > 0 {
-		p.AddField("trigger_src_bytes", float64(in.TriggerMetric.Features[4]))
-		p.AddField("trigger_count", float64(in.TriggerMetric.Features[22]))
-		p.AddField("trigger_serror_rate", float64(in.TriggerMetric.Features[24]))
-	} else if in.TriggerMetric != nil {
-		p.AddField("trigger_value", in.TriggerMetric.Value)
+	key := idempotencyKey(ctx)
+	if s.applied.Seen(key) {
+		log.Printf("Duplicate delivery of alarm for client %s, rule %s ignored", in.ClientId, in.RuleId)
+		return &pb.StorageResponse{Success: true, Message: "Alarm already stored"}, nil
 	}
-
-	// Scriviamo il punto singolo nel bucket degli allarmi
-	s.influxWriteAPIAlarms.WritePoint(p)
+	if err := s.backend.WriteAlarm(ctx, in); err != nil {
+		log.Printf("ERROR: could not store alarm for client %s, rule %s: %v", in.ClientId, in.RuleId, err)
+		return nil, s.deadLetterError(ctx, deadLetterAlarm, in, key, err)
+	}
+	s.applied.Finish(key)
+	s.publishAlarm(in)
 
 	log.Printf("Stored ALARM for client %s, rule %s", in.ClientId, in.RuleId)
 	return &pb.StorageResponse{Success: true, Message: "Alarm stored"}, nil
 }
 
+// writeError converte l'errore di scrittura del backend nello status della RPC.
+// queued indica che la scrittura è stata accodata nel dead-letter e verrà ritentata.
+func writeError(ctx context.Context, err error, queued bool) error {
+	code := codes.Unavailable
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		code = codes.Canceled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	if queued {
+		return status.Errorf(code, "write failed, queued for retry: %v", err)
+	}
+	return status.Errorf(code, "write failed: %v", err)
+}
+
 func main() {
 	// --- Configurazione degli indirizzi dei servizi ---
 	consulAddr := getEnv("CONSUL_ADDR", "localhost:8500")
@@ -116,27 +117,67 @@ func main() {
 		}
 	}()
 
-	// --- Registrazione a Consul (invariata) ---
+	// --- mTLS (TLS_CERT_FILE, TLS_KEY_FILE, TLS_CA_FILE; senza file il servizio resta in chiaro) ---
+	tlsFiles, err := mtls.Load(mtls.Files{
+		CertFile: getEnv("TLS_CERT_FILE", ""),
+		KeyFile:  getEnv("TLS_KEY_FILE", ""),
+		CAFile:   getEnv("TLS_CA_FILE", ""),
+	})
+	if err != nil {
+		log.Fatalf("Failed to load TLS material: %v", err)
+	}
+	tlsReloadStr := getEnv("TLS_RELOAD_SECONDS", "30")
+	tlsReload, err := strconv.Atoi(tlsReloadStr)
+	if err != nil || tlsReload < 0 {
+		log.Fatalf("Invalid TLS_RELOAD_SECONDS: %s", tlsReloadStr)
+	}
+	go tlsFiles.Watch(context.Background(), time.Duration(tlsReload)*time.Second)
+	checkTLSName := ""
+	if tlsFiles.Enabled() {
+		checkTLSName = serviceName
+	}
+
+	// --- Registrazione a Consul ---
 	serviceID := fmt.Sprintf("%s-%s", serviceName, os.Getenv("HOSTNAME"))
-	consulClient := consul.RegisterService(consulAddr, serviceName, serviceID, port)
+	consulClient := consul.RegisterService(consulAddr, serviceName, serviceID, port, checkTLSName)
 	defer consul.DeregisterService(consulClient, serviceID)
 
-	// --- Configurazione del client InfluxDB (invariata) ---
-	client := influxdb2.NewClient(influxURL, influxToken)
-	writeAPI := client.WriteAPI(influxOrg, influxBucket)
-	writeAPIAlarms := client.WriteAPI(influxOrg, influxAlarmsBucket)
-	defer client.Close()
-	defer writeAPI.Flush()
-	defer writeAPIAlarms.Flush()
-
-	go func() {
-		for err := range writeAPI.Errors() {
-			log.Printf("InfluxDB write error: %s\n", err.Error())
-		}
-		for err := range writeAPIAlarms.Errors() {
-			log.Printf("InfluxDB (alarms) write error: %s\n", err.Error())
-		}
-	}()
+	// --- Configurazione del backend (InfluxDB o file locale) ---
+	backendKind := getEnv("STORAGE_BACKEND", backendInfluxDB)
+	backend, err := newBackend(backendKind)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage backend: %v", err)
+	}
+	defer backend.Close()
+	log.Printf("Storage backend: %s (durability: %s)", backendKind, storageDurability)
+
+	// --- Dead-letter delle scritture fallite, ritentate in background ---
+	deadLetters, err := openDeadLetterQueue(getEnv("DEAD_LETTER_PATH", "deadletter.jsonl"))
+	if err != nil {
+		log.Fatalf("Failed to initialize dead-letter file: %v", err)
+	}
+	idempotencyStr := getEnv("IDEMPOTENCY_WINDOW_SECONDS", "3600")
+	idempotencySeconds, err := strconv.Atoi(idempotencyStr)
+	if err != nil || idempotencySeconds <= 0 {
+		log.Fatalf("Invalid IDEMPOTENCY_WINDOW_SECONDS: %s", idempotencyStr)
+	}
+	idempotencyTTL := time.Duration(idempotencySeconds) * time.Second
+	retryStr := getEnv("DEAD_LETTER_RETRY_SECONDS", "30")
+	retrySeconds, err := strconv.Atoi(retryStr)
+	if err != nil || retrySeconds <= 0 {
+		log.Fatalf("Invalid DEAD_LETTER_RETRY_SECONDS: %s", retryStr)
+	}
+
+	alarmBufferStr := getEnv("ALARM_STREAM_BUFFER", "1000")
+	alarmBuffer, err := strconv.Atoi(alarmBufferStr)
+	if err != nil || alarmBuffer < 0 {
+		log.Fatalf("Invalid ALARM_STREAM_BUFFER: %s", alarmBufferStr)
+	}
+	subscriberBufferStr := getEnv("ALARM_SUBSCRIBER_BUFFER", "256")
+	subscriberBuffer, err := strconv.Atoi(subscriberBufferStr)
+	if err != nil || subscriberBuffer <= 0 {
+		log.Fatalf("Invalid ALARM_SUBSCRIBER_BUFFER: %s", subscriberBufferStr)
+	}
 
 	// --- Creazione del Listener di rete (invariata) ---
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", portStr))
@@ -148,19 +189,28 @@ func main() {
 	// Questa è la modifica chiave: l'interceptor di tracing viene applicato
 	// a tutte le chiamate, TRANNE a quella di health check di Consul.
 	s := grpc.NewServer(
+		grpc.Creds(tlsFiles.ServerCredentials()),
 		grpc.UnaryInterceptor(
 			tracing.NewConditionalUnaryInterceptor(
 				otelgrpc.UnaryServerInterceptor(),
 				"/grpc.health.v1.Health/Check", // Nome del metodo da saltare
 			),
 		),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
 	)
 
 	// Registrazione dei servizi sul server gRPC (invariata)
-	pb.RegisterStorageServer(s, &server{
-		influxWriteAPI:       writeAPI,
-		influxWriteAPIAlarms: writeAPIAlarms,
-	})
+	storageServer := &server{
+		backend:       backend,
+		alarms:        newAlarmBroker(alarmBuffer, subscriberBuffer),
+		deadLetters:   deadLetters,
+		applied:       dedup.NewWindow(idempotencyTTL, idempotencyMaxKeys),
+		storedMetrics: dedup.NewWindow(idempotencyTTL, idempotencyMaxKeys),
+	}
+	retryCtx, stopRetry := context.WithCancel(context.Background())
+	defer stopRetry()
+	go storageServer.retryDeadLetters(retryCtx, time.Duration(retrySeconds)*time.Second)
+	pb.RegisterStorageServer(s, storageServer)
 	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
 
 	// Avvio del server
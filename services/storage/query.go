@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Le RPC di consultazione validano i filtri e li passano al backend.
+// La paginazione usa un cursore (seek) invece di un offset: il token contiene la chiave di
+// ordinamento dell'ultimo elemento restituito, e la pagina successiva parte dal primo elemento
+// che la segue. Così le pagine restano coerenti anche se nel frattempo arrivano nuovi dati.
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Tipi di evento della timeline, nell'ordine in cui compaiono a parità di timestamp.
+const (
+	timelineAlarm  = "alarm"
+	timelineMetric = "metric"
+)
+
+// pageCursor è il contenuto del page_token: la chiave di ordinamento dell'ultimo elemento restituito.
+type pageCursor struct {
+	Time int64    `json:"t"`           // Nanosecondi Unix
+	Kind string   `json:"k,omitempty"` // Solo per la timeline: tipo dell'evento
+	Key  []string `json:"v"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c) // Stringhe e interi: la serializzazione non può fallire
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string, keyLen int) (*pageCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "malformed page_token")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Key) != keyLen {
+		return nil, status.Error(codes.InvalidArgument, "malformed page_token")
+	}
+	return &c, nil
+}
+
+// seek restituisce la posizione del backend da cui riprendere, nil senza cursore.
+func (c *pageCursor) seek() *seekKey {
+	if c == nil {
+		return nil
+	}
+	return &seekKey{Time: time.Unix(0, c.Time), Key: c.Key}
+}
+
+// alarmID identifica un allarme con la sua chiave nello storage: timestamp, client, regola e
+// metric_id della metrica scatenante (vedi alarmSortKey).
+type alarmID struct {
+	Time     int64  `json:"t"`
+	ClientID string `json:"c"`
+	RuleID   string `json:"r"`
+	MetricID string `json:"m,omitempty"`
+}
+
+func encodeAlarmID(id alarmID) string {
+	data, _ := json.Marshal(id)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAlarmID(s string) (alarmID, error) {
+	var id alarmID
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &id)
+	}
+	if err != nil || id.ClientID == "" || id.RuleID == "" {
+		return id, status.Error(codes.InvalidArgument, "malformed alarm id")
+	}
+	return id, nil
+}
+
+// withID valorizza l'id con cui GetAlarm ritrova l'allarme.
+func withID(a *pb.Alarm) *pb.Alarm {
+	a.Id = encodeAlarmID(alarmID{Time: time.Unix(a.Timestamp, 0).UnixNano(), ClientID: a.ClientId, RuleID: a.RuleId, MetricID: a.TriggerMetric.GetMetricId()})
+	return a
+}
+
+func pageSize(requested int32) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case requested == 0:
+		return defaultPageSize, nil
+	case requested > maxPageSize:
+		return maxPageSize, nil
+	}
+	return int(requested), nil
+}
+
+// queryRange converte l'intervallo richiesto nei limiti delle query. Un end a 0 non pone limiti
+// superiori: gli allarmi hanno il timestamp dell'evento, che può essere avanti rispetto all'orologio
+// dello storage (fino alla tolleranza del collector), e "adesso" li escluderebbe.
+func queryRange(tr *pb.TimeRange) (timeBounds, error) {
+	start, end := tr.GetStart(), tr.GetEnd()
+	if end != 0 && end <= start {
+		return timeBounds{}, status.Error(codes.InvalidArgument, "time_range end must be after start")
+	}
+	bounds := timeBounds{Start: time.Unix(start, 0)}
+	if end != 0 {
+		bounds.End = time.Unix(end, 0)
+	}
+	return bounds, nil
+}
+
+// backendError converte un errore del backend nello status della RPC.
+func backendError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	log.Printf("ERROR: storage query failed: %v", err)
+	return status.Errorf(codes.Unavailable, "query failed: %v", err)
+}
+
+// nextPageToken restituisce il token della pagina che segue l'elemento (at, key).
+func nextPageToken(at int64, key []string) string {
+	return encodeCursor(pageCursor{Time: time.Unix(at, 0).UnixNano(), Key: key})
+}
+
+func (s *server) ListAlarms(ctx context.Context, in *pb.ListAlarmsRequest) (*pb.ListAlarmsResponse, error) {
+	limit, err := pageSize(in.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := queryRange(in.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(in.PageToken, 3)
+	if err != nil {
+		return nil, err
+	}
+	// Un elemento in più del necessario indica se esiste una pagina successiva
+	alarms, err := s.backend.QueryAlarms(ctx, alarmQuery{
+		ClientID: in.ClientId, RuleID: in.RuleId, Severity: in.Severity,
+		Range: bounds, After: cursor.seek(), Desc: true, Limit: limit + 1,
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	resp := &pb.ListAlarmsResponse{}
+	if len(alarms) > limit {
+		last := alarms[limit-1]
+		resp.NextPageToken = nextPageToken(last.Timestamp, alarmSortKey(last))
+		alarms = alarms[:limit]
+	}
+	for _, a := range alarms {
+		resp.Alarms = append(resp.Alarms, withID(a))
+	}
+	return resp, nil
+}
+
+func (s *server) GetAlarm(ctx context.Context, in *pb.GetAlarmRequest) (*pb.Alarm, error) {
+	id, err := decodeAlarmID(in.Id)
+	if err != nil {
+		return nil, err
+	}
+	// Si leggono gli allarmi del client e della regola in quel secondo (una raffica, al più) e si
+	// sceglie quello della metrica scatenante indicata dall'id
+	at := time.Unix(0, id.Time)
+	alarms, err := s.backend.QueryAlarms(ctx, alarmQuery{
+		ClientID: id.ClientID, RuleID: id.RuleID,
+		Range: timeBounds{Start: at, End: at.Add(time.Second)}, Limit: maxPageSize,
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+	for _, a := range alarms {
+		if a.TriggerMetric.GetMetricId() == id.MetricID {
+			return withID(a), nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "alarm %s not found", in.Id)
+}
+
+func (s *server) QueryMetrics(ctx context.Context, in *pb.QueryMetricsRequest) (*pb.QueryMetricsResponse, error) {
+	limit, err := pageSize(in.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := queryRange(in.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(in.PageToken, 3)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := s.backend.QueryMetrics(ctx, metricQuery{
+		ClientID: in.ClientId, Type: in.Type, Range: bounds, After: cursor.seek(), Limit: limit + 1,
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	resp := &pb.QueryMetricsResponse{}
+	if len(metrics) > limit {
+		last := metrics[limit-1]
+		resp.NextPageToken = nextPageToken(last.Timestamp, metricSortKey(last))
+		metrics = metrics[:limit]
+	}
+	resp.Metrics = metrics
+	return resp, nil
+}
+
+// timelineEntry è un evento della timeline con la sua chiave di ordinamento (timestamp, tipo, nome, id),
+// dove il nome è la regola per gli allarmi e il tipo di metrica per le metriche, e l'id è il
+// metric_id delle metriche o della metrica scatenante degli allarmi.
+type timelineEntry struct {
+	time  int64
+	kind  string
+	name  string
+	id    string
+	event *pb.TimelineEvent
+}
+
+func (e timelineEntry) less(o timelineEntry) bool {
+	if e.time != o.time {
+		return e.time < o.time
+	}
+	if e.kind != o.kind {
+		return e.kind < o.kind
+	}
+	if e.name != o.name {
+		return e.name < o.name
+	}
+	return e.id < o.id
+}
+
+// timelineSeek restituisce la posizione da cui leggere gli eventi di tipo kind del client
+// per riprendere la timeline dal cursore.
+func timelineSeek(cursor *pageCursor, kind, clientID string) *seekKey {
+	if cursor == nil {
+		return nil
+	}
+	at := time.Unix(0, cursor.Time)
+	switch {
+	case kind < cursor.Kind:
+		// A parità di timestamp questo tipo precede il cursore
+		return &seekKey{Time: at}
+	case kind == cursor.Kind:
+		return &seekKey{Time: at, Key: []string{clientID, cursor.Key[0], cursor.Key[1]}}
+	default:
+		// A parità di timestamp questo tipo segue il cursore: il nome vuoto precede qualunque regola o tipo
+		return &seekKey{Time: at, Key: []string{clientID, ""}}
+	}
+}
+
+func (s *server) GetClientTimeline(ctx context.Context, in *pb.GetClientTimelineRequest) (*pb.GetClientTimelineResponse, error) {
+	if in.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	limit, err := pageSize(in.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := queryRange(in.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(in.PageToken, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ogni sorgente restituisce fino a limit+1 eventi dopo il cursore: la fusione dei due elenchi
+	// ordinati contiene quindi tutti i primi limit+1 eventi della timeline
+	alarms, err := s.backend.QueryAlarms(ctx, alarmQuery{
+		ClientID: in.ClientId, Range: bounds, After: timelineSeek(cursor, timelineAlarm, in.ClientId), Limit: limit + 1,
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+	metrics, err := s.backend.QueryMetrics(ctx, metricQuery{
+		ClientID: in.ClientId, Range: bounds, After: timelineSeek(cursor, timelineMetric, in.ClientId), Limit: limit + 1,
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	entries := make([]timelineEntry, 0, len(alarms)+len(metrics))
+	for _, a := range alarms {
+		entries = append(entries, timelineEntry{a.Timestamp, timelineAlarm, a.RuleId, a.TriggerMetric.GetMetricId(), &pb.TimelineEvent{Event: &pb.TimelineEvent_Alarm{Alarm: withID(a)}}})
+	}
+	for _, m := range metrics {
+		entries = append(entries, timelineEntry{m.Timestamp, timelineMetric, m.Type, m.MetricId, &pb.TimelineEvent{Event: &pb.TimelineEvent_Metric{Metric: m}}})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].less(entries[j]) })
+
+	resp := &pb.GetClientTimelineResponse{}
+	if len(entries) > limit {
+		last := entries[limit-1]
+		resp.NextPageToken = encodeCursor(pageCursor{Time: time.Unix(last.time, 0).UnixNano(), Kind: last.kind, Key: []string{last.name, last.id}})
+		entries = entries[:limit]
+	}
+	for _, e := range entries {
+		resp.Events = append(resp.Events, e.event)
+	}
+	return resp, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCursor_RoundTripAndRejectsGarbage(t *testing.T) {
+	token := encodeCursor(pageCursor{Time: 1700000000123456789, Key: []string{"client", "rule"}})
+	c, err := decodeCursor(token, 2)
+	if err != nil || c.Time != 1700000000123456789 || c.Key[0] != "client" || c.Key[1] != "rule" {
+		t.Fatalf("Cursore non ricostruito: %+v (errore %v)", c, err)
+	}
+	if c, err := decodeCursor("", 2); c != nil || err != nil {
+		t.Errorf("Un token vuoto indica la prima pagina")
+	}
+	for _, invalid := range []string{"%%%", encodeCursor(pageCursor{Key: []string{"solo-uno"}})} {
+		if _, err := decodeCursor(invalid, 2); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Il token %q doveva essere rifiutato con InvalidArgument, errore: %v", invalid, err)
+		}
+	}
+}
+
+func TestQueryRange_ValidatesInterval(t *testing.T) {
+	if _, err := queryRange(nil); err != nil {
+		t.Errorf("Un intervallo assente non deve porre limiti: %v", err)
+	}
+	if _, err := queryRange(&pb.TimeRange{Start: 20, End: 10}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Un intervallo con fine prima dell'inizio doveva essere rifiutato, errore: %v", err)
+	}
+}
+
+func TestListAlarms_ZeroEndIncludesFutureEventTime(t *testing.T) {
+	s := newEmbeddedServer(t)
+	future := time.Now().Add(time.Minute).Unix()
+	storeAlarms(t, s, &pb.Alarm{ClientId: "a", RuleId: "r", Timestamp: future})
+
+	resp, err := s.ListAlarms(context.Background(), &pb.ListAlarmsRequest{TimeRange: &pb.TimeRange{Start: 1}})
+	if err != nil || len(resp.Alarms) != 1 {
+		t.Errorf("Con end a 0 un allarme con event time nel futuro doveva essere restituito: %v (errore %v)", resp.GetAlarms(), err)
+	}
+}
+
+// newEmbeddedServer crea un server con il backend su file, senza servizi esterni.
+func newEmbeddedServer(t *testing.T) *server {
+	t.Helper()
+	backend, err := openEmbeddedBackend(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("Impossibile aprire il backend locale: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return &server{backend: backend, alarms: newAlarmBroker(10, 10)}
+}
+
+func storeAlarms(t *testing.T, s *server, alarms ...*pb.Alarm) {
+	t.Helper()
+	for _, a := range alarms {
+		if _, err := s.StoreAlarm(context.Background(), a); err != nil {
+			t.Fatalf("StoreAlarm fallita: %v", err)
+		}
+	}
+}
+
+func TestListAlarms_FiltersAndPaginatesNewestFirst(t *testing.T) {
+	s := newEmbeddedServer(t)
+	storeAlarms(t, s,
+		&pb.Alarm{ClientId: "a", RuleId: "r1", Severity: "high", Timestamp: 100},
+		&pb.Alarm{ClientId: "b", RuleId: "r1", Severity: "high", Timestamp: 100},
+		&pb.Alarm{ClientId: "a", RuleId: "r2", Severity: "low", Timestamp: 200},
+		&pb.Alarm{ClientId: "a", RuleId: "r1", Severity: "high", Timestamp: 300},
+		&pb.Alarm{ClientId: "a", RuleId: "r1", Severity: "high", Timestamp: 400},
+	)
+
+	// Tre pagine da 1 degli allarmi high del client a, dal più recente
+	var got []int64
+	req := &pb.ListAlarmsRequest{ClientId: "a", Severity: "high", PageSize: 1}
+	for page := 0; ; page++ {
+		resp, err := s.ListAlarms(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ListAlarms fallita: %v", err)
+		}
+		for _, a := range resp.Alarms {
+			got = append(got, a.Timestamp)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		if page > 5 {
+			t.Fatalf("La paginazione non termina")
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(got) != 3 || got[0] != 400 || got[1] != 300 || got[2] != 100 {
+		t.Errorf("Allarmi attesi 400, 300, 100 in pagine successive, ricevuti %v", got)
+	}
+
+	// A parità di timestamp le pagine distinguono gli allarmi per client e regola
+	resp, err := s.ListAlarms(context.Background(), &pb.ListAlarmsRequest{TimeRange: &pb.TimeRange{Start: 100, End: 101}, PageSize: 1})
+	if err != nil || len(resp.Alarms) != 1 || resp.NextPageToken == "" {
+		t.Fatalf("Attesa una pagina con un seguito (errore %v)", err)
+	}
+	second, err := s.ListAlarms(context.Background(), &pb.ListAlarmsRequest{TimeRange: &pb.TimeRange{Start: 100, End: 101}, PageSize: 1, PageToken: resp.NextPageToken})
+	if err != nil || len(second.Alarms) != 1 || second.Alarms[0].ClientId == resp.Alarms[0].ClientId || second.NextPageToken != "" {
+		t.Errorf("La seconda pagina doveva contenere l'altro allarme dello stesso secondo e nessun seguito: %v (errore %v)", second.Alarms, err)
+	}
+}
+
+func TestGetAlarm_ByIDAndNotFound(t *testing.T) {
+	s := newEmbeddedServer(t)
+	score := -0.2
+	storeAlarms(t, s, &pb.Alarm{ClientId: "a", RuleId: "ml_model", Timestamp: 100, Score: &score, Description: "anomalia"})
+
+	list, err := s.ListAlarms(context.Background(), &pb.ListAlarmsRequest{})
+	if err != nil || len(list.Alarms) != 1 {
+		t.Fatalf("Atteso un allarme (errore %v)", err)
+	}
+	alarm, err := s.GetAlarm(context.Background(), &pb.GetAlarmRequest{Id: list.Alarms[0].Id})
+	if err != nil || alarm.Description != "anomalia" || alarm.Score == nil || *alarm.Score != score {
+		t.Errorf("GetAlarm doveva restituire l'allarme salvato: %+v (errore %v)", alarm, err)
+	}
+
+	// Gli allarmi di una raffica (stesso client, regola e secondo) hanno id distinti
+	burst := func(metricID string) *pb.Alarm {
+		return &pb.Alarm{ClientId: "b", RuleId: "r", Timestamp: 200, Description: metricID, TriggerMetric: &pb.Metric{MetricId: metricID}}
+	}
+	storeAlarms(t, s, burst("m1"), burst("m2"))
+	list, err = s.ListAlarms(context.Background(), &pb.ListAlarmsRequest{ClientId: "b"})
+	if err != nil || len(list.Alarms) != 2 || list.Alarms[0].Id == list.Alarms[1].Id {
+		t.Fatalf("Attesi due allarmi con id distinti: %v (errore %v)", list.GetAlarms(), err)
+	}
+	for _, listed := range list.Alarms {
+		alarm, err := s.GetAlarm(context.Background(), &pb.GetAlarmRequest{Id: listed.Id})
+		if err != nil || alarm.Description != listed.TriggerMetric.MetricId {
+			t.Errorf("GetAlarm doveva restituire l'allarme di %s, ottenuto %+v (errore %v)", listed.TriggerMetric.MetricId, alarm, err)
+		}
+	}
+
+	missing := encodeAlarmID(alarmID{Time: 1, ClientID: "a", RuleID: "ml_model"})
+	if _, err := s.GetAlarm(context.Background(), &pb.GetAlarmRequest{Id: missing}); status.Code(err) != codes.NotFound {
+		t.Errorf("Atteso NotFound per un allarme inesistente, ricevuto %v", err)
+	}
+}
+
+func TestGetClientTimeline_MergesMetricsAndAlarmsAcrossPages(t *testing.T) {
+	s := newEmbeddedServer(t)
+	for _, ts := range []int64{10, 20, 30} {
+		if _, err := s.StoreMetric(context.Background(), &pb.Metric{SourceClientId: "a", Type: "network_traffic", Timestamp: ts, Value: float64(ts)}); err != nil {
+			t.Fatalf("StoreMetric fallita: %v", err)
+		}
+	}
+	if _, err := s.StoreMetric(context.Background(), &pb.Metric{SourceClientId: "b", Type: "network_traffic", Timestamp: 15}); err != nil {
+		t.Fatalf("StoreMetric fallita: %v", err)
+	}
+	storeAlarms(t, s, &pb.Alarm{ClientId: "a", RuleId: "r", Timestamp: 20})
+
+	// A parità di timestamp l'allarme precede la metrica
+	want := []string{"metric@10", "alarm@20", "metric@20", "metric@30"}
+	var got []string
+	req := &pb.GetClientTimelineRequest{ClientId: "a", PageSize: 2}
+	for {
+		resp, err := s.GetClientTimeline(context.Background(), req)
+		if err != nil {
+			t.Fatalf("GetClientTimeline fallita: %v", err)
+		}
+		for _, ev := range resp.Events {
+			if m := ev.GetMetric(); m != nil {
+				got = append(got, "metric@"+itoa(m.Timestamp))
+			} else {
+				got = append(got, "alarm@"+itoa(ev.GetAlarm().Timestamp))
+			}
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Timeline %v, attesa %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Timeline %v, attesa %v", got, want)
+			break
+		}
+	}
+
+	if _, err := s.GetClientTimeline(context.Background(), &pb.GetClientTimelineRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("La timeline senza client_id doveva essere rifiutata, errore: %v", err)
+	}
+}
+
+func itoa(v int64) string { return strconv.FormatInt(v, 10) }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// WatchAlarms inoltra ai sottoscrittori ogni allarme ricevuto da StoreAlarm. Ogni allarme pubblicato
+// riceve un numero di sequenza e resta in un buffer circolare degli ultimi allarmi: un client che si
+// riconnette con il resume_token dell'ultimo evento ricevuto riparte dal buffer senza perdere nulla.
+// Se il buffer non copre più il token (troppi allarmi nel frattempo, o lo storage è stato riavviato)
+// gli allarmi mancanti vengono riletti dal backend a partire dal timestamp del token: in quel caso
+// un allarme dello stesso secondo può essere consegnato due volte (at-least-once).
+
+// severityRanks ordina le gravità per il filtro min_severity.
+var severityRanks = map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// alarmFilter seleziona gli allarmi di uno stream.
+type alarmFilter struct {
+	clientPrefix string
+	minRank      int
+}
+
+func newAlarmFilter(in *pb.WatchAlarmsRequest) (alarmFilter, error) {
+	f := alarmFilter{clientPrefix: in.ClientIdPrefix}
+	if in.MinSeverity != "" {
+		rank, ok := severityRanks[in.MinSeverity]
+		if !ok {
+			return f, status.Errorf(codes.InvalidArgument, "unknown min_severity %q", in.MinSeverity)
+		}
+		f.minRank = rank
+	}
+	return f, nil
+}
+
+// match indica se l'allarme passa il filtro; un allarme senza gravità passa solo senza min_severity.
+func (f alarmFilter) match(a *pb.Alarm) bool {
+	return strings.HasPrefix(a.ClientId, f.clientPrefix) && severityRanks[a.Severity] >= f.minRank
+}
+
+// resumeToken identifica un allarme nello stream: epoca del broker (cambia a ogni avvio),
+// numero di sequenza e timestamp dell'allarme per la rilettura dal backend.
+type resumeToken struct {
+	Epoch string `json:"e,omitempty"`
+	Seq   uint64 `json:"s,omitempty"`
+	Time  int64  `json:"t"`
+}
+
+func encodeResumeToken(t resumeToken) string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeResumeToken(s string) (*resumeToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var t resumeToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &t)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "malformed resume_token")
+	}
+	return &t, nil
+}
+
+type alarmEvent struct {
+	seq   uint64
+	alarm *pb.Alarm
+}
+
+// alarmSubscriber riceve gli allarmi pubblicati; dropped viene chiuso se il sottoscrittore
+// è troppo lento e il suo buffer si riempie.
+type alarmSubscriber struct {
+	events  chan alarmEvent
+	dropped chan struct{}
+}
+
+type alarmBroker struct {
+	epoch         string
+	subscriberBuf int
+
+	mu          sync.Mutex
+	seq         uint64
+	recent      []alarmEvent // Buffer circolare degli ultimi allarmi, in ordine di sequenza
+	next        int          // Posizione del prossimo inserimento quando recent è pieno
+	capacity    int
+	subscribers map[*alarmSubscriber]struct{}
+}
+
+func newAlarmBroker(capacity, subscriberBuf int) *alarmBroker {
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		// Senza byte casuali basta l'istante di avvio per distinguere i riavvii
+		binary.BigEndian.PutUint64(epoch, uint64(time.Now().UnixNano()))
+	}
+	return newAlarmBrokerWithEpoch(hex.EncodeToString(epoch), capacity, subscriberBuf)
+}
+
+func newAlarmBrokerWithEpoch(epoch string, capacity, subscriberBuf int) *alarmBroker {
+	return &alarmBroker{
+		epoch:         epoch,
+		subscriberBuf: subscriberBuf,
+		capacity:      capacity,
+		subscribers:   make(map[*alarmSubscriber]struct{}),
+	}
+}
+
+// publish assegna all'allarme il prossimo numero di sequenza e lo invia ai sottoscrittori.
+func (b *alarmBroker) publish(alarm *pb.Alarm) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev := alarmEvent{seq: b.seq, alarm: alarm}
+	if len(b.recent) < b.capacity {
+		b.recent = append(b.recent, ev)
+	} else if b.capacity > 0 {
+		b.recent[b.next] = ev
+		b.next = (b.next + 1) % b.capacity
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- ev:
+		default:
+			// Non blocchiamo StoreAlarm per un client lento: lo disconnettiamo e potrà riprendere col token
+			close(sub.dropped)
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// buffered restituisce gli allarmi del buffer in ordine di sequenza.
+func (b *alarmBroker) buffered() []alarmEvent {
+	out := make([]alarmEvent, 0, len(b.recent))
+	out = append(out, b.recent[b.next:]...)
+	return append(out, b.recent[:b.next]...)
+}
+
+// subscribe registra un sottoscrittore e restituisce gli allarmi già pubblicati da inoltrargli:
+// quelli successivi al token se il buffer lo copre (complete = true), altrimenti l'intero buffer.
+func (b *alarmBroker) subscribe(token *resumeToken) (sub *alarmSubscriber, backlog []alarmEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub = &alarmSubscriber{events: make(chan alarmEvent, b.subscriberBuf), dropped: make(chan struct{})}
+	b.subscribers[sub] = struct{}{}
+	if token == nil {
+		return sub, nil, true
+	}
+
+	buffered := b.buffered()
+	oldest := b.seq + 1 // Sequenza del primo allarme ancora nel buffer
+	if len(buffered) > 0 {
+		oldest = buffered[0].seq
+	}
+	if token.Epoch != b.epoch || token.Seq+1 < oldest || token.Seq > b.seq {
+		return sub, buffered, false
+	}
+	for _, ev := range buffered {
+		if ev.seq > token.Seq {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog, true
+}
+
+func (b *alarmBroker) unsubscribe(sub *alarmSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+}
+
+func (b *alarmBroker) token(ev alarmEvent) string {
+	return encodeResumeToken(resumeToken{Epoch: b.epoch, Seq: ev.seq, Time: time.Unix(ev.alarm.Timestamp, 0).UnixNano()})
+}
+
+// publishAlarm rende disponibile ai sottoscrittori una copia dell'allarme salvato, con il suo id.
+func (s *server) publishAlarm(in *pb.Alarm) {
+	if s.alarms == nil {
+		return
+	}
+	s.alarms.publish(withID(proto.Clone(in).(*pb.Alarm)))
+}
+
+// replayAlarms rilegge dal backend, in ordine cronologico, gli allarmi con timestamp a partire da since.
+func (s *server) replayAlarms(ctx context.Context, since time.Time, send func(*pb.Alarm, string) error) error {
+	var after *seekKey
+	for {
+		alarms, err := s.backend.QueryAlarms(ctx, alarmQuery{Range: timeBounds{Start: since}, After: after, Limit: maxPageSize})
+		if err != nil {
+			return backendError(err)
+		}
+		for _, alarm := range alarms {
+			// Il token di un allarme riletto non ha sequenza: una nuova ripresa rilegge dal backend
+			token := encodeResumeToken(resumeToken{Time: time.Unix(alarm.Timestamp, 0).UnixNano()})
+			if err := send(withID(alarm), token); err != nil {
+				return err
+			}
+		}
+		if len(alarms) < maxPageSize {
+			return nil
+		}
+		last := alarms[len(alarms)-1]
+		after = &seekKey{Time: time.Unix(last.Timestamp, 0), Key: alarmSortKey(last)}
+	}
+}
+
+func (s *server) WatchAlarms(in *pb.WatchAlarmsRequest, stream pb.Storage_WatchAlarmsServer) error {
+	filter, err := newAlarmFilter(in)
+	if err != nil {
+		return err
+	}
+	token, err := decodeResumeToken(in.ResumeToken)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+
+	// Ci si registra prima di rileggere gli arretrati: un allarme pubblicato nel frattempo
+	// arriva comunque dal canale del sottoscrittore
+	sub, backlog, complete := s.alarms.subscribe(token)
+	defer s.alarms.unsubscribe(sub)
+	log.Printf("New alarm subscriber (prefix %q, min severity %q, resumed: %t)", in.ClientIdPrefix, in.MinSeverity, token != nil)
+
+	// Con la rilettura dal backend lo stesso allarme può arrivare anche dal buffer o dal canale:
+	// si ricordano gli id già inviati e ogni allarme viene consegnato una volta sola
+	var replayed map[string]bool
+	send := func(alarm *pb.Alarm, resume string) error {
+		if replayed != nil {
+			if replayed[alarm.Id] {
+				delete(replayed, alarm.Id) // Un allarme si ripete al più una volta: la mappa si svuota
+				return nil
+			}
+			if !complete {
+				replayed[alarm.Id] = true
+			}
+		}
+		if !filter.match(alarm) {
+			return nil
+		}
+		return stream.Send(&pb.AlarmEvent{Alarm: alarm, ResumeToken: resume})
+	}
+
+	if !complete {
+		replayed = make(map[string]bool)
+		log.Printf("Resume token no longer covered by the alarm buffer: replaying from the storage backend")
+		if err := s.replayAlarms(ctx, time.Unix(0, token.Time), send); err != nil {
+			return err
+		}
+	}
+	for _, ev := range backlog {
+		if err := send(ev.alarm, s.alarms.token(ev)); err != nil {
+			return err
+		}
+	}
+	// Dal canale arrivano solo allarmi nuovi: si controllano i duplicati senza ricordarne altri
+	complete = true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.dropped:
+			// Gli eventi già in coda vengono comunque consegnati prima della chiusura
+			for {
+				select {
+				case ev := <-sub.events:
+					if err := send(ev.alarm, s.alarms.token(ev)); err != nil {
+						return err
+					}
+				default:
+					return status.Error(codes.ResourceExhausted, "alarm subscriber too slow: reconnect with the last resume_token")
+				}
+			}
+		case ev := <-sub.events:
+			if err := send(ev.alarm, s.alarms.token(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
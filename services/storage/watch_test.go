@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeWatchStream raccoglie gli eventi inviati da WatchAlarms.
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.AlarmEvent
+}
+
+func (f *fakeWatchStream) Context() context.Context { return f.ctx }
+
+func (f *fakeWatchStream) Send(ev *pb.AlarmEvent) error {
+	f.events <- ev
+	return nil
+}
+
+// watch avvia WatchAlarms in background e restituisce lo stream e il canale con il suo esito.
+func watch(t *testing.T, s *server, req *pb.WatchAlarmsRequest) (*fakeWatchStream, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream := &fakeWatchStream{ctx: ctx, events: make(chan *pb.AlarmEvent, 100)}
+	done := make(chan error, 1)
+	go func() { done <- s.WatchAlarms(req, stream) }()
+	return stream, done
+}
+
+func nextEvent(t *testing.T, stream *fakeWatchStream) *pb.AlarmEvent {
+	t.Helper()
+	select {
+	case ev := <-stream.events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("Nessun allarme ricevuto dallo stream")
+		return nil
+	}
+}
+
+// waitSubscribers attende che il broker abbia n sottoscrittori registrati.
+func waitSubscribers(t *testing.T, b *alarmBroker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		count := len(b.subscribers)
+		b.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Attesi %d sottoscrittori", n)
+}
+
+func TestWatchAlarms_FiltersByPrefixAndSeverity(t *testing.T) {
+	s := &server{alarms: newAlarmBroker(10, 10)}
+	stream, _ := watch(t, s, &pb.WatchAlarmsRequest{ClientIdPrefix: "edge-", MinSeverity: "high"})
+	waitSubscribers(t, s.alarms, 1)
+
+	s.publishAlarm(&pb.Alarm{ClientId: "edge-1", RuleId: "a", Severity: "medium", Timestamp: 1})
+	s.publishAlarm(&pb.Alarm{ClientId: "core-1", RuleId: "b", Severity: "critical", Timestamp: 2})
+	s.publishAlarm(&pb.Alarm{ClientId: "edge-2", RuleId: "c", Severity: "critical", Timestamp: 3})
+
+	ev := nextEvent(t, stream)
+	if ev.Alarm.ClientId != "edge-2" || ev.Alarm.RuleId != "c" {
+		t.Errorf("Doveva arrivare solo l'allarme critico di edge-2, ricevuto %+v", ev.Alarm)
+	}
+	if ev.Alarm.Id == "" || ev.ResumeToken == "" {
+		t.Errorf("L'evento doveva riportare l'id dell'allarme e il resume token")
+	}
+}
+
+func TestWatchAlarms_ResumesFromBuffer(t *testing.T) {
+	s := &server{alarms: newAlarmBroker(10, 10)}
+	stream, _ := watch(t, s, &pb.WatchAlarmsRequest{})
+	waitSubscribers(t, s.alarms, 1)
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 1})
+	token := nextEvent(t, stream).ResumeToken
+
+	// Allarmi pubblicati mentre il client è disconnesso
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r2", Timestamp: 2})
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r3", Timestamp: 3})
+
+	resumed, _ := watch(t, s, &pb.WatchAlarmsRequest{ResumeToken: token})
+	for _, want := range []string{"r2", "r3"} {
+		if got := nextEvent(t, resumed).Alarm.RuleId; got != want {
+			t.Errorf("Ripresa dal token: ricevuto %s, atteso %s", got, want)
+		}
+	}
+	waitSubscribers(t, s.alarms, 2)
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r4", Timestamp: 4})
+	if got := nextEvent(t, resumed).Alarm.RuleId; got != "r4" {
+		t.Errorf("Dopo gli arretrati dovevano arrivare gli allarmi nuovi, ricevuto %s", got)
+	}
+}
+
+func TestAlarmBroker_TokenOutsideBufferIsIncomplete(t *testing.T) {
+	b := newAlarmBrokerWithEpoch("e1", 2, 10)
+	for i := 1; i <= 4; i++ {
+		b.publish(&pb.Alarm{Timestamp: int64(i)})
+	}
+	// Il buffer contiene solo le sequenze 3 e 4
+	if _, backlog, complete := b.subscribe(&resumeToken{Epoch: "e1", Seq: 2}); !complete || len(backlog) != 2 || backlog[0].seq != 3 {
+		t.Errorf("Il token 2 è coperto dal buffer: completo %v, arretrati %d", complete, len(backlog))
+	}
+	if _, backlog, complete := b.subscribe(&resumeToken{Epoch: "e1", Seq: 1}); complete || len(backlog) != 2 {
+		t.Errorf("Il token 1 non è più coperto: serve la rilettura dal backend (completo %v)", complete)
+	}
+	if _, _, complete := b.subscribe(&resumeToken{Epoch: "riavvio", Seq: 4}); complete {
+		t.Errorf("Un token di un'altra epoca (storage riavviato) non può essere ripreso dal buffer")
+	}
+}
+
+func TestWatchAlarms_SlowSubscriberIsDisconnected(t *testing.T) {
+	s := &server{alarms: newAlarmBroker(10, 1)}
+	sub, _, _ := s.alarms.subscribe(nil)
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r1"})
+	s.publishAlarm(&pb.Alarm{ClientId: "c", RuleId: "r2"}) // Il buffer da 1 è pieno
+
+	select {
+	case <-sub.dropped:
+	default:
+		t.Fatalf("Un sottoscrittore con il buffer pieno doveva essere disconnesso")
+	}
+
+	// Lo stream consegna gli eventi in coda e chiude con RESOURCE_EXHAUSTED
+	stream := &fakeWatchStream{ctx: context.Background(), events: make(chan *pb.AlarmEvent, 10)}
+	s.alarms = newAlarmBroker(10, 1)
+	done := make(chan error, 1)
+	go func() { done <- s.WatchAlarms(&pb.WatchAlarmsRequest{}, stream) }()
+	waitSubscribers(t, s.alarms, 1)
+	s.alarms.mu.Lock() // Lo stream non può consumare mentre si riempie la sua coda
+	for sub := range s.alarms.subscribers {
+		sub.events <- alarmEvent{seq: 1, alarm: &pb.Alarm{RuleId: "queued"}}
+		close(sub.dropped)
+		delete(s.alarms.subscribers, sub)
+	}
+	s.alarms.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Atteso RESOURCE_EXHAUSTED, ricevuto %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Lo stream del sottoscrittore lento doveva chiudersi")
+	}
+	if ev := <-stream.events; ev.Alarm.RuleId != "queued" {
+		t.Errorf("Gli eventi già in coda dovevano essere consegnati prima della chiusura")
+	}
+}
+
+func TestWatchAlarms_ReplaysFromBackendAfterRestart(t *testing.T) {
+	s := newEmbeddedServer(t)
+	stream, _ := watch(t, s, &pb.WatchAlarmsRequest{})
+	waitSubscribers(t, s.alarms, 1)
+	storeAlarms(t, s, &pb.Alarm{ClientId: "c", RuleId: "r1", Timestamp: 100})
+	token := nextEvent(t, stream).ResumeToken
+
+	storeAlarms(t, s, &pb.Alarm{ClientId: "c", RuleId: "r2", Timestamp: 200})
+	// Riavvio dello storage: il buffer in memoria è perso, gli allarmi restano nel backend
+	restarted := &server{backend: s.backend, alarms: newAlarmBroker(10, 10)}
+
+	resumed, _ := watch(t, restarted, &pb.WatchAlarmsRequest{ResumeToken: token})
+	// Dal secondo del token in poi: r1 (stesso secondo, consegnato di nuovo) e r2
+	for _, want := range []string{"r1", "r2"} {
+		if got := nextEvent(t, resumed).Alarm.RuleId; got != want {
+			t.Errorf("Rilettura dal backend: ricevuto %s, atteso %s", got, want)
+		}
+	}
+}
@@ -1,31 +1,44 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/mtls"
+	"github.com/ANGEL0CADUTO/IDS_project/pkg/sensorauth"
 	pb "github.com/ANGEL0CADUTO/IDS_project/proto"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	collectorAddress  = getEnv("COLLECTOR_ADDR", "localhost:50051")
-	influxURL         = getEnv("INFLUXDB_URL", "http://localhost:8086")
-	influxToken       = getEnv("INFLUXDB_TOKEN", "password123")
-	influxOrg         = getEnv("INFLUXDB_ORG", "ids-project")
-	metricsBucket     = "metrics"
-	alarmsBucket      = "alarms"
+	storageAddress    = getEnv("STORAGE_ADDR", "localhost:50052")
 	alarmThreshold, _ = strconv.Atoi(getEnv("ALARM_THRESHOLD", "4"))
+	// Certificato client per l'mTLS verso i servizi (vuoto = connessione in chiaro)
+	tlsFiles = mtls.Files{
+		CertFile: getEnv("TLS_CERT_FILE", ""),
+		KeyFile:  getEnv("TLS_KEY_FILE", ""),
+		CAFile:   getEnv("TLS_CA_FILE", ""),
+	}
+	// Container del collector in cui i test emettono con sensor-admin una credenziale per ogni
+	// client che simulano (vuoto = autenticazione dei sensori disattivata, nessuna credenziale)
+	collectorContainer = getEnv("COLLECTOR_CONTAINER", "")
 )
 
 func getEnv(key, fallback string) string {
@@ -35,21 +48,80 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func connectToCollector(t *testing.T) (pb.MetricsCollectorClient, func()) {
+func dial(t *testing.T, address, service, serverName string) (*grpc.ClientConn, func()) {
 	t.Helper()
+	tlsMaterial, err := mtls.Load(tlsFiles)
+	require.NoError(t, err, "Configurazione TLS dei test di sistema non valida")
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	conn, err := grpc.DialContext(ctx, collectorAddress,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithTransportCredentials(tlsMaterial.ClientCredentials(serverName)),
 		grpc.WithBlock(),
 	)
-	require.NoError(t, err, "Il test di sistema richiede che il Collector Service sia raggiungibile su %s", collectorAddress)
+	require.NoError(t, err, "Il test di sistema richiede che il %s sia raggiungibile su %s", service, address)
 	cleanup := func() {
 		conn.Close()
 		cancel()
 	}
+	return conn, cleanup
+}
+
+// sensorContext restituisce il contesto di una chiamata al collector autenticata come clientID,
+// con una API key emessa per il client da sensor-admin nel container del collector.
+func sensorContext(t *testing.T, clientID string) context.Context {
+	t.Helper()
+	if collectorContainer == "" {
+		return context.Background()
+	}
+	credential := issueCredential(t, clientID)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", sensorauth.Authorization(credential))
+}
+
+// issueCredential esegue "sensor-admin issue" nel container del collector e restituisce la
+// credenziale stampata su stdout.
+func issueCredential(t *testing.T, clientID string) string {
+	t.Helper()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	ctx := context.Background()
+	exec, err := cli.ContainerExecCreate(ctx, collectorContainer, container.ExecOptions{
+		Cmd:          []string{"/sensor-admin", "issue", "-client", clientID, "-ttl", "1h"},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	require.NoError(t, err, "Impossibile eseguire sensor-admin nel container %s", collectorContainer)
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	require.NoError(t, err)
+	defer attach.Close()
+	var stdout, stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+	require.NoError(t, err)
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	require.NoError(t, err)
+	require.Zero(t, inspect.ExitCode, "sensor-admin issue fallita: %s", stderr.String())
+	return strings.TrimSpace(stdout.String())
+}
+
+func connectToCollector(t *testing.T) (pb.MetricsCollectorClient, func()) {
+	t.Helper()
+	conn, cleanup := dial(t, collectorAddress, "Collector Service", "collector-service")
 	return pb.NewMetricsCollectorClient(conn), cleanup
 }
 
+// connectToStorage apre la API di consultazione dello Storage Service: i test verificano
+// i dati salvati attraverso il contratto del servizio, non interrogando il database.
+func connectToStorage(t *testing.T) (pb.StorageClient, func()) {
+	t.Helper()
+	conn, cleanup := dial(t, storageAddress, "Storage Service", "storage-service")
+	return pb.NewStorageClient(conn), cleanup
+}
+
+// lastMinute è l'intervallo delle query dei test.
+func lastMinute() *pb.TimeRange {
+	return &pb.TimeRange{Start: time.Now().Add(-time.Minute).Unix()}
+}
+
 func TestSystem_HappyPath_MetricIsStored(t *testing.T) {
 	collectorClient, cleanup := connectToCollector(t)
 	defer cleanup()
@@ -62,33 +134,58 @@ func TestSystem_HappyPath_MetricIsStored(t *testing.T) {
 		Features:       make([]float32, 41),
 	}
 
-	_, err := collectorClient.SendMetric(context.Background(), testMetric)
+	_, err := collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
 	require.NoError(t, err)
 
 	time.Sleep(2 * time.Second)
 
-	influxClient := influxdb2.NewClient(influxURL, influxToken)
-	defer influxClient.Close()
-	queryAPI := influxClient.QueryAPI(influxOrg)
+	storageClient, closeStorage := connectToStorage(t)
+	defer closeStorage()
 
-	query := fmt.Sprintf(`from(bucket: "%s") |> range(start: -1m) |> filter(fn: (r) => r._measurement == "network_traffic" and r.client_id == "%s") |> count()`, metricsBucket, uniqueClientID)
-	result, err := queryAPI.Query(context.Background(), query)
+	resp, err := storageClient.QueryMetrics(context.Background(), &pb.QueryMetricsRequest{
+		ClientId:  uniqueClientID,
+		Type:      "network_traffic",
+		TimeRange: lastMinute(),
+	})
 	require.NoError(t, err)
+	require.NotEmpty(t, resp.Metrics, "La metrica inviata non è stata trovata nello storage")
+	assert.Len(t, resp.Metrics[0].Features, 41, "La metrica doveva essere salvata con tutte le feature")
+}
 
-	found := false
-	if result.Next() && result.Record().Value() != nil && result.Record().Value().(int64) > 0 {
-		found = true
+func TestSystem_InvalidMetricIsRejectedWithReason(t *testing.T) {
+	collectorClient, cleanup := connectToCollector(t)
+	defer cleanup()
+
+	uniqueClientID := fmt.Sprintf("integration-test-invalid-%d", time.Now().UnixNano())
+	cases := map[string]*pb.Metric{
+		"feature_count":     {Timestamp: time.Now().Unix(), Features: make([]float32, 10)},
+		"not_finite":        {Timestamp: time.Now().Unix(), Features: append(make([]float32, 40), float32(math.NaN()))},
+		"future_timestamp":  {Timestamp: time.Now().Add(time.Hour).Unix(), Features: make([]float32, 41)},
+		"missing_timestamp": {Features: make([]float32, 41)},
+	}
+	for reason, testMetric := range cases {
+		testMetric.SourceClientId = uniqueClientID
+		testMetric.Type = "network_traffic"
+		_, err := collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
+		require.Error(t, err, "La metrica %s doveva essere rifiutata", reason)
+		st := status.Convert(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+		var got string
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				got = info.Reason
+			}
+		}
+		assert.Equal(t, reason, got, "Il motivo del rifiuto doveva essere nei dettagli dello status")
 	}
-	assert.True(t, found, "La metrica inviata non è stata trovata in InfluxDB")
 }
 
 func TestSystem_AnomalyPath_AlarmIsStoredAfterCorrelation(t *testing.T) {
 	collectorClient, cleanup := connectToCollector(t)
 	defer cleanup()
 
-	influxClient := influxdb2.NewClient(influxURL, influxToken)
-	defer influxClient.Close()
-	queryAPI := influxClient.QueryAPI(influxOrg)
+	storageClient, closeStorage := connectToStorage(t)
+	defer closeStorage()
 
 	uniqueClientID := fmt.Sprintf("integration-test-anomaly-%d", time.Now().UnixNano())
 	anomalousFeatures := []float32{0, 1, 19, 5, 0, 0, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 240, 19, 1, 1, 0.08, 0.08, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
@@ -101,22 +198,18 @@ func TestSystem_AnomalyPath_AlarmIsStoredAfterCorrelation(t *testing.T) {
 
 	t.Logf("Invio di %d metriche sospette (sotto la soglia)...", alarmThreshold-1)
 	for i := 0; i < alarmThreshold-1; i++ {
-		resp, err := collectorClient.SendMetric(context.Background(), testMetric)
+		resp, err := collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
 		require.NoError(t, err)
 		assert.Contains(t, resp.Message, "Suspicious metric recorded", "La risposta doveva indicare metrica sospetta")
 	}
 
-	query := fmt.Sprintf(`from(bucket: "%s") |> range(start: -1m) |> filter(fn: (r) => r.client_id == "%s") |> count()`, alarmsBucket, uniqueClientID)
-	result, err := queryAPI.Query(context.Background(), query)
+	alarmsQuery := &pb.ListAlarmsRequest{ClientId: uniqueClientID, TimeRange: lastMinute()}
+	alarms, err := storageClient.ListAlarms(context.Background(), alarmsQuery)
 	require.NoError(t, err)
-	found := false
-	if result.Next() && result.Record().Value() != nil && result.Record().Value().(int64) > 0 {
-		found = true
-	}
-	assert.False(t, found, "NON doveva esserci un allarme prima del superamento della soglia")
+	assert.Empty(t, alarms.Alarms, "NON doveva esserci un allarme prima del superamento della soglia")
 
 	t.Logf("Invio dell'ultima metrica per superare la soglia di %d...", alarmThreshold)
-	resp, err := collectorClient.SendMetric(context.Background(), testMetric)
+	resp, err := collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
 	require.NoError(t, err)
 
 	// --- CORREZIONE QUI ---
@@ -125,13 +218,15 @@ func TestSystem_AnomalyPath_AlarmIsStoredAfterCorrelation(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	result, err = queryAPI.Query(context.Background(), query)
+	alarmsQuery.TimeRange = lastMinute()
+	alarms, err = storageClient.ListAlarms(context.Background(), alarmsQuery)
 	require.NoError(t, err)
-	found = false
-	if result.Next() && result.Record().Value() != nil && result.Record().Value().(int64) > 0 {
-		found = true
-	}
-	assert.True(t, found, "Un allarme doveva essere presente dopo aver superato la soglia")
+	require.NotEmpty(t, alarms.Alarms, "Un allarme doveva essere presente dopo aver superato la soglia")
+
+	// L'id restituito dalla lista permette di rileggere il singolo allarme
+	alarm, err := storageClient.GetAlarm(context.Background(), &pb.GetAlarmRequest{Id: alarms.Alarms[0].Id})
+	require.NoError(t, err)
+	assert.Equal(t, uniqueClientID, alarm.ClientId)
 }
 
 func TestSystem_FaultTolerance_FallbackIsTriggeredAndRecovers(t *testing.T) {
@@ -162,7 +257,7 @@ func TestSystem_FaultTolerance_FallbackIsTriggeredAndRecovers(t *testing.T) {
 		t.Logf("Invio di %d richieste anomale durante il guasto...", alarmThreshold)
 		for i := 0; i < alarmThreshold; i++ {
 			t.Logf("[DEBUG_TEST] Inizio chiamata #%d", i+1)
-			resp, err := collectorClient.SendMetric(context.Background(), testMetric)
+			resp, err := collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
 			require.NoError(t, err)
 
 			t.Logf("[DEBUG_TEST] Chiamata #%d - Risposta ricevuta: '%s'", i+1, resp.Message)
@@ -189,11 +284,12 @@ func TestSystem_FaultTolerance_FallbackIsTriggeredAndRecovers(t *testing.T) {
 		testMetric := &pb.Metric{
 			SourceClientId: "test-client-recovery",
 			Type:           "network_traffic",
+			Timestamp:      time.Now().Unix(),
 			Features:       anomalousFeatures,
 		}
 		var finalResp *pb.CollectorResponse
 		for i := 0; i < alarmThreshold; i++ {
-			finalResp, err = collectorClient.SendMetric(context.Background(), testMetric)
+			finalResp, err = collectorClient.SendMetric(sensorContext(t, testMetric.SourceClientId), testMetric)
 			require.NoError(t, err)
 		}
 		assert.Contains(t, finalResp.Message, "Correlated anomaly detected by ML Model", "Il sistema doveva riprendersi e usare di nuovo il modello ML")